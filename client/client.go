@@ -1,22 +1,90 @@
 package client
 
+import (
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
 var (
 	operationAuth    = "AUTH"
+	operationSCRAM   = "SCRAM"
 	operationSet     = "SET"
 	operationGet     = "GET"
+	operationGetSet  = "GETSET"
+	operationGets    = "GETS"
+	operationCAS     = "CAS"
 	operationHSet    = "HSET"
 	operationHGet    = "HGET"
 	operationHGetAll = "HGETALL"
 	operationKeys    = "KEYS"
+	operationScan    = "SCAN"
 	operationDelete  = "DELETE"
+	operationInfo    = "INFO"
+	operationIncrBy  = "INCRBY"
+	operationDecrBy  = "DECRBY"
+	operationHIncrBy = "HINCRBY"
+	operationLPush   = "LPUSH"
+	operationRPush   = "RPUSH"
+	operationLPop    = "LPOP"
+	operationRPop    = "RPOP"
+	operationLRange  = "LRANGE"
+	operationLLen    = "LLEN"
+	operationLTrim   = "LTRIM"
+	operationLIndex  = "LINDEX"
+	operationBLPop   = "BLPOP"
+	operationBRPop   = "BRPOP"
+	operationMulti   = "MULTI"
+	operationExec    = "EXEC"
+	operationWatch   = "WATCH"
+	operationUnwatch = "UNWATCH"
+	operationPublish = "PUBLISH"
+
+	operationSubscribe    = "SUBSCRIBE"
+	operationPSubscribe   = "PSUBSCRIBE"
+	operationUnsubscribe  = "UNSUBSCRIBE"
+	operationPUnsubscribe = "PUNSUBSCRIBE"
+
+	operationSAdd        = "SADD"
+	operationSRem        = "SREM"
+	operationSIsMember   = "SISMEMBER"
+	operationSMembers    = "SMEMBERS"
+	operationSCard       = "SCARD"
+	operationSPop        = "SPOP"
+	operationSRandMember = "SRANDMEMBER"
+	operationSInter      = "SINTER"
+	operationSUnion      = "SUNION"
+	operationSDiff       = "SDIFF"
+	operationSInterStore = "SINTERSTORE"
+	operationSUnionStore = "SUNIONSTORE"
+	operationSDiffStore  = "SDIFFSTORE"
+
+	operationZAdd          = "ZADD"
+	operationZRem          = "ZREM"
+	operationZScore        = "ZSCORE"
+	operationZRank         = "ZRANK"
+	operationZRange        = "ZRANGE"
+	operationZRangeByScore = "ZRANGEBYSCORE"
+	operationZIncrBy       = "ZINCRBY"
+	operationZCard         = "ZCARD"
+	operationZPopMin       = "ZPOPMIN"
 )
 
 // Config is a struct representing configuration for logde client
 type Config struct {
+	// Addr is host:port or unix:/path/to.sock.
 	Addr           string
 	MaxConnections uint
 	Username       string
 	Password       string
+	// If SCRAM is set then client authenticates with SCRAM-SHA-256, so password isn't sent to server.
+	// Users file of server must have SCRAM-SHA-256 entry of user.
+	SCRAM bool
+	// If TLS is specified then connections use TLS. Client certificate in it authenticates client
+	// if server verifies client certificates, so Username and Password can be left empty.
+	TLS *tls.Config
 }
 
 func DefaultConfig() Config {
@@ -31,14 +99,20 @@ type Client struct {
 	pool     *pool
 	username string
 	password string
+	useSCRAM bool
+
+	// scram are keys derived from password for the last salt received from server
+	scramLock sync.Mutex
+	scram     *scramCredentials
 }
 
 // New constructs new Client with specified configuration
 func New(config Config) *Client {
 	return &Client{
-		pool:     newPool(config.Addr, 10),
+		pool:     newPool(config.Addr, 10, config.TLS),
 		username: config.Username,
 		password: config.Password,
+		useSCRAM: config.SCRAM,
 	}
 }
 
@@ -59,6 +133,67 @@ func (c *Client) Set(key, value string, ttl int64) error {
 	return err
 }
 
+// SetNX sets value only if key doesn't exist. It returns false if key exists.
+func (c *Client) SetNX(key, value string, ttl int64) (bool, error) {
+	return c.setIf(key, value, ttl, "NX")
+}
+
+// SetXX sets value only if key exists. It returns false if key doesn't exist.
+func (c *Client) SetXX(key, value string, ttl int64) (bool, error) {
+	return c.setIf(key, value, ttl, "XX")
+}
+
+func (c *Client) setIf(key, value string, ttl int64, condition string) (bool, error) {
+	_, err := c.call(operationSet, args(key, ttl, condition, len(value)), value)
+	switch err {
+	case nil:
+		return true, nil
+	case ErrNotStored:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// GetSet sets value without ttl and returns old value. If key didn't exist, value is set and ErrNotFound is returned.
+func (c *Client) GetSet(key, value string) (string, error) {
+	result, err := c.call(operationGetSet, args(key, len(value)), value)
+	if err != nil {
+		return "", err
+	}
+	if len(result) != 1 {
+		return "", ErrServer
+	}
+
+	return result[0], nil
+}
+
+// Gets returns value with token, which can be passed to CompareAndSet.
+func (c *Client) Gets(key string) (string, uint64, error) {
+	result, err := c.call(operationGets, args(key), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(result) != 2 {
+		return "", 0, ErrServer
+	}
+
+	token, err := strconv.ParseUint(result[1], 10, 64)
+	if err != nil {
+		return "", 0, ErrServer
+	}
+
+	return result[0], token, nil
+}
+
+// CompareAndSet sets value only if key hasn't been changed since Gets returned token.
+// It returns ErrConflict if key was changed and ErrNotFound if key doesn't exist.
+func (c *Client) CompareAndSet(key, value string, ttl int64, token uint64) error {
+	_, err := c.call(operationCAS, args(key, token, ttl, len(value)), value)
+
+	return err
+}
+
 // Keys method
 func (c *Client) Keys() ([]string, error) {
 	return c.call(operationKeys, nil, nil)
@@ -102,33 +237,405 @@ func (c *Client) Delete(key string) error {
 	return err
 }
 
+// Incr increments integer value of key by one and returns new value.
+func (c *Client) Incr(key string) (int64, error) {
+	return c.IncrBy(key, 1)
+}
+
+// Decr decrements integer value of key by one and returns new value.
+func (c *Client) Decr(key string) (int64, error) {
+	return c.DecrBy(key, 1)
+}
+
+// IncrBy adds delta to integer value of key and returns new value. Missing key is treated as 0.
+func (c *Client) IncrBy(key string, delta int64) (int64, error) {
+	return c.callInteger(operationIncrBy, args(key, delta), nil)
+}
+
+// DecrBy subtracts delta from integer value of key and returns new value.
+func (c *Client) DecrBy(key string, delta int64) (int64, error) {
+	return c.callInteger(operationDecrBy, args(key, delta), nil)
+}
+
+// HIncrBy adds delta to integer value of hash field and returns new value.
+func (c *Client) HIncrBy(key, field string, delta int64) (int64, error) {
+	return c.callInteger(operationHIncrBy, args(key, field, delta), nil)
+}
+
+// callInteger executes command which returns single integer.
+func (c *Client) callInteger(operation string, arguments []interface{}, data interface{}) (int64, error) {
+	result, err := c.call(operation, arguments, data)
+	if err != nil {
+		return 0, err
+	}
+	if len(result) != 1 {
+		return 0, ErrServer
+	}
+
+	return strconv.ParseInt(result[0], 10, 64)
+}
+
+// LPush inserts values at the head of list and returns length of list.
+func (c *Client) LPush(key string, values ...string) (int, error) {
+	return c.callValues(operationLPush, key, values)
+}
+
+// RPush inserts values at the tail of list and returns length of list.
+func (c *Client) RPush(key string, values ...string) (int, error) {
+	return c.callValues(operationRPush, key, values)
+}
+
+// callValues executes command which takes key and several values and returns integer.
+func (c *Client) callValues(operation, key string, values []string) (int, error) {
+	arguments := args(key)
+	for _, value := range values {
+		arguments = append(arguments, len(value))
+	}
+
+	n, err := c.callInteger(operation, arguments, values)
+
+	return int(n), err
+}
+
+// LPop removes and returns first element of list.
+func (c *Client) LPop(key string) (string, error) {
+	return c.callString(operationLPop, args(key))
+}
+
+// RPop removes and returns last element of list.
+func (c *Client) RPop(key string) (string, error) {
+	return c.callString(operationRPop, args(key))
+}
+
+// LRange returns elements of list between start and stop inclusive. Negative index counts from the end of list.
+func (c *Client) LRange(key string, start, stop int) ([]string, error) {
+	return c.call(operationLRange, args(key, start, stop), nil)
+}
+
+// LLen returns length of list.
+func (c *Client) LLen(key string) (int, error) {
+	n, err := c.callInteger(operationLLen, args(key), nil)
+
+	return int(n), err
+}
+
+// LTrim removes elements of list which are not between start and stop.
+func (c *Client) LTrim(key string, start, stop int) error {
+	_, err := c.call(operationLTrim, args(key, start, stop), nil)
+
+	return err
+}
+
+// LIndex returns element of list by index.
+func (c *Client) LIndex(key string, index int) (string, error) {
+	return c.callString(operationLIndex, args(key, index))
+}
+
+// BLPop removes and returns first element of the first non-empty list. If all lists are empty,
+// it waits for element up to timeout, zero timeout means wait forever. ErrNotFound is returned on timeout.
+func (c *Client) BLPop(timeout time.Duration, keys ...string) (key, value string, err error) {
+	return c.blockingPop(operationBLPop, timeout, keys)
+}
+
+// BRPop removes and returns last element of the first non-empty list. It blocks like BLPop.
+func (c *Client) BRPop(timeout time.Duration, keys ...string) (key, value string, err error) {
+	return c.blockingPop(operationBRPop, timeout, keys)
+}
+
+func (c *Client) blockingPop(operation string, timeout time.Duration, keys []string) (string, string, error) {
+	arguments := append(keyArgs(keys), strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+
+	conn, err := c.conn()
+	if err != nil {
+		return "", "", err
+	}
+	defer c.pool.put(conn)
+
+	// connection deadline must not fire before server replies
+	if timeout > 0 {
+		conn.c.SetDeadline(time.Now().Add(timeout + defaultTimeout))
+	} else {
+		conn.c.SetDeadline(time.Time{})
+	}
+
+	result, err := conn.send(operation, arguments, nil)
+	if err != nil {
+		return "", "", err
+	}
+	if len(result) != 2 {
+		return "", "", ErrServer
+	}
+
+	return result[0], result[1], nil
+}
+
+// SAdd adds members to set and returns number of members which weren't in set.
+func (c *Client) SAdd(key string, members ...string) (int, error) {
+	return c.callValues(operationSAdd, key, members)
+}
+
+// SRem removes members from set and returns number of removed members.
+func (c *Client) SRem(key string, members ...string) (int, error) {
+	return c.callValues(operationSRem, key, members)
+}
+
+// SIsMember checks if member is in set.
+func (c *Client) SIsMember(key, member string) (bool, error) {
+	n, err := c.callInteger(operationSIsMember, args(key, len(member)), member)
+
+	return n == 1, err
+}
+
+// SMembers returns members of set in random order.
+func (c *Client) SMembers(key string) ([]string, error) {
+	return c.call(operationSMembers, args(key), nil)
+}
+
+// SCard returns number of members of set.
+func (c *Client) SCard(key string) (int, error) {
+	n, err := c.callInteger(operationSCard, args(key), nil)
+
+	return int(n), err
+}
+
+// SPop removes and returns random member of set.
+func (c *Client) SPop(key string) (string, error) {
+	return c.callString(operationSPop, args(key))
+}
+
+// SRandMember returns random member of set.
+func (c *Client) SRandMember(key string) (string, error) {
+	return c.callString(operationSRandMember, args(key))
+}
+
+// SInter returns members which are in all sets.
+func (c *Client) SInter(keys ...string) ([]string, error) {
+	return c.call(operationSInter, keyArgs(keys), nil)
+}
+
+// SUnion returns members which are in any of sets.
+func (c *Client) SUnion(keys ...string) ([]string, error) {
+	return c.call(operationSUnion, keyArgs(keys), nil)
+}
+
+// SDiff returns members of the first set which aren't in other sets.
+func (c *Client) SDiff(keys ...string) ([]string, error) {
+	return c.call(operationSDiff, keyArgs(keys), nil)
+}
+
+// SInterStore stores intersection of sets in dest and returns its size.
+func (c *Client) SInterStore(dest string, keys ...string) (int, error) {
+	n, err := c.callInteger(operationSInterStore, keyArgs(append([]string{dest}, keys...)), nil)
+
+	return int(n), err
+}
+
+// SUnionStore stores union of sets in dest and returns its size.
+func (c *Client) SUnionStore(dest string, keys ...string) (int, error) {
+	n, err := c.callInteger(operationSUnionStore, keyArgs(append([]string{dest}, keys...)), nil)
+
+	return int(n), err
+}
+
+// SDiffStore stores difference of sets in dest and returns its size.
+func (c *Client) SDiffStore(dest string, keys ...string) (int, error) {
+	n, err := c.callInteger(operationSDiffStore, keyArgs(append([]string{dest}, keys...)), nil)
+
+	return int(n), err
+}
+
+// ZMember is member of sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd sets scores of sorted set members and returns number of added members.
+func (c *Client) ZAdd(key string, members ...ZMember) (int, error) {
+	arguments := args(key)
+	values := make([]string, len(members))
+	for i, member := range members {
+		arguments = append(arguments, formatScore(member.Score), len(member.Member))
+		values[i] = member.Member
+	}
+
+	n, err := c.callInteger(operationZAdd, arguments, values)
+
+	return int(n), err
+}
+
+// ZRem removes members from sorted set and returns number of removed members.
+func (c *Client) ZRem(key string, members ...string) (int, error) {
+	return c.callValues(operationZRem, key, members)
+}
+
+// ZScore returns score of sorted set member.
+func (c *Client) ZScore(key, member string) (float64, error) {
+	return c.callScore(operationZScore, args(key, len(member)), member)
+}
+
+// ZRank returns 0-based rank of member in sorted set ordered by score.
+func (c *Client) ZRank(key, member string) (int, error) {
+	n, err := c.callInteger(operationZRank, args(key, len(member)), member)
+
+	return int(n), err
+}
+
+// ZRange returns members of sorted set between start and stop ranks inclusive. Negative rank counts from the end.
+func (c *Client) ZRange(key string, start, stop int) ([]ZMember, error) {
+	return c.callMembers(operationZRange, args(key, start, stop, "WITHSCORES"))
+}
+
+// ZRangeByScore returns members of sorted set with score between min and max inclusive.
+// math.Inf can be used for unbounded range.
+func (c *Client) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return c.callMembers(operationZRangeByScore, args(key, formatScore(min), formatScore(max), "WITHSCORES"))
+}
+
+// ZIncrBy adds delta to score of sorted set member and returns new score.
+func (c *Client) ZIncrBy(key, member string, delta float64) (float64, error) {
+	return c.callScore(operationZIncrBy, args(key, formatScore(delta), len(member)), member)
+}
+
+// ZCard returns number of members of sorted set.
+func (c *Client) ZCard(key string) (int, error) {
+	n, err := c.callInteger(operationZCard, args(key), nil)
+
+	return int(n), err
+}
+
+// ZPopMin removes and returns member with the lowest score.
+func (c *Client) ZPopMin(key string) (ZMember, error) {
+	members, err := c.callMembers(operationZPopMin, args(key))
+	if err != nil {
+		return ZMember{}, err
+	}
+	if len(members) != 1 {
+		return ZMember{}, ErrServer
+	}
+
+	return members[0], nil
+}
+
+// callScore executes command which returns single score.
+func (c *Client) callScore(operation string, arguments []interface{}, data interface{}) (float64, error) {
+	result, err := c.call(operation, arguments, data)
+	if err != nil {
+		return 0, err
+	}
+	if len(result) != 1 {
+		return 0, ErrServer
+	}
+
+	return strconv.ParseFloat(result[0], 64)
+}
+
+// callMembers executes command which returns members followed by their scores.
+func (c *Client) callMembers(operation string, arguments []interface{}) ([]ZMember, error) {
+	result, err := c.call(operation, arguments, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(result)%2 != 0 {
+		return nil, ErrServer
+	}
+
+	members := make([]ZMember, len(result)/2)
+	for i := range members {
+		score, err := strconv.ParseFloat(result[i*2+1], 64)
+		if err != nil {
+			return nil, ErrServer
+		}
+		members[i] = ZMember{Member: result[i*2], Score: score}
+	}
+
+	return members, nil
+}
+
+func formatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// callString executes command which returns single value.
+func (c *Client) callString(operation string, arguments []interface{}) (string, error) {
+	result, err := c.call(operation, arguments, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(result) != 1 {
+		return "", ErrServer
+	}
+
+	return result[0], nil
+}
+
+// Info returns server information, e.g. replication role and offset.
+func (c *Client) Info() (map[string]string, error) {
+	result, err := c.call(operationInfo, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]string, len(result))
+	for _, line := range result {
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			info[line[:i]] = line[i+1:]
+		}
+	}
+
+	return info, nil
+}
+
 // call executes command on server. If data is passed then it is added after request:
 // COMMAND_NAME arg1 arg2 arg2\r\n
 // data\r\n
 func (c *Client) call(operation string, arguments []interface{}, data interface{}) ([]string, error) {
-	conn, isNew, err := c.pool.get()
+	conn, err := c.conn()
 	if err != nil {
 		return nil, err
 	}
+	defer c.pool.put(conn)
 
-	proto := newConnection(conn)
+	return conn.send(operation, arguments, data)
+}
+
+// conn takes connection from the pool and authenticates it if it is new.
+func (c *Client) conn() (*connection, error) {
+	conn, isNew, err := c.pool.get()
+	if err != nil {
+		return nil, err
+	}
 
 	// check is authentication is required
 	if isNew && c.username != "" {
-		if _, err := proto.send(operationAuth, args(c.username, c.password), nil); err != nil {
+		if err := c.authenticate(conn); err != nil {
+			conn.c.Close()
 			return nil, err
 		}
 	}
 
-	result, err := proto.send(operation, arguments, data)
-	if err != nil {
-		return nil, err
+	return conn, nil
+}
+
+func (c *Client) authenticate(conn *connection) error {
+	if c.useSCRAM {
+		return c.authenticateSCRAM(conn)
 	}
-	c.pool.put(conn)
-	return result, nil
+
+	_, err := conn.send(operationAuth, args(c.username, c.password), nil)
+	return err
 }
 
 // args is tiny helper that adds some syntax-sugar :)
 func args(a ...interface{}) []interface{} {
 	return a
 }
+
+func keyArgs(keys []string) []interface{} {
+	arguments := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		arguments = append(arguments, key)
+	}
+
+	return arguments
+}
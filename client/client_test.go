@@ -2,8 +2,15 @@ package client
 
 import (
 	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	"time"
@@ -190,3 +197,534 @@ func assertKeyNotFound(t *testing.T, c *Client, key string) {
 		t.Fatalf("Expected ErrNotFound. Got error: %v", err)
 	}
 }
+
+func TestInfo(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	info, err := client.Info()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if info["role"] != "primary" {
+		t.Fatalf("Expected role primary. Got: %v", info)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	p := client.Pipeline()
+	for i := 0; i < 1000; i++ {
+		p.Set("key"+strconv.Itoa(i), strconv.Itoa(i), 0)
+	}
+	p.Get("key999")
+	p.Get("missing")
+	p.HSet("hash", "field", "value")
+	p.HGet("hash", "field")
+	p.Delete("key0")
+
+	results, err := p.Exec()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(results) != 1005 || p.Len() != 0 {
+		t.Fatalf("Expected 1005 results. Got: %d", len(results))
+	}
+
+	if value, err := results[1000].Value(); err != nil || value != "999" {
+		t.Fatalf("Expected: 999. Got: %v, %v", value, err)
+	}
+	if _, err := results[1001].Value(); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+	if value, err := results[1003].Value(); err != nil || value != "value" {
+		t.Fatalf("Expected: value. Got: %v, %v", value, err)
+	}
+
+	assertKeyNotFound(t, client, "key0")
+	assertKey(t, client, "key1", "1")
+}
+
+func TestTransaction(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.Set("balance", "100", 0)
+
+	tx, err := client.Transaction("balance")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tx.Set("balance", "90", 0)
+	tx.HSet("history", "1", "-10")
+	tx.Get("missing")
+	results, err := tx.Exec()
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 results. Got: %v, %v", results, err)
+	}
+	if results[0].Err != nil || results[2].Err != ErrNotFound {
+		t.Fatalf("Expected OK and ErrNotFound. Got: %v", results)
+	}
+	assertKey(t, client, "balance", "90")
+
+	// watched key is changed by other connection
+	tx, err = client.Transaction("balance")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Set("balance", "50", 0)
+	tx.Set("balance", "80", 0)
+	if _, err := tx.Exec(); err != ErrAborted {
+		t.Fatalf("Expected ErrAborted. Got: %v", err)
+	}
+	assertKey(t, client, "balance", "50")
+
+	tx, err = client.Transaction()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tx.Set("balance", "0", 0)
+	if err := tx.Discard(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertKey(t, client, "balance", "50")
+}
+
+func TestConditionalSet(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if set, err := client.SetXX("foo", "bar", 0); err != nil || set {
+		t.Fatalf("Expected missing key not to be replaced. Got: %v, %v", set, err)
+	}
+	if set, err := client.SetNX("foo", "bar", 0); err != nil || !set {
+		t.Fatalf("Expected missing key to be set. Got: %v, %v", set, err)
+	}
+	if set, err := client.SetNX("foo", "baz", 0); err != nil || set {
+		t.Fatalf("Expected existing key not to be set. Got: %v, %v", set, err)
+	}
+	if set, err := client.SetXX("foo", "baz", 0); err != nil || !set {
+		t.Fatalf("Expected existing key to be replaced. Got: %v, %v", set, err)
+	}
+
+	if old, err := client.GetSet("foo", "qux"); err != nil || old != "baz" {
+		t.Fatalf("Expected: baz. Got: %q, %v", old, err)
+	}
+	if _, err := client.GetSet("new", "qux"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+	assertKey(t, client, "new", "qux")
+}
+
+func TestCompareAndSet(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if _, _, err := client.Gets("foo"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+
+	client.Set("foo", "1", 0)
+	value, token, err := client.Gets("foo")
+	if err != nil || value != "1" {
+		t.Fatalf("Expected: 1. Got: %q, %v", value, err)
+	}
+
+	if err := client.CompareAndSet("foo", "2", 0, token); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := client.CompareAndSet("foo", "3", 0, token); err != ErrConflict {
+		t.Fatalf("Expected ErrConflict. Got: %v", err)
+	}
+	assertKey(t, client, "foo", "2")
+
+	if err := client.CompareAndSet("missing", "1", 0, token); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if _, err := client.Subscribe(); err != ErrSyntax {
+		t.Fatalf("Expected ErrSyntax. Got: %v", err)
+	}
+
+	sub, err := client.Subscribe("news")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	receive := func(expected Message) {
+		select {
+		case msg := <-sub.Messages():
+			if msg != expected {
+				t.Fatalf("Expected: %v. Got: %v", expected, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected: %v. Got nothing", expected)
+		}
+	}
+
+	if n, err := client.Publish("news", "hello"); err != nil || n != 1 {
+		t.Fatalf("Expected: 1. Got: %d, %v", n, err)
+	}
+	receive(Message{Channel: "news", Payload: "hello"})
+
+	if err := sub.PSubscribe("sport.*"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Publish("sport.chess", "e4")
+	receive(Message{Pattern: "sport.*", Channel: "sport.chess", Payload: "e4"})
+
+	if err := sub.Unsubscribe("news"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Publish("news", "ignored")
+	client.Publish("sport.go", "ko")
+	receive(Message{Pattern: "sport.*", Channel: "sport.go", Payload: "ko"})
+
+	sub.Close()
+	for range sub.Messages() {
+	}
+	if err := sub.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	l, _ := testutil.NextListener(t)
+	serverTLS, clientTLS := testutil.TLSConfigs(t, "alice")
+
+	config := server.DefaultConfig()
+	config.TLS = serverTLS
+	config.Users, _ = server.NewUserListFromReader(strings.NewReader("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	srv := server.New(server.NewMemory(time.Minute), config)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	// client is authenticated by certificate
+	client := New(Config{Addr: l.Addr().String(), TLS: clientTLS})
+	if err := client.Set("foo", "bar", 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertKey(t, client, "foo", "bar")
+
+	_, clientTLS = testutil.TLSConfigs(t, "")
+	client = New(Config{Addr: l.Addr().String(), TLS: clientTLS})
+	if _, err := client.Get("foo"); err == nil {
+		t.Fatalf("Expected client with certificate of other CA to be rejected")
+	}
+}
+
+func TestSCRAM(t *testing.T) {
+	l, _ := testutil.NextListener(t)
+
+	hash, err := server.HashPassword("password", "scram-sha-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := server.DefaultConfig()
+	config.Users, _ = server.NewUserListFromReader(strings.NewReader("alice:" + hash + "\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	srv := server.New(server.NewMemory(time.Minute), config)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := New(Config{Addr: l.Addr().String(), Username: "alice", Password: "password", SCRAM: true})
+	if err := client.Set("foo", "bar", 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertKey(t, client, "foo", "bar")
+
+	// bob has no SCRAM credentials
+	for _, config := range []Config{
+		{Addr: l.Addr().String(), Username: "alice", Password: "passwor", SCRAM: true},
+		{Addr: l.Addr().String(), Username: "bob", Password: "password", SCRAM: true},
+	} {
+		if _, err := New(config).Get("foo"); err != ErrAuthRequired {
+			t.Fatalf("Expected: %v. Got: %v", ErrAuthRequired, err)
+		}
+	}
+
+	// server which doesn't know ServerKey of user can't prove it
+	forged := hash[:strings.LastIndex(hash, ":")+1] + "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+	config.Users, _ = server.NewUserListFromReader(strings.NewReader("alice:" + forged + "\n"))
+	l, _ = testutil.NextListener(t)
+	forgedSrv := server.New(server.NewMemory(time.Minute), config)
+	go forgedSrv.Serve(l)
+	defer forgedSrv.Close()
+
+	client = New(Config{Addr: l.Addr().String(), Username: "alice", Password: "password", SCRAM: true})
+	if _, err := client.Get("foo"); err != ErrServerSignature {
+		t.Fatalf("Expected: %v. Got: %v", ErrServerSignature, err)
+	}
+
+	// too few iterations are rejected before keys are derived
+	weak := strings.Replace(hash, "$4096:", "$1:", 1)
+	config.Users, _ = server.NewUserListFromReader(strings.NewReader("alice:" + weak + "\n"))
+	l, _ = testutil.NextListener(t)
+	weakSrv := server.New(server.NewMemory(time.Minute), config)
+	go weakSrv.Serve(l)
+	defer weakSrv.Close()
+
+	client = New(Config{Addr: l.Addr().String(), Username: "alice", Password: "password", SCRAM: true})
+	if _, err := client.Get("foo"); err != ErrServerSignature {
+		t.Fatalf("Expected: %v. Got: %v", ErrServerSignature, err)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lodge.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := server.New(server.NewMemory(time.Minute), server.DefaultConfig())
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := New(Config{Addr: "unix:" + path})
+	if err := client.Set("foo", "bar", 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertKey(t, client, "foo", "bar")
+}
+
+func TestSubscribeKeyspace(t *testing.T) {
+	l, _ := testutil.NextListener(t)
+
+	config := server.DefaultConfig()
+	config.KeyspaceNotifications = true
+	srv := server.New(server.NewMemory(time.Minute), config)
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := New(Config{Addr: l.Addr().String()})
+
+	sub, err := client.SubscribeKeyspace("user:*")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer sub.Close()
+
+	client.Set("other", "1", 0)
+	client.Set("user:1", "1", 0)
+	client.Delete("user:1")
+
+	for _, event := range []string{"set", "del"} {
+		select {
+		case msg := <-sub.Messages():
+			if msg.Key() != "user:1" || msg.Payload != event {
+				t.Fatalf("Expected %s of user:1. Got: %v", event, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s of user:1. Got nothing", event)
+		}
+	}
+}
+
+func TestIncr(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if n, err := client.Incr("counter"); err != nil || n != 1 {
+		t.Fatalf("Expected: 1. Got: %d, %v", n, err)
+	}
+	if n, err := client.IncrBy("counter", 10); err != nil || n != 11 {
+		t.Fatalf("Expected: 11. Got: %d, %v", n, err)
+	}
+	if n, err := client.DecrBy("counter", 20); err != nil || n != -9 {
+		t.Fatalf("Expected: -9. Got: %d, %v", n, err)
+	}
+	if n, err := client.Decr("counter"); err != nil || n != -10 {
+		t.Fatalf("Expected: -10. Got: %d, %v", n, err)
+	}
+	if n, err := client.HIncrBy("hash", "field", 5); err != nil || n != 5 {
+		t.Fatalf("Expected: 5. Got: %d, %v", n, err)
+	}
+
+	client.Set("foo", "bar", 0)
+	if _, err := client.Incr("foo"); err != ErrNotInteger {
+		t.Fatalf("Expected ErrNotInteger. Got: %v", err)
+	}
+
+	client.Set("max", "9223372036854775807", 0)
+	if _, err := client.Incr("max"); err != ErrOverflow {
+		t.Fatalf("Expected ErrOverflow. Got: %v", err)
+	}
+}
+
+func TestList(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if n, err := client.RPush("list", "a", "b c", ""); err != nil || n != 3 {
+		t.Fatalf("Expected: 3. Got: %d, %v", n, err)
+	}
+	client.LPush("list", "z")
+
+	list, err := client.LRange("list", 0, -1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"z", "a", "b c", ""}; !reflect.DeepEqual(list, expected) {
+		t.Fatalf("Expected: %q. Got: %q", expected, list)
+	}
+
+	if value, err := client.LPop("list"); err != nil || value != "z" {
+		t.Fatalf("Expected: z. Got: %v, %v", value, err)
+	}
+	if value, err := client.LIndex("list", 1); err != nil || value != "b c" {
+		t.Fatalf("Expected: b c. Got: %v, %v", value, err)
+	}
+	if err := client.LTrim("list", 0, 0); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n, err := client.LLen("list"); err != nil || n != 1 {
+		t.Fatalf("Expected: 1. Got: %d, %v", n, err)
+	}
+
+	client.Set("foo", "bar", 0)
+	if _, err := client.LPush("foo", "a"); err != ErrWrongType {
+		t.Fatalf("Expected ErrWrongType. Got: %v", err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if n, err := client.SAdd("set1", "a", "b c", ""); err != nil || n != 3 {
+		t.Fatalf("Expected: 3. Got: %d, %v", n, err)
+	}
+	client.SAdd("set2", "b c", "d")
+
+	if found, err := client.SIsMember("set1", "b c"); err != nil || !found {
+		t.Fatalf("Expected member. Got: %v, %v", found, err)
+	}
+	if n, err := client.SRem("set1", ""); err != nil || n != 1 {
+		t.Fatalf("Expected: 1. Got: %d, %v", n, err)
+	}
+
+	members, err := client.SInter("set1", "set2")
+	if err != nil || !reflect.DeepEqual(members, []string{"b c"}) {
+		t.Fatalf("Expected: [b c]. Got: %q, %v", members, err)
+	}
+	if n, err := client.SUnionStore("union", "set1", "set2"); err != nil || n != 3 {
+		t.Fatalf("Expected: 3. Got: %d, %v", n, err)
+	}
+	if n, err := client.SCard("union"); err != nil || n != 3 {
+		t.Fatalf("Expected: 3. Got: %d, %v", n, err)
+	}
+
+	members, _ = client.SDiff("set1", "set2")
+	if !reflect.DeepEqual(members, []string{"a"}) {
+		t.Fatalf("Expected: [a]. Got: %q", members)
+	}
+	if value, err := client.SPop("set1"); err != nil || (value != "a" && value != "b c") {
+		t.Fatalf("Expected: a or b c. Got: %v, %v", value, err)
+	}
+
+	client.Set("foo", "bar", 0)
+	if _, err := client.SAdd("foo", "a"); err != ErrWrongType {
+		t.Fatalf("Expected ErrWrongType. Got: %v", err)
+	}
+}
+
+func TestZSet(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if n, err := client.ZAdd("board", ZMember{"alice", 10}, ZMember{"bob c", 2.5}); err != nil || n != 2 {
+		t.Fatalf("Expected: 2. Got: %d, %v", n, err)
+	}
+	if score, err := client.ZIncrBy("board", "bob c", 10); err != nil || score != 12.5 {
+		t.Fatalf("Expected: 12.5. Got: %v, %v", score, err)
+	}
+	if score, err := client.ZScore("board", "alice"); err != nil || score != 10 {
+		t.Fatalf("Expected: 10. Got: %v, %v", score, err)
+	}
+	if rank, err := client.ZRank("board", "bob c"); err != nil || rank != 1 {
+		t.Fatalf("Expected: 1. Got: %d, %v", rank, err)
+	}
+
+	members, err := client.ZRange("board", 0, -1)
+	if expected := []ZMember{{"alice", 10}, {"bob c", 12.5}}; err != nil || !reflect.DeepEqual(members, expected) {
+		t.Fatalf("Expected: %v. Got: %v, %v", expected, members, err)
+	}
+	members, err = client.ZRangeByScore("board", math.Inf(-1), 11)
+	if expected := []ZMember{{"alice", 10}}; err != nil || !reflect.DeepEqual(members, expected) {
+		t.Fatalf("Expected: %v. Got: %v, %v", expected, members, err)
+	}
+
+	if first, err := client.ZPopMin("board"); err != nil || first != (ZMember{"alice", 10}) {
+		t.Fatalf("Expected: alice. Got: %v, %v", first, err)
+	}
+	if n, err := client.ZRem("board", "bob c"); err != nil || n != 1 {
+		t.Fatalf("Expected: 1. Got: %d, %v", n, err)
+	}
+	if n, err := client.ZCard("board"); err != nil || n != 0 {
+		t.Fatalf("Expected: 0. Got: %d, %v", n, err)
+	}
+	if _, err := client.ZPopMin("board"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+	if _, err := client.ZScore("board", "alice"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	for i := 0; i < 30; i++ {
+		client.Set("key"+strconv.Itoa(i), "value", 0)
+	}
+	client.Set("other", "value", 0)
+
+	var keys []string
+	it := client.Scan("key*", 4)
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	sort.Strings(keys)
+	if len(keys) != 30 || keys[0] != "key0" {
+		t.Fatalf("Expected 30 keys. Got: %v", keys)
+	}
+}
+
+func TestBLPop(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	if _, _, err := client.BLPop(100*time.Millisecond, "queue"); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound. Got: %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.RPush("queue", "job")
+	}()
+
+	key, value, err := client.BLPop(2*time.Second, "other", "queue")
+	if err != nil || key != "queue" || value != "job" {
+		t.Fatalf("Expected: queue job. Got: %v %v, %v", key, value, err)
+	}
+}
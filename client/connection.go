@@ -17,55 +17,157 @@ var (
 	replyNotFound     = "NOT_FOUND"
 	replyAuthRequired = "AUTH_REQUIRED"
 	replyBadFormat    = "BAD_FORMAT"
+	replyReadOnly     = "READONLY"
+	replyNotInteger   = "NOT_INTEGER"
+	replyNotFloat     = "NOT_FLOAT"
+	replyOverflow     = "OVERFLOW"
+	replyWrongType    = "WRONG_TYPE"
+	replyNotStored    = "NOT_STORED"
+	replyConflict     = "CONFLICT"
+	replyQueued       = "QUEUED"
+	replyResults      = "RESULTS"
+	replyAborted      = "ABORTED"
+	replyExecAbort    = "EXECABORT"
+	replyMaxClients   = "MAX_CLIENTS"
+	replyNoPerm       = "NOPERM"
 
 	ErrNotFound     = errors.New("Key not found")
 	ErrSyntax       = errors.New("Syntax error")
 	ErrServer       = errors.New("Server error")
 	ErrAuthRequired = errors.New("Authentication required")
 	ErrBadFormat    = errors.New("Bad format")
+	ErrReadOnly     = errors.New("Server is read-only replica")
+	ErrNotInteger   = errors.New("Value is not an integer")
+	ErrNotFloat     = errors.New("Value is not a valid float")
+	ErrOverflow     = errors.New("Increment or decrement would overflow")
+	ErrWrongType    = errors.New("Operation against a key holding the wrong kind of value")
+	ErrNotStored    = errors.New("Value is not stored")
+	ErrConflict     = errors.New("Value was changed since it was read")
+	ErrAborted      = errors.New("Transaction aborted, watched key was changed")
+	ErrExecAbort    = errors.New("Transaction discarded because of previous errors")
+	ErrMaxClients   = errors.New("Max number of clients reached")
+	ErrNoPerm       = errors.New("User has no permissions to run command or access key")
 )
 
 // connection is wrapper for net.Conn and contains logic about logde protocol.
 // connection isn`t thread-safety. Each request must use separate connection (via pool).
+// Reader is kept with connection, so replies read ahead aren't lost between requests.
 type connection struct {
-	c net.Conn
+	c      net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+	// broken is set when reading or writing fails, such connection can't be reused.
+	broken bool
 }
 
 // newConnection returns new connection instance.
 func newConnection(c net.Conn) *connection {
 	return &connection{
-		c: c,
+		c:      c,
+		reader: bufio.NewReader(c),
+		writer: bufio.NewWriter(c),
 	}
 }
 
 // send sends commands and arguments to server.
 func (c *connection) send(operation string, args []interface{}, data interface{}) ([]string, error) {
-	buf := bufio.NewWriter(c.c)
+	c.write(operation, args, data)
 
-	buf.WriteString(operation)
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	return c.parseResponse()
+}
+
+// flush writes buffered requests to server.
+func (c *connection) flush() error {
+	if err := c.writer.Flush(); err != nil {
+		c.broken = true
+		return err
+	}
+
+	return nil
+}
+
+// write adds request to write buffer. Buffer is written to server when it is full or flushed.
+func (c *connection) write(operation string, args []interface{}, data interface{}) {
+	c.writer.WriteString(operation)
 	for _, arg := range args {
-		buf.WriteString(fmt.Sprintf(" %v", arg))
+		c.writer.WriteString(fmt.Sprintf(" %v", arg))
 
 	}
-	buf.WriteString("\r\n")
+	c.writer.WriteString("\r\n")
+
+	switch data := data.(type) {
+	case nil:
+	case []string:
+		// several data blocks
+		for _, value := range data {
+			c.writer.WriteString(value)
+			c.writer.WriteString("\r\n")
+		}
+	default:
+		c.writer.WriteString(fmt.Sprintf("%v\r\n", data))
+	}
+}
 
-	if data != nil {
-		buf.WriteString(fmt.Sprintf("%v\r\n", data))
+// parseResponse reads response from connection and then parses it.
+func (c *connection) parseResponse() ([]string, error) {
+	line, _, err := c.reader.ReadLine()
+	if err != nil {
+		c.broken = true
+		return nil, err
 	}
 
-	if err := buf.Flush(); err != nil {
+	return c.parseReply(string(line))
+}
+
+// parseResults reads reply of EXEC: results of queued operations.
+func (c *connection) parseResults() ([]Result, error) {
+	line, _, err := c.reader.ReadLine()
+	if err != nil {
+		c.broken = true
 		return nil, err
 	}
 
-	return c.parseResponse()
+	if string(line) != replyResults {
+		if _, err := c.parseReply(string(line)); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrServer
+	}
+
+	count, _, err := c.reader.ReadLine()
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(count))
+	if err != nil || n < 0 {
+		c.broken = true
+		return nil, ErrServer
+	}
+
+	results := make([]Result, n)
+	for i := range results {
+		values, err := c.parseResponse()
+		if c.broken {
+			return nil, err
+		}
+
+		results[i] = Result{Values: values, Err: err}
+	}
+
+	return results, nil
 }
 
-// parseResponse reads response from connection and then parses it.
-func (c *connection) parseResponse() ([]string, error) {
-	reader := bufio.NewReader(c.c)
-	line, _, _ := reader.ReadLine()
+// parseReply parses reply which starts with line.
+func (c *connection) parseReply(line string) ([]string, error) {
+	reader := c.reader
 
-	switch string(line) {
+	switch line {
 	case replyError:
 		return nil, fmt.Errorf("some error")
 	case replyOK:
@@ -74,14 +176,20 @@ func (c *connection) parseResponse() ([]string, error) {
 		// reading next line containing number of values
 		values, _, err := reader.ReadLine()
 		if err != nil {
+			c.broken = true
 			return nil, fmt.Errorf("Error reading from response: %v", err)
 		}
-		valuesNumber, _ := strconv.Atoi(string(values))
+		valuesNumber, err := strconv.Atoi(string(values))
+		if err != nil || valuesNumber < 0 {
+			c.broken = true
+			return nil, ErrServer
+		}
 
 		result := make([]string, valuesNumber)
 		for i := 0; i < valuesNumber; i++ {
 			value, err := c.readValue(reader)
 			if err != nil {
+				c.broken = true
 				return nil, err
 			}
 
@@ -95,6 +203,32 @@ func (c *connection) parseResponse() ([]string, error) {
 		return nil, ErrAuthRequired
 	case replyBadFormat:
 		return nil, ErrBadFormat
+	case replyReadOnly:
+		return nil, ErrReadOnly
+	case replyNotInteger:
+		return nil, ErrNotInteger
+	case replyNotFloat:
+		return nil, ErrNotFloat
+	case replyOverflow:
+		return nil, ErrOverflow
+	case replyWrongType:
+		return nil, ErrWrongType
+	case replyNotStored:
+		return nil, ErrNotStored
+	case replyConflict:
+		return nil, ErrConflict
+	case replyQueued:
+		return nil, nil
+	case replyAborted:
+		return nil, ErrAborted
+	case replyExecAbort:
+		return nil, ErrExecAbort
+	case replyNoPerm:
+		return nil, ErrNoPerm
+	case replyMaxClients:
+		// server closes rejected connection
+		c.broken = true
+		return nil, ErrMaxClients
 	default:
 		return nil, ErrServer
 	}
@@ -107,6 +241,10 @@ func (c *connection) readValue(r *bufio.Reader) ([]byte, error) {
 		return nil, err
 	}
 
-	length, _ := strconv.Atoi(string(lengthLine))
+	length, err := strconv.Atoi(string(lengthLine))
+	if err != nil || length < 0 {
+		return nil, ErrServer
+	}
+
 	return ioutil.Read(r, length)
 }
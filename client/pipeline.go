@@ -0,0 +1,137 @@
+package client
+
+import "time"
+
+// Result is result of operation executed in pipeline. Values contains reply of GET, HGET
+// and HGETALL operations, Err is error returned by server for this operation, e.g. ErrNotFound.
+type Result struct {
+	Values []string
+	Err    error
+}
+
+// Value returns single value of GET or HGET operation.
+func (r Result) Value() (interface{}, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if len(r.Values) == 0 {
+		return nil, ErrServer
+	}
+
+	return r.Values[0], nil
+}
+
+type pipelineOperation struct {
+	operation string
+	arguments []interface{}
+	data      interface{}
+}
+
+// Pipeline queues operations and sends them to server at once on single connection, so they
+// cost one round trip instead of one per operation. Pipeline isn't safe for concurrent use.
+//
+//	p := client.Pipeline()
+//	p.Set("foo", "bar", 0)
+//	p.Get("foo")
+//	results, err := p.Exec()
+type Pipeline struct {
+	client     *Client
+	operations []pipelineOperation
+}
+
+// Pipeline returns new empty pipeline.
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{
+		client: c,
+	}
+}
+
+func (p *Pipeline) add(operation string, arguments []interface{}, data interface{}) {
+	p.operations = append(p.operations, pipelineOperation{operation, arguments, data})
+}
+
+// Len returns number of queued operations.
+func (p *Pipeline) Len() int {
+	return len(p.operations)
+}
+
+// Get queues GET operation.
+func (p *Pipeline) Get(key string) {
+	p.add(operationGet, args(key), nil)
+}
+
+// Set queues SET operation.
+func (p *Pipeline) Set(key, value string, ttl int64) {
+	p.add(operationSet, args(key, ttl, len(value)), value)
+}
+
+// HSet queues HSET operation.
+func (p *Pipeline) HSet(key, field, value string) {
+	p.add(operationHSet, args(key, field, len(value)), value)
+}
+
+// HGet queues HGET operation.
+func (p *Pipeline) HGet(key, field string) {
+	p.add(operationHGet, args(key, field), nil)
+}
+
+// HGetAll queues HGETALL operation.
+func (p *Pipeline) HGetAll(key string) {
+	p.add(operationHGetAll, args(key), nil)
+}
+
+// Delete queues DELETE operation.
+func (p *Pipeline) Delete(key string) {
+	p.add(operationDelete, args(key), nil)
+}
+
+// Exec sends queued operations and returns their results in the same order. Error is returned
+// only if connection fails, errors of single operations are returned in results.
+// Pipeline is empty after Exec, so it can be reused.
+func (p *Pipeline) Exec() ([]Result, error) {
+	operations := p.operations
+	p.operations = nil
+
+	if len(operations) == 0 {
+		return nil, nil
+	}
+
+	conn, err := p.client.conn()
+	if err != nil {
+		return nil, err
+	}
+	defer p.client.pool.put(conn)
+
+	// requests are written concurrently with reading replies, otherwise both sides can
+	// block on full socket buffers when pipeline is long
+	written := make(chan error, 1)
+	go func() {
+		for _, op := range operations {
+			conn.write(op.operation, op.arguments, op.data)
+		}
+		written <- conn.writer.Flush()
+	}()
+
+	results := make([]Result, len(operations))
+	for i := range results {
+		// deadline is prolonged while server keeps replying
+		conn.c.SetDeadline(time.Now().Add(defaultTimeout))
+
+		values, err := conn.parseResponse()
+		if conn.broken {
+			// unblocks writer
+			conn.c.Close()
+			<-written
+			return nil, err
+		}
+
+		results[i] = Result{Values: values, Err: err}
+	}
+
+	if err := <-written; err != nil {
+		conn.broken = true
+		return nil, err
+	}
+
+	return results, nil
+}
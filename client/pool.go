@@ -1,42 +1,77 @@
 package client
 
 import (
+	"crypto/tls"
 	"net"
+	"strings"
 	"time"
 )
 
+// defaultTimeout is deadline for every operation.
+var defaultTimeout = 1 * time.Second
+
+// unixPrefix marks address of unix domain socket.
+const unixPrefix = "unix:"
+
 type pool struct {
 	addr string
-	free chan net.Conn
+	tls  *tls.Config
+	free chan *connection
 }
 
 // newPool
-func newPool(addr string, size int) *pool {
+func newPool(addr string, size int, tlsConfig *tls.Config) *pool {
 	return &pool{
 		addr: addr,
-		free: make(chan net.Conn, size),
+		tls:  tlsConfig,
+		free: make(chan *connection, size),
 	}
 }
 
 // get returns free connection from the pool. If pool is empty then new connection will be created
-func (p *pool) get() (net.Conn, bool, error) {
+func (p *pool) get() (*connection, bool, error) {
 	select {
 	case conn := <-p.free:
-		return connWithDeadline(conn, 1*time.Second), false, nil
+		connWithDeadline(conn.c, defaultTimeout)
+		return conn, false, nil
 	default:
-		conn, err := net.Dial("tcp", p.addr)
-		return connWithDeadline(conn, 1*time.Second), true, err
+		conn, err := p.dial()
+		if err != nil {
+			return nil, true, err
+		}
+
+		return newConnection(connWithDeadline(conn, defaultTimeout)), true, nil
+	}
+}
+
+// dial connects to server, TLS handshake is completed before connection is returned.
+// Address unix:/path/to.sock is path of unix domain socket.
+func (p *pool) dial() (net.Conn, error) {
+	network, addr := "tcp", p.addr
+	if strings.HasPrefix(addr, unixPrefix) {
+		network, addr = "unix", strings.TrimPrefix(addr, unixPrefix)
 	}
+
+	if p.tls == nil {
+		return net.Dial(network, addr)
+	}
+
+	return tls.DialWithDialer(&net.Dialer{Timeout: defaultTimeout}, network, addr, p.tls)
 }
 
-// put returns connection to pool
-func (p *pool) put(conn net.Conn) {
+// put returns connection to pool. Broken connections are closed.
+func (p *pool) put(conn *connection) {
+	if conn.broken {
+		conn.c.Close()
+		return
+	}
+
 	select {
 	case p.free <- conn:
 		return
 	default:
 		// queue is full, so this connection isn`t required anymore
-		conn.Close()
+		conn.c.Close()
 	}
 }
 
@@ -44,7 +79,7 @@ func (p *pool) put(conn net.Conn) {
 func (p *pool) close() {
 	close(p.free)
 	for conn := range p.free {
-		conn.Close()
+		conn.c.Close()
 	}
 }
 
@@ -0,0 +1,82 @@
+package client
+
+// ScanIterator iterates over keys of server with SCAN command. Keys which exist during the whole
+// iteration are returned exactly once, keys added or removed meanwhile may be returned or not.
+//
+//	it := c.Scan("user:*", 100)
+//	for it.Next() {
+//		fmt.Println(it.Key())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type ScanIterator struct {
+	client *Client
+	match  string
+	count  int
+
+	cursor string
+	keys   []string
+	key    string
+	done   bool
+	err    error
+}
+
+// Scan returns iterator over keys matching glob pattern, empty pattern matches all keys. Pattern can't
+// contain spaces. Count is number of keys fetched by one request, zero means server default.
+func (c *Client) Scan(match string, count int) *ScanIterator {
+	return &ScanIterator{
+		client: c,
+		match:  match,
+		count:  count,
+		cursor: "0",
+	}
+}
+
+// Next advances iterator to the next key. It returns false when there are no more keys or error occurred.
+func (it *ScanIterator) Next() bool {
+	// server can reply with no keys, e.g. when none of them matches pattern
+	for len(it.keys) == 0 {
+		if it.done || it.err != nil {
+			return false
+		}
+		it.fetch()
+	}
+
+	it.key, it.keys = it.keys[0], it.keys[1:]
+
+	return true
+}
+
+// Key returns current key.
+func (it *ScanIterator) Key() string {
+	return it.key
+}
+
+// Err returns error which stopped iteration.
+func (it *ScanIterator) Err() error {
+	return it.err
+}
+
+func (it *ScanIterator) fetch() {
+	arguments := args(it.cursor)
+	if it.match != "" {
+		arguments = append(arguments, "MATCH", it.match)
+	}
+	if it.count > 0 {
+		arguments = append(arguments, "COUNT", it.count)
+	}
+
+	result, err := it.client.call(operationScan, arguments, nil)
+	if err != nil {
+		it.err = err
+		return
+	}
+	if len(result) == 0 {
+		it.err = ErrServer
+		return
+	}
+
+	it.cursor, it.keys = result[0], result[1:]
+	it.done = it.cursor == "0"
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var ErrServerSignature = errors.New("Server failed SCRAM authentication")
+
+// Iteration counts accepted from server. RFC 7677 requires at least 4096 iterations, too many of them
+// would make client spend long time deriving keys.
+const (
+	minSCRAMIterations = 4096
+	maxSCRAMIterations = 1 << 20
+)
+
+// scramCredentials are keys derived from password and salt sent by server.
+type scramCredentials struct {
+	salt       string
+	iterations int
+	clientKey  []byte
+	storedKey  []byte
+	serverKey  []byte
+}
+
+// authenticateSCRAM authenticates connection with SCRAM-SHA-256, so password isn't sent to server.
+// Signature of server is verified, so server must know credentials of user too.
+func (c *Client) authenticateSCRAM(conn *connection) error {
+	nonce := make([]byte, 18)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	clientNonce := base64.StdEncoding.EncodeToString(nonce)
+	clientFirstBare := "n=" + encodeSCRAMName(c.username) + ",r=" + clientNonce
+	values, err := conn.send(operationSCRAM, args("n,,"+clientFirstBare), nil)
+	if err != nil {
+		return err
+	}
+	if len(values) != 1 {
+		return ErrServerSignature
+	}
+
+	// r=nonce,s=salt,i=iterations, nonce of server starts with nonce of client
+	serverFirst := values[0]
+	attributes := strings.Split(serverFirst, ",")
+	if len(attributes) < 3 || !strings.HasPrefix(attributes[0], "r="+clientNonce) ||
+		!strings.HasPrefix(attributes[1], "s=") || !strings.HasPrefix(attributes[2], "i=") {
+		return ErrServerSignature
+	}
+	iterations, err := strconv.Atoi(attributes[2][2:])
+	if err != nil || iterations < minSCRAMIterations || iterations > maxSCRAMIterations {
+		return ErrServerSignature
+	}
+	credentials, err := c.scramCredentials(attributes[1][2:], iterations)
+	if err != nil {
+		return ErrServerSignature
+	}
+
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + "," + attributes[0]
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+	proof := scramHMAC(credentials.storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= credentials.clientKey[i]
+	}
+
+	values, err = conn.send(operationSCRAM, args(withoutProof+",p="+base64.StdEncoding.EncodeToString(proof)), nil)
+	if err != nil {
+		return err
+	}
+
+	signature := "v=" + base64.StdEncoding.EncodeToString(scramHMAC(credentials.serverKey, authMessage))
+	if len(values) != 1 || !hmac.Equal([]byte(values[0]), []byte(signature)) {
+		return ErrServerSignature
+	}
+
+	return nil
+}
+
+// scramCredentials returns keys of password for salt. Keys of the last salt are kept, so they aren't
+// derived again for every new connection.
+func (c *Client) scramCredentials(salt string, iterations int) (*scramCredentials, error) {
+	c.scramLock.Lock()
+	defer c.scramLock.Unlock()
+
+	if c.scram != nil && c.scram.salt == salt && c.scram.iterations == iterations {
+		return c.scram, nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	salted := pbkdf2.Key([]byte(c.password), decoded, iterations, sha256.Size, sha256.New)
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+
+	c.scram = &scramCredentials{
+		salt:       salt,
+		iterations: iterations,
+		clientKey:  clientKey,
+		storedKey:  storedKey[:],
+		serverKey:  scramHMAC(salted, "Server Key"),
+	}
+
+	return c.scram, nil
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// encodeSCRAMName replaces , and = in user name with =2C and =3D.
+func encodeSCRAMName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
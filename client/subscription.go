@@ -0,0 +1,254 @@
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyspacePrefix is prefix of channels which receive keyspace notifications.
+const keyspacePrefix = "__keyspace@0__:"
+
+// Message is message published to channel. Pattern is set if message is received by pattern subscription.
+type Message struct {
+	Pattern string
+	Channel string
+	Payload string
+}
+
+// Subscription receives messages published to channels it is subscribed to. It holds dedicated
+// connection until Close is called. Messages are delivered on channel returned by Messages, which
+// is closed when subscription is closed or connection breaks.
+//
+//	sub, err := c.Subscribe("news")
+//	for msg := range sub.Messages() {
+//		fmt.Println(msg.Channel, msg.Payload)
+//	}
+//	err = sub.Err()
+//
+// Server disconnects subscriber which doesn't keep up with published messages, so messages
+// should be read without long pauses.
+type Subscription struct {
+	conn     *connection
+	messages chan Message
+	// acks receives confirmations of subscription requests.
+	acks   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+	err    error
+
+	// l serializes requests and protects channels and patterns subscription is subscribed to.
+	l        sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// Key returns key of keyspace notification, Payload of such message is event, e.g. set, del or expired.
+func (m Message) Key() string {
+	return strings.TrimPrefix(m.Channel, keyspacePrefix)
+}
+
+// Subscribe returns subscription to channels. Channel names can't contain spaces.
+func (c *Client) Subscribe(channels ...string) (*Subscription, error) {
+	return c.subscribe(operationSubscribe, channels)
+}
+
+// PSubscribe returns subscription to channels matching glob patterns. Patterns can't contain spaces.
+func (c *Client) PSubscribe(patterns ...string) (*Subscription, error) {
+	return c.subscribe(operationPSubscribe, patterns)
+}
+
+// SubscribeKeyspace returns subscription to keyspace notifications of keys matching glob pattern.
+// Server must be started with keyspace notifications enabled.
+func (c *Client) SubscribeKeyspace(pattern string) (*Subscription, error) {
+	return c.subscribe(operationPSubscribe, []string{keyspacePrefix + pattern})
+}
+
+func (c *Client) subscribe(operation string, names []string) (*Subscription, error) {
+	if len(names) == 0 {
+		return nil, ErrSyntax
+	}
+
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	// subscription waits for messages as long as it is open
+	conn.c.SetDeadline(time.Time{})
+
+	s := &Subscription{
+		conn:     conn,
+		messages: make(chan Message, 100),
+		acks:     make(chan struct{}),
+		closed:   make(chan struct{}),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+	go s.read()
+
+	if err := s.send(operation, names); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Messages returns channel of received messages.
+func (s *Subscription) Messages() <-chan Message {
+	return s.messages
+}
+
+// Subscribe adds channels to subscription.
+func (s *Subscription) Subscribe(channels ...string) error {
+	return s.send(operationSubscribe, channels)
+}
+
+// PSubscribe adds patterns to subscription.
+func (s *Subscription) PSubscribe(patterns ...string) error {
+	return s.send(operationPSubscribe, patterns)
+}
+
+// Unsubscribe removes channels from subscription, all channels if none is passed. Messages received
+// before server confirmed unsubscription can still be in channel of messages.
+func (s *Subscription) Unsubscribe(channels ...string) error {
+	return s.send(operationUnsubscribe, channels)
+}
+
+// PUnsubscribe removes patterns from subscription, all patterns if none is passed.
+func (s *Subscription) PUnsubscribe(patterns ...string) error {
+	return s.send(operationPUnsubscribe, patterns)
+}
+
+// Close closes connection of subscription.
+func (s *Subscription) Close() error {
+	return s.closeWith(nil)
+}
+
+// closeWith closes connection, err is kept as reason if subscription hasn't been closed yet.
+func (s *Subscription) closeWith(reason error) error {
+	var err error
+	s.once.Do(func() {
+		s.err = reason
+		close(s.closed)
+		err = s.conn.c.Close()
+	})
+
+	return err
+}
+
+// Err returns error which broke subscription. It must be called after channel of messages is closed,
+// nil is returned if subscription was closed by Close.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// send sends subscription request and waits until server confirms it, so messages published
+// afterwards are received, or aren't received after unsubscription.
+func (s *Subscription) send(operation string, names []string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	own := s.channels
+	if operation == operationPSubscribe || operation == operationPUnsubscribe {
+		own = s.patterns
+	}
+	subscribe := operation == operationSubscribe || operation == operationPSubscribe
+
+	// server confirms every name, unsubscription from nothing is confirmed as well
+	confirmations := len(names)
+	switch {
+	case subscribe && len(names) == 0:
+		return ErrSyntax
+	case len(names) == 0:
+		confirmations = len(own)
+		if confirmations == 0 {
+			confirmations = 1
+		}
+	}
+
+	s.conn.c.SetWriteDeadline(time.Now().Add(defaultTimeout))
+	s.conn.write(operation, keyArgs(names), nil)
+	if err := s.conn.flush(); err != nil {
+		s.closeWith(err)
+		return err
+	}
+
+	timeout := time.After(defaultTimeout)
+	for i := 0; i < confirmations; i++ {
+		select {
+		case <-s.acks:
+		case <-s.closed:
+			if s.err != nil {
+				return s.err
+			}
+			return ErrServer
+		case <-timeout:
+			s.closeWith(ErrServer)
+			return ErrServer
+		}
+	}
+
+	switch {
+	case subscribe:
+		for _, name := range names {
+			own[name] = struct{}{}
+		}
+	case len(names) == 0:
+		for name := range own {
+			delete(own, name)
+		}
+	default:
+		for _, name := range names {
+			delete(own, name)
+		}
+	}
+
+	return nil
+}
+
+// read receives pushes from server until connection is closed.
+func (s *Subscription) read() {
+	defer close(s.messages)
+
+	for {
+		values, err := s.conn.parseResponse()
+		if err != nil {
+			s.closeWith(err)
+			return
+		}
+
+		var message Message
+		switch {
+		case len(values) == 3 && values[0] == "message":
+			message = Message{Channel: values[1], Payload: values[2]}
+		case len(values) == 4 && values[0] == "pmessage":
+			message = Message{Pattern: values[1], Channel: values[2], Payload: values[3]}
+		case len(values) == 3:
+			// confirmations of subscription requests
+			select {
+			case s.acks <- struct{}{}:
+			case <-s.closed:
+				return
+			}
+			continue
+		default:
+			// replies to PING
+			continue
+		}
+
+		select {
+		case s.messages <- message:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// Publish sends message to channel and returns number of subscribers which received it.
+func (c *Client) Publish(channel, message string) (int, error) {
+	n, err := c.callInteger(operationPublish, args(channel, len(message)), message)
+
+	return int(n), err
+}
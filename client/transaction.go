@@ -0,0 +1,109 @@
+package client
+
+import "time"
+
+// Transaction queues operations like Pipeline does, but server executes them atomically: other
+// clients see either none or all of their changes. If keys are watched, then transaction is
+// aborted when any of them is changed between Client.Transaction and Exec, so value read
+// meanwhile can be safely used to compute new one.
+//
+//	tx, err := c.Transaction("balance")
+//	balance, err := c.Get("balance")
+//	tx.Set("balance", newBalance, 0)
+//	results, err := tx.Exec()
+//	if err == client.ErrAborted {
+//		// balance was changed, try again
+//	}
+//
+// Transaction holds connection until Exec or Discard is called.
+type Transaction struct {
+	Pipeline
+
+	conn *connection
+}
+
+// Transaction returns new empty transaction which watches keys.
+func (c *Client) Transaction(watch ...string) (*Transaction, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(watch) > 0 {
+		if _, err := conn.send(operationWatch, keyArgs(watch), nil); err != nil {
+			c.pool.put(conn)
+			return nil, err
+		}
+	}
+
+	return &Transaction{
+		Pipeline: Pipeline{client: c},
+		conn:     conn,
+	}, nil
+}
+
+// Exec executes queued operations atomically and returns their results in the same order. It returns
+// ErrAborted if watched key was changed and error of the first invalid operation if server rejected it,
+// in both cases nothing is executed. Errors of single operations are returned in results.
+func (t *Transaction) Exec() ([]Result, error) {
+	conn := t.conn
+	defer t.client.pool.put(conn)
+
+	operations := t.operations
+	t.operations = nil
+
+	// requests are written concurrently with reading replies, see Pipeline.Exec
+	connWithDeadline(conn.c, defaultTimeout)
+	written := make(chan error, 1)
+	go func() {
+		conn.write(operationMulti, nil, nil)
+		for _, op := range operations {
+			conn.write(op.operation, op.arguments, op.data)
+		}
+		conn.write(operationExec, nil, nil)
+		written <- conn.writer.Flush()
+	}()
+
+	// replies to MULTI and to queued operations are OK and QUEUED, the first error is kept
+	var queueErr, err error
+	for i := 0; i <= len(operations) && !conn.broken; i++ {
+		conn.c.SetDeadline(time.Now().Add(defaultTimeout))
+
+		if _, err = conn.parseResponse(); err != nil && queueErr == nil {
+			queueErr = err
+		}
+	}
+
+	var results []Result
+	if !conn.broken {
+		results, err = conn.parseResults()
+	}
+	if conn.broken {
+		// unblocks writer
+		conn.c.Close()
+		<-written
+		return nil, err
+	}
+
+	if writeErr := <-written; writeErr != nil {
+		conn.broken = true
+		return nil, writeErr
+	}
+
+	if err == ErrExecAbort && queueErr != nil {
+		return nil, queueErr
+	}
+
+	return results, err
+}
+
+// Discard drops queued operations, forgets watched keys and releases connection.
+func (t *Transaction) Discard() error {
+	defer t.client.pool.put(t.conn)
+
+	t.operations = nil
+	connWithDeadline(t.conn.c, defaultTimeout)
+	_, err := t.conn.send(operationUnwatch, nil, nil)
+
+	return err
+}
@@ -0,0 +1,7 @@
+module github.com/mkabischev/lodge
+
+go 1.17
+
+require golang.org/x/crypto v0.9.0
+
+require golang.org/x/sys v0.8.0 // indirect
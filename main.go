@@ -1,18 +1,63 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/mkabischev/lodge/server"
 	"github.com/mkabischev/lodge/server/lru"
 )
 
 func main() {
-	bindAddr := flag.String("bind", ":20000", "lodge listen address")
-	usersFile := flag.String("users", "", "Path to users file")
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		passwd(os.Args[2:])
+		return
+	}
+
+	bindAddr := flag.String("bind", ":20000", "lodge listen address, unix:/path/to.sock listens on unix domain socket")
+	respBindAddr := flag.String("resp_bind", "", "Listen address for RESP (Redis protocol) clients")
+	memcachedBindAddr := flag.String("memcached_bind", "", "Listen address for memcached text protocol clients")
+	usersFile := flag.String("users", "", "Path to users file, it is reloaded on SIGHUP")
 	buckets := flag.Int("buckets", 100, "Number of buckets")
 	bucketSize := flag.Int("bucket_size", 10000, "Number of elements in each bucket")
+	snapshotFile := flag.String("snapshot", "", "Path to snapshot file")
+	snapshotInterval := flag.Duration("snapshot_interval", 0, "Interval between background snapshots, 0 disables them")
+	aofFile := flag.String("aof", "", "Path to append-only file")
+	aofFsync := flag.String("aof_fsync", "everysec", "Append-only file fsync policy: always, everysec or no")
+	aofTruncate := flag.Bool("aof_truncate", true, "Truncate corrupted tail of append-only file instead of refusing to start")
+	replicaOf := flag.String("replicaof", "", "Address of primary server, host:port. If specified then server starts as replica")
+	primaryUser := flag.String("primary_user", "", "Username for authentication on primary server")
+	primaryPassword := flag.String("primary_password", "", "Password for authentication on primary server")
+	primaryPlainAuth := flag.Bool("primary_plain_auth", false, "Send password to primary with AUTH instead of authenticating with SCRAM")
+	notifications := flag.Bool("notify_keyspace_events", false, "Publish changes of keys to keyspace notification channels")
+	replicaBufferLimit := flag.Int("replica_buffer_limit", 256*1024*1024, "Maximal size of replication stream queued for replica in bytes, 0 means no limit")
+	pubSubBufferLimit := flag.Int("pubsub_buffer_limit", 8*1024*1024, "Maximal size of messages queued for subscriber in bytes, 0 means no limit")
+	shutdownTimeout := flag.Duration("shutdown_timeout", 10*time.Second, "Time to wait for in-flight requests on SIGINT and SIGTERM")
+	saveOnShutdown := flag.Bool("save_on_shutdown", false, "Write snapshot after shutdown, requires -snapshot")
+	timeout := flag.Duration("timeout", time.Second, "Time to receive request after its first byte, 0 means no limit")
+	readTimeout := flag.Duration("read_timeout", 0, "Time limit of every read of request, 0 means no limit")
+	writeTimeout := flag.Duration("write_timeout", 0, "Time limit of every write of replies, 0 means no limit")
+	idleTimeout := flag.Duration("idle_timeout", 0, "Close connections which don't send requests for this long, 0 means never")
+	maxClients := flag.Int("max_clients", 10000, "Maximal number of connected clients, 0 means no limit")
+	outputBufferLimit := flag.Int("output_buffer_limit", 0, "Disconnect clients whose unread replies exceed this size in bytes, 0 means no limit")
+	tlsCert := flag.String("tls_cert", "", "Path to TLS certificate, if it is specified then only TLS connections are accepted")
+	tlsKey := flag.String("tls_key", "", "Path to private key of TLS certificate")
+	tlsCA := flag.String("tls_ca", "", "Path to CA certificates which verify client certificates, users are authenticated by common name")
+	primaryTLS := flag.Bool("primary_tls", false, "Connect to primary with TLS, its certificate is verified with -tls_ca")
+	unixSocketPerm := flag.String("unix_socket_perm", "0770", "Permissions of unix domain socket files, octal")
 	flag.Parse()
 
 	var users *server.UserList
@@ -27,12 +72,231 @@ func main() {
 
 	config := server.DefaultConfig()
 	config.Users = users
+	config.UsersFile = *usersFile
+	config.SnapshotPath = *snapshotFile
+	config.Timeout = *timeout
+	config.ReadTimeout = *readTimeout
+	config.WriteTimeout = *writeTimeout
+	config.IdleTimeout = *idleTimeout
+	config.MaxClients = *maxClients
+	config.OutputBufferLimit = *outputBufferLimit
+	config.ReplicaOf = *replicaOf
+	config.PrimaryUsername = *primaryUser
+	config.PrimaryPassword = *primaryPassword
+	config.PrimaryPlainAuth = *primaryPlainAuth
+
+	perm, err := strconv.ParseUint(*unixSocketPerm, 8, 32)
+	if err != nil {
+		log.Fatalf("Bad -unix_socket_perm %s: %v", *unixSocketPerm, err)
+	}
+	config.UnixSocketPerm = os.FileMode(perm)
+
+	if *tlsCert != "" {
+		config.TLS, config.PrimaryTLS, err = loadTLSConfig(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !*primaryTLS {
+		config.PrimaryTLS = nil
+	} else if config.PrimaryTLS == nil {
+		log.Fatal("-primary_tls requires -tls_cert")
+	}
+	config.PubSubBufferLimit = *pubSubBufferLimit
+	config.ReplicaBufferLimit = *replicaBufferLimit
+	config.KeyspaceNotifications = *notifications
 
 	storage := server.NewBucketStorage(*buckets, func() server.Storage {
 		return server.NewLRUStorage(lru.New(*bucketSize))
 	})
 
+	// append-only file contains all changes, so snapshot is used only when there is no append-only file yet
+	aofExists := false
+	if *aofFile != "" {
+		start := time.Now()
+		err := server.ReplayAppendOnlyFile(*aofFile, storage, *aofTruncate)
+		switch {
+		case err == nil:
+			aofExists = true
+			log.Printf("Append-only file %s loaded in %v", *aofFile, time.Since(start))
+		case os.IsNotExist(err):
+			log.Printf("Append-only file %s not found, it will be created", *aofFile)
+		default:
+			log.Fatalf("Error loading append-only file %s: %v", *aofFile, err)
+		}
+
+		policy, err := server.ParseFsyncPolicy(*aofFsync)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		config.AppendOnly, err = server.OpenAppendOnlyFile(*aofFile, policy)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *snapshotFile != "" && !aofExists {
+		start := time.Now()
+		err := server.LoadSnapshot(*snapshotFile, storage)
+		switch {
+		case err == nil:
+			log.Printf("Snapshot %s loaded in %v", *snapshotFile, time.Since(start))
+		case os.IsNotExist(err):
+			log.Printf("Snapshot %s not found, starting with empty storage", *snapshotFile)
+		default:
+			log.Fatalf("Error loading snapshot %s: %v", *snapshotFile, err)
+		}
+	}
+
+	if *saveOnShutdown && *snapshotFile == "" {
+		log.Fatal("-save_on_shutdown requires -snapshot")
+	}
+
 	srv := server.New(storage, config)
 
-	log.Fatal(srv.ListenAndServe(*bindAddr))
+	// new append-only file must contain data loaded from snapshot
+	if config.AppendOnly != nil && !aofExists {
+		if err := srv.RewriteAppendOnlyFile(); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *snapshotFile != "" && *snapshotInterval > 0 {
+		go func() {
+			for _ = range time.Tick(*snapshotInterval) {
+				if err := srv.BackgroundSave(); err != nil {
+					log.Printf("Background save: %v", err)
+				}
+			}
+		}()
+	}
+
+	errs := make(chan error, 3)
+	serve := func(listen func(string) error, addr string) {
+		go func() {
+			if err := listen(addr); err != server.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+
+	serve(srv.ListenAndServe, *bindAddr)
+	if *respBindAddr != "" {
+		serve(srv.ListenAndServeRESP, *respBindAddr)
+	}
+	if *memcachedBindAddr != "" {
+		serve(srv.ListenAndServeMemcached, *memcachedBindAddr)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+
+loop:
+	for {
+		select {
+		case err := <-errs:
+			log.Fatal(err)
+		case <-reloads:
+			if err := srv.ReloadUsers(); err != nil {
+				log.Printf("Reloading users failed: %v", err)
+			}
+		case sig := <-signals:
+			log.Printf("Received %v, shutting down", sig)
+			break loop
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+
+	if *saveOnShutdown {
+		start := time.Now()
+		if err := srv.Save(); err != nil {
+			log.Fatalf("Final save failed: %v", err)
+		}
+		log.Printf("Snapshot %s saved in %v", *snapshotFile, time.Since(start))
+	}
+
+	if config.AppendOnly != nil {
+		if err := config.AppendOnly.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// loadTLSConfig returns configuration of server and of connection to primary. Client certificates
+// are verified with CA if it is specified, server certificate is presented to primary.
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, *tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	primary := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("No certificates found in %s", caFile)
+		}
+
+		// clients without certificate authenticate with AUTH
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		primary.RootCAs = pool
+	}
+
+	return config, primary, nil
+}
+
+// passwd prints line of users file for user, password is read from standard input.
+func passwd(args []string) {
+	flags := flag.NewFlagSet("passwd", flag.ExitOnError)
+	scheme := flags.String("scheme", "bcrypt", "Password hash: bcrypt, sha256, sha512, argon2id or scram-sha-256")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: lodge passwd [-scheme=bcrypt] username [rule ...] < password")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	user := flags.Arg(0)
+	if user == "" || strings.ContainsAny(user, ": \t") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal(err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		log.Fatal("Empty password")
+	}
+
+	hash, err := server.HashPassword(password, *scheme)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(strings.Join(append([]string{user + ":" + hash}, flags.Args()[1:]...), " "))
 }
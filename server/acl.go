@@ -0,0 +1,398 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ACL. Lines of users file can have rules after password, separated by spaces:
+//
+//	alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= +@read +@write ~tenantA:*
+//
+// +@read, +@write and +@admin allow categories of commands, +@all allows all of them. ~pattern allows
+// keys matching glob pattern. User without rules can run every command on every key, so users files
+// without rules keep working. KEYS and SCAN can return any key, so they require ~* pattern.
+// Subscribers and publishers need read and write categories. Keyspace notifications of key can be
+// subscribed only with access to key, other notifications require ~* pattern, same as publishing them.
+
+var errNoPerm = errors.New("User has no permissions to run command or access key")
+
+type category uint8
+
+const (
+	categoryRead category = 1 << iota
+	categoryWrite
+	categoryAdmin
+
+	categoryAll = categoryRead | categoryWrite | categoryAdmin
+)
+
+var categoryNames = []struct {
+	name     string
+	category category
+}{
+	{"all", categoryAll},
+	{"read", categoryRead},
+	{"write", categoryWrite},
+	{"admin", categoryAdmin},
+}
+
+// commandCategories are categories required by commands. Commands with zero category are allowed
+// to every authenticated user, commands which aren't listed require admin category.
+var commandCategories = map[string]category{
+	"GET":           categoryRead,
+	"GETS":          categoryRead,
+	"HGET":          categoryRead,
+	"HGETALL":       categoryRead,
+	"KEYS":          categoryRead,
+	"SCAN":          categoryRead,
+	"HSCAN":         categoryRead,
+	"TTL":           categoryRead,
+	"LRANGE":        categoryRead,
+	"LLEN":          categoryRead,
+	"LINDEX":        categoryRead,
+	"SISMEMBER":     categoryRead,
+	"SMEMBERS":      categoryRead,
+	"SCARD":         categoryRead,
+	"SRANDMEMBER":   categoryRead,
+	"SINTER":        categoryRead,
+	"SUNION":        categoryRead,
+	"SDIFF":         categoryRead,
+	"ZSCORE":        categoryRead,
+	"ZRANK":         categoryRead,
+	"ZRANGE":        categoryRead,
+	"ZRANGEBYSCORE": categoryRead,
+	"ZCARD":         categoryRead,
+	"WATCH":         categoryRead,
+	"SUBSCRIBE":     categoryRead,
+	"PSUBSCRIBE":    categoryRead,
+	"SET":           categoryWrite,
+	"SETNX":         categoryWrite,
+	"GETSET":        categoryWrite,
+	"CAS":           categoryWrite,
+	"HSET":          categoryWrite,
+	"DELETE":        categoryWrite,
+	"EXPIRE":        categoryWrite,
+	"EXPIREAT":      categoryWrite,
+	"INCR":          categoryWrite,
+	"DECR":          categoryWrite,
+	"INCRBY":        categoryWrite,
+	"DECRBY":        categoryWrite,
+	"HINCRBY":       categoryWrite,
+	"LPUSH":         categoryWrite,
+	"RPUSH":         categoryWrite,
+	"LPOP":          categoryWrite,
+	"RPOP":          categoryWrite,
+	"LTRIM":         categoryWrite,
+	"BLPOP":         categoryWrite,
+	"BRPOP":         categoryWrite,
+	"SADD":          categoryWrite,
+	"SREM":          categoryWrite,
+	"SPOP":          categoryWrite,
+	"SINTERSTORE":   categoryWrite,
+	"SUNIONSTORE":   categoryWrite,
+	"SDIFFSTORE":    categoryWrite,
+	"ZADD":          categoryWrite,
+	"ZREM":          categoryWrite,
+	"ZINCRBY":       categoryWrite,
+	"ZPOPMIN":       categoryWrite,
+	"PUBLISH":       categoryWrite,
+	"SAVE":          categoryAdmin,
+	"BGSAVE":        categoryAdmin,
+	"BGREWRITEAOF":  categoryAdmin,
+	"INFO":          categoryAdmin,
+	"REPLICAOF":     categoryAdmin,
+	"CONFIG":        categoryAdmin,
+	"SYNC":          categoryAdmin,
+	"ACL LIST":      categoryAdmin,
+	"PING":          0,
+	"ACL WHOAMI":    0,
+}
+
+// permissions are categories of commands and patterns of keys allowed to user.
+type permissions struct {
+	categories category
+	patterns   []string
+}
+
+// allPermissions are permissions of users without rules.
+var allPermissions = &permissions{categories: categoryAll, patterns: []string{"*"}}
+
+// parsePermissions parses ACL rules of user.
+func parsePermissions(rules []string) (*permissions, error) {
+	if len(rules) == 0 {
+		return allPermissions, nil
+	}
+
+	p := &permissions{}
+	for _, rule := range rules {
+		switch {
+		case strings.HasPrefix(rule, "~") && len(rule) > 1:
+			p.patterns = append(p.patterns, rule[1:])
+		case strings.HasPrefix(rule, "+@"):
+			c, ok := parseCategory(rule[2:])
+			if !ok {
+				return nil, fmt.Errorf("Unknown category %s", rule)
+			}
+			p.categories |= c
+		default:
+			return nil, fmt.Errorf("Unknown rule %s", rule)
+		}
+	}
+
+	return p, nil
+}
+
+func parseCategory(name string) (category, bool) {
+	for _, c := range categoryNames {
+		if c.name == name {
+			return c.category, true
+		}
+	}
+
+	return 0, false
+}
+
+// String returns rules of permissions.
+func (p *permissions) String() string {
+	var rules []string
+	if p.categories == categoryAll {
+		rules = append(rules, "+@all")
+	} else {
+		for _, c := range categoryNames[1:] {
+			if p.categories&c.category != 0 {
+				rules = append(rules, "+@"+c.name)
+			}
+		}
+	}
+
+	for _, pattern := range p.patterns {
+		rules = append(rules, "~"+pattern)
+	}
+
+	return strings.Join(rules, " ")
+}
+
+// allows reports whether command of category can be run on keys. all is set if command can use any key.
+func (p *permissions) allows(c category, keys []string, all bool) bool {
+	if p.categories&c != c {
+		return false
+	}
+
+	if all {
+		return p.allowsKey("*", true)
+	}
+
+	for _, key := range keys {
+		if !p.allowsKey(key, false) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allowsKey reports whether key matches any of patterns, pattern must be equal to key if exact is set.
+func (p *permissions) allowsKey(key string, exact bool) bool {
+	for _, pattern := range p.patterns {
+		if pattern == key || (!exact && matchGlob(pattern, key)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globChars are characters which have special meaning in glob patterns.
+const globChars = "*?[\\"
+
+// allowsKeyPattern reports whether every key matching glob pattern is allowed.
+func (p *permissions) allowsKeyPattern(pattern string) bool {
+	for _, allowed := range p.patterns {
+		if allowed == pattern {
+			return true
+		}
+		// keys matching pattern which starts with literal prefix of allowed pattern prefix* have that prefix
+		prefix := strings.TrimSuffix(allowed, "*")
+		if prefix != allowed && !strings.ContainsAny(prefix, globChars) && strings.HasPrefix(pattern, prefix) {
+			return true
+		}
+	}
+
+	if !strings.ContainsAny(pattern, globChars) {
+		return p.allowsKey(pattern, false)
+	}
+
+	return false
+}
+
+// allowsChannel reports whether channel can be subscribed, channel is glob pattern if pattern is set.
+func (p *permissions) allowsChannel(channel string, pattern bool) bool {
+	if strings.HasPrefix(channel, keyspacePrefix) {
+		key := channel[len(keyspacePrefix):]
+		if pattern {
+			return p.allowsKeyPattern(key)
+		}
+		return p.allowsKey(key, false)
+	}
+
+	if notificationChannel(channel, pattern) {
+		return p.allowsKey("*", true)
+	}
+
+	return true
+}
+
+// notificationChannel reports whether channel is channel of keyspace notifications. If pattern is set,
+// it reports whether channel is pattern which can match such channels.
+func notificationChannel(channel string, pattern bool) bool {
+	literal := channel
+	if i := strings.IndexAny(channel, globChars); pattern && i >= 0 {
+		literal = channel[:i]
+	}
+
+	for _, prefix := range []string{keyspacePrefix, keyeventPrefix} {
+		if strings.HasPrefix(literal, prefix) || (pattern && strings.HasPrefix(prefix, literal)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorize checks that user of connection can run command on keys. all is set if command can use any key.
+func (s *Server) authorize(conn *connection, command string, keys []string, all bool) error {
+	users := s.userList()
+	if users == nil {
+		return nil
+	}
+
+	c, ok := commandCategories[command]
+	if !ok {
+		c = categoryAdmin
+	}
+
+	if !users.permissions(conn.user).allows(c, keys, all) {
+		return errNoPerm
+	}
+
+	return nil
+}
+
+// authorizeChannels checks that user of connection can run SUBSCRIBE or PSUBSCRIBE command on channels.
+func (s *Server) authorizeChannels(conn *connection, command string, channels []string) error {
+	if err := s.authorize(conn, command, nil, false); err != nil {
+		return err
+	}
+
+	users := s.userList()
+	if users == nil {
+		return nil
+	}
+
+	p := users.permissions(conn.user)
+	for _, channel := range channels {
+		if !p.allowsChannel(channel, command == "PSUBSCRIBE") {
+			return errNoPerm
+		}
+	}
+
+	return nil
+}
+
+// authorizeRequest checks that user of connection can run command of request. Data of rejected
+// request is read, so it isn't taken for next request.
+func (s *Server) authorizeRequest(conn *connection, cmd command, r *request) error {
+	keys, all, _ := requestKeys(cmd, r)
+
+	err := s.authorize(conn, r.command, keys, all)
+	// only users with access to every key can publish keyspace notifications
+	if _, ok := cmd.(publishCommand); ok && err == nil && notificationChannel(r.arguments[0], false) {
+		err = s.authorize(conn, r.command, nil, true)
+	}
+	if err != nil {
+		if valuesErr := skipValues(cmd, r); valuesErr != nil {
+			return valuesErr
+		}
+	}
+
+	return err
+}
+
+// skipValues reads data blocks of request if command has them. Arguments must be already validated.
+func skipValues(cmd command, r *request) error {
+	c, ok := cmd.(dataCommand)
+	if !ok {
+		return nil
+	}
+
+	lengths, err := c.lengths(r.arguments)
+	if err != nil {
+		return nil
+	}
+
+	_, err = r.values(lengths)
+	return err
+}
+
+// acl handles ACL WHOAMI and ACL LIST. WHOAMI replies with name of user, LIST with rules of every user.
+func (s *Server) acl(conn *connection, arguments []string) ([]string, error) {
+	if !conn.authenticated {
+		return nil, errAuthRequired
+	}
+	if len(arguments) != 1 {
+		return nil, errArguments
+	}
+
+	subcommand := "ACL " + strings.ToUpper(arguments[0])
+	if _, ok := commandCategories[subcommand]; !ok {
+		return nil, errArguments
+	}
+	if err := s.authorize(conn, subcommand, nil, false); err != nil {
+		return nil, err
+	}
+
+	if subcommand == "ACL WHOAMI" {
+		if conn.user == "" {
+			return []string{defaultUser}, nil
+		}
+		return []string{conn.user}, nil
+	}
+
+	users := s.userList()
+	if users == nil {
+		return []string{defaultUser + " " + allPermissions.String()}, nil
+	}
+
+	return users.list(), nil
+}
+
+// defaultUser is name of user when server doesn't require authentication.
+const defaultUser = "default"
+
+// permissions returns permissions of user.
+func (h *UserList) permissions(user string) *permissions {
+	if p, ok := h.acl[user]; ok {
+		return p
+	}
+
+	return &permissions{}
+}
+
+// list returns users with their rules sorted by name.
+func (h *UserList) list() []string {
+	names := make([]string, 0, len(h.acl))
+	for name := range h.acl {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + " " + h.acl[name].String()
+	}
+
+	return lines
+}
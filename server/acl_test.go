@@ -0,0 +1,222 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParsePermissions(t *testing.T) {
+	for _, test := range []struct {
+		rules    string
+		expected string
+	}{
+		{"", "+@all ~*"},
+		{"+@read ~tenantA:*", "+@read ~tenantA:*"},
+		{"+@write +@read +@admin", "+@all"},
+		{"+@admin ~a ~b", "+@admin ~a ~b"},
+	} {
+		p, err := parsePermissions(strings.Fields(test.rules))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.rules, err)
+		}
+		if p.String() != test.expected {
+			t.Fatalf("%s: expected: %s. Got: %s", test.rules, test.expected, p)
+		}
+	}
+
+	for _, rules := range []string{"+@unknown", "~", "-@read", "tenantA:*"} {
+		if _, err := parsePermissions([]string{rules}); err == nil {
+			t.Fatalf("%s: expected error", rules)
+		}
+	}
+}
+
+func TestCommandCategories(t *testing.T) {
+	server := New(NewMemory(0), DefaultConfig())
+	for name := range server.commands {
+		if _, ok := commandCategories[name]; !ok {
+			t.Fatalf("Category of %s isn't specified", name)
+		}
+	}
+}
+
+const testACLUsers = `admin:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= +@read +@write ~tenantA:*
+bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= +@read ~tenantA:* ~shared
+`
+
+// testACLClients returns clients authenticated as users.
+func testACLClients(t *testing.T, users ...string) ([]*testClient, *Server) {
+	config := DefaultConfig()
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString(testACLUsers))
+
+	return testUsersClients(t, config, users...)
+}
+
+// testUsersClients returns clients of server with config authenticated as users.
+func testUsersClients(t *testing.T, config *Config, users ...string) ([]*testClient, *Server) {
+	conn, client, server := testPubSubServer(t, config)
+	clients := []*testClient{{connection: conn}, client}
+	for len(clients) < len(users) {
+		conn, err := net.Dial("tcp", conn.RemoteAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, &testClient{connection: conn})
+	}
+
+	for i, user := range users {
+		clients[i].assertRequest(t, []byte("AUTH "+user+" password\r\n"), resultOK)
+	}
+
+	return clients[:len(users)], server
+}
+
+func TestACL(t *testing.T) {
+	clients, server := testACLClients(t, "admin", "alice", "bob")
+	defer server.Close()
+	admin, alice, bob := clients[0], clients[1], clients[2]
+
+	alice.assertRequest(t, []byte("SET tenantA:x 0 1\r\n1\r\n"), resultOK)
+	alice.assertRequest(t, []byte("GET tenantA:x\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	alice.assertRequest(t, []byte("SET tenantB:x 0 1\r\n1\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("GET tenantB:x\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("SINTERSTORE tenantA:y tenantA:x tenantB:x\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("KEYS\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("INFO\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("PING\r\n"), resultOK)
+
+	// data of rejected request is skipped
+	alice.assertRequest(t, []byte("SET tenantB:x 0 1\r\n1\r\nPING\r\n"), []byte("NOPERM\r\nOK\r\n"))
+
+	bob.assertRequest(t, []byte("GET tenantA:x\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	bob.assertRequest(t, []byte("DELETE tenantA:x\r\n"), resultNoPerm)
+	bob.assertRequest(t, []byte("GET shared\r\n"), resultNotFound)
+	bob.assertRequest(t, []byte("GET shared:x\r\n"), resultNoPerm)
+
+	admin.assertRequest(t, []byte("KEYS\r\n"), []byte("VALUES\r\n1\r\n9\r\ntenantA:x"))
+	admin.assertRequest(t, []byte("SET tenantB:x 0 1\r\n2\r\n"), resultOK)
+
+	// transaction is aborted if any command isn't allowed
+	alice.assertRequest(t, []byte("WATCH tenantB:x\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	alice.assertRequest(t, []byte("SET tenantA:x 0 1\r\n3\r\n"), resultQueued)
+	alice.assertRequest(t, []byte("DELETE tenantB:x\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("EXEC\r\n"), resultExecAbort)
+	alice.assertRequest(t, []byte("GET tenantA:x\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+}
+
+func TestACLCommand(t *testing.T) {
+	clients, server := testACLClients(t, "admin", "alice")
+	defer server.Close()
+	admin, alice := clients[0], clients[1]
+
+	alice.assertRequest(t, []byte("ACL WHOAMI\r\n"), []byte("VALUES\r\n1\r\n5\r\nalice"))
+	alice.assertRequest(t, []byte("ACL LIST\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("ACL USERS\r\n"), resultError)
+
+	admin.assertRequest(t, []byte("ACL LIST\r\n"), []byte("VALUES\r\n3\r\n"+
+		"14\r\nadmin +@all ~*"+
+		"31\r\nalice +@read +@write ~tenantA:*"+
+		"29\r\nbob +@read ~tenantA:* ~shared"))
+}
+
+func TestACLSubscribe(t *testing.T) {
+	users := testACLUsers + "writer:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= +@write ~*\n"
+
+	config := DefaultConfig()
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString(users))
+	sub, _, server := testPubSubServer(t, config)
+	defer server.Close()
+	defer sub.Close()
+
+	client := &testClient{connection: sub}
+	client.assertRequest(t, []byte("AUTH writer password\r\n"), resultOK)
+	client.assertRequest(t, []byte("SUBSCRIBE news\r\n"), resultNoPerm)
+	client.assertRequest(t, []byte("SYNC\r\n"), resultNoPerm)
+}
+
+func TestAllowsChannel(t *testing.T) {
+	p, _ := parsePermissions([]string{"+@read", "~tenantA:*", "~shared"})
+	for _, test := range []struct {
+		channel  string
+		pattern  bool
+		expected bool
+	}{
+		{"news", false, true},
+		{"n*", true, true},
+		{"__keyspace@0__:tenantA:x", false, true},
+		{"__keyspace@0__:tenantB:x", false, false},
+		{"__keyspace@0__:shared", false, true},
+		{"__keyspace@0__:tenantA:*", true, true},
+		{"__keyspace@0__:tenantA:x*", true, true},
+		{"__keyspace@0__:shared", true, true},
+		{"__keyspace@0__:*", true, false},
+		{"__keyspace@0__:tenant?:*", true, false},
+		{"__keyspace@0__:shared*", true, false},
+		{"__keyevent@0__:del", false, false},
+		{"__keyevent@0__:*", true, false},
+		{"__key*", true, false},
+		{"_[_]keyspace@0__:*", true, false},
+		{"*", true, false},
+		{"__keys", false, true},
+		{"__other*", true, true},
+	} {
+		if p.allowsChannel(test.channel, test.pattern) != test.expected {
+			t.Fatalf("%s: expected: %v. Got: %v", test.channel, test.expected, !test.expected)
+		}
+	}
+
+	for _, channel := range []string{"__keyevent@0__:*", "*", "__keyspace@0__:tenantB:x"} {
+		if !allPermissions.allowsChannel(channel, true) {
+			t.Fatalf("%s: expected to be allowed to user with all keys", channel)
+		}
+	}
+}
+
+func TestACLKeyspaceNotifications(t *testing.T) {
+	clients, server := testACLClients(t, "admin", "alice", "bob")
+	defer server.Close()
+	admin, alice, bob := clients[0], clients[1], clients[2]
+
+	alice.assertRequest(t, []byte("PUBLISH __keyspace@0__:tenantA:x 3\r\nset\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("PUBLISH __keyevent@0__:set 9\r\ntenantA:x\r\n"), resultNoPerm)
+	alice.assertRequest(t, []byte("PUBLISH news 5\r\nhello\r\n"), []byte("VALUES\r\n1\r\n1\r\n0"))
+	admin.assertRequest(t, []byte("PUBLISH __keyevent@0__:set 9\r\ntenantA:x\r\n"), []byte("VALUES\r\n1\r\n1\r\n0"))
+
+	bob.assertRequest(t, []byte("SUBSCRIBE __keyspace@0__:tenantB:x\r\n"), resultNoPerm)
+	bob.assertRequest(t, []byte("SUBSCRIBE __keyevent@0__:set\r\n"), resultNoPerm)
+	bob.assertRequest(t, []byte("PSUBSCRIBE __keyspace@0__:*\r\n"), resultNoPerm)
+	bob.assertRequest(t, []byte("PSUBSCRIBE *\r\n"), resultNoPerm)
+
+	bob.connection.Write([]byte("PSUBSCRIBE __keyspace@0__:tenantA:*\r\n"))
+	assertReceived(t, bob.connection, "VALUES\r\n3\r\n10\r\npsubscribe24\r\n__keyspace@0__:tenantA:*1\r\n1")
+}
+
+func TestACLRESP(t *testing.T) {
+	clients, server := testACLClients(t, "alice")
+	defer server.Close()
+
+	// RESP connection authenticates on its own
+	conn, err := net.Dial("tcp", clients[0].connection.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := &testClient{connection: conn}
+
+	client.assertRequest(t, []byte("*3\r\n$4\r\nAUTH\r\n$5\r\nalice\r\n$8\r\npassword\r\n"), []byte("+OK\r\n"))
+	client.assertRequest(t, []byte("*2\r\n$3\r\nGET\r\n$9\r\ntenantB:x\r\n"),
+		[]byte("-NOPERM this user has no permissions to run this command or access its keys\r\n"))
+	client.assertRequest(t, []byte("*2\r\n$3\r\nACL\r\n$6\r\nWHOAMI\r\n"), []byte("$5\r\nalice\r\n"))
+}
+
+func TestACLWithoutUsers(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("ACL WHOAMI\r\n"), []byte("VALUES\r\n1\r\n7\r\ndefault"))
+	client.assertRequest(t, []byte("ACL LIST\r\n"), []byte("VALUES\r\n1\r\n16\r\ndefault +@all ~*"))
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// FsyncPolicy defines how often append-only file is flushed to disk.
+type FsyncPolicy int
+
+const (
+	// FsyncAlways flushes file after every mutation.
+	FsyncAlways FsyncPolicy = iota
+	// FsyncEverySecond flushes file once per second, so at most one second of mutations can be lost.
+	FsyncEverySecond
+	// FsyncNever leaves flushing to operating system.
+	FsyncNever
+)
+
+var (
+	errNoAppendOnly         = errors.New("Append-only file is not configured")
+	errRewriteInProgress    = errors.New("Append-only file rewrite already in progress")
+	errBadAppendOnlyCommand = errors.New("Unexpected command in append-only file")
+)
+
+// journalCommands are commands which can appear in journal records: append-only file and replication stream.
+var journalCommands = map[string]command{
+	"SET":      setCommand{},
+	"HSET":     hSetCommand{},
+	"DELETE":   deleteCommand{},
+	"EXPIRE":   expireCommand{},
+	"EXPIREAT": expireAtCommand{},
+	"INCRBY":   incrByCommand{},
+	"HINCRBY":  hIncrByCommand{},
+	"LPUSH":    pushCommand{left: true},
+	"RPUSH":    pushCommand{},
+	"LPOP":     popCommand{left: true},
+	"RPOP":     popCommand{},
+	"LTRIM":    lTrimCommand{},
+	"SADD":     sAddCommand{},
+	"SREM":     sRemCommand{},
+	"ZADD":     zAddCommand{},
+	"ZREM":     zRemCommand{},
+	"PING":     pingCommand{},
+}
+
+// ParseFsyncPolicy parses policy name: always, everysec or no.
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch name {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySecond, nil
+	case "no":
+		return FsyncNever, nil
+	}
+
+	return 0, fmt.Errorf("Unknown fsync policy %q", name)
+}
+
+// AppendOnlyFile is log of mutations in lodge protocol format.
+type AppendOnlyFile struct {
+	l      sync.Mutex
+	path   string
+	policy FsyncPolicy
+	f      *os.File
+	dirty  bool
+	closed chan struct{}
+}
+
+// OpenAppendOnlyFile opens file for appending. File is created if it doesn't exist.
+func OpenAppendOnlyFile(path string, policy FsyncPolicy) (*AppendOnlyFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	aof := &AppendOnlyFile{
+		path:   path,
+		policy: policy,
+		f:      f,
+		closed: make(chan struct{}),
+	}
+
+	if policy == FsyncEverySecond {
+		go aof.syncEverySecond()
+	}
+
+	return aof, nil
+}
+
+func (a *AppendOnlyFile) append(b []byte) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if _, err := a.f.Write(b); err != nil {
+		return err
+	}
+
+	if a.policy == FsyncAlways {
+		return a.f.Sync()
+	}
+	a.dirty = true
+
+	return nil
+}
+
+func (a *AppendOnlyFile) syncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.l.Lock()
+			if a.dirty {
+				if err := a.f.Sync(); err != nil {
+					log.Printf("Append-only file sync failed: %v", err)
+				}
+				a.dirty = false
+			}
+			a.l.Unlock()
+		case <-a.closed:
+			return
+		}
+	}
+}
+
+// replace atomically replaces log with already synced file f.
+func (a *AppendOnlyFile) replace(f *os.File) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	if err := os.Rename(f.Name(), a.path); err != nil {
+		return err
+	}
+
+	a.f.Close()
+	a.f = f
+	a.dirty = false
+
+	return nil
+}
+
+// Close flushes and closes file.
+func (a *AppendOnlyFile) Close() error {
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	close(a.closed)
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+
+	return a.f.Close()
+}
+
+// rewrite writes minimal set of commands which reproduces current contents of storage to temporary file
+// and then replaces log with it. Mutations made during rewrite are appended to both old and new files.
+func (j *journal) rewrite() error {
+	a := j.aof
+	f, err := os.OpenFile(fmt.Sprintf("%s.%d.rewrite", a.path, os.Getpid()), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	fd := newFeed(0)
+	flush := func() error {
+		_, err := w.Write(fd.take())
+		return err
+	}
+
+	err = j.attach(fd, flush)
+	if err == nil {
+		j.l.Lock()
+		defer j.l.Unlock()
+
+		j.removeFeed(fd)
+		if err = flush(); err == nil {
+			err = w.Flush()
+		}
+		if err == nil {
+			err = f.Sync()
+		}
+		if err == nil {
+			err = a.replace(f)
+		}
+	}
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// ReplayAppendOnlyFile applies commands from file to storage. If file ends with truncated
+// or corrupted command then replay stops there and error is returned, unless truncate is true.
+// In that case file is truncated to the last correct command and nil is returned.
+func ReplayAppendOnlyFile(path string, s Storage, truncate bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	counter := &countingReader{r: f}
+	reader := bufio.NewReader(counter)
+
+	var offset int64
+	for {
+		offset = counter.n - int64(reader.Buffered())
+
+		err = replayCommand(reader, s)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == io.EOF {
+		return nil
+	}
+
+	if !truncate {
+		return fmt.Errorf("Append-only file %s is corrupted at offset %d: %v", path, offset, err)
+	}
+
+	log.Printf("Append-only file %s is corrupted at offset %d: %v. Truncating.", path, offset, err)
+
+	return os.Truncate(path, offset)
+}
+
+// replayCommand reads and applies next command. Storage errors are ignored, because command
+// could succeed originally, but fail now, e.g. EXPIREAT for element which has already expired.
+func replayCommand(reader *bufio.Reader, s Storage) error {
+	request, err := Parse(reader)
+	if err != nil {
+		return err
+	}
+
+	cmd, ok := journalCommands[request.command]
+	if !ok || !validArguments(cmd, len(request.arguments)) {
+		return errBadAppendOnlyCommand
+	}
+
+	_, err = cmd.process(request, s)
+	switch err {
+	case nil, errNotFound, errWrongType:
+		return nil
+	case io.EOF:
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// countingReader counts bytes read from underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/server/lru"
+)
+
+func tempAppendOnlyFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "lodge.aof"), func() { os.RemoveAll(dir) }
+}
+
+func journaledStorage(t *testing.T, path string, policy FsyncPolicy) (*journal, *AppendOnlyFile) {
+	aof, err := OpenAppendOnlyFile(path, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewBucketStorage(10, func() Storage {
+		return NewLRUStorage(lru.New(100))
+	})
+
+	return newJournal(storage, aof), aof
+}
+
+func TestAppendOnlyFileReplay(t *testing.T) {
+	path, cleanup := tempAppendOnlyFile(t)
+	defer cleanup()
+
+	j, aof := journaledStorage(t, path, FsyncAlways)
+	j.Set("foo", "bar", 0)
+	j.Set("expiring", "value", 100)
+	j.Set("deleted", "value", 0)
+	j.Delete("deleted")
+	j.HSet("hash", "field", "com plex\r\nvalue")
+	j.Expire("foo", 100)
+	j.Expire("foo", 0)
+	j.IncrBy("counter", 5)
+	j.IncrBy("counter", -2)
+	j.HIncrBy("hash", "counter", 7)
+	j.RPush("list", "a", "b\r\n", "c")
+	j.LPush("list", "x")
+	j.LPop("list")
+	j.RPop("list")
+	j.SAdd("set", "a", "b", "c")
+	j.SRem("set", "c")
+	j.SPop("set")
+	j.SAdd("source", "x", "y")
+	j.SUnionStore("union", "source", "missing")
+	j.ZAdd("board", ZMember{"alice", 1.5}, ZMember{"bob\r\n", 2}, ZMember{"carol", 3})
+	j.ZIncrBy("board", "alice", 0.25)
+	j.ZPopMin("board")
+	j.ZRem("board", "carol")
+	j.SetIf("nx", "first", 0, false)
+	j.SetIf("nx", "second", 0, false)
+	j.SetIf("xx", "value", 0, true)
+	j.GetSet("foo", "baz")
+	_, version, _ := j.Gets("nx")
+	j.CompareAndSet("nx", "third", 100, version)
+	j.CompareAndSet("nx", "fourth", 0, version)
+	aof.Close()
+
+	storage := NewMemory(time.Minute)
+	if err := ReplayAppendOnlyFile(path, storage, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	assertStorageValue(t, storage, "foo", "baz")
+	assertStorageValue(t, storage, "nx", "third")
+	assertStorageValue(t, storage, "expiring", "value")
+	if _, err := storage.Get("xx"); err != errNotFound {
+		t.Fatalf("Expected errNotFound. Got: %v", err)
+	}
+	assertStorageValue(t, storage, "counter", "3")
+	if list, _ := storage.LRange("list", 0, -1); !reflect.DeepEqual(list, []string{"a", "b\r\n"}) {
+		t.Fatalf("Expected: [a b\r\n]. Got: %q", list)
+	}
+	if n, _ := storage.SCard("set"); n != 1 {
+		t.Fatalf("Expected: 1. Got: %v", n)
+	}
+	assertSet(t, storage, "union", []string{"x", "y"})
+	assertZRange(t, storage, "board", []ZMember{{"bob\r\n", 2}})
+	if value, _ := storage.HGet("hash", "counter"); value != "7" {
+		t.Fatalf("Expected: 7. Got: %v", value)
+	}
+	if _, err := storage.Get("deleted"); err != errNotFound {
+		t.Fatalf("Expected errNotFound. Got: %v", err)
+	}
+	if value, _ := storage.HGet("hash", "field"); value != "com plex\r\nvalue" {
+		t.Fatalf("Expected: com plex\\r\\nvalue. Got: %v", value)
+	}
+
+	storage.Dump(func(e Entry) error {
+		if e.Key == "foo" && e.TTL != 0 {
+			t.Fatalf("Expected no ttl for foo. Got: %v", e.TTL)
+		}
+		if (e.Key == "expiring" || e.Key == "nx") && e.TTL == 0 {
+			t.Fatalf("Expected ttl for %s", e.Key)
+		}
+		return nil
+	})
+}
+
+func TestAppendOnlyFileTruncatedTail(t *testing.T) {
+	path, cleanup := tempAppendOnlyFile(t)
+	defer cleanup()
+
+	content := "SET foo 0 3\r\nbar\r\nSET xyz 0 6\r\nqwe"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplayAppendOnlyFile(path, NewMemory(time.Minute), false); err == nil {
+		t.Fatalf("Expected error for truncated file")
+	}
+
+	storage := NewMemory(time.Minute)
+	if err := ReplayAppendOnlyFile(path, storage, true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertStorageValue(t, storage, "foo", "bar")
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "SET foo 0 3\r\nbar\r\n" {
+		t.Fatalf("Expected file to be truncated. Got: %q", data)
+	}
+}
+
+func TestAppendOnlyFileCorrupted(t *testing.T) {
+	path, cleanup := tempAppendOnlyFile(t)
+	defer cleanup()
+
+	content := "SET foo 0 3\r\nbar\r\nGARBAGE\r\nSET xyz 0 3\r\nqwe\r\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ReplayAppendOnlyFile(path, NewMemory(time.Minute), false); err == nil {
+		t.Fatalf("Expected error for corrupted file")
+	}
+}
+
+func TestAppendOnlyFileRewrite(t *testing.T) {
+	path, cleanup := tempAppendOnlyFile(t)
+	defer cleanup()
+
+	j, aof := journaledStorage(t, path, FsyncNever)
+	for i := 0; i < 100; i++ {
+		j.Set("foo", "bar", 0)
+		j.HSet("hash", "field", "value")
+	}
+	j.Set("deleted", "value", 0)
+	j.Delete("deleted")
+
+	before, _ := os.Stat(path)
+	if err := j.rewrite(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	j.Set("after", "rewrite", 0)
+	aof.Close()
+
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("Expected file to shrink. Before: %d. After: %d", before.Size(), after.Size())
+	}
+
+	storage := NewMemory(time.Minute)
+	if err := ReplayAppendOnlyFile(path, storage, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	keys, _ := storage.Keys()
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 keys. Got: %v", keys)
+	}
+	assertStorageValue(t, storage, "foo", "bar")
+	assertStorageValue(t, storage, "after", "rewrite")
+	hash, _ := storage.HGetAll("hash")
+	if !reflect.DeepEqual(hash, map[string]string{"field": "value"}) {
+		t.Fatalf("Unexpected hash: %v", hash)
+	}
+}
+
+func TestParseFsyncPolicy(t *testing.T) {
+	cases := map[string]FsyncPolicy{
+		"always":   FsyncAlways,
+		"everysec": FsyncEverySecond,
+		"no":       FsyncNever,
+	}
+
+	for name, expected := range cases {
+		policy, err := ParseFsyncPolicy(name)
+		if err != nil || policy != expected {
+			t.Fatalf("Expected: %v. Got: %v, %v", expected, policy, err)
+		}
+	}
+
+	if _, err := ParseFsyncPolicy("sometimes"); err == nil {
+		t.Fatalf("Expected error for unknown policy")
+	}
+}
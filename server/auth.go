@@ -3,19 +3,21 @@ package server
 // Copyright: https://github.com/mindreframer/golang-stuff/blob/master/github.com/bitly/google_auth_proxy/htpasswd.go
 
 import (
-	"crypto/sha1"
-	"encoding/base64"
-	"encoding/csv"
+	"bufio"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 )
 
 // lookup passwords in a htpasswd file
-// The entries must have been created with -s for SHA encryption
+// Supported password hashes are listed in password.go.
+// Password can be followed by ACL rules, see acl.go.
 
 type UserList struct {
-	users map[string]string
+	users map[string]passwordHash
+	acl   map[string]*permissions
 }
 
 func NewUserList(path string) (*UserList, error) {
@@ -24,39 +26,67 @@ func NewUserList(path string) (*UserList, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
+
 	return NewUserListFromReader(r)
 }
 
 func NewUserListFromReader(file io.Reader) (*UserList, error) {
-	csv_reader := csv.NewReader(file)
-	csv_reader.Comma = ':'
-	csv_reader.Comment = '#'
-	csv_reader.TrimLeadingSpace = true
+	h := &UserList{
+		users: make(map[string]passwordHash),
+		acl:   make(map[string]*permissions),
+	}
 
-	records, err := csv_reader.ReadAll()
-	if err != nil {
-		return nil, err
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		// name:password [rule ...]
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("Bad users file line %d", n)
+		}
+		fields := strings.Fields(line[i+1:])
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("Bad users file line %d", n)
+		}
+
+		password, err := parsePasswordHash(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Bad users file line %d: %v", n, err)
+		}
+
+		acl, err := parsePermissions(fields[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Bad users file line %d: %v", n, err)
+		}
+
+		name := line[:i]
+		h.users[name] = password
+		h.acl[name] = acl
 	}
-	h := &UserList{users: make(map[string]string)}
-	for _, record := range records {
-		h.users[record[0]] = record[1]
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
+
 	return h, nil
 }
 
+// Contains reports whether user is in list. It's used to authenticate users by client certificates.
+func (h *UserList) Contains(user string) bool {
+	_, exists := h.users[user]
+	return exists
+}
+
 func (h *UserList) Validate(user string, password string) bool {
-	realPassword, exists := h.users[user]
+	hash, exists := h.users[user]
 	if !exists {
 		return false
 	}
-	if realPassword[:5] == "{SHA}" {
-		d := sha1.New()
-		d.Write([]byte(password))
-		if realPassword[5:] == base64.StdEncoding.EncodeToString(d.Sum(nil)) {
-			return true
-		}
-	} else {
-		log.Printf("Invalid htpasswd entry for %s. Must be a SHA entry.", user)
-	}
-	return false
+
+	return hash.verify(password)
 }
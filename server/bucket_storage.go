@@ -3,6 +3,7 @@ package server
 import (
 	"hash/crc32"
 	"math"
+	"sort"
 )
 
 func NewBucketStorage(n int, factory func() Storage) Storage {
@@ -29,6 +30,22 @@ func (s *bucketStorage) Get(key string) (string, error) {
 	return s.bucket(key).Get(key)
 }
 
+func (s *bucketStorage) SetIf(key, value string, ttl int64, exists bool) (bool, error) {
+	return s.bucket(key).SetIf(key, value, ttl, exists)
+}
+
+func (s *bucketStorage) GetSet(key, value string) (string, error) {
+	return s.bucket(key).GetSet(key, value)
+}
+
+func (s *bucketStorage) Gets(key string) (string, uint64, error) {
+	return s.bucket(key).Gets(key)
+}
+
+func (s *bucketStorage) CompareAndSet(key, value string, ttl int64, version uint64) error {
+	return s.bucket(key).CompareAndSet(key, value, ttl, version)
+}
+
 func (s *bucketStorage) HSet(key, field, value string) error {
 	return s.bucket(key).HSet(key, field, value)
 }
@@ -60,13 +77,297 @@ func (s *bucketStorage) Keys() ([]string, error) {
 	return result, nil
 }
 
+// Scan iterates buckets one by one. Cursor keeps index of bucket in high 32 bits and cursor
+// within bucket in low 32 bits, bucket of key never changes, so Scan gives the same guarantees as
+// a single bucket. Buckets are locked one by one, and only while they are scanned.
+func (s *bucketStorage) Scan(cursor uint64, count int) ([]string, uint64, error) {
+	index, pos := int(cursor>>32), cursor&math.MaxUint32
+
+	var result []string
+	for ; index < len(s.buckets) && len(result) < count; index, pos = index+1, 0 {
+		keys, next, err := s.buckets[index].Scan(pos, count-len(result))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		result = append(result, keys...)
+		if next != 0 {
+			return result, uint64(index)<<32 | next, nil
+		}
+	}
+
+	if index >= len(s.buckets) {
+		return result, 0, nil
+	}
+
+	return result, uint64(index) << 32, nil
+}
+
+func (s *bucketStorage) HScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	return s.bucket(key).HScan(key, cursor, count)
+}
+
 func (s *bucketStorage) Expire(key string, ttl int64) error {
 	return s.bucket(key).Expire(key, ttl)
 }
 
+func (s *bucketStorage) IncrBy(key string, delta int64) (int64, error) {
+	return s.bucket(key).IncrBy(key, delta)
+}
+
+func (s *bucketStorage) HIncrBy(key, field string, delta int64) (int64, error) {
+	return s.bucket(key).HIncrBy(key, field, delta)
+}
+
+func (s *bucketStorage) LPush(key string, values ...string) (int, error) {
+	return s.bucket(key).LPush(key, values...)
+}
+
+func (s *bucketStorage) RPush(key string, values ...string) (int, error) {
+	return s.bucket(key).RPush(key, values...)
+}
+
+func (s *bucketStorage) LPop(key string) (string, error) {
+	return s.bucket(key).LPop(key)
+}
+
+func (s *bucketStorage) RPop(key string) (string, error) {
+	return s.bucket(key).RPop(key)
+}
+
+func (s *bucketStorage) LRange(key string, start, stop int) ([]string, error) {
+	return s.bucket(key).LRange(key, start, stop)
+}
+
+func (s *bucketStorage) LLen(key string) (int, error) {
+	return s.bucket(key).LLen(key)
+}
+
+func (s *bucketStorage) LTrim(key string, start, stop int) error {
+	return s.bucket(key).LTrim(key, start, stop)
+}
+
+func (s *bucketStorage) LIndex(key string, index int) (string, error) {
+	return s.bucket(key).LIndex(key, index)
+}
+
+func (s *bucketStorage) SAdd(key string, members ...string) (int, error) {
+	return s.bucket(key).SAdd(key, members...)
+}
+
+func (s *bucketStorage) SRem(key string, members ...string) (int, error) {
+	return s.bucket(key).SRem(key, members...)
+}
+
+func (s *bucketStorage) SIsMember(key, member string) (bool, error) {
+	return s.bucket(key).SIsMember(key, member)
+}
+
+func (s *bucketStorage) SMembers(key string) ([]string, error) {
+	return s.bucket(key).SMembers(key)
+}
+
+func (s *bucketStorage) SCard(key string) (int, error) {
+	return s.bucket(key).SCard(key)
+}
+
+func (s *bucketStorage) SPop(key string) (string, error) {
+	return s.bucket(key).SPop(key)
+}
+
+func (s *bucketStorage) SRandMember(key string) (string, error) {
+	return s.bucket(key).SRandMember(key)
+}
+
+func (s *bucketStorage) SInter(keys ...string) ([]string, error) {
+	return s.combine(setInter, keys)
+}
+
+func (s *bucketStorage) SUnion(keys ...string) ([]string, error) {
+	return s.combine(setUnion, keys)
+}
+
+func (s *bucketStorage) SDiff(keys ...string) ([]string, error) {
+	return s.combine(setDiff, keys)
+}
+
+func (s *bucketStorage) SInterStore(dest string, keys ...string) (int, error) {
+	return s.combineStore(setInter, dest, keys)
+}
+
+func (s *bucketStorage) SUnionStore(dest string, keys ...string) (int, error) {
+	return s.combineStore(setUnion, dest, keys)
+}
+
+func (s *bucketStorage) SDiffStore(dest string, keys ...string) (int, error) {
+	return s.combineStore(setDiff, dest, keys)
+}
+
+func (s *bucketStorage) ZAdd(key string, members ...ZMember) (int, error) {
+	return s.bucket(key).ZAdd(key, members...)
+}
+
+func (s *bucketStorage) ZRem(key string, members ...string) (int, error) {
+	return s.bucket(key).ZRem(key, members...)
+}
+
+func (s *bucketStorage) ZScore(key, member string) (float64, error) {
+	return s.bucket(key).ZScore(key, member)
+}
+
+func (s *bucketStorage) ZRank(key, member string) (int, error) {
+	return s.bucket(key).ZRank(key, member)
+}
+
+func (s *bucketStorage) ZRange(key string, start, stop int) ([]ZMember, error) {
+	return s.bucket(key).ZRange(key, start, stop)
+}
+
+func (s *bucketStorage) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return s.bucket(key).ZRangeByScore(key, min, max)
+}
+
+func (s *bucketStorage) ZIncrBy(key, member string, delta float64) (float64, error) {
+	return s.bucket(key).ZIncrBy(key, member, delta)
+}
+
+func (s *bucketStorage) ZCard(key string) (int, error) {
+	return s.bucket(key).ZCard(key)
+}
+
+func (s *bucketStorage) ZPopMin(key string) (ZMember, error) {
+	return s.bucket(key).ZPopMin(key)
+}
+
+// combine copies members of every set from its own bucket and combines copies. Keys can live
+// in different buckets and buckets are locked one by one, so result isn't atomic: concurrent
+// writer can change one set after another one was copied. Journal serializes writers while
+// it's logging, so results of STORE variants are consistent with the log.
+func (s *bucketStorage) combine(op setOperation, keys []string) ([]string, error) {
+	result, err := s.combineSets(op, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return setMembers(result), nil
+}
+
+// combineStore combines sets like combine and replaces dest in its bucket with the result.
+func (s *bucketStorage) combineStore(op setOperation, dest string, keys []string) (int, error) {
+	result, err := s.combineSets(op, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	// result is stored the same way bucket stores its own results, so the same event is reported
+	if bucket, ok := s.bucket(dest).(setStorer); ok {
+		bucket.storeSet(op, dest, result)
+		return len(result), nil
+	}
+
+	if len(result) == 0 {
+		return 0, s.bucket(dest).Delete(dest)
+	}
+
+	return len(result), s.bucket(dest).Restore(Entry{Key: dest, Value: result})
+}
+
+// setStorer is implemented by storages which can replace key with result of set operation.
+type setStorer interface {
+	storeSet(op setOperation, dest string, result map[string]struct{})
+}
+
+func (s *bucketStorage) combineSets(op setOperation, keys []string) (map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		members, err := s.bucket(key).SMembers(key)
+		if err != nil {
+			return nil, err
+		}
+
+		sets[i] = make(map[string]struct{}, len(members))
+		addMembers(sets[i], members)
+	}
+
+	return combineSets(op, sets), nil
+}
+
+func (s *bucketStorage) TTL(key string) (int64, error) {
+	return s.bucket(key).TTL(key)
+}
+
+// Dump dumps buckets one by one, so result is consistent only within a bucket,
+// but writers are blocked only while their bucket is being copied.
+func (s *bucketStorage) Dump(fn func(Entry) error) error {
+	for _, bucket := range s.buckets {
+		if err := bucket.Dump(fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *bucketStorage) Restore(e Entry) error {
+	return s.bucket(e.Key).Restore(e)
+}
+
+func (s *bucketStorage) Version(key string) (uint64, error) {
+	return s.bucket(key).Version(key)
+}
+
+// Atomic locks buckets of keys (all buckets for nil keys) in order of their indexes, so concurrent
+// calls can't deadlock, and calls fn with storage which uses locked buckets.
+func (s *bucketStorage) Atomic(keys []string, fn func(Storage) error) error {
+	bucketKeys := make(map[int][]string)
+	if keys == nil {
+		for i := range s.buckets {
+			bucketKeys[i] = nil
+		}
+	}
+	for _, key := range keys {
+		i := s.index(key)
+		bucketKeys[i] = append(bucketKeys[i], key)
+	}
+
+	indexes := make([]int, 0, len(bucketKeys))
+	for i := range bucketKeys {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	view := &bucketStorage{buckets: make([]Storage, len(s.buckets))}
+	copy(view.buckets, s.buckets)
+
+	return s.lock(indexes, bucketKeys, view, fn)
+}
+
+// lock locks the first of indexes, replaces its bucket in view and locks the rest.
+func (s *bucketStorage) lock(indexes []int, bucketKeys map[int][]string, view *bucketStorage, fn func(Storage) error) error {
+	if len(indexes) == 0 {
+		return fn(view)
+	}
+
+	i := indexes[0]
+	return s.buckets[i].Atomic(bucketKeys[i], func(bucket Storage) error {
+		view.buckets[i] = bucket
+
+		return s.lock(indexes[1:], bucketKeys, view, fn)
+	})
+}
+
+func (s *bucketStorage) Notify(fn NotifyFunc) {
+	for _, bucket := range s.buckets {
+		bucket.Notify(fn)
+	}
+}
+
 func (s *bucketStorage) bucket(key string) Storage {
+	return s.buckets[s.index(key)]
+}
+
+func (s *bucketStorage) index(key string) int {
 	sum := crc32.ChecksumIEEE([]byte(key))
-	i := int(math.Mod(float64(sum), float64(len(s.buckets))))
 
-	return s.buckets[i]
+	return int(math.Mod(float64(sum), float64(len(s.buckets))))
 }
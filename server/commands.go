@@ -2,18 +2,55 @@ package server
 
 import (
 	"errors"
+	"math"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var (
 	errBadFormat = errors.New("Bad format")
+	// errBadLength means that request data can't be read, because its length is invalid.
+	errBadLength    = errors.New("Bad data length")
+	errArguments    = errors.New("Wrong number of arguments")
+	errWrongCommand = errors.New("Unknown command")
+	errAuthRequired = errors.New("Authentication required")
+	// errNotStored means that condition of conditional set isn't met.
+	errNotStored = errors.New("Value is not stored")
+	// errBadArgument means that argument can't be written to journal without changing request.
+	errBadArgument = errors.New("Arguments must not be empty or contain spaces and line breaks")
 )
 
 type command interface {
+	// arguments returns number of arguments. Negative number means minimal number of arguments.
 	arguments() int
 	process(r *request, s Storage) ([]string, error)
 }
 
+// validArguments checks number of request arguments.
+func validArguments(cmd command, n int) bool {
+	expected := cmd.arguments()
+	if expected < 0 {
+		return n >= -expected
+	}
+
+	return n == expected
+}
+
+// validArgumentValues reports whether arguments can be sent as lodge request. Requests are written to journal
+// and replication stream with arguments separated by spaces, so RESP arguments with spaces or line breaks
+// would be replayed as other requests.
+func validArgumentValues(arguments []string) bool {
+	for _, argument := range arguments {
+		if argument == "" || strings.ContainsAny(argument, " \r\n") {
+			return false
+		}
+	}
+
+	return true
+}
+
 type getCommand struct{}
 
 func (c getCommand) arguments() int {
@@ -29,31 +66,160 @@ func (c getCommand) process(r *request, s Storage) ([]string, error) {
 	return []string{value}, nil
 }
 
+// setCommand is SET key ttl [NX|XX] length followed by data block. NX sets value only if key doesn't exist,
+// XX only if it exists, otherwise errNotStored is returned.
 type setCommand struct{}
 
 func (c setCommand) arguments() int {
-	return 3
+	return -3
+}
+
+func (c setCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[len(arguments)-1:], nil
 }
 
 func (c setCommand) process(r *request, s Storage) ([]string, error) {
+	dataLength, err := strconv.Atoi(r.arguments[len(r.arguments)-1])
+
+	if err != nil || dataLength < 0 {
+		return nil, errBadLength
+	}
+
+	data, err := r.data(dataLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.arguments) > 4 {
+		return nil, errArguments
+	}
+
 	ttl, err := strconv.Atoi(r.arguments[1])
 	if err != nil || ttl < 0 {
 		return nil, errBadFormat
 	}
 
-	dataLength, err := strconv.Atoi(r.arguments[2])
+	if len(r.arguments) == 3 {
+		err = s.Set(r.arguments[0], string(data), int64(ttl))
+
+		return nil, err
+	}
 
-	if err != nil || dataLength <= 0 {
+	var exists bool
+	switch strings.ToUpper(r.arguments[2]) {
+	case "NX":
+	case "XX":
+		exists = true
+	default:
 		return nil, errBadFormat
 	}
 
-	data, err := r.data(dataLength)
+	set, err := s.SetIf(r.arguments[0], string(data), int64(ttl), exists)
+	if err == nil && !set {
+		err = errNotStored
+	}
+
+	return nil, err
+}
+
+// setNXCommand is SETNX key length followed by data block. It replies 1 if value was set and 0 otherwise.
+type setNXCommand struct{}
+
+func (c setNXCommand) arguments() int {
+	return 2
+}
+
+func (c setNXCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c setNXCommand) process(r *request, s Storage) ([]string, error) {
+	values, err := r.values(r.arguments[1:])
 	if err != nil {
 		return nil, err
 	}
-	err = s.Set(r.arguments[0], string(data), int64(ttl))
 
-	return nil, err
+	set, err := s.SetIf(r.arguments[0], values[0], 0, false)
+	if err != nil {
+		return nil, err
+	}
+	if set {
+		return []string{"1"}, nil
+	}
+
+	return []string{"0"}, nil
+}
+
+// getSetCommand is GETSET key length followed by data block. It replies with old value or NOT_FOUND.
+type getSetCommand struct{}
+
+func (c getSetCommand) arguments() int {
+	return 2
+}
+
+func (c getSetCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c getSetCommand) process(r *request, s Storage) ([]string, error) {
+	values, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := s.GetSet(r.arguments[0], values[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{old}, nil
+}
+
+// getsCommand is GETS key. It replies with value and its version, which is token for CAS.
+type getsCommand struct{}
+
+func (c getsCommand) arguments() int {
+	return 1
+}
+
+func (c getsCommand) process(r *request, s Storage) ([]string, error) {
+	value, version, err := s.Gets(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{value, strconv.FormatUint(version, 10)}, nil
+}
+
+// casCommand is CAS key token ttl length followed by data block. Value is set only if version of key
+// is still equal to token returned by GETS, otherwise errConflict is returned.
+type casCommand struct{}
+
+func (c casCommand) arguments() int {
+	return 4
+}
+
+func (c casCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[3:], nil
+}
+
+func (c casCommand) process(r *request, s Storage) ([]string, error) {
+	values, err := r.values(r.arguments[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := strconv.ParseUint(r.arguments[1], 10, 64)
+	if err != nil {
+		return nil, errBadFormat
+	}
+
+	ttl, err := strconv.Atoi(r.arguments[2])
+	if err != nil || ttl < 0 {
+		return nil, errBadFormat
+	}
+
+	return nil, s.CompareAndSet(r.arguments[0], values[0], int64(ttl), token)
 }
 
 type hSetCommand struct{}
@@ -62,11 +228,22 @@ func (c hSetCommand) arguments() int {
 	return 3
 }
 
+func (c hSetCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[2:], nil
+}
+
 func (c hSetCommand) process(r *request, s Storage) ([]string, error) {
-	dataLength, _ := strconv.Atoi(r.arguments[2])
-	data, _ := r.data(dataLength)
+	dataLength, err := strconv.Atoi(r.arguments[2])
+	if err != nil || dataLength < 0 {
+		return nil, errBadLength
+	}
+
+	data, err := r.data(dataLength)
+	if err != nil {
+		return nil, err
+	}
 
-	err := s.HSet(r.arguments[0], r.arguments[1], string(data))
+	err = s.HSet(r.arguments[0], r.arguments[1], string(data))
 
 	return nil, err
 }
@@ -145,3 +322,309 @@ func (c expireCommand) process(r *request, s Storage) ([]string, error) {
 
 	return nil, s.Expire(r.arguments[0], int64(ttl))
 }
+
+type ttlCommand struct{}
+
+func (c ttlCommand) arguments() int {
+	return 1
+}
+
+func (c ttlCommand) process(r *request, s Storage) ([]string, error) {
+	ttl, err := s.TTL(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.FormatInt(ttl, 10)}, nil
+}
+
+// incrCommand is INCR or DECR, delta is 1 or -1.
+type incrCommand struct {
+	delta int64
+}
+
+func (c incrCommand) arguments() int {
+	return 1
+}
+
+func (c incrCommand) process(r *request, s Storage) ([]string, error) {
+	result, err := s.IncrBy(r.arguments[0], c.delta)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.FormatInt(result, 10)}, nil
+}
+
+// incrByCommand is INCRBY or DECRBY if negative is set.
+type incrByCommand struct {
+	negative bool
+}
+
+func (c incrByCommand) arguments() int {
+	return 2
+}
+
+func (c incrByCommand) process(r *request, s Storage) ([]string, error) {
+	delta, err := parseDelta(r.arguments[1], c.negative)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.IncrBy(r.arguments[0], delta)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.FormatInt(result, 10)}, nil
+}
+
+type hIncrByCommand struct{}
+
+func (c hIncrByCommand) arguments() int {
+	return 3
+}
+
+func (c hIncrByCommand) process(r *request, s Storage) ([]string, error) {
+	delta, err := parseDelta(r.arguments[2], false)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.HIncrBy(r.arguments[0], r.arguments[1], delta)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.FormatInt(result, 10)}, nil
+}
+
+func parseDelta(s string, negative bool) (int64, error) {
+	delta, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+
+	if negative {
+		if delta == math.MinInt64 {
+			return 0, errOverflow
+		}
+		delta = -delta
+	}
+
+	return delta, nil
+}
+
+type expireAtCommand struct{}
+
+func (c expireAtCommand) arguments() int {
+	return 2
+}
+
+// process sets absolute expiration time. Elements with time in the past are deleted,
+// 0 time means that element never expires.
+func (c expireAtCommand) process(r *request, s Storage) ([]string, error) {
+	at, err := strconv.ParseInt(r.arguments[1], 10, 64)
+	if err != nil || at < 0 {
+		return nil, errBadFormat
+	}
+
+	if at == 0 {
+		return nil, s.Expire(r.arguments[0], 0)
+	}
+
+	ttl := at - time.Now().Unix()
+	if ttl <= 0 {
+		return nil, s.Delete(r.arguments[0])
+	}
+
+	return nil, s.Expire(r.arguments[0], ttl)
+}
+
+type saveCommand struct {
+	server *Server
+}
+
+func (c saveCommand) arguments() int {
+	return 0
+}
+
+func (c saveCommand) process(r *request, s Storage) ([]string, error) {
+	return nil, c.server.Save()
+}
+
+type bgSaveCommand struct {
+	server *Server
+}
+
+func (c bgSaveCommand) arguments() int {
+	return 0
+}
+
+func (c bgSaveCommand) process(r *request, s Storage) ([]string, error) {
+	return nil, c.server.BackgroundSave()
+}
+
+type bgRewriteAOFCommand struct {
+	server *Server
+}
+
+func (c bgRewriteAOFCommand) arguments() int {
+	return 0
+}
+
+func (c bgRewriteAOFCommand) process(r *request, s Storage) ([]string, error) {
+	return nil, c.server.RewriteAppendOnlyFile()
+}
+
+type pingCommand struct{}
+
+func (c pingCommand) arguments() int {
+	return 0
+}
+
+func (c pingCommand) process(r *request, s Storage) ([]string, error) {
+	return nil, nil
+}
+
+type infoCommand struct {
+	server *Server
+}
+
+func (c infoCommand) arguments() int {
+	return 0
+}
+
+func (c infoCommand) process(r *request, s Storage) ([]string, error) {
+	return append(c.server.replicationInfo(), c.server.statsInfo()...), nil
+}
+
+type replicaOfCommand struct {
+	server *Server
+}
+
+func (c replicaOfCommand) arguments() int {
+	return 2
+}
+
+// process starts replication of host and port. REPLICAOF NO ONE stops replication.
+func (c replicaOfCommand) process(r *request, s Storage) ([]string, error) {
+	host, port := r.arguments[0], r.arguments[1]
+	if strings.ToUpper(host) == "NO" && strings.ToUpper(port) == "ONE" {
+		return nil, c.server.ReplicaOf("")
+	}
+
+	if _, err := strconv.Atoi(port); err != nil {
+		return nil, errBadFormat
+	}
+
+	return nil, c.server.ReplicaOf(net.JoinHostPort(host, port))
+}
+
+type configCommand struct {
+	server *Server
+}
+
+func (c configCommand) arguments() int {
+	return 1
+}
+
+// process handles CONFIG RELOAD which reloads users file.
+func (c configCommand) process(r *request, s Storage) ([]string, error) {
+	if strings.ToUpper(r.arguments[0]) != "RELOAD" {
+		return nil, errArguments
+	}
+
+	return nil, c.server.ReloadUsers()
+}
+
+// scanCommand is SCAN cursor [MATCH pattern] [COUNT count]. It replies with next cursor followed by keys.
+// Keys are filtered by pattern after they are read, so reply can contain fewer keys than count.
+type scanCommand struct{}
+
+func (c scanCommand) arguments() int {
+	return -1
+}
+
+func (c scanCommand) process(r *request, s Storage) ([]string, error) {
+	cursor, pattern, count, err := parseScan(r.arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, next, err := s.Scan(cursor, count)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{strconv.FormatUint(next, 10)}
+	for _, key := range keys {
+		if pattern == "" || matchGlob(pattern, key) {
+			result = append(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+// hScanCommand is HSCAN key cursor [MATCH pattern] [COUNT count]. It replies with next cursor followed
+// by fields and their values.
+type hScanCommand struct{}
+
+func (c hScanCommand) arguments() int {
+	return -2
+}
+
+func (c hScanCommand) process(r *request, s Storage) ([]string, error) {
+	cursor, pattern, count, err := parseScan(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	values, next, err := s.HScan(r.arguments[0], cursor, count)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{strconv.FormatUint(next, 10)}
+	for i := 0; i < len(values); i += 2 {
+		if pattern == "" || matchGlob(pattern, values[i]) {
+			result = append(result, values[i], values[i+1])
+		}
+	}
+
+	return result, nil
+}
+
+const defaultScanCount = 10
+
+// parseScan parses cursor and options of SCAN and HSCAN.
+func parseScan(args []string) (cursor uint64, pattern string, count int, err error) {
+	cursor, err = strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return 0, "", 0, errBadFormat
+	}
+
+	count = defaultScanCount
+	options := args[1:]
+	for len(options) > 0 {
+		if len(options) < 2 {
+			return 0, "", 0, errBadFormat
+		}
+
+		switch strings.ToUpper(options[0]) {
+		case "MATCH":
+			pattern = options[1]
+		case "COUNT":
+			count, err = strconv.Atoi(options[1])
+			if err != nil || count < 1 {
+				return 0, "", 0, errBadFormat
+			}
+		default:
+			return 0, "", 0, errBadFormat
+		}
+		options = options[2:]
+	}
+
+	return cursor, pattern, count, nil
+}
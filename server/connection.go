@@ -2,8 +2,15 @@ package server
 
 import (
 	"bufio"
-	"fmt"
+	"bytes"
+	"errors"
+	"io"
+	"log"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -14,42 +21,394 @@ var (
 	resultAuthRequired = []byte("AUTH_REQUIRED\r\n")
 	resultNotFound     = []byte("NOT_FOUND\r\n")
 	resultBadFormat    = []byte("BAD_FORMAT\r\n")
+	resultReadOnly     = []byte("READONLY\r\n")
+	resultNotInteger   = []byte("NOT_INTEGER\r\n")
+	resultNotFloat     = []byte("NOT_FLOAT\r\n")
+	resultOverflow     = []byte("OVERFLOW\r\n")
+	resultWrongType    = []byte("WRONG_TYPE\r\n")
+	resultNotStored    = []byte("NOT_STORED\r\n")
+	resultConflict     = []byte("CONFLICT\r\n")
+	resultMaxClients   = []byte("MAX_CLIENTS\r\n")
+	resultNoPerm       = []byte("NOPERM\r\n")
 )
 
+// connection owns reader and writer of client connection. Reader keeps data read ahead,
+// so clients can send many requests without waiting for replies (pipelining). Replies are
+// buffered and written in order; handlers flush them when all received requests are processed.
 type connection struct {
 	conn          net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
 	authenticated bool
+	// user is name of authenticated user, it's empty if server doesn't require authentication.
+	user string
+	// scram is SCRAM exchange started by the first client message.
+	scram *scramExchange
+
+	// tx is transaction started by MULTI, watched are versions of keys watched by WATCH.
+	tx      *transaction
+	watched map[string]uint64
+
+	// busy is set while connection processes requests, so graceful shutdown doesn't close it.
+	busy int32
+
+	limits limits
+	stats  *stats
+	// started is time when first byte of current request was received.
+	started time.Time
+	// streaming is set while connection is subscriber or replica. They aren't closed when idle
+	// and their output isn't limited, pub/sub and replication limit it on their own.
+	streaming bool
+	// output queues replies if output buffer is limited.
+	output *outputQueue
+}
+
+// limits restrict how long connection can wait for client and how much output client can leave
+// unread, zero disables limit. Connection which exceeds any of them is closed.
+type limits struct {
+	// idle limits waiting for next request.
+	idle time.Duration
+	// read limits every read of request header and data.
+	read time.Duration
+	// request limits receiving of whole request after its first byte.
+	request time.Duration
+	// write limits every write of replies.
+	write time.Duration
+	// outputBuffer limits size of replies which client has left unread beyond socket buffers in bytes.
+	outputBuffer int
+}
+
+func newConnection(conn net.Conn, authenticated bool) *connection {
+	return newConnectionWithLimits(conn, authenticated, limits{}, nil)
+}
+
+func newConnectionWithLimits(conn net.Conn, authenticated bool, l limits, st *stats) *connection {
+	c := &connection{
+		conn:          conn,
+		authenticated: authenticated,
+		limits:        l,
+		stats:         st,
+	}
+
+	// wrapper is used only if limits are configured, so syscalls of deadlines are avoided otherwise
+	var rw io.ReadWriter = conn
+	if l != (limits{}) {
+		rw = limitedConn{c}
+	}
+	if l.outputBuffer > 0 {
+		c.output = newOutputQueue()
+	}
+	c.reader = bufio.NewReader(rw)
+	c.writer = bufio.NewWriter(rw)
+
+	return c
+}
+
+var errOutputBufferLimit = errors.New("Output buffer limit exceeded")
+
+// outputBufferTimeout is how long replies can stay over output buffer limit.
+const outputBufferTimeout = 5 * time.Second
+
+// limitedConn sets deadline of connection before every read and write and queues replies if output
+// buffer is limited. Connection is closed when limit is exceeded, so handler doesn't continue with
+// partially received request and client doesn't receive partial reply.
+type limitedConn struct {
+	c *connection
+}
+
+func (l limitedConn) Read(b []byte) (int, error) {
+	l.c.conn.SetReadDeadline(l.c.readDeadline())
+
+	n, err := l.c.conn.Read(b)
+	if isTimeout(err) {
+		l.c.conn.Close()
+	}
+
+	return n, err
+}
+
+func (l limitedConn) Write(b []byte) (int, error) {
+	c := l.c
+	if c.output != nil {
+		if !c.streaming {
+			return c.output.push(c, b)
+		}
+
+		// pushes and replication stream are written after queued replies
+		if err := c.output.wait(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.write(b)
+}
+
+// write writes b to connection with write timeout.
+func (c *connection) write(b []byte) (int, error) {
+	var deadline time.Time
+	if c.limits.write > 0 {
+		deadline = time.Now().Add(c.limits.write)
+	}
+	c.conn.SetWriteDeadline(deadline)
+
+	n, err := c.conn.Write(b)
+	if isTimeout(err) {
+		c.conn.Close()
+	}
+
+	return n, err
+}
+
+// outputQueue keeps replies until socket accepts them. Queue is written by separate goroutine, so size
+// of queue is amount of replies client has left unread beyond socket buffers. Handler waits while queue
+// is over limit, so client which reads replies slower than it pipelines requests isn't disconnected,
+// but client whose queue stays over limit for outputBufferTimeout is.
+type outputQueue struct {
+	l    sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	// writing is size of data being written, it's -1 when there is no writer goroutine.
+	writing int
+	// err is error of the last write, queue doesn't accept data after it.
+	err error
+}
+
+// outputChunk is maximal size of data written at once, so progress of slow client is noticed.
+const outputChunk = 16 * 1024
+
+func newOutputQueue() *outputQueue {
+	q := &outputQueue{writing: -1}
+	q.cond = sync.NewCond(&q.l)
+
+	return q
+}
+
+// pending returns size of data which socket hasn't accepted yet.
+func (q *outputQueue) pending() int {
+	if q.writing > 0 {
+		return q.buf.Len() + q.writing
+	}
+
+	return q.buf.Len()
+}
+
+// push queues b and starts writer goroutine if it isn't running. b is queued by parts, so one
+// huge reply doesn't take more memory than limit.
+func (q *outputQueue) push(c *connection, b []byte) (int, error) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	limit := c.limits.outputBuffer
+	n := 0
+	for n < len(b) {
+		part := b[n:]
+		if len(part) > limit {
+			part = part[:limit]
+		}
+
+		if err := q.waitSpace(c, len(part)); err != nil {
+			return n, err
+		}
+
+		q.buf.Write(part)
+		n += len(part)
+		if q.writing < 0 {
+			q.writing = 0
+			go q.writeLoop(c)
+		}
+	}
+
+	return n, nil
+}
+
+// waitSpace waits until n bytes can be queued without exceeding limit. Client is disconnected if
+// it doesn't read replies for outputBufferTimeout. It must be called with lock held.
+func (q *outputQueue) waitSpace(c *connection, n int) error {
+	if q.err == nil && q.pending()+n > c.limits.outputBuffer {
+		timeout := false
+		timer := time.AfterFunc(outputBufferTimeout, func() {
+			q.l.Lock()
+			timeout = true
+			q.cond.Broadcast()
+			q.l.Unlock()
+		})
+		defer timer.Stop()
+
+		for q.err == nil && q.pending()+n > c.limits.outputBuffer && !timeout {
+			q.cond.Wait()
+		}
+
+		if q.err == nil && timeout {
+			log.Printf("Client %s disconnected: output buffer limit exceeded", c.conn.RemoteAddr())
+			if c.stats != nil {
+				atomic.AddInt64(&c.stats.outputBufferDisconnections, 1)
+			}
+			q.err = errOutputBufferLimit
+			q.buf.Reset()
+			c.conn.Close()
+		}
+	}
+
+	return q.err
+}
+
+// writeLoop writes queued data until queue is empty or write fails.
+func (q *outputQueue) writeLoop(c *connection) {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	for q.buf.Len() > 0 && q.err == nil {
+		b := make([]byte, q.buf.Len())
+		if len(b) > outputChunk {
+			b = b[:outputChunk]
+		}
+		q.buf.Read(b)
+		q.writing = len(b)
+
+		q.l.Unlock()
+		_, err := c.write(b)
+		q.l.Lock()
+
+		if err != nil && q.err == nil {
+			q.err = err
+			q.buf.Reset()
+		}
+		q.writing = 0
+		q.cond.Broadcast()
+	}
+
+	q.writing = -1
+	q.cond.Broadcast()
+}
+
+// wait waits until queued data is written and returns error of writing.
+func (q *outputQueue) wait() error {
+	q.l.Lock()
+	defer q.l.Unlock()
+
+	for q.writing >= 0 {
+		q.cond.Wait()
+	}
+
+	return q.err
+}
+
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+
+	return ok && netErr.Timeout()
+}
+
+// readDeadline returns deadline of next read: idle timeout while connection waits for request,
+// the earliest of read and request timeouts while request is received.
+func (c *connection) readDeadline() time.Time {
+	t := c.limits
+	now := time.Now()
+
+	if c.isIdle() {
+		if t.idle == 0 || c.streaming {
+			return time.Time{}
+		}
+		return now.Add(t.idle)
+	}
+
+	var deadline time.Time
+	if t.read > 0 {
+		deadline = now.Add(t.read)
+	}
+	if t.request > 0 {
+		if end := c.started.Add(t.request); deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+
+	return deadline
+}
+
+// waitRequest waits for first byte of next request and marks connection busy.
+func (c *connection) waitRequest() error {
+	if _, err := c.reader.Peek(1); err != nil {
+		return err
+	}
+
+	c.started = time.Now()
+	c.setIdle(false)
+
+	return nil
+}
+
+// ReadRequest reads next request header from connection.
+func (c *connection) ReadRequest() (*request, error) {
+	return readRequest(c.reader)
+}
+
+// Drained reports whether all received data has been processed.
+func (c *connection) Drained() bool {
+	return c.reader.Buffered() == 0
 }
 
 func (c *connection) WriteOK() {
-	c.conn.Write(resultOK)
+	c.writer.Write(resultOK)
 }
 
 func (c *connection) WriteError() {
-	c.conn.Write(resultError)
+	c.writer.Write(resultError)
 }
 
 func (c *connection) Write(b []byte) (int, error) {
-	return c.conn.Write(b)
+	return c.writer.Write(b)
 }
 
 func (c *connection) WriteValues(values ...string) {
-	w := bufio.NewWriter(c)
-
-	fmt.Fprintf(w, "%s", resultValues)
-	fmt.Fprintf(w, "%d\r\n", len(values))
+	c.writer.Write(resultValues)
+	c.writer.WriteString(strconv.Itoa(len(values)))
+	c.writer.WriteString("\r\n")
 	for _, value := range values {
-		fmt.Fprintf(w, "%d\r\n", len(value))
-		fmt.Fprintf(w, "%s", value)
+		c.writer.WriteString(strconv.Itoa(len(value)))
+		c.writer.WriteString("\r\n")
+		c.writer.WriteString(value)
 	}
+}
 
-	w.Flush()
+// Flush writes buffered replies to connection.
+func (c *connection) Flush() error {
+	return c.writer.Flush()
 }
 
 func (c *connection) Read(b []byte) (int, error) {
-	return c.conn.Read(b)
+	return c.reader.Read(b)
+}
+
+// setIdle marks whether connection waits for next request or processes received ones.
+func (c *connection) setIdle(idle bool) {
+	var busy int32
+	if !idle {
+		busy = 1
+	}
+	atomic.StoreInt32(&c.busy, busy)
+}
+
+func (c *connection) isIdle() bool {
+	return atomic.LoadInt32(&c.busy) == 0
+}
+
+// written reports whether all queued replies have been written to socket.
+func (c *connection) written() bool {
+	if c.output == nil {
+		return true
+	}
+
+	c.output.l.Lock()
+	defer c.output.l.Unlock()
+
+	return c.output.writing < 0
 }
 
 func (c *connection) Close() error {
+	c.writer.Flush()
+	if c.output != nil {
+		c.output.wait()
+	}
+
 	return c.conn.Close()
 }
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/mkabischev/lodge/server/lru"
+)
+
+// readReplies reads exactly n bytes of replies.
+func (c *testClient) readReplies(t *testing.T, n int) []byte {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.connection, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+func TestPipelining(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	var request, expected bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		value := strconv.Itoa(i)
+		request.WriteString("SET key" + value + " 0 " + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+		request.WriteString("GET key" + value + "\r\n")
+		expected.Write(resultOK)
+		expected.WriteString("VALUES\r\n1\r\n" + strconv.Itoa(len(value)) + "\r\n" + value)
+	}
+
+	go client.connection.Write(request.Bytes())
+
+	if replies := client.readReplies(t, expected.Len()); !bytes.Equal(replies, expected.Bytes()) {
+		t.Fatalf("Expected: %q. Got: %q", expected.String(), replies)
+	}
+}
+
+func TestPipeliningRESP(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	var request, expected bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		request.WriteString("*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n")
+		expected.WriteString("+OK\r\n$3\r\nbar\r\n")
+	}
+
+	go client.connection.Write(request.Bytes())
+
+	if replies := client.readReplies(t, expected.Len()); !bytes.Equal(replies, expected.Bytes()) {
+		t.Fatalf("Expected: %q. Got: %q", expected.String(), replies)
+	}
+}
+
+func benchmarkPipeline(b *testing.B, depth int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	server := New(NewBucketStorage(10, func() Storage {
+		return NewLRUStorage(lru.New(1000))
+	}), DefaultConfig())
+	go server.Serve(l)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	var batch bytes.Buffer
+	for i := 0; i < depth; i++ {
+		batch.WriteString("SET foo 0 3\r\nbar\r\n")
+	}
+
+	reader := bufio.NewReader(conn)
+	b.SetBytes(int64(batch.Len() / depth))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i += depth {
+		if _, err := conn.Write(batch.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+
+		for j := 0; j < depth; j++ {
+			if _, err := reader.ReadSlice('\n'); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkPipeline1(b *testing.B) {
+	benchmarkPipeline(b, 1)
+}
+
+func BenchmarkPipeline16(b *testing.B) {
+	benchmarkPipeline(b, 16)
+}
+
+func BenchmarkPipeline128(b *testing.B) {
+	benchmarkPipeline(b, 128)
+}
+
+func BenchmarkPipeline1024(b *testing.B) {
+	benchmarkPipeline(b, 1024)
+}
@@ -0,0 +1,36 @@
+package server
+
+import "testing"
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		matched bool
+	}{
+		{"*", "", true},
+		{"*", "any/thing", true},
+		{"foo*", "foobar", true},
+		{"foo*", "barfoo", false},
+		{"*bar", "foobar", true},
+		{"f*b*r", "foobar", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"tenant\\*", "tenant*", true},
+		{"tenant\\*", "tenantA", false},
+		{"tenantA:*", "tenantA:users", true},
+		{"tenantA:*", "tenantB:users", false},
+	}
+
+	for _, tc := range cases {
+		if matched := matchGlob(tc.pattern, tc.s); matched != tc.matched {
+			t.Fatalf("matchGlob(%q, %q): expected %v", tc.pattern, tc.s, tc.matched)
+		}
+	}
+}
@@ -0,0 +1,743 @@
+package server
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// journal is Storage decorator which writes every successful mutation to append-only file
+// and to attached feeds. Mutations are written in canonical form: requests which give
+// the same result regardless of when they are applied, e.g. relative ttl is replaced with
+// absolute EXPIREAT.
+//
+// Mutations are serialized, so order of records always matches order of changes in storage.
+// When there is neither append-only file nor feeds, mutations run concurrently.
+type journal struct {
+	l       sync.RWMutex
+	storage Storage
+	aof     *AppendOnlyFile
+	feeds   []*feed
+	// offset is total size of written records.
+	offset int64
+
+	// parent is set for journal passed by Atomic. It writes records to parent, which is already locked.
+	parent *journal
+	logged bool
+}
+
+func newJournal(s Storage, aof *AppendOnlyFile) *journal {
+	return &journal{
+		storage: s,
+		aof:     aof,
+	}
+}
+
+func (j *journal) Set(key, value string, ttl int64) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.Set(key, value, ttl); err != nil || !logged {
+		return err
+	}
+
+	return j.write(key, recordSet(nil, key, value, ttl))
+}
+
+func (j *journal) Get(key string) (string, error) {
+	return j.storage.Get(key)
+}
+
+// SetIf, GetSet and CompareAndSet are logged as SET when value is set.
+func (j *journal) SetIf(key, value string, ttl int64, exists bool) (bool, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	set, err := j.storage.SetIf(key, value, ttl, exists)
+	if err != nil || !set || !logged {
+		return set, err
+	}
+
+	return set, j.write(key, recordSet(nil, key, value, ttl))
+}
+
+func (j *journal) GetSet(key, value string) (string, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	old, err := j.storage.GetSet(key, value)
+	if (err != nil && err != errNotFound) || !logged {
+		return old, err
+	}
+
+	if writeErr := j.write(key, recordSet(nil, key, value, 0)); writeErr != nil {
+		return old, writeErr
+	}
+
+	return old, err
+}
+
+func (j *journal) Gets(key string) (string, uint64, error) {
+	return j.storage.Gets(key)
+}
+
+func (j *journal) CompareAndSet(key, value string, ttl int64, version uint64) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.CompareAndSet(key, value, ttl, version); err != nil || !logged {
+		return err
+	}
+
+	return j.write(key, recordSet(nil, key, value, ttl))
+}
+
+func (j *journal) HSet(key, field, value string) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.HSet(key, field, value); err != nil || !logged {
+		return err
+	}
+
+	return j.write(key, recordWithData(nil, "HSET", value, key, field))
+}
+
+func (j *journal) HGet(key, field string) (string, error) {
+	return j.storage.HGet(key, field)
+}
+
+func (j *journal) HGetAll(key string) (map[string]string, error) {
+	return j.storage.HGetAll(key)
+}
+
+func (j *journal) Delete(key string) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.Delete(key); err != nil || !logged {
+		return err
+	}
+
+	return j.write(key, record(nil, "DELETE", key))
+}
+
+func (j *journal) Keys() ([]string, error) {
+	return j.storage.Keys()
+}
+
+func (j *journal) Scan(cursor uint64, count int) ([]string, uint64, error) {
+	return j.storage.Scan(cursor, count)
+}
+
+func (j *journal) HScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	return j.storage.HScan(key, cursor, count)
+}
+
+func (j *journal) Expire(key string, ttl int64) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.Expire(key, ttl); err != nil || !logged {
+		return err
+	}
+
+	if ttl == 0 {
+		return j.write(key, record(nil, "EXPIREAT", key, "0"))
+	}
+
+	return j.write(key, recordExpireAt(nil, key, ttl))
+}
+
+func (j *journal) IncrBy(key string, delta int64) (int64, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	result, err := j.storage.IncrBy(key, delta)
+	if err != nil || !logged {
+		return result, err
+	}
+
+	return result, j.write(key, record(nil, "INCRBY", key, strconv.FormatInt(delta, 10)))
+}
+
+func (j *journal) HIncrBy(key, field string, delta int64) (int64, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	result, err := j.storage.HIncrBy(key, field, delta)
+	if err != nil || !logged {
+		return result, err
+	}
+
+	return result, j.write(key, record(nil, "HINCRBY", key, field, strconv.FormatInt(delta, 10)))
+}
+
+func (j *journal) LPush(key string, values ...string) (int, error) {
+	return j.push(key, values, true)
+}
+
+func (j *journal) RPush(key string, values ...string) (int, error) {
+	return j.push(key, values, false)
+}
+
+func (j *journal) push(key string, values []string, left bool) (int, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	command, push := "RPUSH", j.storage.RPush
+	if left {
+		command, push = "LPUSH", j.storage.LPush
+	}
+
+	length, err := push(key, values...)
+	if err != nil || !logged {
+		return length, err
+	}
+
+	return length, j.write(key, recordWithValues(nil, command, values, key))
+}
+
+func (j *journal) LPop(key string) (string, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	value, err := j.storage.LPop(key)
+	if err != nil || !logged {
+		return value, err
+	}
+
+	return value, j.write(key, record(nil, "LPOP", key))
+}
+
+func (j *journal) RPop(key string) (string, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	value, err := j.storage.RPop(key)
+	if err != nil || !logged {
+		return value, err
+	}
+
+	return value, j.write(key, record(nil, "RPOP", key))
+}
+
+func (j *journal) LRange(key string, start, stop int) ([]string, error) {
+	return j.storage.LRange(key, start, stop)
+}
+
+func (j *journal) LLen(key string) (int, error) {
+	return j.storage.LLen(key)
+}
+
+func (j *journal) LTrim(key string, start, stop int) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.LTrim(key, start, stop); err != nil || !logged {
+		return err
+	}
+
+	return j.write(key, record(nil, "LTRIM", key, strconv.Itoa(start), strconv.Itoa(stop)))
+}
+
+func (j *journal) LIndex(key string, index int) (string, error) {
+	return j.storage.LIndex(key, index)
+}
+
+func (j *journal) SAdd(key string, members ...string) (int, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	added, err := j.storage.SAdd(key, members...)
+	if err != nil || !logged {
+		return added, err
+	}
+
+	return added, j.write(key, recordWithValues(nil, "SADD", members, key))
+}
+
+func (j *journal) SRem(key string, members ...string) (int, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	removed, err := j.storage.SRem(key, members...)
+	if err != nil || !logged {
+		return removed, err
+	}
+
+	return removed, j.write(key, recordWithValues(nil, "SREM", members, key))
+}
+
+func (j *journal) SIsMember(key, member string) (bool, error) {
+	return j.storage.SIsMember(key, member)
+}
+
+func (j *journal) SMembers(key string) ([]string, error) {
+	return j.storage.SMembers(key)
+}
+
+func (j *journal) SCard(key string) (int, error) {
+	return j.storage.SCard(key)
+}
+
+// SPop is logged as SREM of popped member, because member is chosen randomly.
+func (j *journal) SPop(key string) (string, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	member, err := j.storage.SPop(key)
+	if err != nil || !logged {
+		return member, err
+	}
+
+	return member, j.write(key, recordWithValues(nil, "SREM", []string{member}, key))
+}
+
+func (j *journal) SRandMember(key string) (string, error) {
+	return j.storage.SRandMember(key)
+}
+
+func (j *journal) SInter(keys ...string) ([]string, error) {
+	return j.storage.SInter(keys...)
+}
+
+func (j *journal) SUnion(keys ...string) ([]string, error) {
+	return j.storage.SUnion(keys...)
+}
+
+func (j *journal) SDiff(keys ...string) ([]string, error) {
+	return j.storage.SDiff(keys...)
+}
+
+func (j *journal) SInterStore(dest string, keys ...string) (int, error) {
+	return j.store(dest, keys, j.storage.SInterStore)
+}
+
+func (j *journal) SUnionStore(dest string, keys ...string) (int, error) {
+	return j.store(dest, keys, j.storage.SUnionStore)
+}
+
+func (j *journal) SDiffStore(dest string, keys ...string) (int, error) {
+	return j.store(dest, keys, j.storage.SDiffStore)
+}
+
+// store logs result of set operation instead of operation itself, so replay doesn't depend on source sets.
+func (j *journal) store(dest string, keys []string, fn func(dest string, keys ...string) (int, error)) (int, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	n, err := fn(dest, keys...)
+	if err != nil || !logged {
+		return n, err
+	}
+
+	members, err := j.storage.SMembers(dest)
+	if err != nil {
+		return n, err
+	}
+
+	b := record(nil, "DELETE", dest)
+	if len(members) > 0 {
+		b = recordWithValues(b, "SADD", members, dest)
+	}
+
+	return n, j.write(dest, b)
+}
+
+func (j *journal) ZAdd(key string, members ...ZMember) (int, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	added, err := j.storage.ZAdd(key, members...)
+	if err != nil || !logged {
+		return added, err
+	}
+
+	return added, j.write(key, recordZAdd(nil, key, members))
+}
+
+func (j *journal) ZRem(key string, members ...string) (int, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	removed, err := j.storage.ZRem(key, members...)
+	if err != nil || !logged {
+		return removed, err
+	}
+
+	return removed, j.write(key, recordWithValues(nil, "ZREM", members, key))
+}
+
+func (j *journal) ZScore(key, member string) (float64, error) {
+	return j.storage.ZScore(key, member)
+}
+
+func (j *journal) ZRank(key, member string) (int, error) {
+	return j.storage.ZRank(key, member)
+}
+
+func (j *journal) ZRange(key string, start, stop int) ([]ZMember, error) {
+	return j.storage.ZRange(key, start, stop)
+}
+
+func (j *journal) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return j.storage.ZRangeByScore(key, min, max)
+}
+
+// ZIncrBy is logged as ZADD with resulting score.
+func (j *journal) ZIncrBy(key, member string, delta float64) (float64, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	score, err := j.storage.ZIncrBy(key, member, delta)
+	if err != nil || !logged {
+		return score, err
+	}
+
+	return score, j.write(key, recordZAdd(nil, key, []ZMember{{Member: member, Score: score}}))
+}
+
+func (j *journal) ZCard(key string) (int, error) {
+	return j.storage.ZCard(key)
+}
+
+// ZPopMin is logged as ZREM of popped member.
+func (j *journal) ZPopMin(key string) (ZMember, error) {
+	logged := j.begin()
+	defer j.end(logged)
+
+	first, err := j.storage.ZPopMin(key)
+	if err != nil || !logged {
+		return first, err
+	}
+
+	return first, j.write(key, recordWithValues(nil, "ZREM", []string{first.Member}, key))
+}
+
+func (j *journal) TTL(key string) (int64, error) {
+	return j.storage.TTL(key)
+}
+
+func (j *journal) Dump(fn func(Entry) error) error {
+	return j.storage.Dump(fn)
+}
+
+func (j *journal) Restore(e Entry) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	if err := j.storage.Restore(e); err != nil || !logged {
+		return err
+	}
+
+	return j.write(e.Key, recordEntry(record(nil, "DELETE", e.Key), e))
+}
+
+func (j *journal) Version(key string) (uint64, error) {
+	return j.storage.Version(key)
+}
+
+func (j *journal) Notify(fn NotifyFunc) {
+	j.storage.Notify(fn)
+}
+
+// Atomic locks journal for the whole call, so records of mutations made by fn are not mixed with others.
+func (j *journal) Atomic(keys []string, fn func(Storage) error) error {
+	logged := j.begin()
+	defer j.end(logged)
+
+	return j.storage.Atomic(keys, func(s Storage) error {
+		return fn(&journal{storage: s, parent: j, logged: logged})
+	})
+}
+
+// begin locks journal before mutation. It returns false if there is nobody to receive records,
+// in that case journal is locked for reading only, so mutations don't block each other.
+func (j *journal) begin() bool {
+	if j.parent != nil {
+		return j.logged
+	}
+
+	j.l.RLock()
+	if j.aof == nil && len(j.feeds) == 0 {
+		return false
+	}
+	j.l.RUnlock()
+
+	j.l.Lock()
+
+	return true
+}
+
+func (j *journal) end(logged bool) {
+	if j.parent != nil {
+		return
+	}
+
+	if logged {
+		j.l.Unlock()
+	} else {
+		j.l.RUnlock()
+	}
+}
+
+// write passes record to append-only file and feeds. It must be called with lock held.
+func (j *journal) write(key string, b []byte) error {
+	if j.parent != nil {
+		return j.parent.write(key, b)
+	}
+
+	atomic.AddInt64(&j.offset, int64(len(b)))
+
+	for _, f := range j.feeds {
+		f.push(key, b)
+	}
+
+	if j.aof == nil {
+		return nil
+	}
+
+	return j.aof.append(b)
+}
+
+// attach copies contents of storage to feed and makes feed receive further mutations.
+// Storage is copied partition by partition (bucket by bucket for bucketStorage), and
+// mutations are blocked only while current partition is copied. Feed receives mutations
+// of partition only after partition has been copied, so every change is delivered exactly once.
+// progress is called after each partition.
+func (j *journal) attach(f *feed, progress func() error) error {
+	parts, index := partitions(j.storage)
+
+	j.l.Lock()
+	f.index = index
+	f.synced = make([]bool, len(parts))
+	j.feeds = append(j.feeds, f)
+	j.l.Unlock()
+
+	for i, part := range parts {
+		j.l.Lock()
+		var b []byte
+		err := part.Dump(func(e Entry) error {
+			b = recordEntry(b, e)
+			return nil
+		})
+		if err != nil {
+			j.removeFeed(f)
+			j.l.Unlock()
+			return err
+		}
+		f.write(b)
+		f.synced[i] = true
+		j.l.Unlock()
+
+		if progress != nil {
+			if err := progress(); err != nil {
+				j.detach(f)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// detach stops passing mutations to feed.
+func (j *journal) detach(f *feed) {
+	j.l.Lock()
+	defer j.l.Unlock()
+
+	j.removeFeed(f)
+}
+
+func (j *journal) removeFeed(f *feed) {
+	for i, feed := range j.feeds {
+		if feed == f {
+			j.feeds = append(j.feeds[:i], j.feeds[i+1:]...)
+			return
+		}
+	}
+}
+
+// feed is in-memory queue of journal records.
+type feed struct {
+	l      sync.Mutex
+	buf    bytes.Buffer
+	signal chan struct{}
+	// pushed is total size of records written to feed.
+	pushed int64
+	// limit is maximal size of mutations queued in feed, feed which outgrows it is dropped. 0 means no limit.
+	limit   int
+	dropped bool
+
+	// synced marks partitions which have been already copied to feed, index maps key to partition.
+	// Both are protected by journal lock.
+	synced []bool
+	index  func(key string) int
+}
+
+func newFeed(limit int) *feed {
+	return &feed{
+		signal: make(chan struct{}, 1),
+		limit:  limit,
+	}
+}
+
+// push adds record of mutation of key. Mutations of partitions which haven't been copied yet are skipped,
+// because they will be copied with partition. Copies of partitions aren't limited, so they can be larger
+// than limit.
+func (f *feed) push(key string, b []byte) {
+	if f.synced[f.index(key)] {
+		f.queue(b, f.limit)
+	}
+}
+
+// write adds records ignoring limit.
+func (f *feed) write(b []byte) {
+	f.queue(b, 0)
+}
+
+// queue adds records to feed. Feed is dropped if it outgrows limit, 0 means no limit.
+func (f *feed) queue(b []byte, limit int) {
+	if len(b) == 0 {
+		return
+	}
+
+	f.l.Lock()
+	switch {
+	case f.dropped:
+	case limit > 0 && f.buf.Len()+len(b) > limit:
+		f.dropped = true
+		f.buf.Reset()
+	default:
+		f.buf.Write(b)
+		f.pushed += int64(len(b))
+	}
+	f.l.Unlock()
+
+	select {
+	case f.signal <- struct{}{}:
+	default:
+	}
+}
+
+// isDropped reports whether feed has outgrown its limit.
+func (f *feed) isDropped() bool {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	return f.dropped
+}
+
+// take returns all queued records.
+func (f *feed) take() []byte {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	b := make([]byte, f.buf.Len())
+	copy(b, f.buf.Bytes())
+	f.buf.Reset()
+
+	return b
+}
+
+// partitions returns independently locked parts of storage and function which maps key to its part.
+func partitions(s Storage) ([]Storage, func(key string) int) {
+	switch storage := s.(type) {
+	case *journal:
+		return partitions(storage.storage)
+	case *bucketStorage:
+		return storage.buckets, storage.index
+	default:
+		return []Storage{s}, func(string) int { return 0 }
+	}
+}
+
+// record appends request without data to b.
+func record(b []byte, command string, args ...string) []byte {
+	b = append(b, command...)
+	for _, arg := range args {
+		b = append(b, ' ')
+		b = append(b, arg...)
+	}
+
+	return append(b, "\r\n"...)
+}
+
+// recordWithData appends request with data to b. Length of data is added as last argument.
+func recordWithData(b []byte, command, data string, args ...string) []byte {
+	return recordWithValues(b, command, []string{data}, args...)
+}
+
+// recordWithValues appends request with several data blocks to b. Lengths of values are added as last arguments.
+func recordWithValues(b []byte, command string, values []string, args ...string) []byte {
+	for _, value := range values {
+		args = append(args, strconv.Itoa(len(value)))
+	}
+
+	b = record(b, command, args...)
+	for _, value := range values {
+		b = append(b, value...)
+		b = append(b, "\r\n"...)
+	}
+
+	return b
+}
+
+// recordZAdd appends ZADD request: score and length of every member followed by members.
+func recordZAdd(b []byte, key string, members []ZMember) []byte {
+	args := []string{key}
+	values := make([]string, len(members))
+	for i, member := range members {
+		args = append(args, formatScore(member.Score), strconv.Itoa(len(member.Member)))
+		values[i] = member.Member
+	}
+
+	b = record(b, "ZADD", args...)
+	for _, value := range values {
+		b = append(b, value...)
+		b = append(b, "\r\n"...)
+	}
+
+	return b
+}
+
+// recordSet appends SET with absolute expiration time.
+func recordSet(b []byte, key, value string, ttl int64) []byte {
+	b = recordWithData(b, "SET", value, key, "0")
+	if ttl != 0 {
+		b = recordExpireAt(b, key, ttl)
+	}
+
+	return b
+}
+
+func recordExpireAt(b []byte, key string, ttl int64) []byte {
+	return record(b, "EXPIREAT", key, strconv.FormatInt(time.Now().Unix()+ttl, 10))
+}
+
+// recordEntry appends requests which create entry in empty storage.
+func recordEntry(b []byte, e Entry) []byte {
+	switch value := e.Value.(type) {
+	case string:
+		b = recordWithData(b, "SET", value, e.Key, "0")
+	case map[string]string:
+		for field, v := range value {
+			b = recordWithData(b, "HSET", v, e.Key, field)
+		}
+	case []string:
+		b = recordWithValues(b, "RPUSH", value, e.Key)
+	case map[string]struct{}:
+		b = recordWithValues(b, "SADD", setMembers(value), e.Key)
+	case *zset:
+		b = recordZAdd(b, e.Key, value.members())
+	}
+
+	if e.TTL != 0 {
+		b = recordExpireAt(b, e.Key, e.TTL)
+	}
+
+	return b
+}
@@ -0,0 +1,68 @@
+package server
+
+// Lists are stored as []string. Storages implement list commands on top of these helpers,
+// empty lists are removed from storage.
+
+// pushValues inserts values at the head (left) or at the tail of list. Values pushed to the head
+// are inserted one by one, so they end up in reverse order.
+func pushValues(list []string, values []string, left bool) []string {
+	if !left {
+		return append(list, values...)
+	}
+
+	result := make([]string, 0, len(list)+len(values))
+	for i := len(values) - 1; i >= 0; i-- {
+		result = append(result, values[i])
+	}
+
+	return append(result, list...)
+}
+
+// popValue removes first (left) or last element of non-empty list.
+func popValue(list []string, left bool) (string, []string) {
+	if left {
+		return list[0], list[1:]
+	}
+
+	return list[len(list)-1], list[:len(list)-1]
+}
+
+// listRange converts inclusive start and stop indexes, which can be negative to count from
+// the end of list, to bounds of slice.
+func listRange(length, start, stop int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	if start > stop {
+		return 0, 0
+	}
+
+	return start, stop + 1
+}
+
+// listIndex converts index, which can be negative to count from the end of list, to slice index.
+func listIndex(length, index int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+
+	return index, index >= 0 && index < length
+}
+
+// copyList returns copy of list, so it can be used without holding storage lock.
+func copyList(list []string) []string {
+	result := make([]string, len(list))
+	copy(result, list)
+
+	return result
+}
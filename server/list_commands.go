@@ -0,0 +1,286 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// pushCommand is LPUSH or RPUSH: PUSH key length [length ...] followed by data blocks.
+// Connections blocked by BLPOP and BRPOP on the key are woken up.
+type pushCommand struct {
+	waiters *waiters
+	left    bool
+}
+
+func (c pushCommand) arguments() int {
+	return -2
+}
+
+func (c pushCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c pushCommand) process(r *request, s Storage) ([]string, error) {
+	values, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	push := s.RPush
+	if c.left {
+		push = s.LPush
+	}
+
+	length, err := push(r.arguments[0], values...)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.waiters != nil {
+		c.waiters.signal(r.arguments[0])
+	}
+
+	return []string{strconv.Itoa(length)}, nil
+}
+
+// popCommand is LPOP or RPOP.
+type popCommand struct {
+	left bool
+}
+
+func (c popCommand) arguments() int {
+	return 1
+}
+
+func (c popCommand) process(r *request, s Storage) ([]string, error) {
+	value, err := pop(s, r.arguments[0], c.left)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{value}, nil
+}
+
+func pop(s Storage, key string, left bool) (string, error) {
+	if left {
+		return s.LPop(key)
+	}
+
+	return s.RPop(key)
+}
+
+type lRangeCommand struct{}
+
+func (c lRangeCommand) arguments() int {
+	return 3
+}
+
+func (c lRangeCommand) process(r *request, s Storage) ([]string, error) {
+	start, stop, err := parseRange(r.arguments[1], r.arguments[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return s.LRange(r.arguments[0], start, stop)
+}
+
+type lLenCommand struct{}
+
+func (c lLenCommand) arguments() int {
+	return 1
+}
+
+func (c lLenCommand) process(r *request, s Storage) ([]string, error) {
+	length, err := s.LLen(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(length)}, nil
+}
+
+type lTrimCommand struct{}
+
+func (c lTrimCommand) arguments() int {
+	return 3
+}
+
+func (c lTrimCommand) process(r *request, s Storage) ([]string, error) {
+	start, stop, err := parseRange(r.arguments[1], r.arguments[2])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, s.LTrim(r.arguments[0], start, stop)
+}
+
+type lIndexCommand struct{}
+
+func (c lIndexCommand) arguments() int {
+	return 2
+}
+
+func (c lIndexCommand) process(r *request, s Storage) ([]string, error) {
+	index, err := strconv.Atoi(r.arguments[1])
+	if err != nil {
+		return nil, errBadFormat
+	}
+
+	value, err := s.LIndex(r.arguments[0], index)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{value}, nil
+}
+
+func parseRange(start, stop string) (int, int, error) {
+	from, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, errBadFormat
+	}
+
+	to, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, errBadFormat
+	}
+
+	return from, to, nil
+}
+
+// blockingPopCommand is BLPOP or BRPOP: BPOP key [key ...] timeout. It pops element from the first
+// non-empty list and replies with key and element. If all lists are empty, it waits until element
+// is pushed or timeout in seconds expires, then replies NOT_FOUND. Zero timeout means wait forever.
+type blockingPopCommand struct {
+	waiters *waiters
+	left    bool
+}
+
+func (c blockingPopCommand) arguments() int {
+	return -2
+}
+
+func (c blockingPopCommand) process(r *request, s Storage) ([]string, error) {
+	keys := r.arguments[:len(r.arguments)-1]
+
+	seconds, err := strconv.ParseFloat(r.arguments[len(r.arguments)-1], 64)
+	if err != nil || seconds < 0 {
+		return nil, errBadFormat
+	}
+
+	var timeout <-chan time.Time
+	if seconds > 0 {
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	// without waiters (e.g. in transaction) lists are checked once
+	if c.waiters == nil {
+		for _, key := range keys {
+			if value, err := pop(s, key, c.left); err != errNotFound {
+				if err != nil {
+					return nil, err
+				}
+
+				return []string{key, value}, nil
+			}
+		}
+
+		return nil, errNotFound
+	}
+
+	for {
+		// waiter is registered before lists are checked, so push between check and wait isn't missed
+		ch := c.waiters.wait(keys)
+
+		for _, key := range keys {
+			value, err := pop(s, key, c.left)
+			if err != errNotFound {
+				c.waiters.cancel(keys, ch)
+				if err != nil {
+					return nil, err
+				}
+
+				return []string{key, value}, nil
+			}
+		}
+
+		select {
+		case <-ch:
+			c.waiters.cancel(keys, ch)
+		case <-timeout:
+			c.waiters.cancel(keys, ch)
+			return nil, errNotFound
+		case <-c.waiters.closed:
+			// server shuts down
+			c.waiters.cancel(keys, ch)
+			return nil, errNotFound
+		}
+	}
+}
+
+// waiters keeps channels of connections blocked on keys.
+type waiters struct {
+	l    sync.Mutex
+	keys map[string]map[chan struct{}]struct{}
+	// closed is closed on shutdown, it wakes up all waiting connections.
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newWaiters() *waiters {
+	return &waiters{
+		keys:   make(map[string]map[chan struct{}]struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// close stops waiting of all connections, current and future ones.
+func (w *waiters) close() {
+	w.once.Do(func() {
+		close(w.closed)
+	})
+}
+
+// wait returns channel which receives value when one of keys is signaled.
+func (w *waiters) wait(keys []string) chan struct{} {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	ch := make(chan struct{}, 1)
+	for _, key := range keys {
+		if w.keys[key] == nil {
+			w.keys[key] = make(map[chan struct{}]struct{})
+		}
+		w.keys[key][ch] = struct{}{}
+	}
+
+	return ch
+}
+
+func (w *waiters) cancel(keys []string, ch chan struct{}) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	for _, key := range keys {
+		delete(w.keys[key], ch)
+		if len(w.keys[key]) == 0 {
+			delete(w.keys, key)
+		}
+	}
+}
+
+// signal wakes up all connections waiting for key.
+func (w *waiters) signal(key string) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	for ch := range w.keys[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
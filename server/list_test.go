@@ -0,0 +1,143 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListRange(t *testing.T) {
+	cases := []struct {
+		length, start, stop int
+		from, to            int
+	}{
+		{5, 0, -1, 0, 5},
+		{5, 1, 2, 1, 3},
+		{5, -2, -1, 3, 5},
+		{5, -100, 100, 0, 5},
+		{5, 3, 1, 0, 0},
+		{5, 5, 10, 0, 0},
+		{0, 0, -1, 0, 0},
+	}
+
+	for _, tc := range cases {
+		from, to := listRange(tc.length, tc.start, tc.stop)
+		if from != tc.from || to != tc.to {
+			t.Fatalf("Expected: [%d, %d). Got: [%d, %d) for %v", tc.from, tc.to, from, to, tc)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		s.RPush("list", "b", "c")
+		if n, err := s.LPush("list", "a", "z"); err != nil || n != 4 {
+			t.Fatalf("%s: expected 4. Got: %d, %v", name, n, err)
+		}
+
+		assertList(t, s, "list", []string{"z", "a", "b", "c"})
+
+		if value, err := s.LPop("list"); err != nil || value != "z" {
+			t.Fatalf("%s: expected z. Got: %v, %v", name, value, err)
+		}
+		if value, err := s.RPop("list"); err != nil || value != "c" {
+			t.Fatalf("%s: expected c. Got: %v, %v", name, value, err)
+		}
+		if value, err := s.LIndex("list", -1); err != nil || value != "b" {
+			t.Fatalf("%s: expected b. Got: %v, %v", name, value, err)
+		}
+		if _, err := s.LIndex("list", 2); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if n, err := s.LLen("list"); err != nil || n != 2 {
+			t.Fatalf("%s: expected 2. Got: %d, %v", name, n, err)
+		}
+
+		s.RPush("list", "c", "d")
+		s.LTrim("list", 1, -2)
+		assertList(t, s, "list", []string{"b", "c"})
+
+		// empty list is removed
+		s.LTrim("list", 5, 10)
+		if _, err := s.TTL("list"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if _, err := s.LPop("list"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+
+		s.Set("string", "value", 0)
+		if _, err := s.LPush("string", "a"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+		if _, err := s.LRange("string", 0, -1); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+		s.RPush("list", "a")
+		if _, err := s.Get("list"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestListKeepsTTL(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		s.RPush("list", "a")
+		s.Expire("list", 100)
+		s.RPush("list", "b")
+		if ttl, _ := s.TTL("list"); ttl == 0 {
+			t.Fatalf("%s: expected ttl to be kept", name)
+		}
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("RPUSH list 1 2\r\na\r\nbc\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+	client.assertRequest(t, []byte("LPUSH list 1\r\nz\r\n"), []byte("VALUES\r\n1\r\n1\r\n3"))
+	client.assertRequest(t, []byte("LRANGE list 0 -1\r\n"), []byte("VALUES\r\n3\r\n1\r\nz1\r\na2\r\nbc"))
+	client.assertRequest(t, []byte("LLEN list\r\n"), []byte("VALUES\r\n1\r\n1\r\n3"))
+	client.assertRequest(t, []byte("LINDEX list 1\r\n"), []byte("VALUES\r\n1\r\n1\r\na"))
+	client.assertRequest(t, []byte("LPOP list\r\n"), []byte("VALUES\r\n1\r\n1\r\nz"))
+	client.assertRequest(t, []byte("RPOP list\r\n"), []byte("VALUES\r\n1\r\n2\r\nbc"))
+	client.assertRequest(t, []byte("LTRIM list 1 -1\r\n"), resultOK)
+	client.assertRequest(t, []byte("LRANGE list 0 -1\r\n"), resultOK)
+	client.assertRequest(t, []byte("LPOP list\r\n"), resultNotFound)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("LPUSH foo 1\r\na\r\n"), resultWrongType)
+	client.assertRequest(t, []byte("BLPOP missing 0.1\r\n"), resultNotFound)
+}
+
+func TestBlockingPop(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	server := closer.(*Server)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(50 * time.Millisecond)
+		server.execute(newConnection(nil, true), newDataRequest("RPUSH", "value", "queue2"))
+	}()
+
+	client.assertRequest(t, []byte("BRPOP queue1 queue2 5\r\n"), []byte("VALUES\r\n2\r\n6\r\nqueue25\r\nvalue"))
+	<-done
+}
+
+func assertList(t *testing.T, s Storage, key string, expected []string) {
+	list, err := s.LRange(key, 0, -1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(list, expected) {
+		t.Fatalf("Expected: %v. Got: %v", expected, list)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// unixPrefix marks address of unix domain socket, e.g. unix:/var/run/lodge.sock.
+const unixPrefix = "unix:"
+
+// splitAddr returns network and address of listen or dial address.
+func splitAddr(addr string) (string, string) {
+	if strings.HasPrefix(addr, unixPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixPrefix)
+	}
+
+	return "tcp", addr
+}
+
+// listen listens on TCP address or on unix domain socket if address starts with unix:. Socket file left
+// by server which wasn't stopped cleanly is removed, socket of running server isn't.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	network, address := splitAddr(addr)
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if err := removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+
+	if s.unixSocketPerm == 0 {
+		return net.Listen(network, address)
+	}
+
+	return listenUnixWithPerm(address, s.unixSocketPerm)
+}
+
+// listenUnixWithPerm creates socket in directory accessible only by owner, changes its permissions
+// and then moves it to path, so socket is never accessible with permissions set by umask.
+func listenUnixWithPerm(path string, perm os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".lodge")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// socket is removed by unixListener from its final path
+	l.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmp, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return &unixListener{UnixListener: l, path: path}, nil
+}
+
+// unixListener removes socket file when it's closed.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+
+	return err
+}
+
+// removeStaleSocket removes socket file if nobody listens on it.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and isn't socket", path)
+	}
+
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is used by running server", path)
+	}
+
+	return os.Remove(path)
+}
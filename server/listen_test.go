@@ -0,0 +1,92 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// dialUnix waits until server listens on socket.
+func dialUnix(t *testing.T, path string) net.Conn {
+	for i := 0; i < 50; i++ {
+		if conn, err := net.Dial("unix", path); err == nil {
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Server doesn't listen on %s", path)
+	return nil
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "lodge.sock")
+
+	// socket file is left by server which wasn't stopped cleanly
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	config := DefaultConfig()
+	config.UnixSocketPerm = 0700
+	server := New(NewMemory(time.Minute), config)
+	go server.ListenAndServe("unix:" + path)
+	defer server.Close()
+
+	conn := dialUnix(t, path)
+	defer conn.Close()
+
+	client := &testClient{connection: conn}
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Fatalf("Expected permissions: %v. Got: %v", os.FileMode(0700), info.Mode().Perm())
+	}
+
+	// socket of running server isn't removed
+	other := New(NewMemory(time.Minute), DefaultConfig())
+	if err := other.ListenAndServe("unix:" + path); err == nil {
+		t.Fatalf("Expected error for socket used by running server")
+	}
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
+
+	// socket is removed on close, temporary directory of socket isn't left
+	server.Close()
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty directory. Got: %v, error: %v", files, err)
+	}
+}
+
+func TestListenUnixNotSocket(t *testing.T) {
+	file, err := ioutil.TempFile("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	server := New(NewMemory(time.Minute), DefaultConfig())
+	if err := server.ListenAndServe("unix:" + file.Name()); err == nil {
+		t.Fatalf("Expected error for regular file")
+	}
+
+	if _, err := os.Stat(file.Name()); err != nil {
+		t.Fatalf("Expected file to be kept. Got: %v", err)
+	}
+}
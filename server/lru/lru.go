@@ -7,6 +7,7 @@ import (
 
 type expire interface {
 	expired() bool
+	deadline() int64
 }
 
 type neverExpires struct{}
@@ -15,6 +16,10 @@ func (e neverExpires) expired() bool {
 	return false
 }
 
+func (e neverExpires) deadline() int64 {
+	return 0
+}
+
 type expireAt struct {
 	at int64
 }
@@ -23,10 +28,16 @@ func (e expireAt) expired() bool {
 	return time.Now().Unix() > e.at
 }
 
+func (e expireAt) deadline() int64 {
+	return e.at
+}
+
 type item struct {
 	e     expire
 	key   string
 	value interface{}
+	// version is changed by every modification of element.
+	version uint64
 }
 
 func (i *item) expired() bool {
@@ -46,6 +57,9 @@ type LRU struct {
 	size  int
 	list  *list.List
 	items map[string]*list.Element
+	// version is the last version given to element.
+	version uint64
+	onEvict func(key string, expired bool)
 }
 
 func New(size int) *LRU {
@@ -63,10 +77,12 @@ func (l *LRU) Set(key string, value interface{}, ttl int64) {
 
 		item.value = value
 		item.setTTL(ttl)
+		item.version = l.nextVersion()
 	} else {
 		it := &item{
-			key:   key,
-			value: value,
+			key:     key,
+			value:   value,
+			version: l.nextVersion(),
 		}
 
 		it.setTTL(ttl)
@@ -94,6 +110,61 @@ func (l *LRU) Get(key string) (interface{}, bool) {
 	return nil, false
 }
 
+// Update replaces value of not expired element keeping its expiration time.
+func (l *LRU) Update(key string, value interface{}) bool {
+	if it, ok := l.items[key]; ok {
+		item := it.Value.(*item)
+		if !item.expired() {
+			l.list.MoveToFront(it)
+			item.value = value
+			item.version = l.nextVersion()
+			return true
+		}
+	}
+
+	return false
+}
+
+// Deadline returns expiration unix time of element or 0 if element never expires.
+// Unlike Get it doesn't change recency of element.
+func (l *LRU) Deadline(key string) (int64, bool) {
+	if it, ok := l.items[key]; ok {
+		item := it.Value.(*item)
+		if !item.expired() {
+			return item.e.deadline(), true
+		}
+	}
+
+	return 0, false
+}
+
+// Touch changes version of not expired element, it must be called after value of element is modified in place.
+func (l *LRU) Touch(key string) {
+	if it, ok := l.items[key]; ok {
+		if item := it.Value.(*item); !item.expired() {
+			item.version = l.nextVersion()
+		}
+	}
+}
+
+// Version returns version of element, which is changed by Set, Update, Expire and Touch.
+// Missing or expired element has version 0. Unlike Get it doesn't change recency of element.
+func (l *LRU) Version(key string) uint64 {
+	if it, ok := l.items[key]; ok {
+		if item := it.Value.(*item); !item.expired() {
+			return item.version
+		}
+	}
+
+	return 0
+}
+
+func (l *LRU) nextVersion() uint64 {
+	l.version++
+
+	return l.version
+}
+
 func (l *LRU) Delete(key string) {
 	if it, ok := l.items[key]; ok {
 		l.list.Remove(it)
@@ -101,11 +172,22 @@ func (l *LRU) Delete(key string) {
 	}
 }
 
+// OnEvict sets function which is called when element is evicted. expired is true if element has
+// already expired, expired elements are evicted first once they have been accessed.
+func (l *LRU) OnEvict(fn func(key string, expired bool)) {
+	l.onEvict = fn
+}
+
 func (l *LRU) evict() {
 	it := l.list.Back()
 	if it != nil {
+		item := it.Value.(*item)
 		l.list.Remove(it)
-		delete(l.items, it.Value.(*item).key)
+		delete(l.items, item.key)
+
+		if l.onEvict != nil {
+			l.onEvict(item.key, item.expired())
+		}
 	}
 }
 
@@ -123,13 +205,30 @@ func (l *LRU) Keys() []string {
 	return keys[:i]
 }
 
+// Len returns number of elements including expired ones.
+func (l *LRU) Len() int {
+	return l.list.Len()
+}
+
 func (l *LRU) Expire(key string, ttl int64) bool {
 	if it, ok := l.items[key]; ok {
 		l.list.MoveToFront(it)
 		it.Value.(*item).setTTL(ttl)
+		it.Value.(*item).version = l.nextVersion()
 
 		return true
 	}
 
 	return false
 }
+
+// Each calls fn for every not expired element starting from the least recently used one,
+// so adding elements in the same order restores their recency. expiresAt is unix time or 0.
+func (l *LRU) Each(fn func(key string, value interface{}, expiresAt int64)) {
+	for it := l.list.Back(); it != nil; it = it.Prev() {
+		item := it.Value.(*item)
+		if !item.expired() {
+			fn(item.key, item.value, item.e.deadline())
+		}
+	}
+}
@@ -81,3 +81,23 @@ func assertLastKey(t *testing.T, l *LRU, key string) {
 		t.Fatalf("Expected last key: %v. Got: %v", key, lastItem.key)
 	}
 }
+
+func TestOnEvict(t *testing.T) {
+	lru := New(1)
+
+	var evicted []string
+	lru.OnEvict(func(key string, expired bool) {
+		if expired {
+			t.Fatalf("Key '%v' is not expired", key)
+		}
+		evicted = append(evicted, key)
+	})
+
+	lru.Set("key1", "value1", 0)
+	lru.Set("key1", "value2", 0)
+	lru.Set("key2", "value2", 0)
+
+	if len(evicted) != 1 || evicted[0] != "key1" {
+		t.Fatalf("Expected key1 to be evicted. Got: %v", evicted)
+	}
+}
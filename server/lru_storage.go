@@ -1,6 +1,7 @@
 package server
 
 import (
+	"strconv"
 	"sync"
 
 	"github.com/mkabischev/lodge/server/lru"
@@ -9,7 +10,8 @@ import (
 type lruStorage struct {
 	sync.Mutex
 
-	data *lru.LRU
+	data   *lru.LRU
+	notify NotifyFunc
 }
 
 func NewLRUStorage(l *lru.LRU) Storage {
@@ -23,6 +25,7 @@ func (s *lruStorage) Set(key, value string, ttl int64) error {
 	defer s.Unlock()
 
 	s.data.Set(key, value, ttl)
+	s.emit(EventSet, key)
 
 	return nil
 }
@@ -35,11 +38,92 @@ func (s *lruStorage) Get(key string) (string, error) {
 		if str, ok := val.(string); ok {
 			return str, nil
 		}
+
+		return "", errWrongType
 	}
 
 	return "", errNotFound
 }
 
+func (s *lruStorage) SetIf(key, value string, ttl int64, exists bool) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	val, found := s.data.Get(key)
+	if found != exists {
+		return false, nil
+	}
+	if _, ok := val.(string); found && !ok {
+		return false, errWrongType
+	}
+
+	s.data.Set(key, value, ttl)
+	s.emit(EventSet, key)
+
+	return true, nil
+}
+
+func (s *lruStorage) GetSet(key, value string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	var old string
+	val, found := s.data.Get(key)
+	if found {
+		var ok bool
+		if old, ok = val.(string); !ok {
+			return "", errWrongType
+		}
+	}
+
+	s.data.Set(key, value, 0)
+	s.emit(EventSet, key)
+
+	if !found {
+		return "", errNotFound
+	}
+
+	return old, nil
+}
+
+func (s *lruStorage) Gets(key string) (string, uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	val, ok := s.data.Get(key)
+	if !ok {
+		return "", 0, errNotFound
+	}
+
+	value, ok := val.(string)
+	if !ok {
+		return "", 0, errWrongType
+	}
+
+	return value, s.data.Version(key), nil
+}
+
+func (s *lruStorage) CompareAndSet(key, value string, ttl int64, version uint64) error {
+	s.Lock()
+	defer s.Unlock()
+
+	val, ok := s.data.Get(key)
+	if !ok {
+		return errNotFound
+	}
+	if _, ok := val.(string); !ok {
+		return errWrongType
+	}
+	if s.data.Version(key) != version {
+		return errConflict
+	}
+
+	s.data.Set(key, value, ttl)
+	s.emit(EventSet, key)
+
+	return nil
+}
+
 func (s *lruStorage) HSet(key, field, value string) error {
 	s.Lock()
 	defer s.Unlock()
@@ -48,6 +132,7 @@ func (s *lruStorage) HSet(key, field, value string) error {
 		if hash, ok := val.(map[string]string); ok {
 			hash[field] = value
 			s.data.Set(key, hash, 0)
+			s.emit(EventHSet, key)
 
 			return nil
 		}
@@ -60,16 +145,23 @@ func (s *lruStorage) HSet(key, field, value string) error {
 	}
 
 	s.data.Set(key, hash, 0)
+	s.emit(EventHSet, key)
 
 	return nil
 }
 
 func (s *lruStorage) HGet(key, field string) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
 	if val, ok := s.data.Get(key); ok {
-		if hash, ok := val.(map[string]string); ok {
-			if value, ok := hash[field]; ok {
-				return value, nil
-			}
+		hash, ok := val.(map[string]string)
+		if !ok {
+			return "", errWrongType
+		}
+
+		if value, ok := hash[field]; ok {
+			return value, nil
 		}
 	}
 
@@ -77,6 +169,9 @@ func (s *lruStorage) HGet(key, field string) (string, error) {
 }
 
 func (s *lruStorage) HGetAll(key string) (map[string]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
 	if val, ok := s.data.Get(key); ok {
 		if hash, ok := val.(map[string]string); ok {
 			result := make(map[string]string, len(hash))
@@ -97,7 +192,7 @@ func (s *lruStorage) Delete(key string) error {
 	s.Lock()
 	defer s.Unlock()
 
-	s.data.Delete(key)
+	s.remove(key)
 
 	return nil
 }
@@ -109,10 +204,706 @@ func (s *lruStorage) Keys() ([]string, error) {
 	return s.data.Keys(), nil
 }
 
+func (s *lruStorage) Scan(cursor uint64, count int) ([]string, uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	keys, next := scan(cursor, count, func(fn func(key string)) {
+		s.data.Each(func(key string, value interface{}, expiresAt int64) {
+			fn(key)
+		})
+	})
+
+	return keys, next, nil
+}
+
+func (s *lruStorage) HScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	val, ok := s.data.Get(key)
+	if !ok {
+		return nil, 0, nil
+	}
+
+	hash, ok := val.(map[string]string)
+	if !ok {
+		return nil, 0, errWrongType
+	}
+
+	values, next := scanHash(hash, cursor, count)
+
+	return values, next, nil
+}
+
 func (s *lruStorage) Expire(key string, ttl int64) error {
+	s.Lock()
+	defer s.Unlock()
+
 	if ok := s.data.Expire(key, ttl); ok {
+		s.emit(EventExpire, key)
 		return nil
 	}
 
 	return errNotFound
 }
+
+func (s *lruStorage) IncrBy(key string, delta int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	value := "0"
+	val, found := s.data.Get(key)
+	if found {
+		str, ok := val.(string)
+		if !ok {
+			return 0, errWrongType
+		}
+		value = str
+	}
+
+	result, err := incrBy(value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	if found {
+		s.data.Update(key, strconv.FormatInt(result, 10))
+	} else {
+		s.data.Set(key, strconv.FormatInt(result, 10), 0)
+	}
+	s.emit(EventIncrBy, key)
+
+	return result, nil
+}
+
+func (s *lruStorage) HIncrBy(key, field string, delta int64) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	hash := map[string]string{}
+	val, found := s.data.Get(key)
+	if found {
+		var ok bool
+		if hash, ok = val.(map[string]string); !ok {
+			return 0, errWrongType
+		}
+	}
+
+	value, ok := hash[field]
+	if !ok {
+		value = "0"
+	}
+
+	result, err := incrBy(value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	hash[field] = strconv.FormatInt(result, 10)
+	if found {
+		s.data.Touch(key)
+	} else {
+		s.data.Set(key, hash, 0)
+	}
+	s.emit(EventHIncrBy, key)
+
+	return result, nil
+}
+
+func (s *lruStorage) LPush(key string, values ...string) (int, error) {
+	return s.push(key, values, true)
+}
+
+func (s *lruStorage) RPush(key string, values ...string) (int, error) {
+	return s.push(key, values, false)
+}
+
+func (s *lruStorage) push(key string, values []string, left bool) (int, error) {
+	var length int
+	err := s.updateList(key, pushEvent(left), func(list []string) ([]string, error) {
+		list = pushValues(list, values, left)
+		length = len(list)
+
+		return list, nil
+	})
+
+	return length, err
+}
+
+func (s *lruStorage) LPop(key string) (string, error) {
+	return s.pop(key, true)
+}
+
+func (s *lruStorage) RPop(key string) (string, error) {
+	return s.pop(key, false)
+}
+
+func (s *lruStorage) pop(key string, left bool) (string, error) {
+	var value string
+	err := s.updateList(key, popEvent(left), func(list []string) ([]string, error) {
+		if len(list) == 0 {
+			return nil, errNotFound
+		}
+
+		value, list = popValue(list, left)
+
+		return list, nil
+	})
+
+	return value, err
+}
+
+func (s *lruStorage) LRange(key string, start, stop int) ([]string, error) {
+	var result []string
+	err := s.readList(key, func(list []string) {
+		from, to := listRange(len(list), start, stop)
+		result = copyList(list[from:to])
+	})
+
+	return result, err
+}
+
+func (s *lruStorage) LLen(key string) (int, error) {
+	var length int
+	err := s.readList(key, func(list []string) {
+		length = len(list)
+	})
+
+	return length, err
+}
+
+func (s *lruStorage) LTrim(key string, start, stop int) error {
+	return s.updateList(key, EventLTrim, func(list []string) ([]string, error) {
+		from, to := listRange(len(list), start, stop)
+
+		return list[from:to], nil
+	})
+}
+
+func (s *lruStorage) LIndex(key string, index int) (string, error) {
+	var value string
+	var found bool
+	err := s.readList(key, func(list []string) {
+		if i, ok := listIndex(len(list), index); ok {
+			value, found = list[i], true
+		}
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return value, err
+}
+
+// readList calls fn with list stored in key. Missing key is empty list.
+func (s *lruStorage) readList(key string, fn func(list []string)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var list []string
+	if val, ok := s.data.Get(key); ok {
+		if list, ok = val.([]string); !ok {
+			return errWrongType
+		}
+	}
+
+	fn(list)
+
+	return nil
+}
+
+// updateList replaces list stored in key with list returned by fn. Empty list is removed.
+// Change is reported as event.
+func (s *lruStorage) updateList(key, event string, fn func(list []string) ([]string, error)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var list []string
+	val, found := s.data.Get(key)
+	if found {
+		var ok bool
+		if list, ok = val.([]string); !ok {
+			return errWrongType
+		}
+	}
+
+	list, err := fn(list)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(list) == 0:
+		s.remove(key)
+		return nil
+	case found:
+		s.data.Update(key, list)
+	default:
+		s.data.Set(key, list, 0)
+	}
+	s.emit(event, key)
+
+	return nil
+}
+
+func (s *lruStorage) SAdd(key string, members ...string) (int, error) {
+	var added int
+	err := s.updateSet(key, EventSAdd, func(set map[string]struct{}) error {
+		added = addMembers(set, members)
+
+		return nil
+	})
+
+	return added, err
+}
+
+func (s *lruStorage) SRem(key string, members ...string) (int, error) {
+	var removed int
+	err := s.updateSet(key, EventSRem, func(set map[string]struct{}) error {
+		removed = removeMembers(set, members)
+
+		return nil
+	})
+
+	return removed, err
+}
+
+func (s *lruStorage) SIsMember(key, member string) (bool, error) {
+	var found bool
+	err := s.readSet(key, func(set map[string]struct{}) {
+		_, found = set[member]
+	})
+
+	return found, err
+}
+
+func (s *lruStorage) SMembers(key string) ([]string, error) {
+	var members []string
+	err := s.readSet(key, func(set map[string]struct{}) {
+		members = setMembers(set)
+	})
+
+	return members, err
+}
+
+func (s *lruStorage) SCard(key string) (int, error) {
+	var n int
+	err := s.readSet(key, func(set map[string]struct{}) {
+		n = len(set)
+	})
+
+	return n, err
+}
+
+func (s *lruStorage) SPop(key string) (string, error) {
+	var member string
+	err := s.updateSet(key, EventSPop, func(set map[string]struct{}) error {
+		if len(set) == 0 {
+			return errNotFound
+		}
+
+		member = randomMember(set)
+		delete(set, member)
+
+		return nil
+	})
+
+	return member, err
+}
+
+func (s *lruStorage) SRandMember(key string) (string, error) {
+	var member string
+	var found bool
+	err := s.readSet(key, func(set map[string]struct{}) {
+		if len(set) > 0 {
+			member, found = randomMember(set), true
+		}
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return member, err
+}
+
+func (s *lruStorage) SInter(keys ...string) ([]string, error) {
+	return s.combine(setInter, keys)
+}
+
+func (s *lruStorage) SUnion(keys ...string) ([]string, error) {
+	return s.combine(setUnion, keys)
+}
+
+func (s *lruStorage) SDiff(keys ...string) ([]string, error) {
+	return s.combine(setDiff, keys)
+}
+
+func (s *lruStorage) combine(op setOperation, keys []string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	sets, err := s.sets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return setMembers(combineSets(op, sets)), nil
+}
+
+func (s *lruStorage) SInterStore(dest string, keys ...string) (int, error) {
+	return s.combineStore(setInter, dest, keys)
+}
+
+func (s *lruStorage) SUnionStore(dest string, keys ...string) (int, error) {
+	return s.combineStore(setUnion, dest, keys)
+}
+
+func (s *lruStorage) SDiffStore(dest string, keys ...string) (int, error) {
+	return s.combineStore(setDiff, dest, keys)
+}
+
+func (s *lruStorage) combineStore(op setOperation, dest string, keys []string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	sets, err := s.sets(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	result := combineSets(op, sets)
+	s.replaceSet(op, dest, result)
+
+	return len(result), nil
+}
+
+// storeSet replaces dest with result of set operation computed by bucket storage.
+func (s *lruStorage) storeSet(op setOperation, dest string, result map[string]struct{}) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.replaceSet(op, dest, result)
+}
+
+// replaceSet replaces dest with result of set operation. It must be called with lock held.
+func (s *lruStorage) replaceSet(op setOperation, dest string, result map[string]struct{}) {
+	if len(result) == 0 {
+		s.remove(dest)
+	} else {
+		s.data.Set(dest, result, 0)
+		s.emit(storeEvent(op), dest)
+	}
+}
+
+// sets returns sets stored in keys. It must be called with lock held.
+func (s *lruStorage) sets(keys []string) ([]map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		if val, ok := s.data.Get(key); ok {
+			if sets[i], ok = val.(map[string]struct{}); !ok {
+				return nil, errWrongType
+			}
+		}
+	}
+
+	return sets, nil
+}
+
+// readSet calls fn with set stored in key. Missing key is empty set.
+func (s *lruStorage) readSet(key string, fn func(set map[string]struct{})) error {
+	s.Lock()
+	defer s.Unlock()
+
+	sets, err := s.sets([]string{key})
+	if err != nil {
+		return err
+	}
+
+	fn(sets[0])
+
+	return nil
+}
+
+// updateSet calls fn with set stored in key, fn can modify set. Empty set is removed.
+// Change is reported as event.
+func (s *lruStorage) updateSet(key, event string, fn func(set map[string]struct{}) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	set := make(map[string]struct{})
+	val, found := s.data.Get(key)
+	if found {
+		var ok bool
+		if set, ok = val.(map[string]struct{}); !ok {
+			return errWrongType
+		}
+	}
+
+	if err := fn(set); err != nil {
+		return err
+	}
+
+	switch {
+	case len(set) == 0:
+		s.remove(key)
+		return nil
+	case !found:
+		s.data.Set(key, set, 0)
+	default:
+		s.data.Touch(key)
+	}
+	s.emit(event, key)
+
+	return nil
+}
+
+func (s *lruStorage) ZAdd(key string, members ...ZMember) (int, error) {
+	var added int
+	err := s.updateZSet(key, EventZAdd, func(z *zset) error {
+		for _, member := range members {
+			if z.add(member.Member, member.Score) {
+				added++
+			}
+		}
+
+		return nil
+	})
+
+	return added, err
+}
+
+func (s *lruStorage) ZRem(key string, members ...string) (int, error) {
+	var removed int
+	err := s.updateZSet(key, EventZRem, func(z *zset) error {
+		for _, member := range members {
+			if z.remove(member) {
+				removed++
+			}
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+func (s *lruStorage) ZScore(key, member string) (float64, error) {
+	var score float64
+	var found bool
+	err := s.readZSet(key, func(z *zset) {
+		score, found = z.scores[member]
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return score, err
+}
+
+func (s *lruStorage) ZRank(key, member string) (int, error) {
+	var rank int
+	var found bool
+	err := s.readZSet(key, func(z *zset) {
+		rank, found = z.rank(member)
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return rank, err
+}
+
+func (s *lruStorage) ZRange(key string, start, stop int) ([]ZMember, error) {
+	var result []ZMember
+	err := s.readZSet(key, func(z *zset) {
+		result = z.rangeByRank(start, stop)
+	})
+
+	return result, err
+}
+
+func (s *lruStorage) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	var result []ZMember
+	err := s.readZSet(key, func(z *zset) {
+		result = z.rangeByScore(min, max)
+	})
+
+	return result, err
+}
+
+func (s *lruStorage) ZIncrBy(key, member string, delta float64) (float64, error) {
+	var score float64
+	err := s.updateZSet(key, EventZIncrBy, func(z *zset) error {
+		var err error
+		score, err = zIncrBy(z, member, delta)
+
+		return err
+	})
+
+	return score, err
+}
+
+func (s *lruStorage) ZCard(key string) (int, error) {
+	var n int
+	err := s.readZSet(key, func(z *zset) {
+		n = z.len()
+	})
+
+	return n, err
+}
+
+func (s *lruStorage) ZPopMin(key string) (ZMember, error) {
+	var first ZMember
+	err := s.updateZSet(key, EventZPopMin, func(z *zset) error {
+		if z.len() == 0 {
+			return errNotFound
+		}
+
+		first = z.popMin()
+
+		return nil
+	})
+
+	return first, err
+}
+
+// readZSet calls fn with sorted set stored in key. Missing key is empty sorted set.
+func (s *lruStorage) readZSet(key string, fn func(z *zset)) error {
+	s.Lock()
+	defer s.Unlock()
+
+	z := newZSet()
+	if val, ok := s.data.Get(key); ok {
+		if z, ok = val.(*zset); !ok {
+			return errWrongType
+		}
+	}
+
+	fn(z)
+
+	return nil
+}
+
+// updateZSet calls fn with sorted set stored in key, fn can modify sorted set. Empty sorted set is removed.
+// Change is reported as event.
+func (s *lruStorage) updateZSet(key, event string, fn func(z *zset) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	z := newZSet()
+	val, found := s.data.Get(key)
+	if found {
+		var ok bool
+		if z, ok = val.(*zset); !ok {
+			return errWrongType
+		}
+	}
+
+	if err := fn(z); err != nil {
+		return err
+	}
+
+	switch {
+	case z.len() == 0:
+		s.remove(key)
+		return nil
+	case !found:
+		s.data.Set(key, z, 0)
+	default:
+		s.data.Touch(key)
+	}
+	s.emit(event, key)
+
+	return nil
+}
+
+func (s *lruStorage) TTL(key string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if expiresAt, ok := s.data.Deadline(key); ok {
+		return ttlUntil(expiresAt), nil
+	}
+
+	return 0, errNotFound
+}
+
+func (s *lruStorage) Dump(fn func(Entry) error) error {
+	s.Lock()
+	entries := make([]Entry, 0, s.data.Len())
+	s.data.Each(func(key string, value interface{}, expiresAt int64) {
+		entries = append(entries, Entry{
+			Key:   key,
+			Value: copyValue(value),
+			TTL:   ttlUntil(expiresAt),
+		})
+	})
+	s.Unlock()
+
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *lruStorage) Restore(e Entry) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.data.Set(e.Key, copyValue(e.Value), e.TTL)
+	s.emit(EventRestore, e.Key)
+
+	return nil
+}
+
+func (s *lruStorage) Version(key string) (uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.data.Version(key), nil
+}
+
+// Atomic locks the whole storage, so keys are ignored.
+func (s *lruStorage) Atomic(keys []string, fn func(Storage) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	// view shares data, but has its own lock which is held by nobody
+	return fn(&lruStorage{data: s.data, notify: s.notify})
+}
+
+// Notify sets function which is called on every change of key. Expired keys are reported when
+// they are evicted, because LRU doesn't remove them earlier.
+func (s *lruStorage) Notify(fn NotifyFunc) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.notify = fn
+	s.data.OnEvict(func(key string, expired bool) {
+		if expired {
+			s.emit(EventExpired, key)
+		} else {
+			s.emit(EventEvicted, key)
+		}
+	})
+}
+
+// emit reports change of key. It must be called with lock held.
+func (s *lruStorage) emit(event, key string) {
+	if s.notify != nil {
+		s.notify(event, key)
+	}
+}
+
+// remove deletes key and reports it if key existed. It must be called with lock held.
+func (s *lruStorage) remove(key string) {
+	existed := s.data.Version(key) != 0
+	s.data.Delete(key)
+	if existed {
+		s.emit(EventDel, key)
+	}
+}
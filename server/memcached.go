@@ -0,0 +1,728 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mkabischev/lodge/ioutil"
+)
+
+// Memcached text protocol support. Like RESP, memcached commands are translated to lodge
+// requests, so they go through the same storage, journal and replication.
+//
+// Differences from memcached:
+//   - non-zero flags are stored as prefix of value, so such values look prefixed to clients
+//     of other protocols;
+//   - cas unique is version of key, which is changed by every write of any client;
+//   - hash keys are invisible for get and gets.
+
+// Version is reported by memcached "version" command.
+const Version = "1.0.0"
+
+const (
+	maxMemcachedKeyLength   = 250
+	maxMemcachedValueLength = 1024 * 1024
+
+	// exptime greater than 30 days is treated as unix time.
+	maxMemcachedRelativeExptime = 60 * 60 * 24 * 30
+
+	// memcachedFlagsPrefix starts value with flags: prefix, decimal flags, space and data.
+	memcachedFlagsPrefix = "\x00flags:"
+)
+
+var errTooLarge = errors.New("Object too large for cache")
+
+var (
+	memcachedStored    = "STORED"
+	memcachedNotStored = "NOT_STORED"
+	memcachedExists    = "EXISTS"
+	memcachedNotFound  = "NOT_FOUND"
+	memcachedDeleted   = "DELETED"
+	memcachedTouched   = "TOUCHED"
+	memcachedEnd       = "END"
+	memcachedError     = "ERROR"
+	memcachedBadFormat = "CLIENT_ERROR bad command line format"
+	memcachedBadChunk  = "CLIENT_ERROR bad data chunk"
+	memcachedNonNumber = "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	memcachedTooLarge  = "SERVER_ERROR object too large for cache"
+)
+
+type memcachedCommand func(s *Server, c *memcachedConn, args []string) (string, error)
+
+var memcachedCommands = map[string]memcachedCommand{
+	"get":       memcachedGet,
+	"gets":      memcachedGets,
+	"set":       memcachedSet,
+	"add":       memcachedAdd,
+	"replace":   memcachedReplace,
+	"append":    memcachedAppend,
+	"prepend":   memcachedPrepend,
+	"cas":       memcachedCas,
+	"delete":    memcachedDelete,
+	"incr":      memcachedIncr,
+	"decr":      memcachedDecr,
+	"touch":     memcachedTouch,
+	"flush_all": memcachedFlushAll,
+	"version":   memcachedVersion,
+	"verbosity": memcachedVerbosity,
+}
+
+// ServeMemcached accepts connections which use memcached text protocol.
+func (s *Server) ServeMemcached(l net.Listener) error {
+	return s.serve(l, s.handleMemcachedConnection, memcachedRejection)
+}
+
+func memcachedRejection(conn net.Conn) []byte {
+	return []byte("SERVER_ERROR max number of clients reached\r\n")
+}
+
+func (s *Server) ListenAndServeMemcached(addr string) error {
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeMemcached(l)
+}
+
+// memcachedConn is connection of memcached client.
+type memcachedConn struct {
+	*connection
+	w *bufio.Writer
+}
+
+func (s *Server) handleMemcachedConnection(conn *connection) {
+	defer conn.Close()
+
+	c := &memcachedConn{
+		connection: conn,
+		w:          conn.writer,
+	}
+
+	for {
+		if err := conn.waitRequest(); err != nil {
+			return
+		}
+
+		line, err := readLine(conn.reader)
+		if err != nil {
+			if err == errHeaderTooLong {
+				c.w.WriteString("CLIENT_ERROR line too long\r\n")
+				c.w.Flush()
+			}
+			return
+		}
+
+		args := strings.Fields(string(line))
+		if len(args) == 0 {
+			c.writeLine(memcachedError)
+		} else if args[0] == "quit" {
+			return
+		} else if cmd, ok := memcachedCommands[args[0]]; !ok {
+			c.writeLine(memcachedError)
+		} else {
+			// noreply is always the last argument
+			noreply := len(args) > 1 && args[len(args)-1] == "noreply"
+			if noreply {
+				args = args[:len(args)-1]
+			}
+
+			reply, err := cmd(s, c, args[1:])
+			if err != nil {
+				reply = memcachedErrorReply(err)
+			}
+			if !noreply && reply != "" {
+				c.writeLine(reply)
+			}
+		}
+
+		// replies are flushed when there are no more pipelined requests
+		if conn.Drained() {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+			conn.setIdle(true)
+		}
+	}
+}
+
+func (c *memcachedConn) writeLine(line string) {
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// writeValue writes stored value of key, cas unique is written if it isn't empty.
+func (c *memcachedConn) writeValue(key, stored, cas string) {
+	flags, value := decodeMemcachedValue(stored)
+
+	c.w.WriteString("VALUE ")
+	c.w.WriteString(key)
+	c.w.WriteString(" ")
+	c.w.WriteString(strconv.FormatUint(uint64(flags), 10))
+	c.w.WriteString(" ")
+	c.w.WriteString(strconv.Itoa(len(value)))
+	if cas != "" {
+		c.w.WriteString(" ")
+		c.w.WriteString(cas)
+	}
+	c.w.WriteString("\r\n")
+	c.w.WriteString(value)
+	c.w.WriteString("\r\n")
+}
+
+// readData reads data block of storage command.
+func (c *memcachedConn) readData(length string) (string, error) {
+	n, err := strconv.Atoi(length)
+	if err != nil || n < 0 {
+		// data can't be skipped, so everything received so far is dropped
+		c.reader.Discard(c.reader.Buffered())
+		return "", errArguments
+	}
+
+	if n > maxMemcachedValueLength {
+		c.reader.Discard(n + 2)
+		return "", errTooLarge
+	}
+
+	data, err := ioutil.Read(c.reader, n)
+	if err != nil {
+		return "", err
+	}
+
+	tail, err := readLine(c.reader)
+	if err != nil {
+		return "", err
+	}
+	if len(tail) != 0 {
+		return "", errBadFormat
+	}
+
+	return string(data), nil
+}
+
+// encodeMemcachedValue returns value which is stored for data with flags. Data without flags is stored
+// as is, unless it looks like encoded value.
+func encodeMemcachedValue(flags uint32, data string) string {
+	if flags == 0 && !strings.HasPrefix(data, memcachedFlagsPrefix) {
+		return data
+	}
+
+	return memcachedFlagsPrefix + strconv.FormatUint(uint64(flags), 10) + " " + data
+}
+
+// decodeMemcachedValue returns flags and data of stored value. Values written by clients of other
+// protocols have no flags.
+func decodeMemcachedValue(value string) (uint32, string) {
+	if !strings.HasPrefix(value, memcachedFlagsPrefix) {
+		return 0, value
+	}
+
+	encoded := value[len(memcachedFlagsPrefix):]
+	i := strings.IndexByte(encoded, ' ')
+	if i < 0 {
+		return 0, value
+	}
+	flags, err := strconv.ParseUint(encoded[:i], 10, 32)
+	if err != nil {
+		return 0, value
+	}
+
+	return uint32(flags), encoded[i+1:]
+}
+
+func memcachedErrorReply(err error) string {
+	switch err {
+	case errNotFound:
+		return memcachedNotFound
+	case errBadFormat:
+		return memcachedBadChunk
+	case errTooLarge:
+		return memcachedTooLarge
+	case errArguments:
+		return memcachedBadFormat
+	case errAuthRequired:
+		return "CLIENT_ERROR authentication required"
+	case errWrongType:
+		return "CLIENT_ERROR key holds hash value"
+	case errReadOnly:
+		return "SERVER_ERROR replica is read-only"
+	case errNoPerm:
+		return "CLIENT_ERROR no permissions"
+	default:
+		return "SERVER_ERROR " + err.Error()
+	}
+}
+
+func validKey(key string) bool {
+	if len(key) > maxMemcachedKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] < ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseExptime converts memcached expiration time to lodge ttl. Exptime up to 30 days is
+// number of seconds, greater exptime is unix time. expired is true if item must not be stored at all.
+func parseExptime(s string) (ttl int64, expired bool, err error) {
+	exptime, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false, errArguments
+	}
+
+	switch {
+	case exptime == 0:
+		return 0, false, nil
+	case exptime < 0:
+		return 0, true, nil
+	case exptime <= maxMemcachedRelativeExptime:
+		return exptime, false, nil
+	}
+
+	ttl = exptime - time.Now().Unix()
+	if ttl <= 0 {
+		return 0, true, nil
+	}
+
+	return ttl, false, nil
+}
+
+func (s *Server) memcachedStore(c *memcachedConn, key, value string, ttl int64, expired bool) error {
+	if expired {
+		_, err := s.execute(c.connection, newRequest("DELETE", key))
+		return err
+	}
+
+	_, err := s.execute(c.connection, newDataRequest("SET", value, key, strconv.FormatInt(ttl, 10)))
+	return err
+}
+
+func memcachedGet(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedRetrieve(c, args, false)
+}
+
+func memcachedGets(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedRetrieve(c, args, true)
+}
+
+func (s *Server) memcachedRetrieve(c *memcachedConn, keys []string, cas bool) (string, error) {
+	if len(keys) == 0 {
+		return memcachedError, nil
+	}
+
+	for _, key := range keys {
+		if !validKey(key) {
+			return memcachedBadFormat, nil
+		}
+	}
+
+	command := "GET"
+	if cas {
+		command = "GETS"
+	}
+
+	for _, key := range keys {
+		values, err := s.execute(c.connection, newRequest(command, key))
+		switch err {
+		case nil:
+			if cas {
+				c.writeValue(key, values[0], values[1])
+			} else {
+				c.writeValue(key, values[0], "")
+			}
+		case errNotFound, errWrongType:
+		default:
+			return "", err
+		}
+	}
+
+	return memcachedEnd, nil
+}
+
+// storageArguments parses "<key> <flags> <exptime> <bytes>" and reads data block. Returned value
+// is data encoded with flags.
+func storageArguments(c *memcachedConn, args []string, n int) (key, value string, ttl int64, expired bool, err error) {
+	if len(args) != n {
+		return "", "", 0, false, errArguments
+	}
+
+	// data block is read before validation, so it isn't treated as next command
+	data, err := c.readData(args[3])
+	if err != nil {
+		return "", "", 0, false, err
+	}
+
+	if !validKey(args[0]) {
+		return "", "", 0, false, errArguments
+	}
+	flags, err := strconv.ParseUint(args[1], 10, 32)
+	if err != nil {
+		return "", "", 0, false, errArguments
+	}
+
+	ttl, expired, err = parseExptime(args[2])
+	if err != nil {
+		return "", "", 0, false, err
+	}
+
+	return args[0], encodeMemcachedValue(uint32(flags), data), ttl, expired, nil
+}
+
+func memcachedSet(s *Server, c *memcachedConn, args []string) (string, error) {
+	key, value, ttl, expired, err := storageArguments(c, args, 4)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.memcachedStore(c, key, value, ttl, expired); err != nil {
+		return "", err
+	}
+
+	return memcachedStored, nil
+}
+
+func memcachedAdd(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedStoreIf(c, args, false)
+}
+
+func memcachedReplace(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedStoreIf(c, args, true)
+}
+
+// memcachedStoreIf stores value only if key existence is equal to exists.
+func (s *Server) memcachedStoreIf(c *memcachedConn, args []string, exists bool) (string, error) {
+	key, value, ttl, expired, err := storageArguments(c, args, 4)
+	if err != nil {
+		return "", err
+	}
+
+	// storage is used directly, so request checks of execute are done here
+	if !c.authenticated {
+		return "", errAuthRequired
+	}
+	if err := s.authorize(c.connection, "SET", []string{key}, false); err != nil {
+		return "", err
+	}
+
+	// expired item is stored and deleted while key is locked, so it replaces existing item like set does
+	// and can't be read by other clients
+	var stored bool
+	err = s.storage.Atomic([]string{key}, func(storage Storage) error {
+		var err error
+		if stored, err = storage.SetIf(key, value, ttl, exists); err != nil || !stored || !expired {
+			return err
+		}
+
+		return storage.Delete(key)
+	})
+	switch {
+	case err != nil:
+		return "", err
+	case !stored:
+		return memcachedNotStored, nil
+	}
+
+	return memcachedStored, nil
+}
+
+func memcachedAppend(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedConcat(c, args, func(old, data string) string { return old + data })
+}
+
+func memcachedPrepend(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedConcat(c, args, func(old, data string) string { return data + old })
+}
+
+// memcachedConcat adds data to existing value. Flags and exptime are ignored, ttl of item is kept.
+func (s *Server) memcachedConcat(c *memcachedConn, args []string, concat func(old, data string) string) (string, error) {
+	key, value, _, _, err := storageArguments(c, args, 4)
+	if err != nil {
+		return "", err
+	}
+	_, data := decodeMemcachedValue(value)
+
+	err = s.memcachedUpdate(c, "SET", key, func(old string) (string, error) {
+		return concat(old, data), nil
+	})
+	switch err {
+	case nil:
+	case errNotFound, errWrongType:
+		return memcachedNotStored, nil
+	default:
+		return "", err
+	}
+
+	return memcachedStored, nil
+}
+
+// memcachedUpdate replaces data of key with data returned by update, flags and ttl of key are kept.
+// Key is locked in storage, so it can't be changed by other clients between reading and writing.
+func (s *Server) memcachedUpdate(c *memcachedConn, command, key string, update func(old string) (string, error)) error {
+	// storage is used directly, so request checks of execute are done here
+	if !c.authenticated {
+		return errAuthRequired
+	}
+	if err := s.authorize(c.connection, command, []string{key}, false); err != nil {
+		return err
+	}
+
+	return s.storage.Atomic([]string{key}, func(storage Storage) error {
+		old, err := storage.Get(key)
+		if err != nil {
+			return err
+		}
+		ttl, err := storage.TTL(key)
+		if err != nil {
+			return err
+		}
+
+		flags, data := decodeMemcachedValue(old)
+		data, err = update(data)
+		if err != nil {
+			return err
+		}
+
+		return storage.Set(key, encodeMemcachedValue(flags, data), ttl)
+	})
+}
+
+func memcachedCas(s *Server, c *memcachedConn, args []string) (string, error) {
+	if len(args) != 5 {
+		return "", errArguments
+	}
+
+	key, value, ttl, expired, err := storageArguments(c, args[:4], 4)
+	if err != nil {
+		return "", err
+	}
+
+	version, err := strconv.ParseUint(args[4], 10, 64)
+	if err != nil {
+		return "", errArguments
+	}
+
+	// storage is used directly, so request checks of execute are done here
+	if !c.authenticated {
+		return "", errAuthRequired
+	}
+	if err := s.authorize(c.connection, "CAS", []string{key}, false); err != nil {
+		return "", err
+	}
+
+	// expired item is deleted while key is locked, so it can't be read by other clients
+	err = s.storage.Atomic([]string{key}, func(storage Storage) error {
+		if err := storage.CompareAndSet(key, value, ttl, version); err != nil || !expired {
+			return err
+		}
+
+		return storage.Delete(key)
+	})
+	switch err {
+	case nil:
+	case errNotFound, errWrongType:
+		return memcachedNotFound, nil
+	case errConflict:
+		return memcachedExists, nil
+	default:
+		return "", err
+	}
+
+	return memcachedStored, nil
+}
+
+func memcachedDelete(s *Server, c *memcachedConn, args []string) (string, error) {
+	// "delete <key> 0" is accepted for compatibility with old clients
+	if len(args) == 2 && args[1] == "0" {
+		args = args[:1]
+	}
+	if len(args) != 1 || !validKey(args[0]) {
+		return "", errArguments
+	}
+
+	if !c.authenticated {
+		return "", errAuthRequired
+	}
+	if err := s.authorize(c.connection, "DELETE", args, false); err != nil {
+		return "", err
+	}
+
+	// existence is checked with key locked, so only one of concurrent deletes finds it
+	err := s.storage.Atomic(args, func(storage Storage) error {
+		if _, err := storage.TTL(args[0]); err != nil {
+			return err
+		}
+
+		return storage.Delete(args[0])
+	})
+	switch err {
+	case nil:
+	case errNotFound:
+		return memcachedNotFound, nil
+	default:
+		return "", err
+	}
+
+	return memcachedDeleted, nil
+}
+
+func memcachedIncr(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedIncrement(c, args, true)
+}
+
+func memcachedDecr(s *Server, c *memcachedConn, args []string) (string, error) {
+	return s.memcachedIncrement(c, args, false)
+}
+
+// memcachedIncrement changes 64-bit unsigned value. Incrementing wraps around on overflow,
+// decrementing below zero gives zero.
+func (s *Server) memcachedIncrement(c *memcachedConn, args []string, incr bool) (string, error) {
+	if len(args) != 2 || !validKey(args[0]) {
+		return "", errArguments
+	}
+
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument", nil
+	}
+
+	var result string
+	err = s.memcachedUpdate(c, "INCRBY", args[0], func(old string) (string, error) {
+		value, err := strconv.ParseUint(old, 10, 64)
+		if err != nil {
+			return "", errNotInteger
+		}
+
+		switch {
+		case incr:
+			value += delta
+		case delta > value:
+			value = 0
+		default:
+			value -= delta
+		}
+
+		result = strconv.FormatUint(value, 10)
+		return result, nil
+	})
+	switch err {
+	case nil:
+	case errNotFound:
+		return memcachedNotFound, nil
+	case errWrongType, errNotInteger:
+		return memcachedNonNumber, nil
+	default:
+		return "", err
+	}
+
+	return result, nil
+}
+
+func memcachedTouch(s *Server, c *memcachedConn, args []string) (string, error) {
+	if len(args) != 2 || !validKey(args[0]) {
+		return "", errArguments
+	}
+
+	ttl, expired, err := parseExptime(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	request := newRequest("EXPIRE", args[0], strconv.FormatInt(ttl, 10))
+	if expired {
+		request = newRequest("EXPIREAT", args[0], "1")
+	}
+
+	if _, err := s.execute(c.connection, request); err != nil {
+		return "", err
+	}
+
+	return memcachedTouched, nil
+}
+
+// memcachedFlushAll deletes all keys immediately or after delay in seconds.
+func memcachedFlushAll(s *Server, c *memcachedConn, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errArguments
+	}
+
+	var delay int64
+	if len(args) == 1 {
+		n, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || n < 0 {
+			return "", errArguments
+		}
+		delay = n
+	}
+
+	// permissions are checked before flush is scheduled, flush itself doesn't use connection which
+	// can be closed by then
+	if !c.authenticated {
+		return "", errAuthRequired
+	}
+	if err := s.authorize(c.connection, "KEYS", nil, true); err != nil {
+		return "", err
+	}
+	if err := s.authorize(c.connection, "DELETE", nil, true); err != nil {
+		return "", err
+	}
+
+	if delay > 0 {
+		s.scheduleFlush(time.Duration(delay) * time.Second)
+		return "OK", nil
+	}
+
+	if err := s.flushAll(); err != nil {
+		return "", err
+	}
+
+	return "OK", nil
+}
+
+// scheduleFlush deletes all keys after delay unless server is closed before.
+func (s *Server) scheduleFlush(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.mu.Lock()
+		delete(s.flushTimers, timer)
+		s.mu.Unlock()
+
+		if err := s.flushAll(); err != nil {
+			log.Printf("Delayed flush_all failed: %v", err)
+		}
+	})
+
+	if s.flushTimers == nil {
+		s.flushTimers = make(map[*time.Timer]struct{})
+	}
+	s.flushTimers[timer] = struct{}{}
+}
+
+// flushAll deletes all keys.
+func (s *Server) flushAll() error {
+	return clearStorage(s.storage)
+}
+
+func memcachedVersion(s *Server, c *memcachedConn, args []string) (string, error) {
+	return "VERSION " + Version, nil
+}
+
+func memcachedVerbosity(s *Server, c *memcachedConn, args []string) (string, error) {
+	return "OK", nil
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/server/lru"
+	"github.com/mkabischev/lodge/testutil"
+)
+
+func testMemcachedServer(t *testing.T) (*testClient, io.Closer) {
+	l, conn := testutil.NextListener(t)
+
+	storage := NewBucketStorage(10, func() Storage {
+		return NewLRUStorage(lru.New(1000))
+	})
+
+	server := New(storage, DefaultConfig())
+	go server.ServeMemcached(l)
+
+	return &testClient{connection: conn}, server
+}
+
+func TestMemcachedCommands(t *testing.T) {
+	client, closer := testMemcachedServer(t)
+	defer closer.Close()
+
+	cases := []struct {
+		request  string
+		expected string
+	}{
+		{"get foo\r\n", "END\r\n"},
+		{"set foo 5 0 3\r\nbar\r\n", "STORED\r\n"},
+		{"get foo missing\r\n", "VALUE foo 5 3\r\nbar\r\nEND\r\n"},
+		{"add foo 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"add new 0 0 1\r\nx\r\n", "STORED\r\n"},
+		{"replace missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"replace new 0 0 1\r\ny\r\n", "STORED\r\n"},
+		{"append new 0 0 2\r\nzz\r\n", "STORED\r\n"},
+		{"prepend new 0 0 1\r\nx\r\n", "STORED\r\n"},
+		{"append missing 0 0 1\r\nx\r\n", "NOT_STORED\r\n"},
+		{"get new\r\n", "VALUE new 0 4\r\nxyzz\r\nEND\r\n"},
+		{"append foo 7 0 1\r\nx\r\n", "STORED\r\n"},
+		{"get foo\r\n", "VALUE foo 5 4\r\nbarx\r\nEND\r\n"},
+		{"set flags 4294967295 0 1\r\n1\r\n", "STORED\r\n"},
+		{"incr flags 1\r\n", "2\r\n"},
+		{"get flags\r\n", "VALUE flags 4294967295 1\r\n2\r\nEND\r\n"},
+		{"set flags 4294967296 0 1\r\n1\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"set counter 0 0 2\r\n10\r\n", "STORED\r\n"},
+		{"incr counter 5\r\n", "15\r\n"},
+		{"decr counter 20\r\n", "0\r\n"},
+		{"incr foo 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"incr missing 1\r\n", "NOT_FOUND\r\n"},
+		{"set max 0 0 20\r\n18446744073709551615\r\n", "STORED\r\n"},
+		{"incr max 2\r\n", "1\r\n"},
+		{"touch foo 100\r\n", "TOUCHED\r\n"},
+		{"touch missing 100\r\n", "NOT_FOUND\r\n"},
+		{"delete foo\r\n", "DELETED\r\n"},
+		{"delete foo\r\n", "NOT_FOUND\r\n"},
+		{"set expired 0 -1 1\r\nx\r\n", "STORED\r\n"},
+		{"get expired\r\n", "END\r\n"},
+		{"add expired 0 -1 1\r\nx\r\n", "STORED\r\n"},
+		{"set old 0 0 1\r\nx\r\n", "STORED\r\n"},
+		{"replace old 0 -1 1\r\ny\r\n", "STORED\r\n"},
+		{"get expired old\r\n", "END\r\n"},
+		{"replace old 0 -1 1\r\ny\r\n", "NOT_STORED\r\n"},
+		{"set quiet 0 0 1 noreply\r\nx\r\nget quiet\r\n", "VALUE quiet 0 1\r\nx\r\nEND\r\n"},
+		{"set foo 0 0 3\r\nbarbaz\r\n", "CLIENT_ERROR bad data chunk\r\n"},
+		{"set foo 0 0 x\r\n", "CLIENT_ERROR bad command line format\r\n"},
+		{"flush_all\r\n", "OK\r\n"},
+		{"get new counter\r\n", "END\r\n"},
+		{"version\r\n", "VERSION " + Version + "\r\n"},
+		{"unknown\r\n", "ERROR\r\n"},
+	}
+
+	for _, tc := range cases {
+		client.assertRequest(t, []byte(tc.request), []byte(tc.expected))
+	}
+}
+
+func TestMemcachedCas(t *testing.T) {
+	client, closer := testMemcachedServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("set foo 0 0 3\r\nbar\r\n"), []byte("STORED\r\n"))
+
+	reply := string(client.send(t, []byte("gets foo\r\n"), 0))
+	fields := strings.Fields(reply)
+	if len(fields) != 7 || fields[0] != "VALUE" || fields[5] != "bar" || fields[6] != "END" {
+		t.Fatalf("Expected value with cas unique. Got: %q", reply)
+	}
+	cas := fields[4]
+
+	client.assertRequest(t, []byte("cas missing 0 0 3 1\r\nbaz\r\n"), []byte("NOT_FOUND\r\n"))
+	client.assertRequest(t, []byte("cas foo 0 0 3 "+cas+"\r\nbaz\r\n"), []byte("STORED\r\n"))
+
+	// value was changed by previous cas
+	client.assertRequest(t, []byte("cas foo 0 0 3 "+cas+"\r\nqux\r\n"), []byte("EXISTS\r\n"))
+	client.assertRequest(t, []byte("get foo\r\n"), []byte("VALUE foo 0 3\r\nbaz\r\nEND\r\n"))
+
+	// the same value written again changes cas unique
+	reply = string(client.send(t, []byte("gets foo\r\n"), 0))
+	cas = strings.Fields(reply)[4]
+	client.assertRequest(t, []byte("set foo 0 0 3\r\nbaz\r\n"), []byte("STORED\r\n"))
+	client.assertRequest(t, []byte("cas foo 0 0 3 "+cas+"\r\nqux\r\n"), []byte("EXISTS\r\n"))
+}
+
+func TestMemcachedIncrAtomic(t *testing.T) {
+	memcachedListener, client := testutil.NextListener(t)
+	respListener, _ := testutil.NextListener(t)
+
+	server := New(NewBucketStorage(10, func() Storage { return NewMemory(time.Minute) }), DefaultConfig())
+	go server.ServeMemcached(memcachedListener)
+	go server.ServeRESP(respListener)
+	defer server.Close()
+
+	(&testClient{connection: client}).assertRequest(t, []byte("set counter 0 0 1\r\n0\r\n"), []byte("STORED\r\n"))
+
+	// memcached and RESP clients increment the same key concurrently
+	requests := []struct {
+		l       net.Listener
+		request string
+	}{
+		{memcachedListener, "incr counter 1\r\n"},
+		{memcachedListener, "incr counter 1\r\n"},
+		{respListener, "*2\r\n$4\r\nINCR\r\n$7\r\ncounter\r\n"},
+		{respListener, "*2\r\n$4\r\nINCR\r\n$7\r\ncounter\r\n"},
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range requests {
+		conn, err := net.Dial("tcp", r.l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		wg.Add(1)
+		go func(conn net.Conn, request string) {
+			defer wg.Done()
+
+			reader := bufio.NewReader(conn)
+			for i := 0; i < 1000; i++ {
+				if _, err := conn.Write([]byte(request)); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := reader.ReadString('\n'); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(conn, r.request)
+	}
+	wg.Wait()
+
+	(&testClient{connection: client}).assertRequest(t, []byte("get counter\r\n"), []byte("VALUE counter 0 4\r\n4000\r\nEND\r\n"))
+}
+
+func TestMemcachedDelayedFlushAll(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	l, conn := testutil.NextListener(t)
+
+	storage := NewMemory(time.Minute)
+	server := New(storage, DefaultConfig())
+	go server.ServeMemcached(l)
+	defer server.Close()
+
+	client := &testClient{connection: conn}
+	client.assertRequest(t, []byte("set foo 0 0 3\r\nbar\r\n"), []byte("STORED\r\n"))
+	client.assertRequest(t, []byte("flush_all 1\r\n"), []byte("OK\r\n"))
+	client.assertRequest(t, []byte("get foo\r\n"), []byte("VALUE foo 0 3\r\nbar\r\nEND\r\n"))
+
+	// flush doesn't depend on connection which scheduled it
+	conn.Close()
+	time.Sleep(1500 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &testClient{connection: conn}
+	client.assertRequest(t, []byte("get foo\r\n"), []byte("END\r\n"))
+
+	// delayed flush is cancelled when server is closed
+	client.assertRequest(t, []byte("set foo 0 0 3\r\nbar\r\n"), []byte("STORED\r\n"))
+	client.assertRequest(t, []byte("flush_all 1\r\n"), []byte("OK\r\n"))
+	server.Close()
+	time.Sleep(1500 * time.Millisecond)
+
+	if _, err := storage.Get("foo"); err != nil {
+		t.Fatalf("Expected foo to be kept. Got: %v", err)
+	}
+}
+
+func TestMemcachedAuthRequired(t *testing.T) {
+	l, conn := testutil.NextListener(t)
+
+	users, _ := NewUserListFromReader(bytes.NewBufferString("default:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+
+	config := DefaultConfig()
+	config.Users = users
+
+	server := New(NewMemory(time.Minute), config)
+	go server.ServeMemcached(l)
+	defer server.Close()
+
+	client := &testClient{connection: conn}
+	client.assertRequest(t, []byte("get foo\r\n"), []byte("CLIENT_ERROR authentication required\r\n"))
+}
+
+func TestMemcachedValueEncoding(t *testing.T) {
+	cases := []struct {
+		flags uint32
+		data  string
+	}{
+		{0, "value"},
+		{0, ""},
+		{1, "value"},
+		{4294967295, ""},
+		{0, memcachedFlagsPrefix + "1 value"},
+	}
+
+	for _, tc := range cases {
+		flags, data := decodeMemcachedValue(encodeMemcachedValue(tc.flags, tc.data))
+		if flags != tc.flags || data != tc.data {
+			t.Fatalf("Expected: %d %q. Got: %d %q", tc.flags, tc.data, flags, data)
+		}
+	}
+
+	// values of other clients are returned as is
+	for _, value := range []string{"plain", memcachedFlagsPrefix, memcachedFlagsPrefix + "x value"} {
+		if flags, data := decodeMemcachedValue(value); flags != 0 || data != value {
+			t.Fatalf("Expected: 0 %q. Got: %d %q", value, flags, data)
+		}
+	}
+}
+
+func TestParseExptime(t *testing.T) {
+	cases := []struct {
+		exptime string
+		ttl     int64
+		expired bool
+	}{
+		{"0", 0, false},
+		{"100", 100, false},
+		{"-1", 0, true},
+		{"1", 1, false},
+		{"2592000", 2592000, false},
+		{"2592001", 0, true},
+	}
+
+	for _, tc := range cases {
+		ttl, expired, err := parseExptime(tc.exptime)
+		if err != nil {
+			t.Fatalf("Unexpected error %v for %s", err, tc.exptime)
+		}
+
+		if ttl != tc.ttl || expired != tc.expired {
+			t.Fatalf("Expected: %d %v. Got: %d %v", tc.ttl, tc.expired, ttl, expired)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+// Keyspace notifications. Storages report every change of key to function passed to Storage.Notify.
+// Server publishes them to pub/sub channels like Redis does: event name is published to
+// __keyspace@0__:<key> and key is published to __keyevent@0__:<event>, so clients can subscribe
+// to events of keys matching pattern with PSUBSCRIBE __keyspace@0__:<pattern>.
+
+// Events reported by storages. Modifications of lists, sets and sorted sets are reported with names
+// of their commands. Key which becomes empty collection is deleted, which is reported as EventDel.
+const (
+	EventSet     = "set"
+	EventHSet    = "hset"
+	EventDel     = "del"
+	EventExpire  = "expire"
+	EventExpired = "expired"
+	EventEvicted = "evicted"
+	EventRestore = "restore"
+
+	EventIncrBy      = "incrby"
+	EventHIncrBy     = "hincrby"
+	EventLPush       = "lpush"
+	EventRPush       = "rpush"
+	EventLPop        = "lpop"
+	EventRPop        = "rpop"
+	EventLTrim       = "ltrim"
+	EventSAdd        = "sadd"
+	EventSRem        = "srem"
+	EventSPop        = "spop"
+	EventSInterStore = "sinterstore"
+	EventSUnionStore = "sunionstore"
+	EventSDiffStore  = "sdiffstore"
+	EventZAdd        = "zadd"
+	EventZRem        = "zrem"
+	EventZIncrBy     = "zincrby"
+	EventZPopMin     = "zpopmin"
+)
+
+const (
+	keyspacePrefix = "__keyspace@0__:"
+	keyeventPrefix = "__keyevent@0__:"
+)
+
+// NotifyFunc is called by storage when key is changed. It's called while storage is locked,
+// so it must be fast and must not use storage.
+type NotifyFunc func(event, key string)
+
+// storeEvent returns event of set operation which stores result.
+func storeEvent(op setOperation) string {
+	switch op {
+	case setInter:
+		return EventSInterStore
+	case setUnion:
+		return EventSUnionStore
+	default:
+		return EventSDiffStore
+	}
+}
+
+// pushEvent and popEvent return events of list operations.
+func pushEvent(left bool) string {
+	if left {
+		return EventLPush
+	}
+
+	return EventRPush
+}
+
+func popEvent(left bool) string {
+	if left {
+		return EventLPop
+	}
+
+	return EventRPop
+}
+
+// publishEvent publishes keyspace notification.
+func (s *Server) publishEvent(event, key string) {
+	s.pubsub.publish(keyspacePrefix+key, event)
+	s.pubsub.publish(keyeventPrefix+event, key)
+}
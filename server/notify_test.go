@@ -0,0 +1,121 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/server/lru"
+)
+
+// recordEvents makes storage record its notifications as "event key".
+func recordEvents(s Storage) *[]string {
+	events := &[]string{}
+	s.Notify(func(event, key string) {
+		*events = append(*events, event+" "+key)
+	})
+
+	return events
+}
+
+func TestNotify(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+		events := recordEvents(s)
+
+		s.Set("foo", "bar", 0)
+		s.SetIf("foo", "baz", 0, false)
+		s.Expire("foo", 100)
+		s.IncrBy("counter", 1)
+		s.HSet("hash", "field", "value")
+		s.RPush("list", "a")
+		s.LPop("list")
+		s.LPop("list")
+		s.SAdd("set", "a")
+		s.SUnionStore("union", "set")
+		s.SInterStore("union", "missing")
+		s.ZAdd("zset", ZMember{"a", 1})
+		s.Delete("foo")
+		s.Delete("foo")
+		s.Restore(Entry{Key: "restored", Value: "x"})
+		s.Atomic([]string{"tx"}, func(s Storage) error {
+			return s.Set("tx", "1", 0)
+		})
+
+		expected := []string{
+			"set foo",
+			"expire foo",
+			"incrby counter",
+			"hset hash",
+			"rpush list",
+			"del list",
+			"sadd set",
+			"sunionstore union",
+			"del union",
+			"zadd zset",
+			"del foo",
+			"restore restored",
+			"set tx",
+		}
+		if !reflect.DeepEqual(*events, expected) {
+			t.Fatalf("%s: expected events %q. Got: %q", name, expected, *events)
+		}
+	}
+}
+
+func TestNotifyExpired(t *testing.T) {
+	m := NewMemory(time.Minute)
+	events := recordEvents(m)
+
+	m.Set("foo", "bar", 0)
+	m.Set("expiring", "bar", 100)
+	m.Expire("expiring", -10)
+	m.doCleanup()
+
+	expected := []string{"set foo", "set expiring", "expire expiring", "expired expiring"}
+	if !reflect.DeepEqual(*events, expected) {
+		t.Fatalf("Expected events %q. Got: %q", expected, *events)
+	}
+}
+
+func TestNotifyEvicted(t *testing.T) {
+	s := NewLRUStorage(lru.New(2))
+	events := recordEvents(s)
+
+	s.Set("a", "1", 0)
+	s.Set("b", "1", 0)
+	s.Set("c", "1", 0)
+
+	// expired key is accessed, so it's evicted first
+	s.Expire("c", -10)
+	s.Get("c")
+	s.Set("d", "1", 0)
+
+	// eviction is reported before key which caused it
+	expected := []string{"set a", "set b", "evicted a", "set c", "expire c", "expired c", "set d"}
+	if !reflect.DeepEqual(*events, expected) {
+		t.Fatalf("Expected events %q. Got: %q", expected, *events)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	config := DefaultConfig()
+	config.KeyspaceNotifications = true
+
+	sub, client, server := testPubSubServer(t, config)
+	defer server.Close()
+	defer sub.Close()
+
+	sub.Write([]byte("PSUBSCRIBE __keyspace@0__:user:*\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n10\r\npsubscribe21\r\n__keyspace@0__:user:*1\r\n1")
+	sub.Write([]byte("SUBSCRIBE __keyevent@0__:del\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n9\r\nsubscribe18\r\n__keyevent@0__:del1\r\n2")
+
+	client.assertRequest(t, []byte("SET user:1 0 3\r\nbar\r\n"), resultOK)
+	assertReceived(t, sub, "VALUES\r\n4\r\n8\r\npmessage21\r\n__keyspace@0__:user:*21\r\n__keyspace@0__:user:13\r\nset")
+
+	client.assertRequest(t, []byte("SET other 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("DELETE user:1\r\n"), resultOK)
+	assertReceived(t, sub, "VALUES\r\n4\r\n8\r\npmessage21\r\n__keyspace@0__:user:*21\r\n__keyspace@0__:user:13\r\ndel"+
+		"VALUES\r\n3\r\n7\r\nmessage18\r\n__keyevent@0__:del6\r\nuser:1")
+}
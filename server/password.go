@@ -0,0 +1,361 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes of users file:
+//
+//	{SHA}digest                            - unsalted SHA-1, htpasswd -s
+//	$2y$cost$saltdigest                    - bcrypt, htpasswd -B, $2a$ and $2b$ are accepted too
+//	$5$[rounds=N$]salt$digest              - SHA-256 crypt, mkpasswd -m sha-256
+//	$6$[rounds=N$]salt$digest              - SHA-512 crypt, mkpasswd -m sha-512
+//	$argon2id$v=19$m=M,t=T,p=P$salt$digest - argon2id in PHC string format
+//	SCRAM-SHA-256$iterations:salt$StoredKey:ServerKey - SCRAM credentials, see scram.go
+//
+// Digests are compared in constant time.
+
+var errBadPasswordHash = errors.New("Unsupported or malformed password hash")
+
+type passwordHash interface {
+	// verify reports whether password matches hash.
+	verify(password string) bool
+}
+
+// parsePasswordHash parses password hash of users file.
+func parsePasswordHash(s string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(s, "{SHA}"):
+		return parseSHA1Hash(s[5:])
+	case strings.HasPrefix(s, "$2a$"), strings.HasPrefix(s, "$2b$"), strings.HasPrefix(s, "$2y$"):
+		return parseBcryptHash(s)
+	case strings.HasPrefix(s, "$5$"):
+		return parseSHACryptHash(s[3:], sha256Crypt)
+	case strings.HasPrefix(s, "$6$"):
+		return parseSHACryptHash(s[3:], sha512Crypt)
+	case strings.HasPrefix(s, "$argon2id$"):
+		return parseArgon2Hash(s[10:])
+	case strings.HasPrefix(s, scramPrefix):
+		return parseSCRAMHash(s[len(scramPrefix):])
+	}
+
+	return nil, errBadPasswordHash
+}
+
+// HashPassword returns entry of users file for password. Scheme is bcrypt, sha256, sha512, argon2id
+// or scram-sha-256.
+func HashPassword(password, scheme string) (string, error) {
+	switch scheme {
+	case "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(h), err
+	case "sha256", "sha512":
+		variant := sha256Crypt
+		if scheme == "sha512" {
+			variant = sha512Crypt
+		}
+
+		salt, err := randomSalt(shaCryptSaltLength)
+		if err != nil {
+			return "", err
+		}
+		h := &shaCryptHash{variant: variant, rounds: shaCryptRounds, salt: []byte(salt)}
+		h.digest = variant.hash(password, h.salt, h.rounds)
+		return h.String(), nil
+	case "argon2id":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		h := &argon2Hash{memory: 64 * 1024, time: 3, threads: 4, salt: salt}
+		h.digest = h.hash(password, 32)
+		return h.String(), nil
+	case "scram-sha-256":
+		h, err := newSCRAMHash(password)
+		if err != nil {
+			return "", err
+		}
+		return h.String(), nil
+	}
+
+	return "", fmt.Errorf("Unknown password scheme %s", scheme)
+}
+
+type sha1Hash []byte
+
+func parseSHA1Hash(s string) (passwordHash, error) {
+	digest, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(digest) != sha1.Size {
+		return nil, errBadPasswordHash
+	}
+
+	return sha1Hash(digest), nil
+}
+
+func (h sha1Hash) verify(password string) bool {
+	digest := sha1.Sum([]byte(password))
+	return subtle.ConstantTimeCompare(h, digest[:]) == 1
+}
+
+type bcryptHash []byte
+
+func parseBcryptHash(s string) (passwordHash, error) {
+	// cost, salt and digest take 56 bytes after version
+	if len(s) != 60 {
+		return nil, errBadPasswordHash
+	}
+	if _, err := bcrypt.Cost([]byte(s)); err != nil {
+		return nil, errBadPasswordHash
+	}
+
+	return bcryptHash(s), nil
+}
+
+func (h bcryptHash) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(h, []byte(password)) == nil
+}
+
+// SHA-crypt, https://www.akkadia.org/drepper/SHA-crypt.txt
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptSaltLength    = 16
+
+	// shaCryptRounds is number of rounds of generated hashes
+	shaCryptRounds = 100000
+)
+
+type shaCryptVariant struct {
+	magic   string
+	newHash func() hash.Hash
+	// order is order of digest bytes in encoded digest
+	order []int
+}
+
+var (
+	sha256Crypt = &shaCryptVariant{
+		magic:   "$5$",
+		newHash: sha256.New,
+		order: []int{0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14, 15, 25, 5, 6, 16, 26, 27, 7, 17,
+			18, 28, 8, 9, 19, 29, 31, 30},
+	}
+	sha512Crypt = &shaCryptVariant{
+		magic:   "$6$",
+		newHash: sha512.New,
+		order: []int{0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4, 47, 5, 26, 6, 27, 48, 28, 49, 7,
+			50, 8, 29, 9, 30, 51, 31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35, 15, 36, 57, 37, 58,
+			16, 59, 17, 38, 18, 39, 60, 40, 61, 19, 62, 20, 41, 63},
+	}
+)
+
+type shaCryptHash struct {
+	variant *shaCryptVariant
+	rounds  int
+	salt    []byte
+	digest  []byte
+}
+
+func parseSHACryptHash(s string, variant *shaCryptVariant) (passwordHash, error) {
+	h := &shaCryptHash{variant: variant, rounds: shaCryptDefaultRounds}
+
+	if strings.HasPrefix(s, "rounds=") {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			return nil, errBadPasswordHash
+		}
+		rounds, err := strconv.Atoi(s[7:i])
+		if err != nil {
+			return nil, errBadPasswordHash
+		}
+		// out of range rounds are clamped when hash is generated
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		}
+		if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+		h.rounds = rounds
+		s = s[i+1:]
+	}
+
+	i := strings.IndexByte(s, '$')
+	if i < 0 || i > shaCryptSaltLength || len(s[i+1:]) != (len(variant.order)*8+5)/6 {
+		return nil, errBadPasswordHash
+	}
+	h.salt = []byte(s[:i])
+	h.digest = []byte(s[i+1:])
+
+	return h, nil
+}
+
+func (h *shaCryptHash) verify(password string) bool {
+	digest := h.variant.hash(password, h.salt, h.rounds)
+	return subtle.ConstantTimeCompare(h.digest, digest) == 1
+}
+
+// String returns hash in crypt format.
+func (h *shaCryptHash) String() string {
+	s := h.variant.magic
+	if h.rounds != shaCryptDefaultRounds {
+		s += "rounds=" + strconv.Itoa(h.rounds) + "$"
+	}
+
+	return s + string(h.salt) + "$" + string(h.digest)
+}
+
+// hash returns encoded digest of password.
+func (v *shaCryptVariant) hash(password string, salt []byte, rounds int) []byte {
+	p := []byte(password)
+	h := v.newHash()
+
+	h.Write(p)
+	h.Write(salt)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	h.Reset()
+	h.Write(p)
+	h.Write(salt)
+	h.Write(repeatBytes(b, len(p)))
+	for n := len(p); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	h.Reset()
+	for range p {
+		h.Write(p)
+	}
+	dp := repeatBytes(h.Sum(nil), len(p))
+
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	ds := repeatBytes(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i%2 != 0 {
+			h.Write(dp)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(ds)
+		}
+		if i%7 != 0 {
+			h.Write(dp)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(dp)
+		}
+		c = h.Sum(c[:0])
+	}
+
+	// every 3 bytes are encoded by 4 characters starting with the lowest bits, the rest by 3 or 2
+	var encoded []byte
+	for i := 0; i < len(v.order); i += 3 {
+		w, bits := 0, 0
+		for j := i; j < i+3 && j < len(v.order); j++ {
+			w = w<<8 | int(c[v.order[j]])
+			bits += 8
+		}
+		for ; bits > 0; bits -= 6 {
+			encoded = append(encoded, cryptAlphabet[w&0x3f])
+			w >>= 6
+		}
+	}
+
+	return encoded
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// repeatBytes returns n bytes of repeated b.
+func repeatBytes(b []byte, n int) []byte {
+	return bytes.Repeat(b, n/len(b)+1)[:n]
+}
+
+// randomSalt returns salt of n characters of crypt alphabet.
+func randomSalt(n int) (string, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	for i, b := range salt {
+		salt[i] = cryptAlphabet[b&0x3f]
+	}
+
+	return string(salt), nil
+}
+
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	digest  []byte
+}
+
+func parseArgon2Hash(s string) (passwordHash, error) {
+	// v=19$m=65536,t=3,p=4$salt$digest
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 || parts[0] != "v="+strconv.Itoa(argon2.Version) {
+		return nil, errBadPasswordHash
+	}
+
+	h := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, errBadPasswordHash
+	}
+	if h.time == 0 || h.threads == 0 || h.memory < 8*uint32(h.threads) {
+		return nil, errBadPasswordHash
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, errBadPasswordHash
+	}
+	if h.digest, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil || len(h.digest) < 4 {
+		return nil, errBadPasswordHash
+	}
+
+	return h, nil
+}
+
+func (h *argon2Hash) verify(password string) bool {
+	return subtle.ConstantTimeCompare(h.digest, h.hash(password, uint32(len(h.digest)))) == 1
+}
+
+func (h *argon2Hash) hash(password string, length uint32) []byte {
+	return argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, length)
+}
+
+// String returns hash in PHC string format.
+func (h *argon2Hash) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(h.salt), base64.RawStdEncoding.EncodeToString(h.digest))
+}
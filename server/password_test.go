@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashes(t *testing.T) {
+	for _, test := range []struct {
+		hash     string
+		password string
+	}{
+		{"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", "password"},
+		{"$2y$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm", "password"},
+		{"$2b$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm", "password"},
+		{"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5", "Hello world!"},
+		{"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA", "Hello world!"},
+		{"$5$rounds=1000$short$G6a7kCgkpoq2XWVJNOVxq6CFUfO7mHOsayZj/hiCfo2", "password"},
+		{"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "Hello world!"},
+		{"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v.", "Hello world!"},
+		{"$6$x$QSmr1Bx2g4O6BzKvdkgOcyU6H91X6I/XBv5pSalMhSPkwdH6Beo3F455xZJg0v//bxVK5F4OE5k1.0xuR26MK0", ""},
+	} {
+		h, err := parsePasswordHash(test.hash)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.hash, err)
+		}
+		if !h.verify(test.password) {
+			t.Fatalf("%s: expected password %q to match", test.hash, test.password)
+		}
+		if h.verify(test.password + "x") {
+			t.Fatalf("%s: expected password %q not to match", test.hash, test.password+"x")
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, scheme := range []string{"bcrypt", "sha256", "sha512", "argon2id", "scram-sha-256"} {
+		hash, err := HashPassword("secret", scheme)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", scheme, err)
+		}
+
+		h, err := parsePasswordHash(hash)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", hash, err)
+		}
+		if !h.verify("secret") || h.verify("Secret") {
+			t.Fatalf("%s: expected only secret to match", hash)
+		}
+	}
+
+	if _, err := HashPassword("secret", "md5"); err == nil {
+		t.Fatalf("Expected error for unknown scheme")
+	}
+}
+
+func TestMalformedPasswordHashes(t *testing.T) {
+	for _, hash := range []string{
+		"p",
+		"password",
+		"{SHA}",
+		"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g",
+		"{SHA}cGFzc3dvcmQ=",
+		"$2y$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzd",
+		"$2y$99$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm",
+		"$5$",
+		"$5$saltstring",
+		"$5$saltstring$5B8vYYiY",
+		"$5$rounds=x$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		"$5$saltstringsaltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		"$6$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHQ$ZGlnZXN0ZGlnZXN0",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$ZGlnZXN0ZGlnZXN0",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$!",
+		"$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHQ$ZGlnZXN0ZGlnZXN0",
+		"SCRAM-SHA-256$4096:c2FsdA==$",
+		"SCRAM-SHA-256$0:c2FsdA==$ZGlnZXN0ZGlnZXN0ZGlnZXN0ZGlnZXN0ZGlnZXN0MTI=:ZGlnZXN0ZGlnZXN0ZGlnZXN0ZGlnZXN0ZGlnZXN0MTI=",
+		"SCRAM-SHA-256$4096:c2FsdA==$ZGlnZXN0:ZGlnZXN0ZGlnZXN0ZGlnZXN0ZGlnZXN0ZGlnZXN0MTI=",
+	} {
+		if _, err := parsePasswordHash(hash); err != errBadPasswordHash {
+			t.Fatalf("%s: expected: %v. Got: %v", hash, errBadPasswordHash, err)
+		}
+	}
+}
+
+func TestUserListMalformedLines(t *testing.T) {
+	for _, users := range []string{
+		"alice",
+		"alice:",
+		":{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=",
+		"alice:pass",
+		"alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\nbob:$5$",
+	} {
+		if _, err := NewUserListFromReader(strings.NewReader(users)); err == nil {
+			t.Fatalf("%q: expected error", users)
+		}
+	}
+
+	users, err := NewUserListFromReader(bytes.NewBufferString("# comment\n\nalice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"bob:$2y$04$abcdefghijklmnopqrstuughE8Ev8uGFaUgY2cNEySvxngrb/Jzdm +@read\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, user := range []string{"alice", "bob"} {
+		if !users.Validate(user, "password") || users.Validate(user, "passwor") {
+			t.Fatalf("Expected only password of %s to be valid", user)
+		}
+	}
+	if users.Validate("carol", "password") {
+		t.Fatalf("Expected unknown user to be invalid")
+	}
+}
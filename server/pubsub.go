@@ -0,0 +1,451 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Pub/sub. SUBSCRIBE and PSUBSCRIBE switch connection into push mode: messages published to subscribed
+// channels and to channels matching subscribed patterns are pushed to connection as they are published.
+// Only subscription commands and PING are accepted in push mode; connection returns to normal mode when
+// it unsubscribes from all channels and patterns.
+//
+// Pushes and replies are queued for subscriber and written by separate goroutine, so PUBLISH never waits
+// for slow subscriber. Subscriber whose queue outgrows output buffer limit is disconnected.
+
+var errSubscribed = errors.New("Only (P)SUBSCRIBE, (P)UNSUBSCRIBE and PING are allowed in subscribed mode")
+
+// pubsub keeps subscribers of channels and patterns.
+type pubsub struct {
+	l        sync.RWMutex
+	channels map[string]map[*subscriber]struct{}
+	patterns map[string]map[*subscriber]struct{}
+	// limit is maximal size of data queued for subscriber in bytes, 0 means no limit.
+	limit int
+}
+
+func newPubSub(limit int) *pubsub {
+	return &pubsub{
+		channels: make(map[string]map[*subscriber]struct{}),
+		patterns: make(map[string]map[*subscriber]struct{}),
+		limit:    limit,
+	}
+}
+
+// subscribe adds subscription to channel or pattern and returns number of subscriptions of subscriber.
+func (p *pubsub) subscribe(sub *subscriber, name string, pattern bool) int {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	all, own := p.channels, sub.channels
+	if pattern {
+		all, own = p.patterns, sub.patterns
+	}
+
+	subscribers, ok := all[name]
+	if !ok {
+		subscribers = make(map[*subscriber]struct{})
+		all[name] = subscribers
+	}
+	subscribers[sub] = struct{}{}
+	own[name] = struct{}{}
+
+	return sub.count()
+}
+
+// unsubscribe removes subscription to channel or pattern and returns number of subscriptions left.
+func (p *pubsub) unsubscribe(sub *subscriber, name string, pattern bool) int {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	all, own := p.channels, sub.channels
+	if pattern {
+		all, own = p.patterns, sub.patterns
+	}
+
+	if subscribers, ok := all[name]; ok {
+		delete(subscribers, sub)
+		if len(subscribers) == 0 {
+			delete(all, name)
+		}
+	}
+	delete(own, name)
+
+	return sub.count()
+}
+
+// unsubscribeAll removes all subscriptions of subscriber.
+func (p *pubsub) unsubscribeAll(sub *subscriber) {
+	for _, channel := range sub.names(false) {
+		p.unsubscribe(sub, channel, false)
+	}
+	for _, pattern := range sub.names(true) {
+		p.unsubscribe(sub, pattern, true)
+	}
+}
+
+// publish pushes message to subscribers of channel and of patterns matching channel. It returns number
+// of subscribers which received message.
+func (p *pubsub) publish(channel, message string) int {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	n := 0
+	for sub := range p.channels[channel] {
+		if sub.push(sub.frame("message", channel, message)) {
+			n++
+		}
+	}
+
+	for pattern, subscribers := range p.patterns {
+		if !matchGlob(pattern, channel) {
+			continue
+		}
+
+		for sub := range subscribers {
+			if sub.push(sub.frame("pmessage", pattern, channel, message)) {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// subscriber is connection in push mode. Pushes and replies are encoded in protocol of connection.
+type subscriber struct {
+	conn *connection
+	resp bool
+
+	// channels and patterns are subscriptions of connection. They are changed under pubsub lock
+	// by connection goroutine only, so connection can read them without lock.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	l      sync.Mutex
+	buf    bytes.Buffer
+	signal chan struct{}
+	limit  int
+	// dropped is set when subscriber is disconnected, further pushes are ignored.
+	dropped bool
+}
+
+func newSubscriber(conn *connection, resp bool, limit int) *subscriber {
+	return &subscriber{
+		conn:     conn,
+		resp:     resp,
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		signal:   make(chan struct{}, 1),
+		limit:    limit,
+	}
+}
+
+// count returns number of subscriptions.
+func (sub *subscriber) count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// names returns sorted channels or patterns subscriber is subscribed to.
+func (sub *subscriber) names(pattern bool) []string {
+	own := sub.channels
+	if pattern {
+		own = sub.patterns
+	}
+
+	names := make([]string, 0, len(own))
+	for name := range own {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// push queues data for writing. Subscriber is disconnected if queue outgrows limit.
+// It returns false if subscriber has been disconnected.
+func (sub *subscriber) push(b []byte) bool {
+	sub.l.Lock()
+	defer sub.l.Unlock()
+
+	if sub.dropped {
+		return false
+	}
+
+	if sub.limit > 0 && sub.buf.Len()+len(b) > sub.limit {
+		log.Printf("Subscriber %s disconnected: output buffer limit exceeded", sub.conn.conn.RemoteAddr())
+		sub.drop()
+		return false
+	}
+
+	sub.buf.Write(b)
+
+	select {
+	case sub.signal <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// drop disconnects subscriber. It must be called with lock held.
+func (sub *subscriber) drop() {
+	sub.dropped = true
+	sub.buf.Reset()
+	// unblocks writer and reader of connection
+	sub.conn.conn.Close()
+}
+
+// take returns all queued data.
+func (sub *subscriber) take() []byte {
+	sub.l.Lock()
+	defer sub.l.Unlock()
+
+	b := make([]byte, sub.buf.Len())
+	copy(b, sub.buf.Bytes())
+	sub.buf.Reset()
+
+	return b
+}
+
+// writeLoop writes queued data to connection until stop is closed and queue is written.
+func (sub *subscriber) writeLoop(stop chan struct{}) {
+	for {
+		stopped := false
+		select {
+		case <-sub.signal:
+		case <-stop:
+			stopped = true
+		}
+
+		sub.conn.Write(sub.take())
+		if err := sub.conn.Flush(); err != nil {
+			sub.l.Lock()
+			sub.drop()
+			sub.l.Unlock()
+			return
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+// frame encodes push as VALUES in lodge protocol and as array in RESP.
+func (sub *subscriber) frame(values ...string) []byte {
+	return encode(func(conn *connection, w *respWriter) {
+		if sub.resp {
+			w.writeArray(values)
+		} else {
+			conn.WriteValues(values...)
+		}
+	})
+}
+
+// confirmation encodes reply to subscription command. Number of subscriptions is integer in RESP.
+func (sub *subscriber) confirmation(kind, name string, count int) []byte {
+	return encode(func(conn *connection, w *respWriter) {
+		if sub.resp {
+			w.w.WriteString("*3\r\n")
+			w.writeBulk(kind)
+			w.writeBulk(name)
+			w.writeInteger(int64(count))
+		} else {
+			conn.WriteValues(kind, name, strconv.Itoa(count))
+		}
+	})
+}
+
+// reply encodes reply of command in protocol of subscriber.
+func (sub *subscriber) reply(err error) []byte {
+	return encode(func(conn *connection, w *respWriter) {
+		switch {
+		case !sub.resp:
+			writeReply(conn, nil, err)
+		case err != nil:
+			w.writeErr(err)
+		default:
+			w.writeString("OK")
+		}
+	})
+}
+
+// encode returns data written by fn, so it can be queued instead of being written to connection.
+func encode(fn func(conn *connection, w *respWriter)) []byte {
+	var b bytes.Buffer
+	conn := &connection{writer: bufio.NewWriter(&b)}
+	fn(conn, &respWriter{w: conn.writer})
+	conn.writer.Flush()
+
+	return b.Bytes()
+}
+
+// serveSubscriber handles connection in push mode, starting with subscription request. It returns when
+// connection unsubscribes from all channels and patterns or breaks.
+func (s *Server) serveSubscriber(conn *connection, request *request, resp bool) {
+	// replies to previous requests are sent before pushes
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	// subscriber only waits for messages, so shutdown closes it at once and idle timeout doesn't
+	conn.setIdle(true)
+	conn.streaming = true
+
+	sub := newSubscriber(conn, resp, s.pubsub.limit)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		sub.writeLoop(stop)
+		close(done)
+	}()
+
+	quit := false
+	defer func() {
+		s.pubsub.unsubscribeAll(sub)
+		close(stop)
+		<-done
+
+		conn.streaming = false
+		if quit {
+			conn.Close()
+		}
+	}()
+
+	for {
+		quit = s.handleSubscriberRequest(sub, request)
+		if quit || sub.count() == 0 {
+			return
+		}
+
+		var err error
+		request, err = readSubscriberRequest(conn, resp)
+		if err != nil {
+			quit = true
+			return
+		}
+	}
+}
+
+// readSubscriberRequest reads next request of subscribed connection.
+func readSubscriberRequest(conn *connection, resp bool) (*request, error) {
+	if !resp {
+		return conn.ReadRequest()
+	}
+
+	for {
+		args, err := readRESPRequest(conn.reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(args) > 0 {
+			return newRequest(strings.ToUpper(args[0]), args[1:]...), nil
+		}
+	}
+}
+
+// handleSubscriberRequest handles request received in push mode. It returns true if connection must be closed.
+func (s *Server) handleSubscriberRequest(sub *subscriber, request *request) bool {
+	switch request.command {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(request.arguments) == 0 {
+			sub.push(sub.reply(errArguments))
+			return false
+		}
+		if err := s.authorizeChannels(sub.conn, request.command, request.arguments); err != nil {
+			sub.push(sub.reply(err))
+			return false
+		}
+
+		pattern := request.command == "PSUBSCRIBE"
+		kind := strings.ToLower(request.command)
+		for _, name := range request.arguments {
+			count := s.pubsub.subscribe(sub, name, pattern)
+			sub.push(sub.confirmation(kind, name, count))
+		}
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		pattern := request.command == "PUNSUBSCRIBE"
+		kind := strings.ToLower(request.command)
+
+		// without arguments connection is unsubscribed from everything
+		names := request.arguments
+		if len(names) == 0 {
+			names = sub.names(pattern)
+		}
+		if len(names) == 0 {
+			sub.push(sub.confirmation(kind, "", sub.count()))
+		}
+
+		for _, name := range names {
+			count := s.pubsub.unsubscribe(sub, name, pattern)
+			sub.push(sub.confirmation(kind, name, count))
+		}
+	case "PING":
+		if sub.resp {
+			message := ""
+			if len(request.arguments) > 0 {
+				message = request.arguments[0]
+			}
+			sub.push(sub.frame("pong", message))
+		} else {
+			sub.push(sub.reply(nil))
+		}
+	case "QUIT":
+		sub.push(sub.reply(nil))
+		return true
+	default:
+		// data of rejected request is read, so it isn't taken for next request
+		if !sub.resp {
+			if err := s.skipData(request); err != nil {
+				sub.push(sub.reply(err))
+				return true
+			}
+		}
+		sub.push(sub.reply(errSubscribed))
+	}
+
+	return false
+}
+
+// skipData reads data blocks of lodge request which isn't executed.
+func (s *Server) skipData(r *request) error {
+	cmd, ok := s.commands[r.command]
+	if !ok || !validArguments(cmd, len(r.arguments)) {
+		return nil
+	}
+
+	return skipValues(cmd, r)
+}
+
+// publishCommand is PUBLISH channel length. It replies with number of subscribers which received message.
+type publishCommand struct {
+	pubsub *pubsub
+}
+
+func (c publishCommand) arguments() int {
+	return 2
+}
+
+func (c publishCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c publishCommand) process(r *request, s Storage) ([]string, error) {
+	values, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	n := c.pubsub.publish(r.arguments[0], values[0])
+
+	return []string{strconv.Itoa(n)}, nil
+}
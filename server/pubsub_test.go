@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/testutil"
+)
+
+// assertReceived reads exactly len(expected) bytes, pushes can be split across several writes.
+func assertReceived(t *testing.T, conn net.Conn, expected string) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Expected: %q. Got error: %v", expected, err)
+	}
+	if string(buf) != expected {
+		t.Fatalf("Expected: %q. Got: %q", expected, buf)
+	}
+}
+
+func testPubSubServer(t *testing.T, config *Config) (net.Conn, *testClient, *Server) {
+	l, conn := testutil.NextListener(t)
+
+	server := New(NewMemory(time.Minute), config)
+	go server.Serve(l)
+
+	otherConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, &testClient{connection: otherConn}, server
+}
+
+func TestPubSub(t *testing.T) {
+	sub, publisher, server := testPubSubServer(t, DefaultConfig())
+	defer server.Close()
+	defer sub.Close()
+
+	sub.Write([]byte("SUBSCRIBE news\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n9\r\nsubscribe4\r\nnews1\r\n1")
+
+	publisher.assertRequest(t, []byte("PUBLISH news 5\r\nhello\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n7\r\nmessage4\r\nnews5\r\nhello")
+
+	publisher.assertRequest(t, []byte("PUBLISH other 5\r\nhello\r\n"), []byte("VALUES\r\n1\r\n1\r\n0"))
+
+	sub.Write([]byte("PSUBSCRIBE n*\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n10\r\npsubscribe2\r\nn*1\r\n2")
+
+	publisher.assertRequest(t, []byte("PUBLISH news 3\r\nhey\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n7\r\nmessage4\r\nnews3\r\nhey"+
+		"VALUES\r\n4\r\n8\r\npmessage2\r\nn*4\r\nnews3\r\nhey")
+
+	// only subscription commands are allowed
+	sub.Write([]byte("GET foo\r\nPING\r\n"))
+	assertReceived(t, sub, "ERROR\r\nOK\r\n")
+
+	// data of rejected command isn't taken for next request
+	sub.Write([]byte("SET foo 0 9\r\nPING PING\r\nPING\r\n"))
+	assertReceived(t, sub, "ERROR\r\nOK\r\n")
+
+	// connection returns to normal mode when all subscriptions are removed
+	sub.Write([]byte("UNSUBSCRIBE\r\nPUNSUBSCRIBE n*\r\nGET foo\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n11\r\nunsubscribe4\r\nnews1\r\n1"+
+		"VALUES\r\n3\r\n12\r\npunsubscribe2\r\nn*1\r\n0"+
+		"NOT_FOUND\r\n")
+
+	publisher.assertRequest(t, []byte("PUBLISH news 3\r\nhey\r\n"), []byte("VALUES\r\n1\r\n1\r\n0"))
+}
+
+func TestPubSubAuth(t *testing.T) {
+	config := DefaultConfig()
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString("default:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+
+	sub, _, server := testPubSubServer(t, config)
+	defer server.Close()
+	defer sub.Close()
+
+	sub.Write([]byte("SUBSCRIBE news\r\n"))
+	assertReceived(t, sub, "AUTH_REQUIRED\r\n")
+}
+
+func TestPubSubSlowSubscriber(t *testing.T) {
+	config := DefaultConfig()
+	config.PubSubBufferLimit = 1024 * 1024
+
+	sub, publisher, server := testPubSubServer(t, config)
+	defer server.Close()
+	defer sub.Close()
+
+	sub.Write([]byte("SUBSCRIBE news\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n9\r\nsubscribe4\r\nnews1\r\n1")
+
+	// subscriber doesn't read, so messages stay queued once socket buffers are full
+	message := strings.Repeat("x", 64*1024)
+	request := []byte("PUBLISH news 65536\r\n" + message + "\r\n")
+	for i := 0; ; i++ {
+		if i == 10000 {
+			t.Fatalf("Expected slow subscriber to be disconnected")
+		}
+
+		reply := publisher.send(t, request, 0)
+		if string(reply) == "VALUES\r\n1\r\n1\r\n0" {
+			break
+		}
+	}
+
+	publisher.assertRequest(t, request, []byte("VALUES\r\n1\r\n1\r\n0"))
+}
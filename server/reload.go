@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sort"
+)
+
+var errNoUsersFile = errors.New("Users file is not configured")
+
+// ReloadUsers reads users file again and replaces users. Connections of removed users are closed,
+// other connections stay authenticated and get new permissions of their users. Users are kept if
+// file can't be read.
+func (s *Server) ReloadUsers() error {
+	if s.usersFile == "" || s.userList() == nil {
+		return errNoUsersFile
+	}
+
+	users, err := NewUserList(s.usersFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	previous := s.userList()
+	s.users.Store(users)
+	for conn := range s.conns {
+		if conn.user != "" && !users.Contains(conn.user) {
+			conn.conn.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	added, removed := diffUsers(previous, users)
+	log.Printf("Users file %s reloaded, added: %v, removed: %v", s.usersFile, added, removed)
+
+	return nil
+}
+
+// diffUsers returns sorted names of users which are in next list only and in previous list only.
+func diffUsers(previous, next *UserList) (added, removed []string) {
+	for name := range next.users {
+		if !previous.Contains(name) {
+			added = append(added, name)
+		}
+	}
+	for name := range previous.users {
+		if !next.Contains(name) {
+			removed = append(removed, name)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+
+	return added, removed
+}
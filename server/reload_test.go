@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReloadUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "users")
+	if err := ioutil.WriteFile(path, []byte(testACLUsers), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConfig()
+	config.UsersFile = path
+	config.Users, err = NewUserList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients, server := testUsersClients(t, config, "admin", "alice", "bob")
+	defer server.Close()
+	admin, alice, bob := clients[0], clients[1], clients[2]
+
+	bob.assertRequest(t, []byte("GET tenantA:x\r\n"), resultNotFound)
+	bob.assertRequest(t, []byte("SET tenantA:x 0 1\r\n1\r\n"), resultNoPerm)
+
+	// alice is removed, bob can write and carol is added
+	users := "admin:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g= +@write ~tenantA:*\n" +
+		"carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+	if err := ioutil.WriteFile(path, []byte(users), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	bob.assertRequest(t, []byte("CONFIG RELOAD\r\n"), resultNoPerm)
+	admin.assertRequest(t, []byte("CONFIG RELOAD\r\n"), resultOK)
+
+	alice.connection.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := alice.connection.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("Expected connection of removed user to be closed. Got: %v", err)
+	}
+
+	bob.assertRequest(t, []byte("SET tenantA:x 0 1\r\n1\r\n"), resultOK)
+	bob.assertRequest(t, []byte("GET tenantA:x\r\n"), resultNoPerm)
+
+	for user, expected := range map[string][]byte{"alice": resultAuthRequired, "carol": resultOK} {
+		conn, err := net.Dial("tcp", admin.connection.RemoteAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		client := &testClient{connection: conn}
+		client.assertRequest(t, []byte("AUTH "+user+" password\r\n"), expected)
+	}
+	admin.assertRequest(t, []byte("ACL LIST\r\n"), []byte("VALUES\r\n3\r\n"+
+		"14\r\nadmin +@all ~*"+
+		"22\r\nbob +@write ~tenantA:*"+
+		"14\r\ncarol +@all ~*"))
+
+	// users are kept if file is malformed
+	if err := ioutil.WriteFile(path, []byte("carol\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	admin.assertRequest(t, []byte("CONFIG RELOAD\r\n"), resultError)
+	admin.assertRequest(t, []byte("CONFIG REWRITE\r\n"), resultError)
+	bob.assertRequest(t, []byte("SET tenantA:x 0 1\r\n2\r\n"), resultOK)
+}
+
+func TestReloadUsersWithoutFile(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("CONFIG RELOAD\r\n"), resultError)
+}
+
+func TestDiffUsers(t *testing.T) {
+	previous, _ := NewUserListFromReader(bytes.NewBufferString(testACLUsers))
+	next, _ := NewUserListFromReader(bytes.NewBufferString("bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\ndave:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+
+	added, removed := diffUsers(previous, next)
+	if !reflect.DeepEqual(added, []string{"carol", "dave"}) || !reflect.DeepEqual(removed, []string{"admin", "alice"}) {
+		t.Fatalf("Expected: [carol dave] [admin alice]. Got: %v %v", added, removed)
+	}
+}
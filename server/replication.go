@@ -0,0 +1,734 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replication protocol.
+//
+// Replica connects to primary, authenticates if credentials are configured, and sends SYNC. Replica
+// authenticates with SCRAM, AUTH sending password is used only if it's enabled by PrimaryPlainAuth.
+// Primary replies OK and then streams journal records: first contents of its storage, then every
+// mutation. Replica clears its storage before applying the stream. Primary sends PING every
+// second, so replica can detect broken link. Replica sends "ACK <offset>" every second,
+// where offset is number of stream bytes it has applied.
+
+var (
+	errReadOnly = errors.New("Replica is read-only")
+
+	replicationHeartbeat = 1 * time.Second
+	replicationRetry     = 1 * time.Second
+)
+
+// replication keeps replication state of server.
+type replication struct {
+	l sync.Mutex
+	// primary is address of primary server. It's empty when server isn't replica.
+	primary  string
+	conn     net.Conn
+	stop     chan struct{}
+	replicas map[*replicaLink]struct{}
+
+	// offset is number of bytes of replication stream applied by replica.
+	offset int64
+	// lastIO is unix time of last data received from primary.
+	lastIO int64
+	linkUp int32
+}
+
+func newReplication() *replication {
+	return &replication{
+		replicas: make(map[*replicaLink]struct{}),
+	}
+}
+
+func (r *replication) isReplica() bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	return r.primary != ""
+}
+
+// halt stops replication loop. It must be called with lock held.
+func (r *replication) halt() {
+	if r.stop == nil {
+		return
+	}
+
+	close(r.stop)
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.stop = nil
+	r.conn = nil
+}
+
+// replicaLink is connection of primary to one of its replicas.
+type replicaLink struct {
+	addr string
+	feed *feed
+	// acked is offset acknowledged by replica, ackedAt is unix time of acknowledgement.
+	acked   int64
+	ackedAt int64
+}
+
+// ReplicaOf makes server replica of primary at addr. Empty addr stops replication and
+// makes server primary. Replica keeps its data when it is promoted.
+func (s *Server) ReplicaOf(addr string) error {
+	r := s.replication
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	r.halt()
+	r.primary = addr
+	atomic.StoreInt32(&r.linkUp, 0)
+	atomic.StoreInt64(&r.offset, 0)
+
+	if addr == "" {
+		log.Printf("Replication stopped, server is primary")
+		return nil
+	}
+
+	log.Printf("Starting replication of %s", addr)
+	r.stop = make(chan struct{})
+	go s.replicate(addr, r.stop)
+
+	return nil
+}
+
+// replicate keeps connection to primary until stop is closed.
+func (s *Server) replicate(addr string, stop chan struct{}) {
+	for {
+		err := s.syncWithPrimary(addr, stop)
+		atomic.StoreInt32(&s.replication.linkUp, 0)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		log.Printf("Replication of %s failed: %v. Reconnecting in %v", addr, err, replicationRetry)
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(replicationRetry):
+		}
+	}
+}
+
+func (s *Server) syncWithPrimary(addr string, stop chan struct{}) error {
+	dialer := &net.Dialer{Timeout: replicationRetry}
+	var conn net.Conn
+	var err error
+	network, address := splitAddr(addr)
+	if s.primaryTLS != nil {
+		conn, err = tls.DialWithDialer(dialer, network, address, s.primaryTLS)
+	} else {
+		conn, err = dialer.Dial(network, address)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := s.replication
+	r.l.Lock()
+	select {
+	case <-stop:
+		r.l.Unlock()
+		return nil
+	default:
+	}
+	r.conn = conn
+	r.l.Unlock()
+
+	counter := &countingReader{r: conn}
+	reader := bufio.NewReader(counter)
+
+	if s.primaryUsername != "" {
+		if err := s.authenticateOnPrimary(conn, reader); err != nil {
+			return fmt.Errorf("authentication failed: %v", err)
+		}
+	}
+	if err := replicationCall(conn, reader, record(nil, "SYNC")); err != nil {
+		return err
+	}
+
+	// stream contains full copy of primary storage
+	if err := clearStorage(s.journal); err != nil {
+		return err
+	}
+
+	atomic.StoreInt32(&r.linkUp, 1)
+	start := counter.n - int64(reader.Buffered())
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.acknowledge(conn, done)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(5 * replicationHeartbeat))
+		if err := replayCommand(reader, s.journal); err != nil {
+			return err
+		}
+
+		atomic.StoreInt64(&r.offset, counter.n-int64(reader.Buffered())-start)
+		atomic.StoreInt64(&r.lastIO, time.Now().Unix())
+	}
+}
+
+// replicationCall sends request to primary and checks that it replies OK.
+func replicationCall(conn net.Conn, reader *bufio.Reader, request []byte) error {
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if reply != string(resultOK) {
+		return fmt.Errorf("unexpected reply %q", reply)
+	}
+
+	return nil
+}
+
+// authenticateOnPrimary logs in to primary with SCRAM, or with AUTH if plain authentication is enabled.
+func (s *Server) authenticateOnPrimary(conn net.Conn, reader *bufio.Reader) error {
+	if !s.primaryPlainAuth {
+		return scramLogin(conn, reader, s.primaryUsername, s.primaryPassword)
+	}
+
+	// arguments of request are separated by spaces
+	if !validArgumentValues([]string{s.primaryUsername, s.primaryPassword}) {
+		return errBadArgument
+	}
+
+	return replicationCall(conn, reader, record(nil, "AUTH", s.primaryUsername, s.primaryPassword))
+}
+
+// replicationValue sends request to primary and returns single value of its reply.
+func replicationValue(conn net.Conn, reader *bufio.Reader, request []byte) (string, error) {
+	if _, err := conn.Write(request); err != nil {
+		return "", err
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if reply != string(resultValues) {
+		return "", fmt.Errorf("unexpected reply %q", reply)
+	}
+
+	var lengths [2]int
+	for i := range lengths {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if lengths[i], err = strconv.Atoi(strings.TrimSuffix(line, "\r\n")); err != nil {
+			return "", fmt.Errorf("unexpected reply %q", line)
+		}
+	}
+	if lengths[0] != 1 || lengths[1] < 0 || lengths[1] > maxSCRAMMessage {
+		return "", fmt.Errorf("unexpected reply of %d values of length %d", lengths[0], lengths[1])
+	}
+
+	value := make([]byte, lengths[1])
+	if _, err := io.ReadFull(reader, value); err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// acknowledge periodically sends applied offset to primary.
+func (s *Server) acknowledge(conn net.Conn, done chan struct{}) {
+	ticker := time.NewTicker(replicationHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			offset := strconv.FormatInt(atomic.LoadInt64(&s.replication.offset), 10)
+			if _, err := conn.Write(record(nil, "ACK", offset)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serveReplica streams journal to replica connected with SYNC command. It returns when connection breaks.
+func (s *Server) serveReplica(conn *connection) {
+	defer conn.Close()
+	// replica link is closed at once on shutdown and isn't closed by idle timeout
+	conn.setIdle(true)
+	conn.streaming = true
+
+	link := &replicaLink{
+		addr: conn.conn.RemoteAddr().String(),
+		feed: newFeed(s.replicaBufferLimit),
+	}
+
+	r := s.replication
+	r.l.Lock()
+	r.replicas[link] = struct{}{}
+	r.l.Unlock()
+
+	defer func() {
+		r.l.Lock()
+		delete(r.replicas, link)
+		r.l.Unlock()
+	}()
+
+	log.Printf("Replica %s connected", link.addr)
+	defer log.Printf("Replica %s disconnected", link.addr)
+
+	conn.Write(resultOK)
+	if err := conn.Flush(); err != nil {
+		return
+	}
+
+	send := func() error {
+		conn.Write(link.feed.take())
+		return conn.Flush()
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		link.readAcks(conn)
+		close(closed)
+	}()
+
+	if err := s.journal.attach(link.feed, send); err != nil {
+		return
+	}
+	defer s.journal.detach(link.feed)
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-link.feed.signal:
+			// replica reconnects and receives full copy of storage again
+			if link.feed.isDropped() {
+				log.Printf("Replica %s disconnected: output buffer limit exceeded", link.addr)
+				return
+			}
+			if err := send(); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			link.feed.write(record(nil, "PING"))
+		case <-closed:
+			return
+		}
+	}
+}
+
+// readAcks reads acknowledgements sent by replica.
+func (l *replicaLink) readAcks(conn *connection) {
+	for {
+		request, err := conn.ReadRequest()
+		if err != nil {
+			return
+		}
+
+		if request.command != "ACK" || len(request.arguments) != 1 {
+			continue
+		}
+
+		if offset, err := strconv.ParseInt(request.arguments[0], 10, 64); err == nil {
+			atomic.StoreInt64(&l.acked, offset)
+			atomic.StoreInt64(&l.ackedAt, time.Now().Unix())
+		}
+	}
+}
+
+// replicationInfo returns replication section of INFO reply.
+func (s *Server) replicationInfo() []string {
+	r := s.replication
+
+	r.l.Lock()
+	defer r.l.Unlock()
+
+	if r.primary != "" {
+		link := "down"
+		if atomic.LoadInt32(&r.linkUp) == 1 {
+			link = "up"
+		}
+
+		lastIO := int64(-1)
+		if at := atomic.LoadInt64(&r.lastIO); at != 0 {
+			lastIO = time.Now().Unix() - at
+		}
+
+		return []string{
+			"role:replica",
+			"primary:" + r.primary,
+			"primary_link:" + link,
+			fmt.Sprintf("primary_last_io_seconds:%d", lastIO),
+			fmt.Sprintf("replication_offset:%d", atomic.LoadInt64(&r.offset)),
+		}
+	}
+
+	info := []string{
+		"role:primary",
+		fmt.Sprintf("replication_offset:%d", atomic.LoadInt64(&s.journal.offset)),
+		fmt.Sprintf("connected_replicas:%d", len(r.replicas)),
+	}
+
+	i := 0
+	now := time.Now().Unix()
+	for link := range r.replicas {
+		link.feed.l.Lock()
+		pushed := link.feed.pushed
+		link.feed.l.Unlock()
+
+		acked := atomic.LoadInt64(&link.acked)
+		lag := int64(-1)
+		if at := atomic.LoadInt64(&link.ackedAt); at != 0 {
+			lag = now - at
+		}
+
+		info = append(info, fmt.Sprintf("replica%d:addr=%s,offset=%d,lag_bytes=%d,lag_seconds=%d", i, link.addr, acked, pushed-acked, lag))
+		i++
+	}
+
+	return info
+}
+
+// readOnlyStorage rejects mutations while server is replica.
+type readOnlyStorage struct {
+	storage     Storage
+	replication *replication
+}
+
+func (s *readOnlyStorage) Set(key, value string, ttl int64) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.Set(key, value, ttl)
+}
+
+func (s *readOnlyStorage) Get(key string) (string, error) {
+	return s.storage.Get(key)
+}
+
+func (s *readOnlyStorage) SetIf(key, value string, ttl int64, exists bool) (bool, error) {
+	if s.replication.isReplica() {
+		return false, errReadOnly
+	}
+
+	return s.storage.SetIf(key, value, ttl, exists)
+}
+
+func (s *readOnlyStorage) GetSet(key, value string) (string, error) {
+	if s.replication.isReplica() {
+		return "", errReadOnly
+	}
+
+	return s.storage.GetSet(key, value)
+}
+
+func (s *readOnlyStorage) Gets(key string) (string, uint64, error) {
+	return s.storage.Gets(key)
+}
+
+func (s *readOnlyStorage) CompareAndSet(key, value string, ttl int64, version uint64) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.CompareAndSet(key, value, ttl, version)
+}
+
+func (s *readOnlyStorage) HSet(key, field, value string) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.HSet(key, field, value)
+}
+
+func (s *readOnlyStorage) HGet(key, field string) (string, error) {
+	return s.storage.HGet(key, field)
+}
+
+func (s *readOnlyStorage) HGetAll(key string) (map[string]string, error) {
+	return s.storage.HGetAll(key)
+}
+
+func (s *readOnlyStorage) Delete(key string) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.Delete(key)
+}
+
+func (s *readOnlyStorage) Keys() ([]string, error) {
+	return s.storage.Keys()
+}
+
+func (s *readOnlyStorage) Scan(cursor uint64, count int) ([]string, uint64, error) {
+	return s.storage.Scan(cursor, count)
+}
+
+func (s *readOnlyStorage) HScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	return s.storage.HScan(key, cursor, count)
+}
+
+func (s *readOnlyStorage) Expire(key string, ttl int64) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.Expire(key, ttl)
+}
+
+func (s *readOnlyStorage) IncrBy(key string, delta int64) (int64, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.IncrBy(key, delta)
+}
+
+func (s *readOnlyStorage) HIncrBy(key, field string, delta int64) (int64, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.HIncrBy(key, field, delta)
+}
+
+func (s *readOnlyStorage) LPush(key string, values ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.LPush(key, values...)
+}
+
+func (s *readOnlyStorage) RPush(key string, values ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.RPush(key, values...)
+}
+
+func (s *readOnlyStorage) LPop(key string) (string, error) {
+	if s.replication.isReplica() {
+		return "", errReadOnly
+	}
+
+	return s.storage.LPop(key)
+}
+
+func (s *readOnlyStorage) RPop(key string) (string, error) {
+	if s.replication.isReplica() {
+		return "", errReadOnly
+	}
+
+	return s.storage.RPop(key)
+}
+
+func (s *readOnlyStorage) LRange(key string, start, stop int) ([]string, error) {
+	return s.storage.LRange(key, start, stop)
+}
+
+func (s *readOnlyStorage) LLen(key string) (int, error) {
+	return s.storage.LLen(key)
+}
+
+func (s *readOnlyStorage) LTrim(key string, start, stop int) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.LTrim(key, start, stop)
+}
+
+func (s *readOnlyStorage) LIndex(key string, index int) (string, error) {
+	return s.storage.LIndex(key, index)
+}
+
+func (s *readOnlyStorage) SAdd(key string, members ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.SAdd(key, members...)
+}
+
+func (s *readOnlyStorage) SRem(key string, members ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.SRem(key, members...)
+}
+
+func (s *readOnlyStorage) SIsMember(key, member string) (bool, error) {
+	return s.storage.SIsMember(key, member)
+}
+
+func (s *readOnlyStorage) SMembers(key string) ([]string, error) {
+	return s.storage.SMembers(key)
+}
+
+func (s *readOnlyStorage) SCard(key string) (int, error) {
+	return s.storage.SCard(key)
+}
+
+func (s *readOnlyStorage) SPop(key string) (string, error) {
+	if s.replication.isReplica() {
+		return "", errReadOnly
+	}
+
+	return s.storage.SPop(key)
+}
+
+func (s *readOnlyStorage) SRandMember(key string) (string, error) {
+	return s.storage.SRandMember(key)
+}
+
+func (s *readOnlyStorage) SInter(keys ...string) ([]string, error) {
+	return s.storage.SInter(keys...)
+}
+
+func (s *readOnlyStorage) SUnion(keys ...string) ([]string, error) {
+	return s.storage.SUnion(keys...)
+}
+
+func (s *readOnlyStorage) SDiff(keys ...string) ([]string, error) {
+	return s.storage.SDiff(keys...)
+}
+
+func (s *readOnlyStorage) SInterStore(dest string, keys ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.SInterStore(dest, keys...)
+}
+
+func (s *readOnlyStorage) SUnionStore(dest string, keys ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.SUnionStore(dest, keys...)
+}
+
+func (s *readOnlyStorage) SDiffStore(dest string, keys ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.SDiffStore(dest, keys...)
+}
+
+func (s *readOnlyStorage) ZAdd(key string, members ...ZMember) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.ZAdd(key, members...)
+}
+
+func (s *readOnlyStorage) ZRem(key string, members ...string) (int, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.ZRem(key, members...)
+}
+
+func (s *readOnlyStorage) ZScore(key, member string) (float64, error) {
+	return s.storage.ZScore(key, member)
+}
+
+func (s *readOnlyStorage) ZRank(key, member string) (int, error) {
+	return s.storage.ZRank(key, member)
+}
+
+func (s *readOnlyStorage) ZRange(key string, start, stop int) ([]ZMember, error) {
+	return s.storage.ZRange(key, start, stop)
+}
+
+func (s *readOnlyStorage) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	return s.storage.ZRangeByScore(key, min, max)
+}
+
+func (s *readOnlyStorage) ZIncrBy(key, member string, delta float64) (float64, error) {
+	if s.replication.isReplica() {
+		return 0, errReadOnly
+	}
+
+	return s.storage.ZIncrBy(key, member, delta)
+}
+
+func (s *readOnlyStorage) ZCard(key string) (int, error) {
+	return s.storage.ZCard(key)
+}
+
+func (s *readOnlyStorage) ZPopMin(key string) (ZMember, error) {
+	if s.replication.isReplica() {
+		return ZMember{}, errReadOnly
+	}
+
+	return s.storage.ZPopMin(key)
+}
+
+func (s *readOnlyStorage) TTL(key string) (int64, error) {
+	return s.storage.TTL(key)
+}
+
+func (s *readOnlyStorage) Dump(fn func(Entry) error) error {
+	return s.storage.Dump(fn)
+}
+
+func (s *readOnlyStorage) Restore(e Entry) error {
+	if s.replication.isReplica() {
+		return errReadOnly
+	}
+
+	return s.storage.Restore(e)
+}
+
+func (s *readOnlyStorage) Version(key string) (uint64, error) {
+	return s.storage.Version(key)
+}
+
+func (s *readOnlyStorage) Notify(fn NotifyFunc) {
+	s.storage.Notify(fn)
+}
+
+func (s *readOnlyStorage) Atomic(keys []string, fn func(Storage) error) error {
+	return s.storage.Atomic(keys, func(view Storage) error {
+		return fn(&readOnlyStorage{storage: view, replication: s.replication})
+	})
+}
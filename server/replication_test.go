@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/server/lru"
+	"github.com/mkabischev/lodge/testutil"
+)
+
+type testNode struct {
+	server  *Server
+	client  *testClient
+	storage Storage
+	addr    string
+}
+
+func startNode(t *testing.T, config *Config) *testNode {
+	l, conn := testutil.NextListener(t)
+
+	storage := NewBucketStorage(10, func() Storage {
+		return NewLRUStorage(lru.New(1000))
+	})
+
+	server := New(storage, config)
+	go server.Serve(l)
+
+	return &testNode{
+		server:  server,
+		client:  &testClient{connection: conn},
+		storage: storage,
+		addr:    l.Addr().String(),
+	}
+}
+
+// eventually waits until condition becomes true.
+func eventually(t *testing.T, message string, condition func() bool) {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("Condition wasn't met: %s", message)
+}
+
+func hasValue(s Storage, key, expected string) func() bool {
+	return func() bool {
+		value, err := s.Get(key)
+		return err == nil && value == expected
+	}
+}
+
+func TestReplication(t *testing.T) {
+	primary := startNode(t, DefaultConfig())
+	defer primary.server.Close()
+
+	primary.client.assertRequest(t, []byte("SET before 0 3\r\nbar\r\n"), resultOK)
+	primary.client.assertRequest(t, []byte("HSET hash field 5\r\nvalue\r\n"), resultOK)
+
+	config := DefaultConfig()
+	config.ReplicaOf = primary.addr
+	replica := startNode(t, config)
+	defer replica.server.Close()
+
+	// replica's own data is replaced by primary data
+	replica.storage.Set("stale", "value", 0)
+
+	eventually(t, "full sync", hasValue(replica.storage, "before", "bar"))
+	if value, _ := replica.storage.HGet("hash", "field"); value != "value" {
+		t.Fatalf("Expected: value. Got: %v", value)
+	}
+	if _, err := replica.storage.Get("stale"); err != errNotFound {
+		t.Fatalf("Expected stale key to be removed. Got: %v", err)
+	}
+
+	primary.client.assertRequest(t, []byte("SET after 0 3\r\nxyz\r\n"), resultOK)
+	primary.client.assertRequest(t, []byte("DELETE before\r\n"), resultOK)
+
+	eventually(t, "streaming", hasValue(replica.storage, "after", "xyz"))
+	eventually(t, "delete", func() bool {
+		_, err := replica.storage.Get("before")
+		return err == errNotFound
+	})
+}
+
+func TestReplicaIsReadOnly(t *testing.T) {
+	primary := startNode(t, DefaultConfig())
+	defer primary.server.Close()
+
+	config := DefaultConfig()
+	config.ReplicaOf = primary.addr
+	replica := startNode(t, config)
+	defer replica.server.Close()
+
+	replica.client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultReadOnly)
+	replica.client.assertRequest(t, []byte("DELETE foo\r\n"), resultReadOnly)
+
+	replica.client.assertRequest(t, []byte("REPLICAOF NO ONE\r\n"), resultOK)
+	replica.client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+}
+
+func TestReplicaOfCommand(t *testing.T) {
+	primary := startNode(t, DefaultConfig())
+	defer primary.server.Close()
+	primary.storage.Set("foo", "bar", 0)
+
+	replica := startNode(t, DefaultConfig())
+	defer replica.server.Close()
+
+	host, port, err := net.SplitHostPort(primary.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replica.client.assertRequest(t, []byte("REPLICAOF "+host+" "+port+"\r\n"), resultOK)
+
+	eventually(t, "full sync", hasValue(replica.storage, "foo", "bar"))
+}
+
+func TestReplicationInfo(t *testing.T) {
+	primary := startNode(t, DefaultConfig())
+	defer primary.server.Close()
+
+	config := DefaultConfig()
+	config.ReplicaOf = primary.addr
+	replica := startNode(t, config)
+	defer replica.server.Close()
+
+	primary.client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	eventually(t, "streaming", hasValue(replica.storage, "foo", "bar"))
+
+	info := replica.client.send(t, []byte("INFO\r\n"), 0)
+	for _, expected := range []string{"role:replica", "primary_link:up", "replication_offset:"} {
+		if !bytes.Contains(info, []byte(expected)) {
+			t.Fatalf("Expected %s in %s", expected, info)
+		}
+	}
+
+	eventually(t, "replica is listed", func() bool {
+		info := primary.client.send(t, []byte("INFO\r\n"), 0)
+		return bytes.Contains(info, []byte("connected_replicas:1")) && bytes.Contains(info, []byte("replica0:addr="))
+	})
+}
+
+func TestReplicationAuthentication(t *testing.T) {
+	hash, err := HashPassword("password", "scram-sha-256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultConfig()
+	config.Users, _ = NewUserListFromReader(strings.NewReader("scram:" + hash + "\nplain:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	primary := startNode(t, config)
+	defer primary.server.Close()
+	primary.storage.Set("foo", "bar", 0)
+
+	// user without SCRAM credentials authenticates with AUTH if it's enabled
+	for _, user := range []string{"scram", "plain"} {
+		config := DefaultConfig()
+		config.ReplicaOf = primary.addr
+		config.PrimaryUsername = user
+		config.PrimaryPassword = "password"
+		config.PrimaryPlainAuth = user == "plain"
+		replica := startNode(t, config)
+
+		eventually(t, "full sync of "+user, hasValue(replica.storage, "foo", "bar"))
+		replica.server.Close()
+	}
+
+	config = DefaultConfig()
+	config.ReplicaOf = primary.addr
+	config.PrimaryUsername = "plain"
+	config.PrimaryPassword = "password"
+	replica := startNode(t, config)
+	defer replica.server.Close()
+
+	time.Sleep(100 * time.Millisecond)
+	if _, err := replica.storage.Get("foo"); err != errNotFound {
+		t.Fatalf("Expected replica without SCRAM credentials not to sync. Got: %v", err)
+	}
+}
+
+func TestReplicationSCRAM(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	config := DefaultConfig()
+	config.ReplicaOf = l.Addr().String()
+	config.PrimaryUsername = "replica"
+	config.PrimaryPassword = "password"
+	replica := startNode(t, config)
+	defer replica.server.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// password isn't sent to primary
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "SCRAM n,,n=replica,r=") {
+		t.Fatalf("Expected: SCRAM n,,n=replica,r=... Got: %q", line)
+	}
+
+	// replica doesn't fall back to AUTH when SCRAM is rejected
+	conn.Write(resultError)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if line, err := reader.ReadString('\n'); err == nil {
+		t.Fatalf("Expected connection to be closed. Got: %q", line)
+	}
+}
+
+func TestFeedLimit(t *testing.T) {
+	f := newFeed(10)
+	f.synced = []bool{true}
+	f.index = func(string) int { return 0 }
+
+	// copies of partitions aren't limited
+	f.write([]byte("0123456789abcdef"))
+	if b := f.take(); len(b) != 16 || f.isDropped() {
+		t.Fatalf("Expected copy to be queued. Got: %q, dropped: %v", b, f.isDropped())
+	}
+
+	f.push("foo", []byte("01234567"))
+	f.push("foo", []byte("01234567"))
+	if !f.isDropped() {
+		t.Fatalf("Expected feed to be dropped")
+	}
+	f.push("foo", []byte("0"))
+	if b := f.take(); len(b) != 0 {
+		t.Fatalf("Expected dropped feed to be empty. Got: %q", b)
+	}
+}
@@ -2,23 +2,37 @@ package server
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/mkabischev/lodge/ioutil"
 )
 
+var errHeaderTooLong = errors.New("Request header is too long")
+
 type request struct {
 	command   string
 	arguments []string
 
 	reader *bufio.Reader
+	// body is request data blocks which have been already read by protocol parser, e.g. RESP.
+	body [][]byte
 }
 
+// Parse reads request header. If reader isn't *bufio.Reader then data read ahead is lost,
+// so connections should be read with connection.ReadRequest.
 func Parse(reader io.Reader) (*request, error) {
+	return readRequest(bufio.NewReader(reader))
+}
+
+// readRequest reads request header. Request data is left in reader and read by command.
+func readRequest(reader *bufio.Reader) (*request, error) {
 	r := &request{
-		reader: bufio.NewReader(reader),
+		reader: reader,
 	}
 
 	if err := r.parseHeader(); err != nil {
@@ -29,7 +43,7 @@ func Parse(reader io.Reader) (*request, error) {
 }
 
 func (r *request) parseHeader() error {
-	header, _, err := r.reader.ReadLine()
+	header, err := readLine(r.reader)
 	if err != nil {
 		return err
 	}
@@ -45,6 +59,70 @@ func (r *request) parseHeader() error {
 	return nil
 }
 
+// data reads n bytes of request data followed by line break.
 func (r *request) data(n int) ([]byte, error) {
-	return ioutil.Read(r.reader, n)
+	if r.body != nil {
+		if len(r.body) == 0 || len(r.body[0]) != n {
+			return nil, errBadFormat
+		}
+
+		data := r.body[0]
+		r.body = r.body[1:]
+
+		return data, nil
+	}
+
+	data, err := ioutil.Read(r.reader, n)
+	if err != nil {
+		return nil, err
+	}
+
+	tail, err := readLine(r.reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(tail) != 0 {
+		return nil, errBadFormat
+	}
+
+	return data, nil
+}
+
+// values reads data blocks, one for every length.
+func (r *request) values(lengths []string) ([]string, error) {
+	sizes := make([]int, len(lengths))
+	for i, argument := range lengths {
+		length, err := strconv.Atoi(argument)
+		if err != nil || length < 0 {
+			return nil, errBadLength
+		}
+		sizes[i] = length
+	}
+
+	values := make([]string, len(sizes))
+	for i, size := range sizes {
+		data, err := r.data(size)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = string(data)
+	}
+
+	return values, nil
+}
+
+// readLine reads line terminated by \n. Unlike bufio.Reader.ReadLine it treats unterminated
+// line at the end of input as truncated request.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadSlice('\n')
+	switch {
+	case err == bufio.ErrBufferFull:
+		return nil, errHeaderTooLong
+	case err == io.EOF && len(line) > 0:
+		return nil, io.ErrUnexpectedEOF
+	case err != nil:
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
 }
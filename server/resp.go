@@ -0,0 +1,898 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mkabischev/lodge/ioutil"
+)
+
+// RESP (Redis Serialization Protocol) support. Redis commands are translated to lodge
+// requests and executed exactly like requests received via lodge protocol.
+
+const (
+	respArray   = '*'
+	respBulk    = '$'
+	respString  = '+'
+	respError   = '-'
+	respInteger = ':'
+
+	maxRESPArguments  = 1024 * 1024
+	maxRESPBulkLength = 512 * 1024 * 1024
+)
+
+var errRESPProtocol = errors.New("Protocol error")
+
+type respCommand struct {
+	// arity is number of arguments including command name. Negative arity means minimal number of arguments.
+	arity   int
+	handler func(s *Server, conn *connection, args []string, w *respWriter)
+}
+
+var respCommands = map[string]respCommand{
+	"GET":           {2, respGet},
+	"SET":           {-3, respSet},
+	"SETNX":         {3, respSetNX},
+	"GETSET":        {3, respGetSet},
+	"HSET":          {-4, respHSet},
+	"HGET":          {3, respHGet},
+	"HGETALL":       {2, respHGetAll},
+	"DEL":           {-2, respDel},
+	"KEYS":          {2, respKeys},
+	"SCAN":          {-2, respScan},
+	"HSCAN":         {-3, respHScan},
+	"EXPIRE":        {3, respExpire},
+	"TTL":           {2, respTTL},
+	"INCR":          {2, respIncr},
+	"DECR":          {2, respDecr},
+	"INCRBY":        {3, respIncrBy},
+	"DECRBY":        {3, respDecrBy},
+	"HINCRBY":       {4, respHIncrBy},
+	"LPUSH":         {-3, respLPush},
+	"RPUSH":         {-3, respRPush},
+	"LPOP":          {2, respLPop},
+	"RPOP":          {2, respRPop},
+	"LRANGE":        {4, respLRange},
+	"LLEN":          {2, respLLen},
+	"LTRIM":         {4, respLTrim},
+	"LINDEX":        {3, respLIndex},
+	"BLPOP":         {-3, respBLPop},
+	"BRPOP":         {-3, respBRPop},
+	"SADD":          {-3, respSAdd},
+	"SREM":          {-3, respSRem},
+	"SISMEMBER":     {3, respSIsMember},
+	"SMEMBERS":      {2, respSMembers},
+	"SCARD":         {2, respSCard},
+	"SPOP":          {2, respSPop},
+	"SRANDMEMBER":   {2, respSRandMember},
+	"SINTER":        {-2, respSInter},
+	"SUNION":        {-2, respSUnion},
+	"SDIFF":         {-2, respSDiff},
+	"SINTERSTORE":   {-3, respSInterStore},
+	"SUNIONSTORE":   {-3, respSUnionStore},
+	"SDIFFSTORE":    {-3, respSDiffStore},
+	"ZADD":          {-4, respZAdd},
+	"ZREM":          {-3, respZRem},
+	"ZSCORE":        {3, respZScore},
+	"ZRANK":         {3, respZRank},
+	"ZRANGE":        {-4, respZRange},
+	"ZRANGEBYSCORE": {-4, respZRangeByScore},
+	"ZINCRBY":       {4, respZIncrBy},
+	"ZCARD":         {2, respZCard},
+	"ZPOPMIN":       {2, respZPopMin},
+	"PUBLISH":       {3, respPublish},
+	"SUBSCRIBE":     {-2, respSubscribe},
+	"PSUBSCRIBE":    {-2, respPSubscribe},
+	"AUTH":          {-2, respAuth},
+	"ACL":           {2, respACL},
+	"PING":          {-1, respPing},
+	"SELECT":        {2, respSelect},
+	"QUIT":          {1, respQuit},
+}
+
+func (s *Server) handleRESPConnection(conn *connection) {
+	defer conn.Close()
+
+	w := &respWriter{w: conn.writer}
+	for {
+		if err := conn.waitRequest(); err != nil {
+			return
+		}
+
+		args, err := readRESPRequest(conn.reader)
+		if err != nil {
+			if err == errRESPProtocol {
+				w.writeError("ERR Protocol error")
+				w.flush()
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			// empty lines aren't requests
+			if conn.Drained() {
+				conn.setIdle(true)
+			}
+			continue
+		}
+
+		name := strings.ToUpper(args[0])
+		cmd, ok := respCommands[name]
+		switch {
+		case !ok:
+			w.writeError("ERR unknown command '" + args[0] + "'")
+		case (cmd.arity > 0 && len(args) != cmd.arity) || len(args) < -cmd.arity:
+			w.writeError("ERR wrong number of arguments for '" + strings.ToLower(name) + "' command")
+		default:
+			cmd.handler(s, conn, args[1:], w)
+		}
+
+		if name == "QUIT" {
+			return
+		}
+
+		// replies are flushed when there are no more pipelined requests
+		if conn.Drained() {
+			if err := w.flush(); err != nil {
+				return
+			}
+			conn.setIdle(true)
+		}
+	}
+}
+
+// readRESPRequest reads command sent as array of bulk strings. Inline commands
+// (space separated words terminated by line break) are supported as well.
+func readRESPRequest(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != respArray {
+		return strings.Fields(string(line)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxRESPArguments {
+		return nil, errRESPProtocol
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 || line[0] != respBulk {
+			return nil, errRESPProtocol
+		}
+
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > maxRESPBulkLength {
+			return nil, errRESPProtocol
+		}
+
+		data, err := ioutil.Read(r, length)
+		if err != nil {
+			return nil, err
+		}
+
+		tail, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(tail) != 0 {
+			return nil, errRESPProtocol
+		}
+
+		args = append(args, string(data))
+	}
+
+	return args, nil
+}
+
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func (w *respWriter) writeString(s string) {
+	w.w.WriteByte(respString)
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeError(s string) {
+	w.w.WriteByte(respError)
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeInteger(n int64) {
+	w.w.WriteByte(respInteger)
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeBulk(s string) {
+	w.w.WriteByte(respBulk)
+	w.w.WriteString(strconv.Itoa(len(s)))
+	w.w.WriteString("\r\n")
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeNull() {
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w *respWriter) writeNullArray() {
+	w.w.WriteString("*-1\r\n")
+}
+
+func (w *respWriter) writeArray(values []string) {
+	w.w.WriteByte(respArray)
+	w.w.WriteString(strconv.Itoa(len(values)))
+	w.w.WriteString("\r\n")
+	for _, value := range values {
+		w.writeBulk(value)
+	}
+}
+
+// writeErr writes error returned by lodge command.
+func (w *respWriter) writeErr(err error) {
+	switch err {
+	case errAuthRequired:
+		w.writeError("NOAUTH Authentication required.")
+	case errReadOnly:
+		w.writeError("READONLY You can't write against a read only replica.")
+	case errWrongType:
+		w.writeError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	case errBadFormat:
+		w.writeError("ERR syntax error")
+	case errNotInteger:
+		w.writeError("ERR value is not an integer or out of range")
+	case errOverflow:
+		w.writeError("ERR increment or decrement would overflow")
+	case errNotFloat:
+		w.writeError("ERR value is not a valid float")
+	case errNoPerm:
+		w.writeError("NOPERM this user has no permissions to run this command or access its keys")
+	default:
+		w.writeError("ERR " + err.Error())
+	}
+}
+
+func (w *respWriter) flush() error {
+	return w.w.Flush()
+}
+
+func newRequest(command string, arguments ...string) *request {
+	return &request{
+		command:   command,
+		arguments: arguments,
+	}
+}
+
+// newDataRequest creates request with data. Length of data is added as last argument.
+func newDataRequest(command, data string, arguments ...string) *request {
+	return newValuesRequest(command, []string{data}, arguments...)
+}
+
+// newValuesRequest creates request with several data blocks. Lengths of values are added as last arguments.
+func newValuesRequest(command string, values []string, arguments ...string) *request {
+	body := make([][]byte, len(values))
+	for i, value := range values {
+		arguments = append(arguments, strconv.Itoa(len(value)))
+		body[i] = []byte(value)
+	}
+
+	r := newRequest(command, arguments...)
+	r.body = body
+
+	return r
+}
+
+func respGet(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newRequest("GET", args[0]))
+	switch err {
+	case nil:
+		w.writeBulk(values[0])
+	case errNotFound:
+		w.writeNull()
+	default:
+		w.writeErr(err)
+	}
+}
+
+// respSet supports SET key value [EX seconds | PX milliseconds] [NX | XX].
+func respSet(s *Server, conn *connection, args []string, w *respWriter) {
+	var ttl int64
+	var condition string
+	options := args[2:]
+	for len(options) > 0 {
+		option := strings.ToUpper(options[0])
+		if option == "NX" || option == "XX" {
+			if condition != "" {
+				w.writeErr(errBadFormat)
+				return
+			}
+
+			condition = option
+			options = options[1:]
+			continue
+		}
+
+		if len(options) < 2 {
+			w.writeErr(errBadFormat)
+			return
+		}
+
+		n, err := strconv.ParseInt(options[1], 10, 64)
+		if err != nil || n <= 0 {
+			w.writeError("ERR invalid expire time in 'set' command")
+			return
+		}
+
+		switch option {
+		case "EX":
+			ttl = n
+		case "PX":
+			// lodge ttl has seconds precision
+			ttl = (n + 999) / 1000
+		default:
+			w.writeErr(errBadFormat)
+			return
+		}
+
+		options = options[2:]
+	}
+
+	arguments := []string{args[0], strconv.FormatInt(ttl, 10)}
+	if condition != "" {
+		arguments = append(arguments, condition)
+	}
+
+	_, err := s.execute(conn, newDataRequest("SET", args[1], arguments...))
+	switch err {
+	case nil:
+		w.writeString("OK")
+	case errNotStored:
+		w.writeNull()
+	default:
+		w.writeErr(err)
+	}
+}
+
+func respSetNX(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newDataRequest("SETNX", args[1], args[0]), w)
+}
+
+func respGetSet(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newDataRequest("GETSET", args[1], args[0]), w)
+}
+
+// respHSet sets fields and returns number of added fields.
+func respHSet(s *Server, conn *connection, args []string, w *respWriter) {
+	if len(args)%2 != 1 {
+		w.writeError("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+
+	key := args[0]
+	arguments := []string{key}
+	for i := 1; i < len(args); i += 2 {
+		arguments = append(arguments, args[i])
+	}
+	if err := s.respAuthorize(conn, "HSET", arguments, arguments[:1]); err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	// fields are set with key locked, so added fields are counted exactly and set together
+	var added int64
+	err := s.storage.Atomic([]string{key}, func(storage Storage) error {
+		for i := 1; i < len(args); i += 2 {
+			_, err := storage.HGet(key, args[i])
+			switch err {
+			case nil:
+			case errNotFound:
+				added++
+			default:
+				return err
+			}
+
+			if err := storage.HSet(key, args[i], args[i+1]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	w.writeInteger(added)
+}
+
+func respHGet(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newRequest("HGET", args[0], args[1]))
+	switch err {
+	case nil:
+		w.writeBulk(values[0])
+	case errNotFound:
+		w.writeNull()
+	default:
+		w.writeErr(err)
+	}
+}
+
+func respHGetAll(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newRequest("HGETALL", args[0]))
+	switch err {
+	case nil, errNotFound:
+		w.writeArray(values)
+	default:
+		w.writeErr(err)
+	}
+}
+
+// respDel deletes keys and replies with number of keys which existed.
+func respDel(s *Server, conn *connection, args []string, w *respWriter) {
+	if err := s.respAuthorize(conn, "DELETE", args, args); err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	// existence is checked with keys locked, so only one of concurrent deletes counts key
+	var deleted int64
+	err := s.storage.Atomic(args, func(storage Storage) error {
+		for _, key := range args {
+			if _, err := storage.TTL(key); err == errNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			if err := storage.Delete(key); err != nil {
+				return err
+			}
+			deleted++
+		}
+
+		return nil
+	})
+	if err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	w.writeInteger(deleted)
+}
+
+// respAuthorize checks request which is run on storage directly like execute checks lodge requests.
+func (s *Server) respAuthorize(conn *connection, command string, arguments, keys []string) error {
+	if !conn.authenticated {
+		return errAuthRequired
+	}
+	if !validArgumentValues(arguments) {
+		return errBadArgument
+	}
+
+	return s.authorize(conn, command, keys, false)
+}
+
+func respScan(s *Server, conn *connection, args []string, w *respWriter) {
+	respScanCommand(s, conn, newRequest("SCAN", args...), w)
+}
+
+func respHScan(s *Server, conn *connection, args []string, w *respWriter) {
+	respScanCommand(s, conn, newRequest("HSCAN", args...), w)
+}
+
+// respScanCommand replies with array of next cursor and array of elements.
+func respScanCommand(s *Server, conn *connection, r *request, w *respWriter) {
+	values, err := s.execute(conn, r)
+	if err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	w.w.WriteString("*2\r\n")
+	w.writeBulk(values[0])
+	w.writeArray(values[1:])
+}
+
+func respKeys(s *Server, conn *connection, args []string, w *respWriter) {
+	keys, err := s.execute(conn, newRequest("KEYS"))
+	if err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	result := keys[:0]
+	for _, key := range keys {
+		if matchGlob(args[0], key) {
+			result = append(result, key)
+		}
+	}
+
+	w.writeArray(result)
+}
+
+func respExpire(s *Server, conn *connection, args []string, w *respWriter) {
+	ttl, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		w.writeError("ERR value is not an integer or out of range")
+		return
+	}
+
+	// non-positive ttl deletes key like in Redis, while lodge treats 0 as "never expires"
+	if ttl <= 0 {
+		_, err = s.execute(conn, newRequest("EXPIREAT", args[0], "1"))
+	} else {
+		_, err = s.execute(conn, newRequest("EXPIRE", args[0], args[1]))
+	}
+
+	switch err {
+	case nil:
+		w.writeInteger(1)
+	case errNotFound:
+		w.writeInteger(0)
+	default:
+		w.writeErr(err)
+	}
+}
+
+// respTTL returns -2 for missing key and -1 for key which never expires.
+func respTTL(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newRequest("TTL", args[0]))
+	switch err {
+	case nil:
+		ttl, _ := strconv.ParseInt(values[0], 10, 64)
+		if ttl == 0 {
+			ttl = -1
+		}
+		w.writeInteger(ttl)
+	case errNotFound:
+		w.writeInteger(-2)
+	default:
+		w.writeErr(err)
+	}
+}
+
+func respIncr(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("INCR", args...), w)
+}
+
+func respDecr(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("DECR", args...), w)
+}
+
+func respIncrBy(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("INCRBY", args...), w)
+}
+
+func respDecrBy(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("DECRBY", args...), w)
+}
+
+func respHIncrBy(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("HINCRBY", args...), w)
+}
+
+// respIntegerCommand executes command which returns single integer.
+func respIntegerCommand(s *Server, conn *connection, r *request, w *respWriter) {
+	values, err := s.execute(conn, r)
+	if err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	n, _ := strconv.ParseInt(values[0], 10, 64)
+	w.writeInteger(n)
+}
+
+func respLPush(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newValuesRequest("LPUSH", args[1:], args[0]), w)
+}
+
+func respRPush(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newValuesRequest("RPUSH", args[1:], args[0]), w)
+}
+
+func respLPop(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newRequest("LPOP", args...), w)
+}
+
+func respRPop(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newRequest("RPOP", args...), w)
+}
+
+func respLRange(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("LRANGE", args...), w)
+}
+
+func respLLen(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("LLEN", args...), w)
+}
+
+func respLTrim(s *Server, conn *connection, args []string, w *respWriter) {
+	if _, err := s.execute(conn, newRequest("LTRIM", args...)); err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	w.writeString("OK")
+}
+
+func respLIndex(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newRequest("LINDEX", args...), w)
+}
+
+func respBLPop(s *Server, conn *connection, args []string, w *respWriter) {
+	respBlockingPop(s, conn, newRequest("BLPOP", args...), w)
+}
+
+func respBRPop(s *Server, conn *connection, args []string, w *respWriter) {
+	respBlockingPop(s, conn, newRequest("BRPOP", args...), w)
+}
+
+func respBlockingPop(s *Server, conn *connection, r *request, w *respWriter) {
+	values, err := s.execute(conn, r)
+	switch err {
+	case nil:
+		w.writeArray(values)
+	case errNotFound:
+		w.writeNullArray()
+	default:
+		w.writeErr(err)
+	}
+}
+
+func respSAdd(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newValuesRequest("SADD", args[1:], args[0]), w)
+}
+
+func respSRem(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newValuesRequest("SREM", args[1:], args[0]), w)
+}
+
+func respSIsMember(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newValuesRequest("SISMEMBER", args[1:], args[0]), w)
+}
+
+func respSMembers(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("SMEMBERS", args...), w)
+}
+
+func respSCard(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("SCARD", args...), w)
+}
+
+func respSPop(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newRequest("SPOP", args...), w)
+}
+
+func respSRandMember(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newRequest("SRANDMEMBER", args...), w)
+}
+
+func respSInter(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("SINTER", args...), w)
+}
+
+func respSUnion(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("SUNION", args...), w)
+}
+
+func respSDiff(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("SDIFF", args...), w)
+}
+
+func respSInterStore(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("SINTERSTORE", args...), w)
+}
+
+func respSUnionStore(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("SUNIONSTORE", args...), w)
+}
+
+func respSDiffStore(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("SDIFFSTORE", args...), w)
+}
+
+// respZAdd translates ZADD key score member [score member ...] to lodge ZADD key score length [score length ...].
+func respZAdd(s *Server, conn *connection, args []string, w *respWriter) {
+	pairs := args[1:]
+	if len(pairs)%2 != 0 {
+		w.writeErr(errBadFormat)
+		return
+	}
+
+	arguments := []string{args[0]}
+	body := make([][]byte, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		arguments = append(arguments, pairs[i], strconv.Itoa(len(pairs[i+1])))
+		body = append(body, []byte(pairs[i+1]))
+	}
+
+	r := newRequest("ZADD", arguments...)
+	r.body = body
+
+	respIntegerCommand(s, conn, r, w)
+}
+
+func respZRem(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newValuesRequest("ZREM", args[1:], args[0]), w)
+}
+
+func respZScore(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newValuesRequest("ZSCORE", args[1:], args[0]), w)
+}
+
+// respZRank replies null bulk string if member isn't found.
+func respZRank(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newValuesRequest("ZRANK", args[1:], args[0]))
+	switch err {
+	case nil:
+		rank, _ := strconv.ParseInt(values[0], 10, 64)
+		w.writeInteger(rank)
+	case errNotFound:
+		w.writeNull()
+	default:
+		w.writeErr(err)
+	}
+}
+
+func respZRange(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("ZRANGE", args...), w)
+}
+
+func respZRangeByScore(s *Server, conn *connection, args []string, w *respWriter) {
+	respArrayCommand(s, conn, newRequest("ZRANGEBYSCORE", args...), w)
+}
+
+// respZIncrBy translates ZINCRBY key increment member.
+func respZIncrBy(s *Server, conn *connection, args []string, w *respWriter) {
+	respGetCommand(s, conn, newDataRequest("ZINCRBY", args[2], args[0], args[1]), w)
+}
+
+func respZCard(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newRequest("ZCARD", args...), w)
+}
+
+// respZPopMin replies empty array if sorted set is empty.
+func respZPopMin(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newRequest("ZPOPMIN", args...))
+	switch err {
+	case nil:
+		w.writeArray(values)
+	case errNotFound:
+		w.writeArray(nil)
+	default:
+		w.writeErr(err)
+	}
+}
+
+// respArrayCommand executes command which returns array of values.
+func respArrayCommand(s *Server, conn *connection, r *request, w *respWriter) {
+	values, err := s.execute(conn, r)
+	if err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	w.writeArray(values)
+}
+
+// respGetCommand executes command which returns single value or NOT_FOUND.
+func respGetCommand(s *Server, conn *connection, r *request, w *respWriter) {
+	values, err := s.execute(conn, r)
+	switch err {
+	case nil:
+		w.writeBulk(values[0])
+	case errNotFound:
+		w.writeNull()
+	default:
+		w.writeErr(err)
+	}
+}
+
+func respPublish(s *Server, conn *connection, args []string, w *respWriter) {
+	respIntegerCommand(s, conn, newDataRequest("PUBLISH", args[1], args[0]), w)
+}
+
+func respSubscribe(s *Server, conn *connection, args []string, w *respWriter) {
+	respSubscribeCommand(s, conn, newRequest("SUBSCRIBE", args...), w)
+}
+
+func respPSubscribe(s *Server, conn *connection, args []string, w *respWriter) {
+	respSubscribeCommand(s, conn, newRequest("PSUBSCRIBE", args...), w)
+}
+
+// respSubscribeCommand switches connection to push mode.
+func respSubscribeCommand(s *Server, conn *connection, r *request, w *respWriter) {
+	if !conn.authenticated {
+		w.writeErr(errAuthRequired)
+		return
+	}
+	if err := s.authorizeChannels(conn, r.command, r.arguments); err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	s.serveSubscriber(conn, r, true)
+}
+
+// respACL replies to ACL WHOAMI with user name and to ACL LIST with array of users and their rules.
+func respACL(s *Server, conn *connection, args []string, w *respWriter) {
+	values, err := s.execute(conn, newRequest("ACL", args...))
+	switch {
+	case err != nil:
+		w.writeErr(err)
+	case strings.ToUpper(args[0]) == "WHOAMI":
+		w.writeBulk(values[0])
+	default:
+		w.writeArray(values)
+	}
+}
+
+// respAuth supports AUTH password for "default" user and AUTH username password.
+func respAuth(s *Server, conn *connection, args []string, w *respWriter) {
+	if len(args) > 2 {
+		w.writeErr(errBadFormat)
+		return
+	}
+
+	if len(args) == 1 {
+		args = []string{"default", args[0]}
+	}
+
+	if s.userList() == nil {
+		w.writeError("ERR AUTH called without any password configured")
+		return
+	}
+
+	if _, err := s.execute(conn, newRequest("AUTH", args...)); err != nil {
+		w.writeError("WRONGPASS invalid username-password pair")
+		return
+	}
+
+	w.writeString("OK")
+}
+
+func respPing(s *Server, conn *connection, args []string, w *respWriter) {
+	if _, err := s.execute(conn, newRequest("PING")); err != nil {
+		w.writeErr(err)
+		return
+	}
+
+	switch len(args) {
+	case 0:
+		w.writeString("PONG")
+	case 1:
+		w.writeBulk(args[0])
+	default:
+		w.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// respSelect accepts only database 0, because lodge has single keyspace.
+func respSelect(s *Server, conn *connection, args []string, w *respWriter) {
+	if args[0] != "0" {
+		w.writeError("ERR DB index is out of range")
+		return
+	}
+
+	w.writeString("OK")
+}
+
+func respQuit(s *Server, conn *connection, args []string, w *respWriter) {
+	w.writeString("OK")
+}
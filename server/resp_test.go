@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestReadRESPRequest(t *testing.T) {
+	cases := []struct {
+		request string
+		args    []string
+	}{
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", []string{"GET", "foo"}},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$8\r\nb a\r\n r\n\r\n", []string{"SET", "foo", "b a\r\n r\n"}},
+		{"*2\r\n$3\r\nGET\r\n$0\r\n\r\n", []string{"GET", ""}},
+		{"PING\r\n", []string{"PING"}},
+	}
+
+	for _, tc := range cases {
+		args, err := readRESPRequest(bufio.NewReader(bytes.NewBufferString(tc.request)))
+		if err != nil {
+			t.Fatalf("Unexpected error %v for %q", err, tc.request)
+		}
+
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Fatalf("Expected: %q. Got: %q", tc.args, args)
+		}
+	}
+}
+
+func TestReadRESPRequestProtocolError(t *testing.T) {
+	cases := []string{
+		"*x\r\n",
+		"*1\r\n+GET\r\n",
+		"*1\r\n$3\r\nGETX\r\n",
+		"*-1\r\n",
+		"*1\r\n$-1\r\n",
+	}
+
+	for _, tc := range cases {
+		if _, err := readRESPRequest(bufio.NewReader(bytes.NewBufferString(tc))); err != errRESPProtocol {
+			t.Fatalf("Expected protocol error for %q. Got: %v", tc, err)
+		}
+	}
+}
+
+func TestRESPNegativeLength(t *testing.T) {
+	for _, request := range []string{"*-1\r\n", "*-100\r\n", "*1\r\n$-1\r\n", "*2\r\n$3\r\nGET\r\n$-5\r\n"} {
+		client, closer := testServer(t)
+		client.assertRequest(t, []byte(request), []byte("-ERR Protocol error\r\n"))
+		closer.Close()
+	}
+}
+
+func TestRESPCommands(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	cases := []struct {
+		request  string
+		expected string
+	}{
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$-1\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$3\r\nbar\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nget\r\n$3\r\nfoo\r\n", "$3\r\nbar\r\n"},
+		{"*5\r\n$3\r\nSET\r\n$3\r\nttl\r\n$1\r\nx\r\n$2\r\nEX\r\n$3\r\n100\r\n", "+OK\r\n"},
+		{"*4\r\n$3\r\nSET\r\n$3\r\nttl\r\n$1\r\ny\r\n$2\r\nNX\r\n", "$-1\r\n"},
+		{"*6\r\n$3\r\nSET\r\n$3\r\nttl\r\n$1\r\ny\r\n$2\r\nxx\r\n$2\r\nEX\r\n$2\r\n10\r\n", "+OK\r\n"},
+		{"*3\r\n$5\r\nSETNX\r\n$3\r\nttl\r\n$1\r\nz\r\n", ":0\r\n"},
+		{"*3\r\n$5\r\nSETNX\r\n$2\r\nnx\r\n$1\r\nz\r\n", ":1\r\n"},
+		{"*3\r\n$6\r\nGETSET\r\n$2\r\nnx\r\n$1\r\nw\r\n", "$1\r\nz\r\n"},
+		{"*3\r\n$6\r\nGETSET\r\n$3\r\nnew\r\n$1\r\nw\r\n", "$-1\r\n"},
+		{"*4\r\n$4\r\nHSET\r\n$4\r\nhash\r\n$1\r\nf\r\n$1\r\nv\r\n", ":1\r\n"},
+		{"*4\r\n$4\r\nHSET\r\n$4\r\nhash\r\n$1\r\nf\r\n$1\r\nw\r\n", ":0\r\n"},
+		{"*6\r\n$4\r\nHSET\r\n$3\r\nmix\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n", ":2\r\n"},
+		{"*6\r\n$4\r\nHSET\r\n$3\r\nmix\r\n$1\r\na\r\n$1\r\n3\r\n$1\r\nc\r\n$1\r\n4\r\n", ":1\r\n"},
+		{"*4\r\n$4\r\nHSET\r\n$3\r\nfoo\r\n$1\r\na\r\n$1\r\n1\r\n", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"*3\r\n$4\r\nHGET\r\n$4\r\nhash\r\n$1\r\nf\r\n", "$1\r\nw\r\n"},
+		{"*3\r\n$4\r\nHGET\r\n$4\r\nhash\r\n$1\r\nx\r\n", "$-1\r\n"},
+		{"*2\r\n$7\r\nHGETALL\r\n$4\r\nhash\r\n", "*2\r\n$1\r\nf\r\n$1\r\nw\r\n"},
+		{"*2\r\n$7\r\nHGETALL\r\n$7\r\nmissing\r\n", "*0\r\n"},
+		{"*2\r\n$4\r\nKEYS\r\n$2\r\nf*\r\n", "*1\r\n$3\r\nfoo\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$3\r\nfoo\r\n$3\r\n100\r\n", ":1\r\n"},
+		{"*3\r\n$6\r\nEXPIRE\r\n$7\r\nmissing\r\n$3\r\n100\r\n", ":0\r\n"},
+		{"*2\r\n$3\r\nDEL\r\n$3\r\nfoo\r\n", ":1\r\n"},
+		{"*4\r\n$3\r\nDEL\r\n$3\r\nfoo\r\n$3\r\nmix\r\n$7\r\nmissing\r\n", ":1\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$-1\r\n"},
+		{"*2\r\n$4\r\nINCR\r\n$7\r\ncounter\r\n", ":1\r\n"},
+		{"*3\r\n$6\r\nDECRBY\r\n$7\r\ncounter\r\n$1\r\n3\r\n", ":-2\r\n"},
+		{"*2\r\n$4\r\nINCR\r\n$4\r\nhash\r\n", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"*4\r\n$5\r\nRPUSH\r\n$4\r\nlist\r\n$1\r\na\r\n$1\r\nb\r\n", ":2\r\n"},
+		{"*4\r\n$6\r\nLRANGE\r\n$4\r\nlist\r\n$1\r\n0\r\n$2\r\n-1\r\n", "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"*2\r\n$4\r\nRPOP\r\n$4\r\nlist\r\n", "$1\r\nb\r\n"},
+		{"*3\r\n$5\r\nBLPOP\r\n$4\r\nlist\r\n$1\r\n1\r\n", "*2\r\n$4\r\nlist\r\n$1\r\na\r\n"},
+		{"*3\r\n$5\r\nBLPOP\r\n$4\r\nlist\r\n$3\r\n0.1\r\n", "*-1\r\n"},
+		{"*4\r\n$4\r\nSADD\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\nb\r\n", ":2\r\n"},
+		{"*3\r\n$9\r\nSISMEMBER\r\n$3\r\nset\r\n$1\r\nb\r\n", ":1\r\n"},
+		{"*3\r\n$4\r\nSREM\r\n$3\r\nset\r\n$1\r\na\r\n", ":1\r\n"},
+		{"*2\r\n$8\r\nSMEMBERS\r\n$3\r\nset\r\n", "*1\r\n$1\r\nb\r\n"},
+		{"*4\r\n$11\r\nSINTERSTORE\r\n$4\r\ndest\r\n$3\r\nset\r\n$7\r\nmissing\r\n", ":0\r\n"},
+		{"*2\r\n$4\r\nSPOP\r\n$3\r\nset\r\n", "$1\r\nb\r\n"},
+		{"*2\r\n$4\r\nSPOP\r\n$3\r\nset\r\n", "$-1\r\n"},
+		{"*6\r\n$4\r\nZADD\r\n$5\r\nboard\r\n$1\r\n2\r\n$1\r\na\r\n$3\r\n1.5\r\n$1\r\nb\r\n", ":2\r\n"},
+		{"*5\r\n$6\r\nZRANGE\r\n$5\r\nboard\r\n$1\r\n0\r\n$2\r\n-1\r\n$10\r\nWITHSCORES\r\n", "*4\r\n$1\r\nb\r\n$3\r\n1.5\r\n$1\r\na\r\n$1\r\n2\r\n"},
+		{"*4\r\n$7\r\nZINCRBY\r\n$5\r\nboard\r\n$1\r\n1\r\n$1\r\nb\r\n", "$3\r\n2.5\r\n"},
+		{"*3\r\n$5\r\nZRANK\r\n$5\r\nboard\r\n$1\r\nb\r\n", ":1\r\n"},
+		{"*3\r\n$6\r\nZSCORE\r\n$5\r\nboard\r\n$1\r\nx\r\n", "$-1\r\n"},
+		{"*4\r\n$4\r\nZADD\r\n$5\r\nboard\r\n$1\r\nx\r\n$1\r\na\r\n", "-ERR value is not a valid float\r\n"},
+		{"*2\r\n$7\r\nZPOPMIN\r\n$7\r\nmissing\r\n", "*0\r\n"},
+		{"*4\r\n$4\r\nSCAN\r\n$1\r\n0\r\n$5\r\nMATCH\r\n$3\r\nzzz\r\n", "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*1\r\n$7\r\nUNKNOWN\r\n", "-ERR unknown command 'UNKNOWN'\r\n"},
+		{"*1\r\n$3\r\nGET\r\n", "-ERR wrong number of arguments for 'get' command\r\n"},
+	}
+
+	for _, tc := range cases {
+		client.assertRequest(t, []byte(tc.request), []byte(tc.expected))
+	}
+}
+
+func TestRESPArguments(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	badArgument := "-ERR Arguments must not be empty or contain spaces and line breaks\r\n"
+	cases := []struct {
+		request  string
+		expected string
+	}{
+		// arguments would be replayed from journal as other requests
+		{"*3\r\n$3\r\nSET\r\n$22\r\na 0 1\r\nx\r\nSET injected\r\n$1\r\nv\r\n", badArgument},
+		{"*2\r\n$3\r\nGET\r\n$8\r\ninjected\r\n", "$-1\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$3\r\na b\r\n$1\r\nv\r\n", badArgument},
+		{"*3\r\n$3\r\nSET\r\n$0\r\n\r\n$1\r\nv\r\n", badArgument},
+		{"*4\r\n$4\r\nHSET\r\n$4\r\nhash\r\n$3\r\nf g\r\n$1\r\nv\r\n", badArgument},
+		// values are sent as data, so they can contain anything
+		{"*3\r\n$3\r\nSET\r\n$3\r\nfoo\r\n$5\r\na\r\nb \r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "$5\r\na\r\nb \r\n"},
+	}
+
+	for _, tc := range cases {
+		client.assertRequest(t, []byte(tc.request), []byte(tc.expected))
+	}
+}
+
+func TestRESPAuth(t *testing.T) {
+	users, _ := NewUserListFromReader(bytes.NewBufferString("default:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	config := DefaultConfig()
+	config.Users = users
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"), []byte("-NOAUTH Authentication required.\r\n"))
+	client.assertRequest(t, []byte("*1\r\n$4\r\nPING\r\n"), []byte("-NOAUTH Authentication required.\r\n"))
+	client.assertRequest(t, []byte("*2\r\n$4\r\nAUTH\r\n$5\r\nwrong\r\n"), []byte("-WRONGPASS invalid username-password pair\r\n"))
+	client.assertRequest(t, []byte("*2\r\n$4\r\nAUTH\r\n$8\r\npassword\r\n"), []byte("+OK\r\n"))
+	client.assertRequest(t, []byte("*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"), []byte("$-1\r\n"))
+	client.assertRequest(t, []byte("*1\r\n$4\r\nPING\r\n"), []byte("+PONG\r\n"))
+}
+
+func TestRESPPubSub(t *testing.T) {
+	sub, publisher, server := testPubSubServer(t, DefaultConfig())
+	defer server.Close()
+	defer sub.Close()
+
+	sub.Write([]byte("*3\r\n$9\r\nSUBSCRIBE\r\n$4\r\nnews\r\n$5\r\nsport\r\n"))
+	assertReceived(t, sub, "*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$5\r\nsport\r\n:2\r\n")
+
+	publisher.assertRequest(t, []byte("*3\r\n$7\r\nPUBLISH\r\n$4\r\nnews\r\n$5\r\nhello\r\n"), []byte(":1\r\n"))
+	assertReceived(t, sub, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+
+	sub.Write([]byte("*1\r\n$4\r\nPING\r\n*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n"))
+	assertReceived(t, sub, "*2\r\n$4\r\npong\r\n$0\r\n\r\n-ERR "+errSubscribed.Error()+"\r\n")
+
+	sub.Write([]byte("*1\r\n$11\r\nUNSUBSCRIBE\r\n*1\r\n$4\r\nPING\r\n"))
+	assertReceived(t, sub, "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:1\r\n*3\r\n$11\r\nunsubscribe\r\n$5\r\nsport\r\n:0\r\n+PONG\r\n")
+}
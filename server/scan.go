@@ -0,0 +1,91 @@
+package server
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"math"
+)
+
+// SCAN and HSCAN visit keys (or hash fields) in order of their position, which is 32-bit hash of key.
+// Cursor is position to continue from, so iteration doesn't depend on how keys are stored: every key
+// which is present during the whole iteration is returned exactly once, keys added or removed during
+// iteration may be returned or not. Cursor 0 starts iteration and is returned when iteration is over.
+//
+// Every call looks through all keys of storage, but holds only count of them, so cost of call is
+// proportional to size of storage (bucket for bucketStorage) rather than to size of reply.
+
+// scanPosition returns position of key in scan order.
+func scanPosition(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return h.Sum32()
+}
+
+// scan returns at least count keys with position not less than cursor and next cursor. Keys with the same
+// position are never split between calls. each must call fn for every key.
+func scan(cursor uint64, count int, each func(fn func(key string))) ([]string, uint64) {
+	if cursor > math.MaxUint32 {
+		return nil, 0
+	}
+
+	// the first pass finds position of count-th key
+	h := &scanHeap{}
+	each(func(key string) {
+		pos := scanPosition(key)
+		switch {
+		case uint64(pos) < cursor:
+		case h.Len() < count:
+			heap.Push(h, pos)
+		case pos < (*h)[0]:
+			(*h)[0] = pos
+			heap.Fix(h, 0)
+		}
+	})
+
+	last, next := uint64(math.MaxUint32), uint64(0)
+	if h.Len() == count && count > 0 && (*h)[0] < math.MaxUint32 {
+		last, next = uint64((*h)[0]), uint64((*h)[0])+1
+	}
+
+	keys := make([]string, 0, h.Len())
+	each(func(key string) {
+		if pos := uint64(scanPosition(key)); pos >= cursor && pos <= last {
+			keys = append(keys, key)
+		}
+	})
+
+	return keys, next
+}
+
+// scanHeap is max-heap of positions.
+type scanHeap []uint32
+
+func (h scanHeap) Len() int            { return len(h) }
+func (h scanHeap) Less(i, j int) bool  { return h[i] > h[j] }
+func (h scanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scanHeap) Push(x interface{}) { *h = append(*h, x.(uint32)) }
+
+func (h *scanHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return x
+}
+
+// scanHash returns fields of hash with values, see scan.
+func scanHash(hash map[string]string, cursor uint64, count int) ([]string, uint64) {
+	fields, next := scan(cursor, count, func(fn func(key string)) {
+		for field := range hash {
+			fn(field)
+		}
+	})
+
+	result := make([]string, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, field, hash[field])
+	}
+
+	return result, next
+}
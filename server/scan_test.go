@@ -0,0 +1,143 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		for i := 0; i < 50; i++ {
+			s.Set("stable"+strconv.Itoa(i), "value", 0)
+			s.Set("volatile"+strconv.Itoa(i), "value", 0)
+		}
+
+		seen := make(map[string]int)
+		cursor, calls := uint64(0), 0
+		for {
+			keys, next, err := s.Scan(cursor, 7)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			for _, key := range keys {
+				seen[key]++
+			}
+
+			// keys added and removed during iteration don't affect other keys
+			s.Delete("volatile" + strconv.Itoa(calls))
+			s.Set("added"+strconv.Itoa(calls), "value", 0)
+
+			calls++
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+
+		if calls < 2 {
+			t.Fatalf("%s: expected several calls. Got: %d", name, calls)
+		}
+		for i := 0; i < 50; i++ {
+			if n := seen["stable"+strconv.Itoa(i)]; n != 1 {
+				t.Fatalf("%s: expected stable%d to be returned once. Got: %d", name, i, n)
+			}
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Fatalf("%s: expected %s to be returned once. Got: %d", name, key, n)
+			}
+		}
+	}
+}
+
+func TestHScan(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		for i := 0; i < 25; i++ {
+			s.HSet("hash", "field"+strconv.Itoa(i), strconv.Itoa(i))
+		}
+
+		fields := make(map[string]string)
+		cursor := uint64(0)
+		for {
+			values, next, err := s.HScan("hash", cursor, 4)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+			for i := 0; i < len(values); i += 2 {
+				fields[values[i]] = values[i+1]
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+
+		if len(fields) != 25 || fields["field7"] != "7" {
+			t.Fatalf("%s: expected 25 fields. Got: %v", name, fields)
+		}
+
+		if values, next, err := s.HScan("missing", 0, 10); err != nil || len(values) != 0 || next != 0 {
+			t.Fatalf("%s: expected empty result. Got: %v, %d, %v", name, values, next, err)
+		}
+		s.Set("string", "value", 0)
+		if _, _, err := s.HScan("string", 0, 10); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestScanCommands(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SET foo 0 1\r\na\r\n"), resultOK)
+	client.assertRequest(t, []byte("SET bar 0 1\r\nb\r\n"), resultOK)
+	client.assertRequest(t, []byte("HSET hash field 1\r\nc\r\n"), resultOK)
+	client.assertRequest(t, []byte("SCAN 0 MATCH f* COUNT 100\r\n"), []byte("VALUES\r\n2\r\n1\r\n03\r\nfoo"))
+	client.assertRequest(t, []byte("HSCAN hash 0\r\n"), []byte("VALUES\r\n3\r\n1\r\n05\r\nfield1\r\nc"))
+	client.assertRequest(t, []byte("SCAN 0 COUNT 0\r\n"), resultBadFormat)
+	client.assertRequest(t, []byte("SCAN 0 MATCH\r\n"), resultBadFormat)
+	client.assertRequest(t, []byte("SCAN x\r\n"), resultBadFormat)
+}
+
+func TestScanOrder(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e", "f", "g"}
+	each := func(fn func(key string)) {
+		for _, key := range keys {
+			fn(key)
+		}
+	}
+
+	// batches are ordered by positions of keys
+	var result []string
+	var last uint32
+	cursor := uint64(0)
+	for {
+		batch, next := scan(cursor, 2, each)
+		for _, key := range batch {
+			if pos := scanPosition(key); cursor > 0 && pos <= last {
+				t.Fatalf("Expected position of %s after %d. Got: %d", key, last, pos)
+			}
+			result = append(result, key)
+		}
+		for _, key := range batch {
+			if pos := scanPosition(key); pos > last {
+				last = pos
+			}
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	sort.Strings(result)
+	if len(result) != len(keys) {
+		t.Fatalf("Expected: %v. Got: %v", keys, result)
+	}
+}
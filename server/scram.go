@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// SCRAM-SHA-256 authentication, RFC 5802 and RFC 7677. Client proves that it knows password without
+// sending it and verifies that server knows credentials of user:
+//
+//	SCRAM n,,n=alice,r=clientnonce
+//	VALUES 1 r=clientnonceservernonce,s=salt,i=4096
+//	SCRAM c=biws,r=clientnonceservernonce,p=proof
+//	VALUES 1 v=serversignature
+//
+// User must have SCRAM-SHA-256 entry in users file, unknown users get challenge with fake salt, so they
+// can't be told from known ones. Channel binding and authorization identity aren't supported.
+
+var (
+	errNoAuthentication = errors.New("Authentication is not required")
+	errServerSignature  = errors.New("Server failed SCRAM authentication")
+)
+
+const (
+	scramPrefix     = "SCRAM-SHA-256$"
+	scramIterations = 4096
+	scramSaltLength = 16
+	scramNonceBytes = 18
+
+	// Bounds of iterations and length of messages accepted from server when replica logs in to primary.
+	minSCRAMIterations = 4096
+	maxSCRAMIterations = 1 << 20
+	maxSCRAMMessage    = 1024
+)
+
+// scramHash is SCRAM-SHA-256$iterations:salt$StoredKey:ServerKey entry of users file, the same as
+// PostgreSQL uses.
+type scramHash struct {
+	iterations int
+	salt       []byte
+	storedKey  []byte
+	serverKey  []byte
+}
+
+func parseSCRAMHash(s string) (passwordHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 2 {
+		return nil, errBadPasswordHash
+	}
+	parameters, keys := strings.Split(parts[0], ":"), strings.Split(parts[1], ":")
+	if len(parameters) != 2 || len(keys) != 2 {
+		return nil, errBadPasswordHash
+	}
+
+	h := &scramHash{}
+	var err error
+	if h.iterations, err = strconv.Atoi(parameters[0]); err != nil || h.iterations <= 0 {
+		return nil, errBadPasswordHash
+	}
+	if h.salt, err = base64.StdEncoding.DecodeString(parameters[1]); err != nil || len(h.salt) == 0 {
+		return nil, errBadPasswordHash
+	}
+	if h.storedKey, err = base64.StdEncoding.DecodeString(keys[0]); err != nil || len(h.storedKey) != sha256.Size {
+		return nil, errBadPasswordHash
+	}
+	if h.serverKey, err = base64.StdEncoding.DecodeString(keys[1]); err != nil || len(h.serverKey) != sha256.Size {
+		return nil, errBadPasswordHash
+	}
+
+	return h, nil
+}
+
+// newSCRAMHash returns SCRAM credentials of password.
+func newSCRAMHash(password string) (*scramHash, error) {
+	salt := make([]byte, scramSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	_, storedKey, serverKey := scramKeys(password, salt, scramIterations)
+	return &scramHash{iterations: scramIterations, salt: salt, storedKey: storedKey, serverKey: serverKey}, nil
+}
+
+// verify checks password sent by AUTH, so users with SCRAM entries can use both.
+func (h *scramHash) verify(password string) bool {
+	_, storedKey, _ := scramKeys(password, h.salt, h.iterations)
+	return subtle.ConstantTimeCompare(h.storedKey, storedKey) == 1
+}
+
+// String returns entry of users file.
+func (h *scramHash) String() string {
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("%s%d:%s$%s:%s", scramPrefix, h.iterations, b64(h.salt), b64(h.storedKey), b64(h.serverKey))
+}
+
+// scramKeys derives ClientKey, StoredKey and ServerKey from password.
+func scramKeys(password string, salt []byte, iterations int) (clientKey, storedKey, serverKey []byte) {
+	salted := pbkdf2.Key([]byte(password), salt, iterations, sha256.Size, sha256.New)
+	clientKey = scramHMAC(salted, "Client Key")
+	stored := sha256.Sum256(clientKey)
+
+	return clientKey, stored[:], scramHMAC(salted, "Server Key")
+}
+
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// scramExchange is state of connection between the first and the final client messages.
+type scramExchange struct {
+	user string
+	// hash is nil if user is unknown or has no SCRAM entry
+	hash            *scramHash
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+}
+
+// scram handles SCRAM command. The first message of client starts exchange, the next one completes it.
+func (s *Server) scram(conn *connection, arguments []string) ([]string, error) {
+	users := s.userList()
+	if users == nil {
+		return nil, errNoAuthentication
+	}
+	if len(arguments) != 1 {
+		return nil, errArguments
+	}
+
+	if conn.scram == nil {
+		return s.scramFirst(conn, users, arguments[0])
+	}
+
+	exchange := conn.scram
+	conn.scram = nil
+	return s.scramFinal(conn, exchange, arguments[0])
+}
+
+// scramFirst replies to n,,n=user,r=nonce with challenge r=nonce,s=salt,i=iterations.
+func (s *Server) scramFirst(conn *connection, users *UserList, message string) ([]string, error) {
+	if !strings.HasPrefix(message, "n,,") && !strings.HasPrefix(message, "y,,") {
+		return nil, errBadFormat
+	}
+
+	bare := message[3:]
+	attributes := strings.Split(bare, ",")
+	if len(attributes) < 2 || !strings.HasPrefix(attributes[0], "n=") || !strings.HasPrefix(attributes[1], "r=") {
+		return nil, errBadFormat
+	}
+	user, ok := decodeSCRAMName(attributes[0][2:])
+	clientNonce := attributes[1][2:]
+	if !ok || user == "" || clientNonce == "" {
+		return nil, errBadFormat
+	}
+
+	nonce := make([]byte, scramNonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	exchange := &scramExchange{
+		user:            user,
+		gs2Header:       message[:3],
+		clientFirstBare: bare,
+		nonce:           clientNonce + base64.StdEncoding.EncodeToString(nonce),
+	}
+
+	salt, iterations := s.scramFakeSalt(user), scramIterations
+	if h, ok := users.users[user].(*scramHash); ok {
+		exchange.hash = h
+		salt, iterations = h.salt, h.iterations
+	}
+
+	exchange.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", exchange.nonce, base64.StdEncoding.EncodeToString(salt), iterations)
+	conn.scram = exchange
+
+	return []string{exchange.serverFirst}, nil
+}
+
+// scramFinal checks proof of c=header,r=nonce,p=proof and replies with server signature v=signature.
+func (s *Server) scramFinal(conn *connection, exchange *scramExchange, message string) ([]string, error) {
+	i := strings.LastIndex(message, ",p=")
+	if i < 0 {
+		return nil, errBadFormat
+	}
+	withoutProof := message[:i]
+	proof, err := base64.StdEncoding.DecodeString(message[i+3:])
+	if err != nil {
+		return nil, errBadFormat
+	}
+
+	attributes := strings.Split(withoutProof, ",")
+	if len(attributes) < 2 || attributes[0] != "c="+base64.StdEncoding.EncodeToString([]byte(exchange.gs2Header)) ||
+		attributes[1] != "r="+exchange.nonce {
+		return nil, errAuthRequired
+	}
+
+	if exchange.hash == nil || len(proof) != sha256.Size {
+		return nil, errAuthRequired
+	}
+
+	authMessage := exchange.clientFirstBare + "," + exchange.serverFirst + "," + withoutProof
+	clientKey := scramHMAC(exchange.hash.storedKey, authMessage)
+	for i := range clientKey {
+		clientKey[i] ^= proof[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(storedKey[:], exchange.hash.storedKey) != 1 {
+		return nil, errAuthRequired
+	}
+
+	if !s.login(conn, exchange.user) {
+		return nil, errAuthRequired
+	}
+
+	return []string{"v=" + base64.StdEncoding.EncodeToString(scramHMAC(exchange.hash.serverKey, authMessage))}, nil
+}
+
+// scramFakeSalt returns salt for user without SCRAM entry. It doesn't change, so repeated challenges
+// don't reveal that user is unknown.
+func (s *Server) scramFakeSalt(user string) []byte {
+	return scramHMAC(s.scramSecret, user)[:scramSaltLength]
+}
+
+// scramLogin authenticates replica connection on primary. errServerSignature is returned if primary doesn't
+// know credentials of user.
+func scramLogin(conn net.Conn, reader *bufio.Reader, user, password string) error {
+	nonce := make([]byte, scramNonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	clientNonce := base64.StdEncoding.EncodeToString(nonce)
+	clientFirstBare := "n=" + encodeSCRAMName(user) + ",r=" + clientNonce
+	serverFirst, err := replicationValue(conn, reader, record(nil, "SCRAM", "n,,"+clientFirstBare))
+	if err != nil {
+		return err
+	}
+
+	// r=nonce,s=salt,i=iterations, nonce of server starts with nonce of client
+	attributes := strings.Split(serverFirst, ",")
+	if len(attributes) < 3 || !strings.HasPrefix(attributes[0], "r="+clientNonce) ||
+		!strings.HasPrefix(attributes[1], "s=") || !strings.HasPrefix(attributes[2], "i=") {
+		return errServerSignature
+	}
+	salt, err := base64.StdEncoding.DecodeString(attributes[1][2:])
+	if err != nil {
+		return errServerSignature
+	}
+	iterations, err := strconv.Atoi(attributes[2][2:])
+	if err != nil || iterations < minSCRAMIterations || iterations > maxSCRAMIterations {
+		return errServerSignature
+	}
+
+	clientKey, storedKey, serverKey := scramKeys(password, salt, iterations)
+	withoutProof := "c=" + base64.StdEncoding.EncodeToString([]byte("n,,")) + "," + attributes[0]
+	authMessage := clientFirstBare + "," + serverFirst + "," + withoutProof
+	proof := scramHMAC(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	signature, err := replicationValue(conn, reader, record(nil, "SCRAM", withoutProof+",p="+base64.StdEncoding.EncodeToString(proof)))
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(signature), []byte("v="+base64.StdEncoding.EncodeToString(scramHMAC(serverKey, authMessage)))) {
+		return errServerSignature
+	}
+
+	return nil
+}
+
+// encodeSCRAMName replaces , and = in user name with =2C and =3D.
+func encodeSCRAMName(name string) string {
+	return strings.NewReplacer("=", "=3D", ",", "=2C").Replace(name)
+}
+
+// decodeSCRAMName decodes =2C and =3D in user name, other = are invalid.
+func decodeSCRAMName(name string) (string, bool) {
+	var decoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '=' {
+			decoded.WriteByte(name[i])
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(name[i:], "=2C"):
+			decoded.WriteByte(',')
+		case strings.HasPrefix(name[i:], "=3D"):
+			decoded.WriteByte('=')
+		default:
+			return "", false
+		}
+		i += 2
+	}
+
+	return decoded.String(), true
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSCRAMKeys(t *testing.T) {
+	// example of RFC 7677
+	salt, _ := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	clientKey, storedKey, serverKey := scramKeys("pencil", salt, 4096)
+
+	authMessage := "n=user,r=rOprNGfwEbeRWgbNEkqO," +
+		"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096," +
+		"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0"
+	proof := scramHMAC(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	if p := base64.StdEncoding.EncodeToString(proof); p != "dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=" {
+		t.Fatalf("Expected: dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=. Got: %s", p)
+	}
+	if v := base64.StdEncoding.EncodeToString(scramHMAC(serverKey, authMessage)); v != "6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=" {
+		t.Fatalf("Expected: 6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=. Got: %s", v)
+	}
+}
+
+// testSCRAMUsers has alice with SCRAM credentials of password and bob without them.
+func testSCRAMUsers(t *testing.T) string {
+	h, err := newSCRAMHash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "alice:" + h.String() + "\nbob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"
+}
+
+// scramChallenge sends the first message and returns challenge of server.
+func scramChallenge(t *testing.T, client *testClient, message string) string {
+	response := string(client.send(t, []byte("SCRAM "+message+"\r\n"), 0))
+
+	lines := strings.SplitN(response, "\r\n", 4)
+	if len(lines) != 4 || lines[0] != "VALUES" || lines[1] != "1" || lines[2] != strconv.Itoa(len(lines[3])) {
+		t.Fatalf("Expected challenge. Got: %s", response)
+	}
+
+	return lines[3]
+}
+
+// scramFinal returns the final message of client for challenge and password and expected signature of server.
+func scramFinal(t *testing.T, clientFirstBare, challenge, password string) (string, string) {
+	attributes := strings.Split(challenge, ",")
+	salt, _ := base64.StdEncoding.DecodeString(attributes[1][2:])
+	iterations, _ := strconv.Atoi(attributes[2][2:])
+	clientKey, storedKey, serverKey := scramKeys(password, salt, iterations)
+
+	withoutProof := "c=biws," + attributes[0]
+	authMessage := clientFirstBare + "," + challenge + "," + withoutProof
+	proof := scramHMAC(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof),
+		"v=" + base64.StdEncoding.EncodeToString(scramHMAC(serverKey, authMessage))
+}
+
+func TestSCRAM(t *testing.T) {
+	config := DefaultConfig()
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString(testSCRAMUsers(t)))
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("GET foo\r\n"), resultAuthRequired)
+
+	// wrong password
+	challenge := scramChallenge(t, client, "n,,n=alice,r=nonce")
+	final, _ := scramFinal(t, "n=alice,r=nonce", challenge, "passwor")
+	client.assertRequest(t, []byte("SCRAM "+final+"\r\n"), resultAuthRequired)
+	client.assertRequest(t, []byte("GET foo\r\n"), resultAuthRequired)
+
+	// nonce of other exchange
+	scramChallenge(t, client, "n,,n=alice,r=nonce")
+	client.assertRequest(t, []byte("SCRAM "+final+"\r\n"), resultAuthRequired)
+
+	challenge = scramChallenge(t, client, "n,,n=alice,r=nonce")
+	if !strings.HasPrefix(challenge, "r=nonce") || !strings.HasSuffix(challenge, ",i=4096") {
+		t.Fatalf("Expected challenge r=nonce...,s=...,i=4096. Got: %s", challenge)
+	}
+	final, signature := scramFinal(t, "n=alice,r=nonce", challenge, "password")
+	client.assertRequest(t, []byte("SCRAM "+final+"\r\n"),
+		[]byte("VALUES\r\n1\r\n"+strconv.Itoa(len(signature))+"\r\n"+signature))
+	client.assertRequest(t, []byte("ACL WHOAMI\r\n"), []byte("VALUES\r\n1\r\n5\r\nalice"))
+}
+
+func TestSCRAMWithoutCredentials(t *testing.T) {
+	config := DefaultConfig()
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString(testSCRAMUsers(t)))
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	// unknown users get the same salt every time
+	challenge := scramChallenge(t, client, "n,,n=carol,r=nonce")
+	final, _ := scramFinal(t, "n=carol,r=nonce", challenge, "password")
+	client.assertRequest(t, []byte("SCRAM "+final+"\r\n"), resultAuthRequired)
+	if other := scramChallenge(t, client, "n,,n=carol,r=nonce"); strings.Split(other, ",")[1] != strings.Split(challenge, ",")[1] {
+		t.Fatalf("Expected the same salt. Got: %s and %s", challenge, other)
+	}
+	client.assertRequest(t, []byte("SCRAM "+final+"\r\n"), resultAuthRequired)
+
+	// bob has no SCRAM credentials, but alice can use AUTH
+	challenge = scramChallenge(t, client, "n,,n=bob,r=nonce")
+	final, _ = scramFinal(t, "n=bob,r=nonce", challenge, "password")
+	client.assertRequest(t, []byte("SCRAM "+final+"\r\n"), resultAuthRequired)
+	client.assertRequest(t, []byte("AUTH alice password\r\n"), resultOK)
+
+	for _, message := range []string{"p=tls-server-end-point,,n=alice,r=nonce", "n,a=bob,n=alice,r=nonce", "n,,r=nonce",
+		"n,,n=alice", "n,,n=alice,r=", "n,,n=al=ice,r=nonce"} {
+		client.assertRequest(t, []byte("SCRAM "+message+"\r\n"), resultBadFormat)
+	}
+
+	other, closer := testServer(t)
+	defer closer.Close()
+	other.assertRequest(t, []byte("SCRAM n,,n=alice,r=nonce\r\n"), resultError)
+}
+
+func TestDecodeSCRAMName(t *testing.T) {
+	for name, expected := range map[string]string{"alice": "alice", "a=2Cb=3Dc": "a,b=c", "=3D=3D": "=="} {
+		if decoded, ok := decodeSCRAMName(name); !ok || decoded != expected {
+			t.Fatalf("%s: expected: %s. Got: %s", name, expected, decoded)
+		}
+	}
+
+	for _, name := range []string{"a=b", "a=2", "=2c"} {
+		if _, ok := decodeSCRAMName(name); ok {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
@@ -1,74 +1,420 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"log"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	// ErrServerClosed is returned by Serve methods after Shutdown or Close.
+	ErrServerClosed = errors.New("Server closed")
+	errMaxClients   = errors.New("Max number of clients reached")
+)
+
+const (
+	// rejectTimeout limits writing reply to connection rejected because of MaxClients.
+	rejectTimeout = time.Second
+	// handshakeTimeout limits TLS handshake.
+	handshakeTimeout = 10 * time.Second
+)
+
+// shutdownPollInterval is how often Shutdown looks for connections which became idle.
+const shutdownPollInterval = 10 * time.Millisecond
+
 // Config is configuration for lodge server
 type Config struct {
+	// Permissions of unix domain socket file, 0 keeps permissions set by umask.
+	UnixSocketPerm os.FileMode
 	// Is users specified then server will require authentication.
 	Users *UserList
-	// Timeout for queries processing.
+	// UsersFile is path of file Users were read from. ReloadUsers reads it again.
+	UsersFile string
+	// If TLS is specified then all listeners accept only TLS connections. If it verifies client certificates,
+	// client with certificate whose subject common name is in Users is authenticated as that user.
+	TLS *tls.Config
+	// Timeout for queries processing: request must be received within it after its first byte arrives.
+	// Commands aren't interrupted, so it doesn't limit BLPOP and BRPOP waiting for values.
 	Timeout time.Duration
+	// ReadTimeout limits every read of request, WriteTimeout limits every write of replies.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Connection which doesn't send requests for IdleTimeout is closed. Subscribers and replicas aren't closed.
+	IdleTimeout time.Duration
+	// Maximal number of connected clients, further connections are rejected. 0 means no limit.
+	MaxClients int
+	// Client whose unread replies exceed OutputBufferLimit bytes beyond socket buffers for 5 seconds is
+	// disconnected, e.g. when it sends KEYS or HGETALL of huge hash without reading replies. Until then
+	// requests of client wait for replies to be read. 0 means no limit. Subscribers are limited by
+	// PubSubBufferLimit instead.
+	OutputBufferLimit int
+	// Path to snapshot file used by SAVE and BGSAVE commands.
+	SnapshotPath string
+	// If append-only file is specified then every mutation is written to it.
+	AppendOnly *AppendOnlyFile
+	// Address of primary server. If it is specified then server starts as read-only replica.
+	ReplicaOf string
+	// Credentials used by replica to authenticate on primary.
+	PrimaryUsername string
+	PrimaryPassword string
+	// Replica authenticates with SCRAM, so password isn't sent to primary. If PrimaryPlainAuth is set then
+	// password is sent with AUTH instead, e.g. if user has no SCRAM credentials on primary.
+	PrimaryPlainAuth bool
+	// If PrimaryTLS is specified then replica connects to primary with TLS.
+	PrimaryTLS *tls.Config
+	// If keyspace notifications are enabled then changes of keys are published to pub/sub channels.
+	KeyspaceNotifications bool
+	// Maximal size of messages queued for subscriber in bytes. Subscriber which reads messages slower than
+	// they are published is disconnected when it is exceeded. 0 means no limit.
+	PubSubBufferLimit int
+	// Maximal size of replication stream queued for replica in bytes. Replica which receives mutations slower
+	// than they are made is disconnected when it is exceeded, it reconnects and receives full copy of storage
+	// again. 0 means no limit.
+	ReplicaBufferLimit int
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Timeout: 1 * time.Second,
+		Timeout:            1 * time.Second,
+		MaxClients:         10000,
+		PubSubBufferLimit:  8 * 1024 * 1024,
+		ReplicaBufferLimit: 256 * 1024 * 1024,
 	}
 }
 
 type Server struct {
 	storage Storage
-	l       net.Listener
-	users   *UserList
-	timeout time.Duration
+	// users is *UserList replaced by ReloadUsers, nil list means that authentication isn't required.
+	users     atomic.Value
+	usersFile string
+	// scramSecret derives salts of users without SCRAM credentials.
+	scramSecret []byte
+	tls         *tls.Config
+	limits      limits
+
+	snapshotPath string
+	// saving is semaphore which allows only one snapshot to be written at the same time.
+	saving chan struct{}
+
+	journal     *journal
+	replication *replication
+	// credentials for primary server
+	primaryUsername  string
+	primaryPassword  string
+	primaryPlainAuth bool
+	primaryTLS       *tls.Config
+	// replicaBufferLimit is limit of replication stream queued for every replica.
+	replicaBufferLimit int
+	// rewriting is semaphore which allows only one append-only file rewrite at the same time.
+	rewriting chan struct{}
 
 	commands map[string]command
+	// waiters are connections blocked by BLPOP and BRPOP.
+	waiters *waiters
+	pubsub  *pubsub
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	// conns are open client connections, handlers is number of their running handlers.
+	conns      map[*connection]struct{}
+	handlers   sync.WaitGroup
+	closed     bool
+	maxClients int
+	stats      stats
+	// unixSocketPerm is permissions of unix domain socket files.
+	unixSocketPerm os.FileMode
+	// flushTimers are delayed memcached flush_all commands, they are stopped when server is closed.
+	flushTimers map[*time.Timer]struct{}
 }
 
 func New(s Storage, config *Config) *Server {
 	server := &Server{
-		storage: s,
-		users:   config.Users,
-		timeout: config.Timeout,
-		commands: map[string]command{
-			"GET":     getCommand{},
-			"SET":     setCommand{},
-			"HGET":    hGetCommand{},
-			"HSET":    hSetCommand{},
-			"HGETALL": hGetAllCommand{},
-			"DELETE":  deleteCommand{},
-			"KEYS":    keysCommand{},
-			"EXPIRE":  expireCommand{},
+		storage:   s,
+		usersFile: config.UsersFile,
+		tls:       config.TLS,
+		limits: limits{
+			idle:    config.IdleTimeout,
+			read:    config.ReadTimeout,
+			request: config.Timeout,
+			write:   config.WriteTimeout,
+
+			outputBuffer: config.OutputBufferLimit,
 		},
+		maxClients:     config.MaxClients,
+		unixSocketPerm: config.UnixSocketPerm,
+		snapshotPath:   config.SnapshotPath,
+		saving:         make(chan struct{}, 1),
+		rewriting:      make(chan struct{}, 1),
+		conns:          make(map[*connection]struct{}),
+
+		waiters:            newWaiters(),
+		pubsub:             newPubSub(config.PubSubBufferLimit),
+		journal:            newJournal(s, config.AppendOnly),
+		replication:        newReplication(),
+		primaryUsername:    config.PrimaryUsername,
+		primaryPassword:    config.PrimaryPassword,
+		primaryPlainAuth:   config.PrimaryPlainAuth,
+		primaryTLS:         config.PrimaryTLS,
+		replicaBufferLimit: config.ReplicaBufferLimit,
+	}
+
+	server.users.Store(config.Users)
+
+	server.scramSecret = make([]byte, sha256.Size)
+	if _, err := rand.Read(server.scramSecret); err != nil {
+		panic(err)
+	}
+
+	server.storage = &readOnlyStorage{
+		storage:     server.journal,
+		replication: server.replication,
+	}
+
+	server.commands = map[string]command{
+		"GET":           getCommand{},
+		"SET":           setCommand{},
+		"SETNX":         setNXCommand{},
+		"GETSET":        getSetCommand{},
+		"GETS":          getsCommand{},
+		"CAS":           casCommand{},
+		"HGET":          hGetCommand{},
+		"HSET":          hSetCommand{},
+		"HGETALL":       hGetAllCommand{},
+		"DELETE":        deleteCommand{},
+		"KEYS":          keysCommand{},
+		"SCAN":          scanCommand{},
+		"HSCAN":         hScanCommand{},
+		"EXPIRE":        expireCommand{},
+		"EXPIREAT":      expireAtCommand{},
+		"TTL":           ttlCommand{},
+		"INCR":          incrCommand{1},
+		"DECR":          incrCommand{-1},
+		"INCRBY":        incrByCommand{},
+		"DECRBY":        incrByCommand{negative: true},
+		"HINCRBY":       hIncrByCommand{},
+		"LPUSH":         pushCommand{server.waiters, true},
+		"RPUSH":         pushCommand{server.waiters, false},
+		"LPOP":          popCommand{left: true},
+		"RPOP":          popCommand{},
+		"LRANGE":        lRangeCommand{},
+		"LLEN":          lLenCommand{},
+		"LTRIM":         lTrimCommand{},
+		"LINDEX":        lIndexCommand{},
+		"BLPOP":         blockingPopCommand{server.waiters, true},
+		"BRPOP":         blockingPopCommand{server.waiters, false},
+		"SADD":          sAddCommand{},
+		"SREM":          sRemCommand{},
+		"SISMEMBER":     sIsMemberCommand{},
+		"SMEMBERS":      sMembersCommand{},
+		"SCARD":         sCardCommand{},
+		"SPOP":          sPopCommand{},
+		"SRANDMEMBER":   sRandMemberCommand{},
+		"SINTER":        combineCommand{setInter},
+		"SUNION":        combineCommand{setUnion},
+		"SDIFF":         combineCommand{setDiff},
+		"SINTERSTORE":   combineStoreCommand{setInter},
+		"SUNIONSTORE":   combineStoreCommand{setUnion},
+		"SDIFFSTORE":    combineStoreCommand{setDiff},
+		"ZADD":          zAddCommand{},
+		"ZREM":          zRemCommand{},
+		"ZSCORE":        zScoreCommand{},
+		"ZRANK":         zRankCommand{},
+		"ZRANGE":        zRangeCommand{},
+		"ZRANGEBYSCORE": zRangeByScoreCommand{},
+		"ZINCRBY":       zIncrByCommand{},
+		"ZCARD":         zCardCommand{},
+		"ZPOPMIN":       zPopMinCommand{},
+		"SAVE":          saveCommand{server},
+		"BGSAVE":        bgSaveCommand{server},
+		"BGREWRITEAOF":  bgRewriteAOFCommand{server},
+		"PING":          pingCommand{},
+		"INFO":          infoCommand{server},
+		"REPLICAOF":     replicaOfCommand{server},
+		"CONFIG":        configCommand{server},
+		"PUBLISH":       publishCommand{server.pubsub},
+	}
+
+	if config.KeyspaceNotifications {
+		server.storage.Notify(server.publishEvent)
+	}
+
+	if config.ReplicaOf != "" {
+		server.ReplicaOf(config.ReplicaOf)
 	}
 
 	return server
 }
 
+// Serve accepts connections on listener. Clients can use either lodge protocol or RESP,
+// protocol is detected by first byte of connection.
 func (s *Server) Serve(l net.Listener) error {
-	s.l = l
+	return s.serve(l, s.handleConnection, rejection)
+}
+
+// ServeRESP accepts connections which use only RESP (Redis Serialization Protocol).
+func (s *Server) ServeRESP(l net.Listener) error {
+	return s.serve(l, s.handleRESPConnection, respRejection)
+}
+
+// rejection returns reply to connection rejected because of MaxClients in protocol detected by first byte.
+func rejection(conn net.Conn) []byte {
+	first, err := bufio.NewReader(conn).Peek(1)
+	if err == nil && first[0] == respArray {
+		return respRejection(conn)
+	}
+
+	return resultMaxClients
+}
+
+func respRejection(conn net.Conn) []byte {
+	return []byte("-ERR max number of clients reached\r\n")
+}
+
+// serve accepts connections and runs handler for each of them. Connections above MaxClients
+// receive reply returned by reject and are closed.
+func (s *Server) serve(l net.Listener, handler func(*connection), reject func(net.Conn) []byte) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+
+	if s.tls != nil {
+		l = tls.NewListener(l, s.tls)
+	}
 
 	for {
 		// Listen for an incoming connection.
-		conn, err := s.l.Accept()
+		conn, err := l.Accept()
 		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c := newConnectionWithLimits(conn, s.userList() == nil, s.limits, &s.stats)
+		switch err := s.track(c); err {
+		case ErrServerClosed:
+			conn.Close()
 			return err
+		case errMaxClients:
+			atomic.AddInt64(&s.stats.rejectedConnections, 1)
+			go func() {
+				conn.SetDeadline(time.Now().Add(rejectTimeout))
+				conn.Write(reject(conn))
+				conn.Close()
+			}()
+			continue
 		}
 
-		go s.handleConnection(&connection{
-			conn:          conn,
-			authenticated: s.users == nil,
-		})
+		go func() {
+			defer s.untrack(c)
+
+			if err := s.handshake(c); err != nil {
+				log.Printf("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			handler(c)
+		}()
+	}
+}
+
+// handshake completes TLS handshake and authenticates client by its certificate.
+func (s *Server) handshake(conn *connection) error {
+	tlsConn, ok := conn.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	// only verified certificates are trusted
+	chains := tlsConn.ConnectionState().VerifiedChains
+	if len(chains) > 0 && s.userList() != nil {
+		s.login(conn, chains[0][0].Subject.CommonName)
 	}
 
 	return nil
 }
 
+// userList returns current users, nil if authentication isn't required.
+func (s *Server) userList() *UserList {
+	users, _ := s.users.Load().(*UserList)
+	return users
+}
+
+// login authenticates connection as user. It fails if user isn't in current users, e.g. was removed
+// by ReloadUsers after password was validated.
+func (s *Server) login(conn *connection, user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.userList().Contains(user) {
+		return false
+	}
+
+	conn.authenticated = true
+	conn.user = user
+	return true
+}
+
+// track registers connection, so it can be closed on shutdown. It returns error if server is closed
+// or has too many connections.
+func (s *Server) track(conn *connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed:
+		return ErrServerClosed
+	case s.maxClients > 0 && len(s.conns) >= s.maxClients:
+		return errMaxClients
+	}
+
+	s.conns[conn] = struct{}{}
+	s.handlers.Add(1)
+	atomic.AddInt64(&s.stats.connections, 1)
+
+	return nil
+}
+
+func (s *Server) untrack(conn *connection) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	s.handlers.Done()
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// ListenAndServe listens on TCP address or on unix domain socket if address is unix:/path/to.sock.
+// The same applies to ListenAndServeRESP and ListenAndServeMemcached.
 func (s *Server) ListenAndServe(addr string) error {
-	l, err := net.Listen("tcp", addr)
+	l, err := s.listen(addr)
 	if err != nil {
 		return err
 	}
@@ -76,74 +422,329 @@ func (s *Server) ListenAndServe(addr string) error {
 	return s.Serve(l)
 }
 
+func (s *Server) ListenAndServeRESP(addr string) error {
+	l, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeRESP(l)
+}
+
+// Close immediately closes listeners and all connections. Commands which are being processed
+// can't send replies. Use Shutdown to let them finish.
 func (s *Server) Close() error {
-	return s.l.Close()
+	err := s.closeListeners()
+	s.closeConnections(false)
+
+	return err
+}
+
+// Shutdown gracefully stops server. It closes listeners, then closes connections as soon as they become
+// idle, i.e. have replied to all received requests, and waits for their handlers to return. Connections
+// which are still busy when ctx is done are closed forcibly and ctx error is returned.
+// Subscribers and replicas are closed at once, clients blocked by BLPOP and BRPOP get empty reply.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.closeListeners()
+	s.waiters.close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for !s.closeConnections(true) {
+		select {
+		case <-ctx.Done():
+			s.closeConnections(false)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	// handlers are untracked right before they return
+	s.handlers.Wait()
+
+	return err
+}
+
+// closeListeners stops accepting connections and replication from primary.
+func (s *Server) closeListeners() error {
+	s.replication.l.Lock()
+	s.replication.halt()
+	s.replication.l.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for timer := range s.flushTimers {
+		timer.Stop()
+	}
+	s.flushTimers = nil
+
+	var err error
+	for _, l := range s.listeners {
+		if closeErr := l.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// closeConnections closes open connections, only idle ones if idleOnly is set.
+// It returns true if there are no open connections left.
+func (s *Server) closeConnections(idleOnly bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		if !idleOnly || conn.isIdle() && conn.written() {
+			conn.conn.Close()
+		}
+	}
+
+	return len(s.conns) == 0
+}
+
+// Save writes snapshot of storage to configured path. It waits for running background save to finish.
+func (s *Server) Save() error {
+	if s.snapshotPath == "" {
+		return errNoSnapshot
+	}
+
+	s.saving <- struct{}{}
+	defer func() { <-s.saving }()
+
+	return SaveSnapshot(s.snapshotPath, s.storage)
+}
+
+// BackgroundSave starts writing snapshot in separate goroutine. It returns error if other save is in progress.
+func (s *Server) BackgroundSave() error {
+	if s.snapshotPath == "" {
+		return errNoSnapshot
+	}
+
+	select {
+	case s.saving <- struct{}{}:
+	default:
+		return errSaveInProgress
+	}
+
+	go func() {
+		defer func() { <-s.saving }()
+
+		start := time.Now()
+		if err := SaveSnapshot(s.snapshotPath, s.storage); err != nil {
+			log.Printf("Background save failed: %v", err)
+			return
+		}
+		log.Printf("Background save finished in %v", time.Since(start))
+	}()
+
+	return nil
+}
+
+// RewriteAppendOnlyFile starts rewriting append-only file in separate goroutine. It returns error
+// if append-only file isn't configured or other rewrite is in progress.
+func (s *Server) RewriteAppendOnlyFile() error {
+	if s.journal.aof == nil {
+		return errNoAppendOnly
+	}
+
+	select {
+	case s.rewriting <- struct{}{}:
+	default:
+		return errRewriteInProgress
+	}
+
+	go func() {
+		defer func() { <-s.rewriting }()
+
+		start := time.Now()
+		if err := s.journal.rewrite(); err != nil {
+			log.Printf("Append-only file rewrite failed: %v", err)
+			return
+		}
+		log.Printf("Append-only file rewrite finished in %v", time.Since(start))
+	}()
+
+	return nil
 }
 
 func (s *Server) handleConnection(conn *connection) {
+	if err := conn.waitRequest(); err != nil {
+		conn.Close()
+		return
+	}
+
+	if first, _ := conn.reader.Peek(1); first[0] == respArray {
+		s.handleRESPConnection(conn)
+		return
+	}
+
 	for {
-		request, err := Parse(conn)
+		if err := conn.waitRequest(); err != nil {
+			conn.Close()
+			break
+		}
+
+		request, err := conn.ReadRequest()
 		if err != nil {
 			conn.Close()
 			break
 		}
 
 		s.handleRequest(conn, request)
+
+		// replies are flushed when there are no more pipelined requests
+		if conn.Drained() {
+			if err := conn.Flush(); err != nil {
+				conn.Close()
+				break
+			}
+			conn.setIdle(true)
+		}
 	}
 }
 
 func (s *Server) handleRequest(conn *connection, request *request) {
-	// authentication checking
-	if request.command == "AUTH" {
-		if conn.authenticated {
-			conn.WriteOK()
+	// connection becomes replication stream
+	if request.command == "SYNC" {
+		if !conn.authenticated {
+			conn.Write(resultAuthRequired)
 			return
 		}
-
-		if len(request.arguments) != 2 {
-			conn.WriteError()
-		}
-		if s.users.Validate(request.arguments[0], request.arguments[1]) {
-			conn.WriteOK()
-			conn.authenticated = true
-		} else {
-			conn.Write(resultAuthRequired)
+		// replica receives every key
+		if err := s.authorize(conn, request.command, nil, true); err != nil {
+			writeReply(conn, nil, err)
+			return
 		}
+
+		s.serveReplica(conn)
+		return
+	}
+
+	if s.handleTransaction(conn, request) {
 		return
 	}
 
-	if cmd, ok := s.commands[request.command]; ok {
+	// connection switches to push mode
+	if request.command == "SUBSCRIBE" || request.command == "PSUBSCRIBE" {
 		if !conn.authenticated {
 			conn.Write(resultAuthRequired)
 			return
 		}
-		if len(request.arguments) != cmd.arguments() {
-			conn.WriteError()
+		if err := s.authorizeChannels(conn, request.command, request.arguments); err != nil {
+			writeReply(conn, nil, err)
 			return
 		}
 
-		values, err := cmd.process(request, s.storage)
-		if err != nil {
-			switch err {
-			case errNotFound:
-				conn.Write(resultNotFound)
-			case errBadFormat:
-				conn.Write(resultBadFormat)
-			default:
-				conn.WriteError()
-			}
+		s.serveSubscriber(conn, request, false)
+		return
+	}
 
-			return
-		}
+	values, err := s.execute(conn, request)
+	writeReply(conn, values, err)
+}
 
-		if len(values) == 0 {
-			conn.WriteOK()
-			return
+// writeReply writes result of command in lodge protocol.
+func writeReply(conn *connection, values []string, err error) {
+	if err != nil {
+		switch err {
+		case errNotFound:
+			conn.Write(resultNotFound)
+		case errBadFormat:
+			conn.Write(resultBadFormat)
+		case errBadLength:
+			// request data can't be skipped, so everything received so far is dropped
+			conn.reader.Discard(conn.reader.Buffered())
+			conn.Write(resultBadFormat)
+		case errReadOnly:
+			conn.Write(resultReadOnly)
+		case errAuthRequired:
+			conn.Write(resultAuthRequired)
+		case errWrongCommand:
+			conn.Write(resultWrongCommand)
+		case errNotInteger:
+			conn.Write(resultNotInteger)
+		case errNotFloat:
+			conn.Write(resultNotFloat)
+		case errOverflow:
+			conn.Write(resultOverflow)
+		case errWrongType:
+			conn.Write(resultWrongType)
+		case errNotStored:
+			conn.Write(resultNotStored)
+		case errConflict:
+			conn.Write(resultConflict)
+		case errNoPerm:
+			conn.Write(resultNoPerm)
+		default:
+			conn.WriteError()
 		}
 
-		conn.WriteValues(values...)
 		return
 	}
 
-	conn.Write(resultWrongCommand)
+	if len(values) == 0 {
+		conn.WriteOK()
+		return
+	}
+
+	conn.WriteValues(values...)
+}
+
+// execute checks authentication and runs command. It doesn't depend on protocol used by client.
+func (s *Server) execute(conn *connection, request *request) ([]string, error) {
+	// authentication checking
+	if request.command == "AUTH" {
+		if conn.authenticated {
+			return nil, nil
+		}
+
+		if len(request.arguments) != 2 {
+			return nil, errArguments
+		}
+		if !s.userList().Validate(request.arguments[0], request.arguments[1]) {
+			return nil, errAuthRequired
+		}
+		if !s.login(conn, request.arguments[0]) {
+			return nil, errAuthRequired
+		}
+
+		return nil, nil
+	}
+
+	if request.command == "SCRAM" {
+		return s.scram(conn, request.arguments)
+	}
+
+	if request.command == "ACL" {
+		return s.acl(conn, request.arguments)
+	}
+
+	cmd, ok := s.commands[request.command]
+	if !ok {
+		return nil, errWrongCommand
+	}
+
+	if !conn.authenticated {
+		return nil, errAuthRequired
+	}
+	if !validArguments(cmd, len(request.arguments)) {
+		return nil, errArguments
+	}
+	if !validArgumentValues(request.arguments) {
+		return nil, errBadArgument
+	}
+	if err := s.authorizeRequest(conn, cmd, request); err != nil {
+		return nil, err
+	}
+
+	// replies to previous requests are sent before connection is blocked
+	if _, ok := cmd.(blockingPopCommand); ok {
+		conn.Flush()
+	}
+
+	return cmd.process(request, s.storage)
 }
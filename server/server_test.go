@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"time"
@@ -40,13 +45,17 @@ func (c *testClient) assertRequest(t *testing.T, request []byte, expected []byte
 }
 
 func testServer(t *testing.T) (*testClient, io.Closer) {
+	return testServerWithConfig(t, DefaultConfig())
+}
+
+func testServerWithConfig(t *testing.T, config *Config) (*testClient, io.Closer) {
 	l, conn := testutil.NextListener(t)
 
 	storage := NewBucketStorage(10, func() Storage {
 		return NewLRUStorage(lru.New(1000))
 	})
 
-	server := New(storage, DefaultConfig())
+	server := New(storage, config)
 	go server.Serve(l)
 
 	return &testClient{connection: conn}, server
@@ -97,3 +106,403 @@ func TestHSetHGet(t *testing.T) {
 	client.assertRequest(t, []byte("HGET foo key1\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
 	client.assertRequest(t, []byte("HGET foo key2\r\n"), []byte("NOT_FOUND\r\n"))
 }
+
+func TestIncr(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("INCR foo\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("INCRBY foo 10\r\n"), []byte("VALUES\r\n1\r\n2\r\n11"))
+	client.assertRequest(t, []byte("DECRBY foo 12\r\n"), []byte("VALUES\r\n1\r\n2\r\n-1"))
+	client.assertRequest(t, []byte("DECR foo\r\n"), []byte("VALUES\r\n1\r\n2\r\n-2"))
+	client.assertRequest(t, []byte("HINCRBY hash field 3\r\n"), []byte("VALUES\r\n1\r\n1\r\n3"))
+	client.assertRequest(t, []byte("INCRBY foo x\r\n"), resultNotInteger)
+	client.assertRequest(t, []byte("SET bar 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("INCR bar\r\n"), resultNotInteger)
+	client.assertRequest(t, []byte("SET max 0 19\r\n9223372036854775807\r\n"), resultOK)
+	client.assertRequest(t, []byte("INCR max\r\n"), resultOverflow)
+}
+
+func TestConditionalSet(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SET foo 0 XX 3\r\nbar\r\n"), resultNotStored)
+	client.assertRequest(t, []byte("SET foo 0 NX 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("SET foo 0 NX 3\r\nbaz\r\n"), resultNotStored)
+	client.assertRequest(t, []byte("SET foo 0 XY 3\r\nbaz\r\n"), resultBadFormat)
+	client.assertRequest(t, []byte("SETNX foo 3\r\nbaz\r\n"), []byte("VALUES\r\n1\r\n1\r\n0"))
+	client.assertRequest(t, []byte("SETNX new 3\r\nbaz\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("GETSET foo 3\r\nqux\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
+	client.assertRequest(t, []byte("GETSET missing 3\r\nqux\r\n"), resultNotFound)
+	client.assertRequest(t, []byte("GET missing\r\n"), []byte("VALUES\r\n1\r\n3\r\nqux"))
+
+	client.assertRequest(t, []byte("CAS absent 1 0 1\r\nx\r\n"), resultNotFound)
+	client.assertRequest(t, []byte("CAS foo x 0 1\r\nx\r\n"), resultBadFormat)
+
+	reply := client.send(t, []byte("GETS foo\r\n"), 0)
+	prefix := "VALUES\r\n2\r\n3\r\nqux"
+	if !strings.HasPrefix(string(reply), prefix) {
+		t.Fatalf("Expected value with token. Got: %q", reply)
+	}
+	token := strings.SplitN(strings.TrimPrefix(string(reply), prefix), "\r\n", 2)[1]
+
+	client.assertRequest(t, []byte("CAS foo "+token+" 0 1\r\nx\r\n"), resultOK)
+	client.assertRequest(t, []byte("CAS foo "+token+" 0 1\r\ny\r\n"), resultConflict)
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n1\r\nx"))
+}
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DefaultConfig()
+	config.SnapshotPath = filepath.Join(dir, "dump.lodge")
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("SAVE\r\n"), resultOK)
+
+	storage := NewMemory(time.Minute)
+	if err := LoadSnapshot(config.SnapshotPath, storage); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertStorageValue(t, storage, "foo", "bar")
+}
+
+func TestSaveWithoutPath(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SAVE\r\n"), resultError)
+	client.assertRequest(t, []byte("BGSAVE\r\n"), resultError)
+}
+
+// testShutdownServer returns client connected to server which is served in background,
+// error returned by Serve is sent to channel.
+func testShutdownServer(t *testing.T) (*testClient, *Server, chan error) {
+	l, conn := testutil.NextListener(t)
+
+	server := New(NewMemory(time.Minute), DefaultConfig())
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	return &testClient{connection: conn}, server, served
+}
+
+// assertClosed checks that server closed connection after sending expected data.
+func assertClosed(t *testing.T, conn net.Conn, expected string) {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to be closed. Got error: %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Expected: %q. Got: %q", expected, data)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	client, server, served := testShutdownServer(t)
+	defer client.connection.Close()
+
+	idle, err := net.Dial("tcp", client.connection.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+
+	// request is in flight until its value is received
+	client.connection.Write([]byte("SET foo 0 3\r\nb"))
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	assertClosed(t, idle, "")
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Expected shutdown to wait for in-flight request. Got: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	client.connection.Write([]byte("ar\r\n"))
+	assertClosed(t, client.connection, "OK\r\n")
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("Expected: %v. Got: %v", ErrServerClosed, err)
+	}
+	assertStorageValue(t, server.storage, "foo", "bar")
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	client, server, _ := testShutdownServer(t)
+	defer client.connection.Close()
+
+	client.connection.Write([]byte("SET foo 0 3\r\nb"))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected: %v. Got: %v", context.DeadlineExceeded, err)
+	}
+	assertClosed(t, client.connection, "")
+}
+
+func TestShutdownBlockingPop(t *testing.T) {
+	client, server, _ := testShutdownServer(t)
+	defer client.connection.Close()
+
+	client.connection.Write([]byte("BLPOP list 0\r\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertClosed(t, client.connection, "NOT_FOUND\r\n")
+}
+
+func TestIdleTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.IdleTimeout = 100 * time.Millisecond
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	assertClosed(t, client.connection, "")
+}
+
+func TestRequestTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.Timeout = 100 * time.Millisecond
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	// client stalls in the middle of data
+	client.connection.Write([]byte("SET foo 0 3\r\nb"))
+	assertClosed(t, client.connection, "")
+}
+
+func TestReadTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.Timeout = 0
+	config.ReadTimeout = 200 * time.Millisecond
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	// request is received slowly, but every read is in time
+	for _, part := range []string{"SET foo", " 0 3\r\n", "b", "ar\r\n"} {
+		client.connection.Write([]byte(part))
+		time.Sleep(20 * time.Millisecond)
+	}
+	assertReceived(t, client.connection, "OK\r\n")
+
+	client.connection.Write([]byte("GET"))
+	assertClosed(t, client.connection, "")
+}
+
+func TestWriteTimeout(t *testing.T) {
+	l, conn := testutil.NextListener(t)
+	defer conn.Close()
+
+	config := DefaultConfig()
+	config.WriteTimeout = 100 * time.Millisecond
+
+	server := New(NewMemory(time.Minute), config)
+	go server.Serve(l)
+	defer server.Close()
+
+	value := strings.Repeat("x", 1024*1024)
+	(&testClient{connection: conn}).assertRequest(t, []byte("SET foo 0 1048576\r\n"+value+"\r\n"), resultOK)
+
+	// replies aren't read, so server blocks on writing them until write timeout closes connection
+	// and requests can't be written anymore
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, err := conn.Write([]byte("GET foo\r\n")); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected connection to be closed by write timeout")
+	}
+}
+
+func TestSubscriberIdleTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.IdleTimeout = 50 * time.Millisecond
+
+	sub, publisher, server := testPubSubServer(t, config)
+	defer server.Close()
+	defer sub.Close()
+
+	sub.Write([]byte("SUBSCRIBE news\r\n"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n9\r\nsubscribe4\r\nnews1\r\n1")
+
+	time.Sleep(150 * time.Millisecond)
+
+	// publisher is idle as well, so it connects again
+	publisher.connection.Close()
+	conn, err := net.Dial("tcp", sub.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher = &testClient{connection: conn}
+	defer conn.Close()
+
+	publisher.assertRequest(t, []byte("PUBLISH news 5\r\nhello\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	assertReceived(t, sub, "VALUES\r\n3\r\n7\r\nmessage4\r\nnews5\r\nhello")
+}
+
+func TestMaxClients(t *testing.T) {
+	config := DefaultConfig()
+	// listener is checked by testutil with connection which is never closed
+	config.MaxClients = 2
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+
+	addr := client.connection.RemoteAddr().String()
+	for _, test := range []struct {
+		request  string
+		expected string
+	}{
+		{"GET foo\r\n", "MAX_CLIENTS\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nfoo\r\n", "-ERR max number of clients reached\r\n"},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(test.request))
+		assertClosed(t, conn, test.expected)
+		conn.Close()
+	}
+
+	info := client.send(t, []byte("INFO\r\n"), 0)
+	for _, expected := range []string{"connected_clients:2", "rejected_connections:2"} {
+		if !strings.Contains(string(info), expected) {
+			t.Fatalf("Expected %s in %s", expected, info)
+		}
+	}
+
+	// slot is released when client disconnects
+	client.connection.Close()
+	for i := 0; ; i++ {
+		if i == 50 {
+			t.Fatalf("Expected connection to be accepted")
+		}
+
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply := (&testClient{connection: conn}).send(t, []byte("GET foo\r\n"), 0)
+		conn.Close()
+
+		if string(reply) == "VALUES\r\n1\r\n3\r\nbar" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+
+	config := DefaultConfig()
+	config.OutputBufferLimit = 64 * 1024
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	value := strings.Repeat("x", 16*1024)
+	client.assertRequest(t, []byte("SET foo 0 16384\r\n"+value+"\r\n"), resultOK)
+
+	// client sends requests one by one and doesn't read replies, so they outgrow socket buffers
+	for i := 0; i < 1000; i++ {
+		if _, err := client.connection.Write([]byte("GET foo\r\n")); err != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; !strings.Contains(string(testInfo(t, client)), "output_buffer_disconnections:1"); i++ {
+		if i == 100 {
+			t.Fatalf("Expected client to be disconnected")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	client.connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ioutil.ReadAll(client.connection); isTimeout(err) {
+		t.Fatalf("Expected connection to be closed. Got: %v", err)
+	}
+}
+
+func TestOutputBufferLimitPipelining(t *testing.T) {
+	config := DefaultConfig()
+	config.OutputBufferLimit = 64 * 1024
+
+	client, closer := testServerWithConfig(t, config)
+	defer closer.Close()
+
+	value := strings.Repeat("x", 16*1024)
+	reply := "VALUES\r\n1\r\n16384\r\n" + value
+	client.assertRequest(t, []byte("SET foo 0 16384\r\n"+value+"\r\n"), resultOK)
+
+	// replies to pipelined requests exceed limit, but client reads them
+	client.connection.Write([]byte(strings.Repeat("GET foo\r\n", 200)))
+
+	client.connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data := make([]byte, 200*len(reply))
+	if _, err := io.ReadFull(client.connection, data); err != nil {
+		t.Fatalf("Expected all replies. Got error: %v", err)
+	}
+	if string(data) != strings.Repeat(reply, 200) {
+		t.Fatalf("Expected %d replies to GET", 200)
+	}
+}
+
+// testInfo returns reply to INFO sent by new connection to server of client.
+func testInfo(t *testing.T, client *testClient) []byte {
+	conn, err := net.Dial("tcp", client.connection.RemoteAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	return (&testClient{connection: conn}).send(t, []byte("INFO\r\n"), 0)
+}
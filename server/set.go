@@ -0,0 +1,102 @@
+package server
+
+import "math/rand"
+
+// Sets are stored as map[string]struct{}. Storages implement set commands on top of these helpers,
+// empty sets are removed from storage.
+
+type setOperation int
+
+const (
+	setInter setOperation = iota
+	setUnion
+	setDiff
+)
+
+// addMembers adds members to set and returns number of added members.
+func addMembers(set map[string]struct{}, members []string) int {
+	added := 0
+	for _, member := range members {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			added++
+		}
+	}
+
+	return added
+}
+
+// removeMembers removes members from set and returns number of removed members.
+func removeMembers(set map[string]struct{}, members []string) int {
+	removed := 0
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+func setMembers(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+
+	return members
+}
+
+// randomMember returns random member of non-empty set.
+func randomMember(set map[string]struct{}) string {
+	n := rand.Intn(len(set))
+	for member := range set {
+		if n == 0 {
+			return member
+		}
+		n--
+	}
+
+	return ""
+}
+
+// combineSets returns intersection, union or difference of sets. Difference is members of
+// the first set which aren't members of other sets. Sets aren't modified.
+func combineSets(op setOperation, sets []map[string]struct{}) map[string]struct{} {
+	result := make(map[string]struct{})
+	if len(sets) == 0 {
+		return result
+	}
+
+	switch op {
+	case setUnion:
+		for _, set := range sets {
+			for member := range set {
+				result[member] = struct{}{}
+			}
+		}
+	case setInter:
+	members:
+		for member := range sets[0] {
+			for _, set := range sets[1:] {
+				if _, ok := set[member]; !ok {
+					continue members
+				}
+			}
+			result[member] = struct{}{}
+		}
+	case setDiff:
+	diff:
+		for member := range sets[0] {
+			for _, set := range sets[1:] {
+				if _, ok := set[member]; ok {
+					continue diff
+				}
+			}
+			result[member] = struct{}{}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,191 @@
+package server
+
+import "strconv"
+
+// sAddCommand is SADD key length [length ...] followed by data blocks.
+type sAddCommand struct{}
+
+func (c sAddCommand) arguments() int {
+	return -2
+}
+
+func (c sAddCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c sAddCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := s.SAdd(r.arguments[0], members...)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(added)}, nil
+}
+
+// sRemCommand is SREM key length [length ...] followed by data blocks.
+type sRemCommand struct{}
+
+func (c sRemCommand) arguments() int {
+	return -2
+}
+
+func (c sRemCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c sRemCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := s.SRem(r.arguments[0], members...)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(removed)}, nil
+}
+
+// sIsMemberCommand is SISMEMBER key length followed by data block. It replies 1 or 0.
+type sIsMemberCommand struct{}
+
+func (c sIsMemberCommand) arguments() int {
+	return 2
+}
+
+func (c sIsMemberCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c sIsMemberCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := s.SIsMember(r.arguments[0], members[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		return []string{"1"}, nil
+	}
+
+	return []string{"0"}, nil
+}
+
+type sMembersCommand struct{}
+
+func (c sMembersCommand) arguments() int {
+	return 1
+}
+
+func (c sMembersCommand) process(r *request, s Storage) ([]string, error) {
+	return s.SMembers(r.arguments[0])
+}
+
+type sCardCommand struct{}
+
+func (c sCardCommand) arguments() int {
+	return 1
+}
+
+func (c sCardCommand) process(r *request, s Storage) ([]string, error) {
+	n, err := s.SCard(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(n)}, nil
+}
+
+type sPopCommand struct{}
+
+func (c sPopCommand) arguments() int {
+	return 1
+}
+
+func (c sPopCommand) process(r *request, s Storage) ([]string, error) {
+	member, err := s.SPop(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{member}, nil
+}
+
+type sRandMemberCommand struct{}
+
+func (c sRandMemberCommand) arguments() int {
+	return 1
+}
+
+func (c sRandMemberCommand) process(r *request, s Storage) ([]string, error) {
+	member, err := s.SRandMember(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{member}, nil
+}
+
+// combineCommand is SINTER, SUNION or SDIFF: COMBINE key [key ...].
+type combineCommand struct {
+	op setOperation
+}
+
+func (c combineCommand) arguments() int {
+	return -1
+}
+
+func (c combineCommand) process(r *request, s Storage) ([]string, error) {
+	return combine(s, c.op, r.arguments...)
+}
+
+func combine(s Storage, op setOperation, keys ...string) ([]string, error) {
+	switch op {
+	case setInter:
+		return s.SInter(keys...)
+	case setUnion:
+		return s.SUnion(keys...)
+	default:
+		return s.SDiff(keys...)
+	}
+}
+
+// combineStoreCommand is SINTERSTORE, SUNIONSTORE or SDIFFSTORE: STORE dest key [key ...].
+// It replies with size of result.
+type combineStoreCommand struct {
+	op setOperation
+}
+
+func (c combineStoreCommand) arguments() int {
+	return -2
+}
+
+func (c combineStoreCommand) process(r *request, s Storage) ([]string, error) {
+	n, err := combineStore(s, c.op, r.arguments[0], r.arguments[1:]...)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(n)}, nil
+}
+
+func combineStore(s Storage, op setOperation, dest string, keys ...string) (int, error) {
+	switch op {
+	case setInter:
+		return s.SInterStore(dest, keys...)
+	case setUnion:
+		return s.SUnionStore(dest, keys...)
+	default:
+		return s.SDiffStore(dest, keys...)
+	}
+}
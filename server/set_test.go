@@ -0,0 +1,182 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCombineSets(t *testing.T) {
+	sets := []map[string]struct{}{
+		{"a": {}, "b": {}, "c": {}},
+		{"b": {}, "c": {}, "d": {}},
+		nil,
+	}
+
+	cases := []struct {
+		op       setOperation
+		sets     []map[string]struct{}
+		expected []string
+	}{
+		{setInter, sets[:2], []string{"b", "c"}},
+		{setInter, sets, []string{}},
+		{setUnion, sets, []string{"a", "b", "c", "d"}},
+		{setDiff, sets, []string{"a"}},
+		{setDiff, sets[2:], []string{}},
+		{setUnion, nil, []string{}},
+	}
+
+	for _, tc := range cases {
+		members := setMembers(combineSets(tc.op, tc.sets))
+		sort.Strings(members)
+		if !reflect.DeepEqual(members, tc.expected) {
+			t.Fatalf("Expected: %v. Got: %v", tc.expected, members)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		if n, err := s.SAdd("set", "a", "b", "a"); err != nil || n != 2 {
+			t.Fatalf("%s: expected 2. Got: %d, %v", name, n, err)
+		}
+		if n, err := s.SAdd("set", "b", "c"); err != nil || n != 1 {
+			t.Fatalf("%s: expected 1. Got: %d, %v", name, n, err)
+		}
+		assertSet(t, s, "set", []string{"a", "b", "c"})
+
+		if found, err := s.SIsMember("set", "b"); err != nil || !found {
+			t.Fatalf("%s: expected b to be member. Got: %v, %v", name, found, err)
+		}
+		if found, err := s.SIsMember("set", "x"); err != nil || found {
+			t.Fatalf("%s: expected x not to be member. Got: %v, %v", name, found, err)
+		}
+		if n, err := s.SRem("set", "a", "x"); err != nil || n != 1 {
+			t.Fatalf("%s: expected 1. Got: %d, %v", name, n, err)
+		}
+		if n, err := s.SCard("set"); err != nil || n != 2 {
+			t.Fatalf("%s: expected 2. Got: %d, %v", name, n, err)
+		}
+		if member, err := s.SRandMember("set"); err != nil || (member != "b" && member != "c") {
+			t.Fatalf("%s: expected b or c. Got: %v, %v", name, member, err)
+		}
+
+		// empty set is removed
+		s.SPop("set")
+		s.SPop("set")
+		if _, err := s.TTL("set"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if _, err := s.SPop("set"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if _, err := s.SRandMember("set"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if n, err := s.SCard("set"); err != nil || n != 0 {
+			t.Fatalf("%s: expected 0. Got: %d, %v", name, n, err)
+		}
+
+		s.Set("string", "value", 0)
+		if _, err := s.SAdd("string", "a"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+		if _, err := s.SMembers("string"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+		s.SAdd("set", "a")
+		if _, err := s.Get("set"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestSetAlgebra(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		// keys are spread over buckets of bucket storage
+		s.SAdd("set1", "a", "b", "c")
+		s.SAdd("set2", "b", "c", "d")
+		s.SAdd("set3", "c", "e")
+
+		members, err := s.SInter("set1", "set2", "set3")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		assertMembers(t, members, []string{"c"})
+
+		members, _ = s.SUnion("set1", "set3", "missing")
+		assertMembers(t, members, []string{"a", "b", "c", "e"})
+
+		members, _ = s.SDiff("set1", "set2", "missing")
+		assertMembers(t, members, []string{"a"})
+
+		members, _ = s.SInter("set1", "missing")
+		assertMembers(t, members, []string{})
+
+		if n, err := s.SUnionStore("dest", "set1", "set2"); err != nil || n != 4 {
+			t.Fatalf("%s: expected 4. Got: %d, %v", name, n, err)
+		}
+		assertSet(t, s, "dest", []string{"a", "b", "c", "d"})
+
+		// STORE variants replace destination, source can be destination
+		if n, err := s.SDiffStore("dest", "dest", "set1"); err != nil || n != 1 {
+			t.Fatalf("%s: expected 1. Got: %d, %v", name, n, err)
+		}
+		assertSet(t, s, "dest", []string{"d"})
+
+		s.Set("string", "value", 0)
+		if n, err := s.SInterStore("string", "set1", "missing"); err != nil || n != 0 {
+			t.Fatalf("%s: expected 0. Got: %d, %v", name, n, err)
+		}
+		if _, err := s.Get("string"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+
+		s.Set("string", "value", 0)
+		if _, err := s.SUnion("set1", "string"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("SADD set 1 2 1\r\na\r\nbc\r\na\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+	client.assertRequest(t, []byte("SISMEMBER set 2\r\nbc\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("SISMEMBER set 1\r\nx\r\n"), []byte("VALUES\r\n1\r\n1\r\n0"))
+	client.assertRequest(t, []byte("SCARD set\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+	client.assertRequest(t, []byte("SREM set 1\r\na\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("SMEMBERS set\r\n"), []byte("VALUES\r\n1\r\n2\r\nbc"))
+	client.assertRequest(t, []byte("SADD other 2\r\nbc\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("SINTER set other\r\n"), []byte("VALUES\r\n1\r\n2\r\nbc"))
+	client.assertRequest(t, []byte("SDIFF set other\r\n"), resultOK)
+	client.assertRequest(t, []byte("SUNIONSTORE dest set other\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("SRANDMEMBER dest\r\n"), []byte("VALUES\r\n1\r\n2\r\nbc"))
+	client.assertRequest(t, []byte("SPOP dest\r\n"), []byte("VALUES\r\n1\r\n2\r\nbc"))
+	client.assertRequest(t, []byte("SPOP dest\r\n"), resultNotFound)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("SADD foo 1\r\na\r\n"), resultWrongType)
+}
+
+func assertSet(t *testing.T, s Storage, key string, expected []string) {
+	members, err := s.SMembers(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	assertMembers(t, members, expected)
+}
+
+func assertMembers(t *testing.T, members []string, expected []string) {
+	members = append([]string{}, members...)
+	sort.Strings(members)
+	if !reflect.DeepEqual(members, expected) {
+		t.Fatalf("Expected: %v. Got: %v", expected, members)
+	}
+}
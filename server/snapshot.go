@@ -0,0 +1,461 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Snapshot file layout:
+//
+//	"LODGE" version(1 byte) created-at(8 bytes, unix time)
+//	records: kind(1 byte) key ttl value
+//	kindEOF(1 byte) crc32 of all preceding bytes (4 bytes)
+//
+// Strings are written as uvarint length followed by bytes, ttl is uvarint number of seconds
+// left at the moment of creation (0 means that element never expires).
+// Hash value is uvarint number of fields followed by field and value strings.
+// List value is uvarint number of elements followed by element strings.
+const (
+	snapshotMagic   = "LODGE"
+	snapshotVersion = 1
+
+	kindEOF    byte = 0
+	kindString byte = 's'
+	kindHash   byte = 'h'
+	kindList   byte = 'l'
+	kindSet    byte = 'S'
+	kindZSet   byte = 'z'
+
+	// snapshotChunk is size of data allocated at once, larger data is read in growing buffer.
+	snapshotChunk = 64 * 1024
+)
+
+var (
+	errBadSnapshot    = errors.New("Bad snapshot")
+	errSaveInProgress = errors.New("Save already in progress")
+	errNoSnapshot     = errors.New("Snapshot path is not configured")
+)
+
+// WriteSnapshot writes all elements of storage to w.
+func WriteSnapshot(w io.Writer, s Storage) error {
+	sw := newSnapshotWriter(w)
+
+	sw.writeHeader(time.Now())
+	err := s.Dump(func(e Entry) error {
+		sw.writeEntry(e)
+
+		return sw.err
+	})
+	if err != nil {
+		return err
+	}
+
+	return sw.close()
+}
+
+// ReadSnapshot restores elements from snapshot into storage. Elements which have expired
+// since snapshot creation are skipped. Nothing is restored unless checksum of the whole snapshot
+// matches, so corrupted snapshot doesn't leave storage partially loaded.
+func ReadSnapshot(r io.Reader, s Storage) error {
+	sr := newSnapshotReader(r)
+
+	created, err := sr.readHeader()
+	if err != nil {
+		return err
+	}
+
+	var entries []*Entry
+	for {
+		e, err := sr.readEntry()
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			break
+		}
+		entries = append(entries, e)
+	}
+	if err := sr.verify(); err != nil {
+		return err
+	}
+
+	elapsed := time.Now().Unix() - created
+	for _, e := range entries {
+		if e.TTL != 0 {
+			if e.TTL <= elapsed {
+				continue
+			}
+			e.TTL -= elapsed
+		}
+
+		if err := s.Restore(*e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SaveSnapshot writes snapshot to temporary file and then renames it to path,
+// so file at path always contains complete snapshot.
+func SaveSnapshot(path string, s Storage) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = WriteSnapshot(f, s)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot restores storage from snapshot file.
+func LoadSnapshot(path string, s Storage) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ReadSnapshot(f, s)
+}
+
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	crc := crc32.NewIEEE()
+
+	return &snapshotWriter{
+		w:   bufio.NewWriter(io.MultiWriter(w, crc)),
+		crc: crc,
+	}
+}
+
+func (w *snapshotWriter) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+
+	_, w.err = w.w.Write(b)
+}
+
+func (w *snapshotWriter) writeUvarint(v uint64) {
+	n := binary.PutUvarint(w.buf[:], v)
+	w.write(w.buf[:n])
+}
+
+func (w *snapshotWriter) writeString(s string) {
+	w.writeUvarint(uint64(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *snapshotWriter) writeHeader(created time.Time) {
+	w.write([]byte(snapshotMagic))
+	w.write([]byte{snapshotVersion})
+	binary.BigEndian.PutUint64(w.buf[:], uint64(created.Unix()))
+	w.write(w.buf[:8])
+}
+
+func (w *snapshotWriter) writeEntry(e Entry) {
+	switch value := e.Value.(type) {
+	case string:
+		w.write([]byte{kindString})
+		w.writeString(e.Key)
+		w.writeUvarint(uint64(e.TTL))
+		w.writeString(value)
+	case map[string]string:
+		w.write([]byte{kindHash})
+		w.writeString(e.Key)
+		w.writeUvarint(uint64(e.TTL))
+		w.writeUvarint(uint64(len(value)))
+		for field, v := range value {
+			w.writeString(field)
+			w.writeString(v)
+		}
+	case []string:
+		w.write([]byte{kindList})
+		w.writeString(e.Key)
+		w.writeUvarint(uint64(e.TTL))
+		w.writeUvarint(uint64(len(value)))
+		for _, v := range value {
+			w.writeString(v)
+		}
+	case map[string]struct{}:
+		w.write([]byte{kindSet})
+		w.writeString(e.Key)
+		w.writeUvarint(uint64(e.TTL))
+		w.writeUvarint(uint64(len(value)))
+		for member := range value {
+			w.writeString(member)
+		}
+	case *zset:
+		w.write([]byte{kindZSet})
+		w.writeString(e.Key)
+		w.writeUvarint(uint64(e.TTL))
+		w.writeUvarint(uint64(value.len()))
+		for member, score := range value.scores {
+			w.writeString(member)
+			binary.BigEndian.PutUint64(w.buf[:], math.Float64bits(score))
+			w.write(w.buf[:8])
+		}
+	default:
+		w.err = errWrongType
+	}
+}
+
+// close writes trailer and flushes buffered data.
+func (w *snapshotWriter) close() error {
+	w.write([]byte{kindEOF})
+	if w.err != nil {
+		return w.err
+	}
+	// crc must be calculated only for data written before trailer
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(w.buf[:], w.crc.Sum32())
+	w.write(w.buf[:4])
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.w.Flush()
+}
+
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func newSnapshotReader(r io.Reader) *snapshotReader {
+	return &snapshotReader{
+		r:   bufio.NewReader(r),
+		crc: crc32.NewIEEE(),
+	}
+}
+
+// read reads n bytes. Buffer for long data grows while data is read, so corrupted length can't cause
+// allocation larger than the rest of snapshot.
+func (r *snapshotReader) read(n int) ([]byte, error) {
+	var b []byte
+	if n <= snapshotChunk {
+		b = make([]byte, n)
+		if _, err := io.ReadFull(r.r, b); err != nil {
+			return nil, errBadSnapshot
+		}
+	} else {
+		buf := bytes.NewBuffer(make([]byte, 0, snapshotChunk))
+		if _, err := io.CopyN(buf, r.r, int64(n)); err != nil {
+			return nil, errBadSnapshot
+		}
+		b = buf.Bytes()
+	}
+	r.crc.Write(b)
+
+	return b, nil
+}
+
+func (r *snapshotReader) readByte() (byte, error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+func (r *snapshotReader) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(byteReaderFunc(r.readByte))
+}
+
+func (r *snapshotReader) readString() (string, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return "", err
+	}
+	if n > math.MaxInt {
+		return "", errBadSnapshot
+	}
+
+	b, err := r.read(int(n))
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// readScore reads score of sorted set member stored as 8 bytes of float64.
+func (r *snapshotReader) readScore() (float64, error) {
+	b, err := r.read(8)
+	if err != nil {
+		return 0, err
+	}
+
+	score := math.Float64frombits(binary.BigEndian.Uint64(b))
+	if math.IsNaN(score) {
+		return 0, errBadSnapshot
+	}
+
+	return score, nil
+}
+
+func (r *snapshotReader) readHeader() (int64, error) {
+	header, err := r.read(len(snapshotMagic) + 1 + 8)
+	if err != nil {
+		return 0, err
+	}
+
+	if string(header[:len(snapshotMagic)]) != snapshotMagic || header[len(snapshotMagic)] != snapshotVersion {
+		return 0, errBadSnapshot
+	}
+
+	return int64(binary.BigEndian.Uint64(header[len(snapshotMagic)+1:])), nil
+}
+
+// readEntry reads next entry. It returns nil entry when there are no more entries.
+func (r *snapshotReader) readEntry() (*Entry, error) {
+	kind, err := r.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if kind == kindEOF {
+		return nil, nil
+	}
+
+	e := &Entry{}
+	if e.Key, err = r.readString(); err != nil {
+		return nil, err
+	}
+	ttl, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	e.TTL = int64(ttl)
+
+	switch kind {
+	case kindString:
+		if e.Value, err = r.readString(); err != nil {
+			return nil, err
+		}
+	case kindHash:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		hash := make(map[string]string)
+		for i := uint64(0); i < n; i++ {
+			field, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			if hash[field], err = r.readString(); err != nil {
+				return nil, err
+			}
+		}
+		e.Value = hash
+	case kindList:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		list := make([]string, 0)
+		for i := uint64(0); i < n; i++ {
+			v, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		e.Value = list
+	case kindSet:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		set := make(map[string]struct{})
+		for i := uint64(0); i < n; i++ {
+			member, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			set[member] = struct{}{}
+		}
+		e.Value = set
+	case kindZSet:
+		n, err := r.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+
+		z := newZSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := r.readString()
+			if err != nil {
+				return nil, err
+			}
+			score, err := r.readScore()
+			if err != nil {
+				return nil, err
+			}
+			z.add(member, score)
+		}
+		e.Value = z
+	default:
+		return nil, errBadSnapshot
+	}
+
+	return e, nil
+}
+
+// verify checks that checksum from trailer matches data.
+func (r *snapshotReader) verify() error {
+	sum := r.crc.Sum32()
+
+	trailer := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, trailer); err != nil {
+		return errBadSnapshot
+	}
+
+	if binary.BigEndian.Uint32(trailer) != sum {
+		return errBadSnapshot
+	}
+
+	return nil
+}
+
+// byteReaderFunc adapts function to io.ByteReader.
+type byteReaderFunc func() (byte, error)
+
+func (f byteReaderFunc) ReadByte() (byte, error) {
+	return f()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	for name, factory := range testStorages() {
+		source := factory()
+		source.Set("foo", "bar", 0)
+		source.Set("expiring", "value", 100)
+		source.HSet("hash", "field1", "value1")
+		source.HSet("hash", "field2", "com plex\r\nvalue")
+		source.RPush("list", "a", "", "c")
+		source.SAdd("set", "x", "y\r\n")
+		source.ZAdd("board", ZMember{"b", 0.1}, ZMember{"a", -1}, ZMember{"c", math.Inf(1)})
+
+		buf := &bytes.Buffer{}
+		if err := WriteSnapshot(buf, source); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		target := factory()
+		if err := ReadSnapshot(buf, target); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		assertStorageValue(t, target, "foo", "bar")
+		assertStorageValue(t, target, "expiring", "value")
+
+		hash, err := target.HGetAll("hash")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		expected := map[string]string{"field1": "value1", "field2": "com plex\r\nvalue"}
+		if !reflect.DeepEqual(hash, expected) {
+			t.Fatalf("%s: expected: %v. Got: %v", name, expected, hash)
+		}
+
+		if list, _ := target.LRange("list", 0, -1); !reflect.DeepEqual(list, []string{"a", "", "c"}) {
+			t.Fatalf("%s: expected: [a  c]. Got: %v", name, list)
+		}
+
+		assertSet(t, target, "set", []string{"x", "y\r\n"})
+		assertZRange(t, target, "board", []ZMember{{"a", -1}, {"b", 0.1}, {"c", math.Inf(1)}})
+
+		target.Dump(func(e Entry) error {
+			if e.Key == "expiring" && (e.TTL <= 0 || e.TTL > 100) {
+				t.Fatalf("%s: expected ttl in (0, 100]. Got: %v", name, e.TTL)
+			}
+			if e.Key == "foo" && e.TTL != 0 {
+				t.Fatalf("%s: expected no ttl. Got: %v", name, e.TTL)
+			}
+			return nil
+		})
+	}
+}
+
+func TestSnapshotSkipsExpired(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := newSnapshotWriter(buf)
+	w.writeHeader(time.Now().Add(-10 * time.Second))
+	w.writeEntry(Entry{Key: "expired", Value: "value", TTL: 5})
+	w.writeEntry(Entry{Key: "alive", Value: "value", TTL: 100})
+	if err := w.close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	storage := NewMemory(time.Minute)
+	if err := ReadSnapshot(buf, storage); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, err := storage.Get("expired"); err != errNotFound {
+		t.Fatalf("Expected errNotFound. Got: %v", err)
+	}
+	assertStorageValue(t, storage, "alive", "value")
+}
+
+func TestSnapshotCorrupted(t *testing.T) {
+	source := NewMemory(time.Minute)
+	source.Set("foo", "bar", 0)
+
+	buf := &bytes.Buffer{}
+	WriteSnapshot(buf, source)
+	data := buf.Bytes()
+
+	cases := map[string][]byte{
+		"truncated": data[:len(data)-3],
+		"bad magic": append([]byte("X"), data[1:]...),
+		"bad crc":   append(append([]byte{}, data[:len(data)-1]...), data[len(data)-1]+1),
+	}
+
+	for name, tc := range cases {
+		target := NewMemory(time.Minute)
+		if err := ReadSnapshot(bytes.NewReader(tc), target); err != errBadSnapshot {
+			t.Fatalf("%s: expected errBadSnapshot. Got: %v", name, err)
+		}
+		if _, err := target.Get("foo"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+	}
+}
+
+func TestSaveLoadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lodge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dump.lodge")
+
+	source := NewMemory(time.Minute)
+	source.Set("foo", "bar", 0)
+	if err := SaveSnapshot(path, source); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	target := NewMemory(time.Minute)
+	if err := LoadSnapshot(path, target); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	assertStorageValue(t, target, "foo", "bar")
+}
+
+func assertStorageValue(t *testing.T, s Storage, key, expected string) {
+	value, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Unexpected error for %s: %v", key, err)
+	}
+
+	if value != expected {
+		t.Fatalf("Expected: %v. Got: %v", expected, value)
+	}
+}
+
+func TestSnapshotHugeLength(t *testing.T) {
+	buf := &bytes.Buffer{}
+	WriteSnapshot(buf, NewMemory(time.Minute))
+	header := buf.Bytes()[:len(snapshotMagic)+1+8]
+
+	// string claims 768MB, but snapshot ends after few bytes
+	data := append(append([]byte{}, header...), kindString)
+	length := make([]byte, binary.MaxVarintLen64)
+	data = append(data, length[:binary.PutUvarint(length, 1<<29 + 1<<28)]...)
+	data = append(data, "foo"...)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := ReadSnapshot(bytes.NewReader(data), NewMemory(time.Minute)); err != errBadSnapshot {
+		t.Fatalf("Expected: %v. Got: %v", errBadSnapshot, err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Fatalf("Expected allocation bounded by snapshot size. Got: %d bytes", allocated)
+	}
+}
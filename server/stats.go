@@ -0,0 +1,27 @@
+package server
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// stats are counters of client connections reported by INFO.
+type stats struct {
+	connections                int64
+	rejectedConnections        int64
+	outputBufferDisconnections int64
+}
+
+// statsInfo returns INFO lines with number of connected clients and counters.
+func (s *Server) statsInfo() []string {
+	s.mu.Lock()
+	connected := len(s.conns)
+	s.mu.Unlock()
+
+	return []string{
+		fmt.Sprintf("connected_clients:%d", connected),
+		fmt.Sprintf("total_connections_received:%d", atomic.LoadInt64(&s.stats.connections)),
+		fmt.Sprintf("rejected_connections:%d", atomic.LoadInt64(&s.stats.rejectedConnections)),
+		fmt.Sprintf("output_buffer_disconnections:%d", atomic.LoadInt64(&s.stats.outputBufferDisconnections)),
+	}
+}
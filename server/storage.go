@@ -2,33 +2,146 @@ package server
 
 import (
 	"fmt"
+	"math"
+	"strconv"
 	"sync"
 	"time"
 )
 
 var errNotFound = fmt.Errorf("Element not found")
 var errWrongType = fmt.Errorf("Wrong type")
+var errNotInteger = fmt.Errorf("Value is not an integer")
+var errOverflow = fmt.Errorf("Increment or decrement would overflow")
+var errNotFloat = fmt.Errorf("Value is not a valid float")
+var errConflict = fmt.Errorf("Version of key has changed")
 
 type Storage interface {
 	Set(key, value string, ttl int64) error
 	Get(key string) (string, error)
+	// SetIf sets value only if key existence is equal to exists. It returns false if value wasn't set.
+	SetIf(key, value string, ttl int64, exists bool) (bool, error)
+	// GetSet sets value without ttl and returns old value. If key didn't exist, value is set and errNotFound is returned.
+	GetSet(key, value string) (string, error)
+	// Gets returns value with its version, which can be passed to CompareAndSet.
+	Gets(key string) (string, uint64, error)
+	// CompareAndSet sets value only if version of key is equal to version, otherwise it returns errConflict.
+	CompareAndSet(key, value string, ttl int64, version uint64) error
 	HSet(key, field, value string) error
 	HGet(key, field string) (string, error)
 	HGetAll(key string) (map[string]string, error)
 	Delete(key string) error
 	Keys() ([]string, error)
+	// Scan returns some keys starting from cursor and cursor to continue from. Zero cursor starts
+	// iteration and is returned when iteration is over. Count is number of keys to return, it's a hint.
+	Scan(cursor uint64, count int) ([]string, uint64, error)
+	// HScan iterates over hash fields like Scan does over keys. Fields are followed by their values.
+	HScan(key string, cursor uint64, count int) ([]string, uint64, error)
 	Expire(key string, ttl int64) error
+	// IncrBy adds delta to integer value of key and returns new value. Missing key is treated as 0.
+	// Expiration time of key is kept.
+	IncrBy(key string, delta int64) (int64, error)
+	// HIncrBy adds delta to integer value of hash field and returns new value.
+	HIncrBy(key, field string, delta int64) (int64, error)
+	// LPush and RPush insert values at the head or at the tail of list and return length of list.
+	LPush(key string, values ...string) (int, error)
+	RPush(key string, values ...string) (int, error)
+	// LPop and RPop remove and return first or last element of list.
+	LPop(key string) (string, error)
+	RPop(key string) (string, error)
+	// LRange returns elements between start and stop inclusive. Negative index counts from the end of list.
+	LRange(key string, start, stop int) ([]string, error)
+	LLen(key string) (int, error)
+	// LTrim removes elements which are not between start and stop.
+	LTrim(key string, start, stop int) error
+	LIndex(key string, index int) (string, error)
+	// SAdd adds members to set and returns number of added members.
+	SAdd(key string, members ...string) (int, error)
+	// SRem removes members from set and returns number of removed members.
+	SRem(key string, members ...string) (int, error)
+	SIsMember(key, member string) (bool, error)
+	SMembers(key string) ([]string, error)
+	SCard(key string) (int, error)
+	// SPop removes and returns random member of set.
+	SPop(key string) (string, error)
+	SRandMember(key string) (string, error)
+	// SInter, SUnion and SDiff return intersection, union and difference of sets. Missing key is empty set.
+	SInter(keys ...string) ([]string, error)
+	SUnion(keys ...string) ([]string, error)
+	SDiff(keys ...string) ([]string, error)
+	// SInterStore, SUnionStore and SDiffStore replace dest with result and return its size.
+	SInterStore(dest string, keys ...string) (int, error)
+	SUnionStore(dest string, keys ...string) (int, error)
+	SDiffStore(dest string, keys ...string) (int, error)
+	// ZAdd sets scores of sorted set members and returns number of added members.
+	ZAdd(key string, members ...ZMember) (int, error)
+	ZRem(key string, members ...string) (int, error)
+	ZScore(key, member string) (float64, error)
+	// ZRank returns 0-based rank of member ordered by score.
+	ZRank(key, member string) (int, error)
+	// ZRange returns members between start and stop ranks inclusive. Negative rank counts from the end.
+	ZRange(key string, start, stop int) ([]ZMember, error)
+	// ZRangeByScore returns members with score between min and max inclusive.
+	ZRangeByScore(key string, min, max float64) ([]ZMember, error)
+	// ZIncrBy adds delta to score of member and returns new score. Missing member has score 0.
+	ZIncrBy(key, member string, delta float64) (float64, error)
+	ZCard(key string) (int, error)
+	// ZPopMin removes and returns member with the lowest score.
+	ZPopMin(key string) (ZMember, error)
+	// TTL returns number of seconds before element expires or 0 if it never expires.
+	TTL(key string) (int64, error)
+	// Dump calls fn with copies of all not expired elements. Implementations copy elements
+	// under their lock and call fn after releasing it, so writers are not blocked by fn.
+	Dump(fn func(Entry) error) error
+	// Restore puts element into storage replacing existing one.
+	Restore(e Entry) error
+	// Version returns number which is changed by every modification of key. Missing key has version 0.
+	Version(key string) (uint64, error)
+	// Atomic calls fn with storage which can be used to access keys while other clients can't access them.
+	// Nil keys means all keys. fn must not use keys which are not listed and must not call Atomic.
+	Atomic(keys []string, fn func(Storage) error) error
+	// Notify sets function which is called on every change of key, nil disables notifications.
+	Notify(fn NotifyFunc)
+}
+
+// Entry is point-in-time copy of stored element.
+type Entry struct {
+	Key string
+	// Value is string, map[string]string (hash), []string (list), map[string]struct{} (set) or *zset (sorted set).
+	Value interface{}
+	// TTL is number of seconds before element expires. 0 means that element never expires.
+	TTL int64
+}
+
+// clearStorage deletes every key while other clients can't access storage.
+func clearStorage(s Storage) error {
+	return s.Atomic(nil, func(storage Storage) error {
+		keys, err := storage.Keys()
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := storage.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 }
 
 type Memory struct {
 	items         map[string]item
 	l             sync.RWMutex
 	cleanupPeriod time.Duration
+	// version is the last version given to item.
+	version uint64
+	notify  NotifyFunc
 }
 
 func NewMemory(cleanupPeriod time.Duration) *Memory {
 	storage := &Memory{
-		items:        make(map[string]item),
+		items:         make(map[string]item),
 		cleanupPeriod: cleanupPeriod,
 	}
 
@@ -40,6 +153,8 @@ func NewMemory(cleanupPeriod time.Duration) *Memory {
 type item struct {
 	expiresAt int64
 	value     interface{}
+	// version is changed by every modification of item.
+	version uint64
 }
 
 func (i item) expired() bool {
@@ -63,6 +178,7 @@ func (m *Memory) doCleanup() {
 	for key, item := range m.items {
 		if item.expired() {
 			delete(m.items, key)
+			m.emit(EventExpired, key)
 		}
 	}
 }
@@ -77,10 +193,11 @@ func (m *Memory) Set(key, value string, ttl int64) error {
 		}
 	}
 
-	m.items[key] = item{
+	m.put(key, item{
 		value:     value,
 		expiresAt: expiresAfter(ttl),
-	}
+	})
+	m.emit(EventSet, key)
 
 	return nil
 }
@@ -101,6 +218,93 @@ func (m *Memory) Get(key string) (string, error) {
 	return "", errNotFound
 }
 
+func (m *Memory) SetIf(key, value string, ttl int64, exists bool) (bool, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	it, found := m.items[key]
+	found = found && !it.expired()
+	if found != exists {
+		return false, nil
+	}
+	if _, ok := it.value.(string); found && !ok {
+		return false, errWrongType
+	}
+
+	m.put(key, item{
+		value:     value,
+		expiresAt: expiresAfter(ttl),
+	})
+	m.emit(EventSet, key)
+
+	return true, nil
+}
+
+func (m *Memory) GetSet(key, value string) (string, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	var old string
+	it, found := m.items[key]
+	found = found && !it.expired()
+	if found {
+		var ok bool
+		if old, ok = it.value.(string); !ok {
+			return "", errWrongType
+		}
+	}
+
+	m.put(key, item{value: value})
+	m.emit(EventSet, key)
+
+	if !found {
+		return "", errNotFound
+	}
+
+	return old, nil
+}
+
+func (m *Memory) Gets(key string) (string, uint64, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	it, ok := m.items[key]
+	if !ok || it.expired() {
+		return "", 0, errNotFound
+	}
+
+	value, ok := it.value.(string)
+	if !ok {
+		return "", 0, errWrongType
+	}
+
+	return value, it.version, nil
+}
+
+func (m *Memory) CompareAndSet(key, value string, ttl int64, version uint64) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	it, ok := m.items[key]
+	if !ok || it.expired() {
+		return errNotFound
+	}
+	if _, ok := it.value.(string); !ok {
+		return errWrongType
+	}
+	if it.version != version {
+		return errConflict
+	}
+
+	m.put(key, item{
+		value:     value,
+		expiresAt: expiresAfter(ttl),
+	})
+	m.emit(EventSet, key)
+
+	return nil
+}
+
 func (m *Memory) HSet(key, field, value string) error {
 	m.l.Lock()
 	defer m.l.Unlock()
@@ -108,16 +312,18 @@ func (m *Memory) HSet(key, field, value string) error {
 	if hashItem, ok := m.items[key]; ok {
 		if hash, ok := hashItem.value.(map[string]string); ok {
 			hash[field] = value
+			m.put(key, hashItem)
 		} else {
 			return errWrongType
 		}
 	} else {
-		m.items[key] = item{
+		m.put(key, item{
 			value: map[string]string{
 				field: value,
 			},
-		}
+		})
 	}
+	m.emit(EventHSet, key)
 
 	return nil
 }
@@ -126,14 +332,15 @@ func (m *Memory) HGet(key, field string) (string, error) {
 	m.l.RLock()
 	defer m.l.RUnlock()
 
-	if hashItem, ok := m.items[key]; ok {
-		if hash, ok := hashItem.value.(map[string]string); ok {
-			if value, ok := hash[field]; ok {
-				return value, nil
-			}
+	if hashItem, ok := m.items[key]; ok && !hashItem.expired() {
+		hash, ok := hashItem.value.(map[string]string)
+		if !ok {
+			return "", errWrongType
+		}
+
+		if value, ok := hash[field]; ok {
+			return value, nil
 		}
-	} else {
-		return "", errWrongType
 	}
 
 	return "", errNotFound
@@ -143,25 +350,72 @@ func (m *Memory) HGetAll(key string) (map[string]string, error) {
 	m.l.RLock()
 	defer m.l.RUnlock()
 
-	if hashItem, ok := m.items[key]; ok {
-		if hash, ok := hashItem.value.(map[string]string); ok {
-			result := make(map[string]string, len(hash))
-			for field, value := range hash {
-				result[field] = value
-			}
+	if hashItem, ok := m.items[key]; ok && !hashItem.expired() {
+		hash, ok := hashItem.value.(map[string]string)
+		if !ok {
+			return nil, errWrongType
+		}
 
-			return result, nil
+		result := make(map[string]string, len(hash))
+		for field, value := range hash {
+			result[field] = value
 		}
+
+		return result, nil
 	}
 
 	return nil, errNotFound
 }
 
+func (m *Memory) Scan(cursor uint64, count int) ([]string, uint64, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	keys, next := scan(cursor, count, func(fn func(key string)) {
+		for key, it := range m.items {
+			if !it.expired() {
+				fn(key)
+			}
+		}
+	})
+
+	return keys, next, nil
+}
+
+func (m *Memory) HScan(key string, cursor uint64, count int) ([]string, uint64, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	it, ok := m.items[key]
+	if !ok || it.expired() {
+		return nil, 0, nil
+	}
+
+	hash, ok := it.value.(map[string]string)
+	if !ok {
+		return nil, 0, errWrongType
+	}
+
+	values, next := scanHash(hash, cursor, count)
+
+	return values, next, nil
+}
+
 func (m *Memory) Delete(key string) error {
 	m.l.Lock()
 	defer m.l.Unlock()
 
+	it, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+
 	delete(m.items, key)
+	if it.expired() {
+		m.emit(EventExpired, key)
+	} else {
+		m.emit(EventDel, key)
+	}
 
 	return nil
 }
@@ -182,11 +436,13 @@ func (m *Memory) Keys() ([]string, error) {
 
 func (m *Memory) Expire(key string, ttl int64) error {
 	m.l.Lock()
-	m.l.Unlock()
+	defer m.l.Unlock()
 
 	if item, ok := m.items[key]; ok {
 		if !item.expired() {
 			item.expiresAt = expiresAfter(ttl)
+			m.put(key, item)
+			m.emit(EventExpire, key)
 			return nil
 		}
 	}
@@ -194,6 +450,675 @@ func (m *Memory) Expire(key string, ttl int64) error {
 	return errNotFound
 }
 
+func (m *Memory) IncrBy(key string, delta int64) (int64, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	current := item{}
+	if it, ok := m.items[key]; ok && !it.expired() {
+		current = it
+	}
+
+	value := "0"
+	if current.value != nil {
+		str, ok := current.value.(string)
+		if !ok {
+			return 0, errWrongType
+		}
+		value = str
+	}
+
+	result, err := incrBy(value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	current.value = strconv.FormatInt(result, 10)
+	m.put(key, current)
+	m.emit(EventIncrBy, key)
+
+	return result, nil
+}
+
+func (m *Memory) HIncrBy(key, field string, delta int64) (int64, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	hashItem, ok := m.items[key]
+	if !ok || hashItem.expired() {
+		hashItem = item{value: map[string]string{}}
+	}
+
+	hash, ok := hashItem.value.(map[string]string)
+	if !ok {
+		return 0, errWrongType
+	}
+
+	value, ok := hash[field]
+	if !ok {
+		value = "0"
+	}
+
+	result, err := incrBy(value, delta)
+	if err != nil {
+		return 0, err
+	}
+
+	hash[field] = strconv.FormatInt(result, 10)
+	m.put(key, hashItem)
+	m.emit(EventHIncrBy, key)
+
+	return result, nil
+}
+
+func (m *Memory) LPush(key string, values ...string) (int, error) {
+	return m.push(key, values, true)
+}
+
+func (m *Memory) RPush(key string, values ...string) (int, error) {
+	return m.push(key, values, false)
+}
+
+func (m *Memory) push(key string, values []string, left bool) (int, error) {
+	var length int
+	err := m.updateList(key, pushEvent(left), func(list []string) ([]string, error) {
+		list = pushValues(list, values, left)
+		length = len(list)
+
+		return list, nil
+	})
+
+	return length, err
+}
+
+func (m *Memory) LPop(key string) (string, error) {
+	return m.pop(key, true)
+}
+
+func (m *Memory) RPop(key string) (string, error) {
+	return m.pop(key, false)
+}
+
+func (m *Memory) pop(key string, left bool) (string, error) {
+	var value string
+	err := m.updateList(key, popEvent(left), func(list []string) ([]string, error) {
+		if len(list) == 0 {
+			return nil, errNotFound
+		}
+
+		value, list = popValue(list, left)
+
+		return list, nil
+	})
+
+	return value, err
+}
+
+func (m *Memory) LRange(key string, start, stop int) ([]string, error) {
+	var result []string
+	err := m.readList(key, func(list []string) {
+		from, to := listRange(len(list), start, stop)
+		result = copyList(list[from:to])
+	})
+
+	return result, err
+}
+
+func (m *Memory) LLen(key string) (int, error) {
+	var length int
+	err := m.readList(key, func(list []string) {
+		length = len(list)
+	})
+
+	return length, err
+}
+
+func (m *Memory) LTrim(key string, start, stop int) error {
+	return m.updateList(key, EventLTrim, func(list []string) ([]string, error) {
+		from, to := listRange(len(list), start, stop)
+
+		return list[from:to], nil
+	})
+}
+
+func (m *Memory) LIndex(key string, index int) (string, error) {
+	var value string
+	var found bool
+	err := m.readList(key, func(list []string) {
+		if i, ok := listIndex(len(list), index); ok {
+			value, found = list[i], true
+		}
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return value, err
+}
+
+// readList calls fn with list stored in key. Missing key is empty list.
+func (m *Memory) readList(key string, fn func(list []string)) error {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	var list []string
+	if it, ok := m.items[key]; ok && !it.expired() {
+		if list, ok = it.value.([]string); !ok {
+			return errWrongType
+		}
+	}
+
+	fn(list)
+
+	return nil
+}
+
+// updateList replaces list stored in key with list returned by fn. Empty list is removed.
+// Change is reported as event.
+func (m *Memory) updateList(key, event string, fn func(list []string) ([]string, error)) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	var list []string
+	it, found := m.items[key]
+	found = found && !it.expired()
+	if found {
+		var ok bool
+		if list, ok = it.value.([]string); !ok {
+			return errWrongType
+		}
+	} else {
+		it = item{}
+	}
+
+	list, err := fn(list)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		m.remove(key, found)
+		return nil
+	}
+
+	it.value = list
+	m.put(key, it)
+	m.emit(event, key)
+
+	return nil
+}
+
+func (m *Memory) SAdd(key string, members ...string) (int, error) {
+	var added int
+	err := m.updateSet(key, EventSAdd, func(set map[string]struct{}) error {
+		added = addMembers(set, members)
+
+		return nil
+	})
+
+	return added, err
+}
+
+func (m *Memory) SRem(key string, members ...string) (int, error) {
+	var removed int
+	err := m.updateSet(key, EventSRem, func(set map[string]struct{}) error {
+		removed = removeMembers(set, members)
+
+		return nil
+	})
+
+	return removed, err
+}
+
+func (m *Memory) SIsMember(key, member string) (bool, error) {
+	var found bool
+	err := m.readSet(key, func(set map[string]struct{}) {
+		_, found = set[member]
+	})
+
+	return found, err
+}
+
+func (m *Memory) SMembers(key string) ([]string, error) {
+	var members []string
+	err := m.readSet(key, func(set map[string]struct{}) {
+		members = setMembers(set)
+	})
+
+	return members, err
+}
+
+func (m *Memory) SCard(key string) (int, error) {
+	var n int
+	err := m.readSet(key, func(set map[string]struct{}) {
+		n = len(set)
+	})
+
+	return n, err
+}
+
+func (m *Memory) SPop(key string) (string, error) {
+	var member string
+	err := m.updateSet(key, EventSPop, func(set map[string]struct{}) error {
+		if len(set) == 0 {
+			return errNotFound
+		}
+
+		member = randomMember(set)
+		delete(set, member)
+
+		return nil
+	})
+
+	return member, err
+}
+
+func (m *Memory) SRandMember(key string) (string, error) {
+	var member string
+	var found bool
+	err := m.readSet(key, func(set map[string]struct{}) {
+		if len(set) > 0 {
+			member, found = randomMember(set), true
+		}
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return member, err
+}
+
+func (m *Memory) SInter(keys ...string) ([]string, error) {
+	return m.combine(setInter, keys)
+}
+
+func (m *Memory) SUnion(keys ...string) ([]string, error) {
+	return m.combine(setUnion, keys)
+}
+
+func (m *Memory) SDiff(keys ...string) ([]string, error) {
+	return m.combine(setDiff, keys)
+}
+
+func (m *Memory) combine(op setOperation, keys []string) ([]string, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	sets, err := m.sets(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return setMembers(combineSets(op, sets)), nil
+}
+
+func (m *Memory) SInterStore(dest string, keys ...string) (int, error) {
+	return m.combineStore(setInter, dest, keys)
+}
+
+func (m *Memory) SUnionStore(dest string, keys ...string) (int, error) {
+	return m.combineStore(setUnion, dest, keys)
+}
+
+func (m *Memory) SDiffStore(dest string, keys ...string) (int, error) {
+	return m.combineStore(setDiff, dest, keys)
+}
+
+func (m *Memory) combineStore(op setOperation, dest string, keys []string) (int, error) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	sets, err := m.sets(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	result := combineSets(op, sets)
+	m.replaceSet(op, dest, result)
+
+	return len(result), nil
+}
+
+// storeSet replaces dest with result of set operation computed by bucket storage.
+func (m *Memory) storeSet(op setOperation, dest string, result map[string]struct{}) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.replaceSet(op, dest, result)
+}
+
+// replaceSet replaces dest with result of set operation. It must be called with lock held.
+func (m *Memory) replaceSet(op setOperation, dest string, result map[string]struct{}) {
+	if len(result) == 0 {
+		it, found := m.items[dest]
+		m.remove(dest, found && !it.expired())
+	} else {
+		m.put(dest, item{value: result})
+		m.emit(storeEvent(op), dest)
+	}
+}
+
+// sets returns sets stored in keys. It must be called with lock held.
+func (m *Memory) sets(keys []string) ([]map[string]struct{}, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		if it, ok := m.items[key]; ok && !it.expired() {
+			if sets[i], ok = it.value.(map[string]struct{}); !ok {
+				return nil, errWrongType
+			}
+		}
+	}
+
+	return sets, nil
+}
+
+// readSet calls fn with set stored in key. Missing key is empty set.
+func (m *Memory) readSet(key string, fn func(set map[string]struct{})) error {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	sets, err := m.sets([]string{key})
+	if err != nil {
+		return err
+	}
+
+	fn(sets[0])
+
+	return nil
+}
+
+// updateSet calls fn with set stored in key, fn can modify set. Empty set is removed.
+// Change is reported as event.
+func (m *Memory) updateSet(key, event string, fn func(set map[string]struct{}) error) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	it, found := m.items[key]
+	found = found && !it.expired()
+	if !found {
+		it = item{value: make(map[string]struct{})}
+	}
+
+	set, ok := it.value.(map[string]struct{})
+	if !ok {
+		return errWrongType
+	}
+
+	if err := fn(set); err != nil {
+		return err
+	}
+
+	if len(set) == 0 {
+		m.remove(key, found)
+		return nil
+	}
+
+	m.put(key, it)
+	m.emit(event, key)
+
+	return nil
+}
+
+func (m *Memory) ZAdd(key string, members ...ZMember) (int, error) {
+	var added int
+	err := m.updateZSet(key, EventZAdd, func(z *zset) error {
+		for _, member := range members {
+			if z.add(member.Member, member.Score) {
+				added++
+			}
+		}
+
+		return nil
+	})
+
+	return added, err
+}
+
+func (m *Memory) ZRem(key string, members ...string) (int, error) {
+	var removed int
+	err := m.updateZSet(key, EventZRem, func(z *zset) error {
+		for _, member := range members {
+			if z.remove(member) {
+				removed++
+			}
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+func (m *Memory) ZScore(key, member string) (float64, error) {
+	var score float64
+	var found bool
+	err := m.readZSet(key, func(z *zset) {
+		score, found = z.scores[member]
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return score, err
+}
+
+func (m *Memory) ZRank(key, member string) (int, error) {
+	var rank int
+	var found bool
+	err := m.readZSet(key, func(z *zset) {
+		rank, found = z.rank(member)
+	})
+	if err == nil && !found {
+		err = errNotFound
+	}
+
+	return rank, err
+}
+
+func (m *Memory) ZRange(key string, start, stop int) ([]ZMember, error) {
+	var result []ZMember
+	err := m.readZSet(key, func(z *zset) {
+		result = z.rangeByRank(start, stop)
+	})
+
+	return result, err
+}
+
+func (m *Memory) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	var result []ZMember
+	err := m.readZSet(key, func(z *zset) {
+		result = z.rangeByScore(min, max)
+	})
+
+	return result, err
+}
+
+func (m *Memory) ZIncrBy(key, member string, delta float64) (float64, error) {
+	var score float64
+	err := m.updateZSet(key, EventZIncrBy, func(z *zset) error {
+		var err error
+		score, err = zIncrBy(z, member, delta)
+
+		return err
+	})
+
+	return score, err
+}
+
+func (m *Memory) ZCard(key string) (int, error) {
+	var n int
+	err := m.readZSet(key, func(z *zset) {
+		n = z.len()
+	})
+
+	return n, err
+}
+
+func (m *Memory) ZPopMin(key string) (ZMember, error) {
+	var first ZMember
+	err := m.updateZSet(key, EventZPopMin, func(z *zset) error {
+		if z.len() == 0 {
+			return errNotFound
+		}
+
+		first = z.popMin()
+
+		return nil
+	})
+
+	return first, err
+}
+
+// readZSet calls fn with sorted set stored in key. Missing key is empty sorted set.
+func (m *Memory) readZSet(key string, fn func(z *zset)) error {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	z := newZSet()
+	if it, ok := m.items[key]; ok && !it.expired() {
+		if z, ok = it.value.(*zset); !ok {
+			return errWrongType
+		}
+	}
+
+	fn(z)
+
+	return nil
+}
+
+// updateZSet calls fn with sorted set stored in key, fn can modify sorted set. Empty sorted set is removed.
+// Change is reported as event.
+func (m *Memory) updateZSet(key, event string, fn func(z *zset) error) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	it, found := m.items[key]
+	found = found && !it.expired()
+	if !found {
+		it = item{value: newZSet()}
+	}
+
+	z, ok := it.value.(*zset)
+	if !ok {
+		return errWrongType
+	}
+
+	if err := fn(z); err != nil {
+		return err
+	}
+
+	if z.len() == 0 {
+		m.remove(key, found)
+		return nil
+	}
+
+	m.put(key, it)
+	m.emit(event, key)
+
+	return nil
+}
+
+func (m *Memory) TTL(key string) (int64, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	if item, ok := m.items[key]; ok && !item.expired() {
+		return ttlUntil(item.expiresAt), nil
+	}
+
+	return 0, errNotFound
+}
+
+func (m *Memory) Dump(fn func(Entry) error) error {
+	m.l.RLock()
+	entries := make([]Entry, 0, len(m.items))
+	for key, item := range m.items {
+		if !item.expired() {
+			entries = append(entries, Entry{
+				Key:   key,
+				Value: copyValue(item.value),
+				TTL:   ttlUntil(item.expiresAt),
+			})
+		}
+	}
+	m.l.RUnlock()
+
+	for _, e := range entries {
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *Memory) Restore(e Entry) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.put(e.Key, item{
+		value:     copyValue(e.Value),
+		expiresAt: expiresAfter(e.TTL),
+	})
+	m.emit(EventRestore, e.Key)
+
+	return nil
+}
+
+func (m *Memory) Version(key string) (uint64, error) {
+	m.l.RLock()
+	defer m.l.RUnlock()
+
+	if it, ok := m.items[key]; ok && !it.expired() {
+		return it.version, nil
+	}
+
+	return 0, nil
+}
+
+// Atomic locks the whole storage, so keys are ignored.
+func (m *Memory) Atomic(keys []string, fn func(Storage) error) error {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	// view shares items, but has its own lock which is held by nobody
+	view := &Memory{items: m.items, version: m.version, notify: m.notify}
+	err := fn(view)
+	m.version = view.version
+
+	return err
+}
+
+func (m *Memory) Notify(fn NotifyFunc) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	m.notify = fn
+}
+
+// emit reports change of key. It must be called with lock held.
+func (m *Memory) emit(event, key string) {
+	if m.notify != nil {
+		m.notify(event, key)
+	}
+}
+
+// remove deletes key which became empty collection, existed is false if key was missing or expired.
+// It must be called with lock held.
+func (m *Memory) remove(key string, existed bool) {
+	delete(m.items, key)
+	if existed {
+		m.emit(EventDel, key)
+	}
+}
+
+// put stores item with new version. It must be called with lock held.
+func (m *Memory) put(key string, it item) {
+	m.version++
+	it.version = m.version
+	m.items[key] = it
+}
+
 func expiresAfter(ttl int64) int64 {
 	var expiresAt int64
 
@@ -203,3 +1128,62 @@ func expiresAfter(ttl int64) int64 {
 
 	return expiresAt
 }
+
+// incrBy adds delta to integer stored as string.
+func incrBy(value string, delta int64) (int64, error) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return 0, errOverflow
+		}
+
+		return 0, errNotInteger
+	}
+
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, errOverflow
+	}
+
+	return n + delta, nil
+}
+
+// ttlUntil returns number of seconds left till expiresAt. Result is never 0 for expiring elements,
+// because 0 ttl means that element never expires.
+func ttlUntil(expiresAt int64) int64 {
+	if expiresAt == 0 {
+		return 0
+	}
+
+	ttl := expiresAt - time.Now().Unix()
+	if ttl < 1 {
+		ttl = 1
+	}
+
+	return ttl
+}
+
+// copyValue returns copy of stored value, so it can be used without holding storage lock.
+func copyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]string:
+		result := make(map[string]string, len(value))
+		for field, v := range value {
+			result[field] = v
+		}
+
+		return result
+	case []string:
+		return copyList(value)
+	case *zset:
+		return value.copy()
+	case map[string]struct{}:
+		result := make(map[string]struct{}, len(value))
+		for member := range value {
+			result[member] = struct{}{}
+		}
+
+		return result
+	}
+
+	return value
+}
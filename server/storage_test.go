@@ -8,6 +8,186 @@ import (
 	"github.com/mkabischev/lodge/server/lru"
 )
 
+func testStorages() map[string]func() Storage {
+	return map[string]func() Storage{
+		"memory": func() Storage {
+			return NewMemory(time.Minute)
+		},
+		"lru": func() Storage {
+			return NewLRUStorage(lru.New(100))
+		},
+		"bucket": func() Storage {
+			return NewBucketStorage(10, func() Storage {
+				return NewLRUStorage(lru.New(100))
+			})
+		},
+	}
+}
+
+func TestIncrBy(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		if n, err := s.IncrBy("counter", 5); err != nil || n != 5 {
+			t.Fatalf("%s: expected 5. Got: %d, %v", name, n, err)
+		}
+		if n, err := s.IncrBy("counter", -7); err != nil || n != -2 {
+			t.Fatalf("%s: expected -2. Got: %d, %v", name, n, err)
+		}
+		assertStorageValue(t, s, "counter", "-2")
+
+		s.Set("expiring", "1", 100)
+		s.IncrBy("expiring", 1)
+		if ttl, _ := s.TTL("expiring"); ttl == 0 {
+			t.Fatalf("%s: expected ttl to be kept", name)
+		}
+
+		s.Set("foo", "bar", 0)
+		if _, err := s.IncrBy("foo", 1); err != errNotInteger {
+			t.Fatalf("%s: expected errNotInteger. Got: %v", name, err)
+		}
+
+		s.Set("max", "9223372036854775807", 0)
+		if _, err := s.IncrBy("max", 1); err != errOverflow {
+			t.Fatalf("%s: expected errOverflow. Got: %v", name, err)
+		}
+		if _, err := s.IncrBy("max", -1); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+
+		s.HSet("hash", "field", "x")
+		if _, err := s.IncrBy("hash", 1); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestHIncrBy(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		if n, err := s.HIncrBy("hash", "counter", 3); err != nil || n != 3 {
+			t.Fatalf("%s: expected 3. Got: %d, %v", name, n, err)
+		}
+		if n, err := s.HIncrBy("hash", "counter", 3); err != nil || n != 6 {
+			t.Fatalf("%s: expected 6. Got: %d, %v", name, n, err)
+		}
+		if value, _ := s.HGet("hash", "counter"); value != "6" {
+			t.Fatalf("%s: expected 6. Got: %v", name, value)
+		}
+
+		s.HSet("hash", "foo", "bar")
+		if _, err := s.HIncrBy("hash", "foo", 1); err != errNotInteger {
+			t.Fatalf("%s: expected errNotInteger. Got: %v", name, err)
+		}
+
+		s.Set("string", "1", 0)
+		if _, err := s.HIncrBy("string", "foo", 1); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestSetIf(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		if set, err := s.SetIf("foo", "bar", 0, true); err != nil || set {
+			t.Fatalf("%s: expected missing key not to be replaced. Got: %v, %v", name, set, err)
+		}
+		if set, err := s.SetIf("foo", "bar", 0, false); err != nil || !set {
+			t.Fatalf("%s: expected missing key to be added. Got: %v, %v", name, set, err)
+		}
+		if set, err := s.SetIf("foo", "baz", 0, false); err != nil || set {
+			t.Fatalf("%s: expected existing key not to be added. Got: %v, %v", name, set, err)
+		}
+		assertStorageValue(t, s, "foo", "bar")
+
+		if set, err := s.SetIf("foo", "baz", 100, true); err != nil || !set {
+			t.Fatalf("%s: expected existing key to be replaced. Got: %v, %v", name, set, err)
+		}
+		assertStorageValue(t, s, "foo", "baz")
+		if ttl, _ := s.TTL("foo"); ttl == 0 {
+			t.Fatalf("%s: expected ttl to be set", name)
+		}
+
+		s.HSet("hash", "field", "value")
+		if set, err := s.SetIf("hash", "value", 0, false); err != nil || set {
+			t.Fatalf("%s: expected hash not to be replaced. Got: %v, %v", name, set, err)
+		}
+		if _, err := s.SetIf("hash", "value", 0, true); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		if _, err := s.GetSet("foo", "bar"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		assertStorageValue(t, s, "foo", "bar")
+
+		s.Expire("foo", 100)
+		if old, err := s.GetSet("foo", "baz"); err != nil || old != "bar" {
+			t.Fatalf("%s: expected bar. Got: %v, %v", name, old, err)
+		}
+		assertStorageValue(t, s, "foo", "baz")
+		if ttl, _ := s.TTL("foo"); ttl != 0 {
+			t.Fatalf("%s: expected ttl to be removed. Got: %d", name, ttl)
+		}
+
+		s.HSet("hash", "field", "value")
+		if _, err := s.GetSet("hash", "value"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestCompareAndSet(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		if _, _, err := s.Gets("foo"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if err := s.CompareAndSet("foo", "bar", 0, 1); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+
+		s.Set("foo", "bar", 0)
+		value, version, err := s.Gets("foo")
+		if err != nil || value != "bar" {
+			t.Fatalf("%s: expected bar. Got: %v, %v", name, value, err)
+		}
+
+		if err := s.CompareAndSet("foo", "baz", 0, version); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		assertStorageValue(t, s, "foo", "baz")
+
+		// version is changed by CompareAndSet itself
+		if err := s.CompareAndSet("foo", "qux", 0, version); err != errConflict {
+			t.Fatalf("%s: expected errConflict. Got: %v", name, err)
+		}
+
+		// writing the same value changes version as well
+		_, version, _ = s.Gets("foo")
+		s.Set("foo", "baz", 0)
+		if err := s.CompareAndSet("foo", "qux", 0, version); err != errConflict {
+			t.Fatalf("%s: expected errConflict. Got: %v", name, err)
+		}
+		assertStorageValue(t, s, "foo", "baz")
+
+		s.HSet("hash", "field", "value")
+		if _, _, err := s.Gets("hash"); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
 func benchMemorySet(s Storage) func(*testing.PB) {
 	return func(pb *testing.PB) {
 		i := 0
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/testutil"
+)
+
+func testTLSServer(t *testing.T, user string) (*testClient, *Server) {
+	l, conn := testutil.NextListener(t)
+	serverTLS, clientTLS := testutil.TLSConfigs(t, user)
+
+	config := DefaultConfig()
+	config.TLS = serverTLS
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString("default:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\nalice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+
+	server := New(NewMemory(time.Minute), config)
+	go server.Serve(l)
+
+	return &testClient{connection: tls.Client(conn, clientTLS)}, server
+}
+
+func TestTLS(t *testing.T) {
+	client, server := testTLSServer(t, "")
+	defer server.Close()
+	defer client.connection.Close()
+
+	client.assertRequest(t, []byte("GET foo\r\n"), resultAuthRequired)
+	client.assertRequest(t, []byte("AUTH default password\r\n"), resultOK)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	for _, test := range []struct {
+		user     string
+		expected []byte
+	}{
+		{"alice", resultNotFound},
+		// user isn't in users list
+		{"bob", resultAuthRequired},
+	} {
+		client, server := testTLSServer(t, test.user)
+		client.assertRequest(t, []byte("GET foo\r\n"), test.expected)
+		client.connection.Close()
+		server.Close()
+	}
+}
+
+func TestTLSPlaintext(t *testing.T) {
+	l, conn := testutil.NextListener(t)
+	defer conn.Close()
+
+	config := DefaultConfig()
+	config.TLS, _ = testutil.TLSConfigs(t, "")
+
+	server := New(NewMemory(time.Minute), config)
+	go server.Serve(l)
+	defer server.Close()
+
+	conn.Write([]byte("GET foo\r\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	// server replies with TLS alert and closes connection
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected connection to be closed. Got error: %v", err)
+	}
+	if bytes.HasPrefix(data, resultNotFound) {
+		t.Fatalf("Expected request to be rejected. Got: %q", data)
+	}
+}
+
+func TestReplicationTLS(t *testing.T) {
+	serverTLS, replicaTLS := testutil.TLSConfigs(t, "replica")
+
+	config := DefaultConfig()
+	config.TLS = serverTLS
+	config.Users, _ = NewUserListFromReader(bytes.NewBufferString("replica:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	primary := startNode(t, config)
+	defer primary.server.Close()
+	primary.storage.Set("foo", "bar", 0)
+
+	// replica is authenticated by its certificate
+	config = DefaultConfig()
+	config.ReplicaOf = primary.addr
+	config.PrimaryTLS = replicaTLS
+	replica := startNode(t, config)
+	defer replica.server.Close()
+
+	eventually(t, "full sync", hasValue(replica.storage, "foo", "bar"))
+}
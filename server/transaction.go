@@ -0,0 +1,266 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Transactions are supported by lodge protocol only. MULTI starts queuing: following requests are
+// checked, their data is read and they are replied with QUEUED. EXEC runs queued requests while
+// their keys are locked, so other clients see either none or all of their changes, and replies with
+// RESULTS followed by number of replies and replies themselves. DISCARD drops queue.
+//
+// WATCH remembers versions of keys before MULTI. If version of any watched key has changed by EXEC,
+// nothing is run and EXEC replies ABORTED. EXEC, DISCARD and UNWATCH forget watched keys.
+//
+// Invalid request in queue makes EXEC reply EXECABORT without running anything.
+
+var (
+	resultQueued    = []byte("QUEUED\r\n")
+	resultResults   = []byte("RESULTS\r\n")
+	resultAborted   = []byte("ABORTED\r\n")
+	resultExecAbort = []byte("EXECABORT\r\n")
+)
+
+var (
+	errNoMulti = errors.New("Command without MULTI")
+	// errWatchChanged means that watched key was changed before EXEC.
+	errWatchChanged = errors.New("Watched key was changed")
+	// errNotQueued means that command can't be run in transaction.
+	errNotQueued = errors.New("Command is not allowed in transaction")
+)
+
+// transaction is queue of requests started by MULTI.
+type transaction struct {
+	requests []*request
+	commands []command
+	// aborted is set when request couldn't be queued.
+	aborted bool
+}
+
+// dataCommand is command which has request data. Data of queued requests is read when they are queued.
+type dataCommand interface {
+	// lengths returns arguments which are lengths of data blocks.
+	lengths(arguments []string) ([]string, error)
+}
+
+// handleTransaction handles transaction commands and queues requests after MULTI.
+// It returns false if request must be executed as usual.
+func (s *Server) handleTransaction(conn *connection, request *request) bool {
+	switch request.command {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+	default:
+		if conn.tx == nil {
+			return false
+		}
+
+		if err := s.queue(conn.tx, conn, request); err != nil {
+			conn.tx.aborted = true
+			writeReply(conn, nil, err)
+			return true
+		}
+
+		conn.Write(resultQueued)
+		return true
+	}
+
+	if !conn.authenticated {
+		conn.Write(resultAuthRequired)
+		return true
+	}
+
+	switch request.command {
+	case "MULTI":
+		if len(request.arguments) != 0 || conn.tx != nil {
+			conn.WriteError()
+			return true
+		}
+
+		conn.tx = &transaction{}
+	case "EXEC":
+		if len(request.arguments) != 0 {
+			conn.WriteError()
+			return true
+		}
+
+		s.exec(conn)
+		return true
+	case "DISCARD":
+		if len(request.arguments) != 0 || conn.tx == nil {
+			conn.WriteError()
+			return true
+		}
+
+		conn.tx, conn.watched = nil, nil
+	case "WATCH":
+		if len(request.arguments) == 0 || conn.tx != nil {
+			conn.WriteError()
+			return true
+		}
+		if err := s.authorize(conn, request.command, request.arguments, false); err != nil {
+			writeReply(conn, nil, err)
+			return true
+		}
+
+		if conn.watched == nil {
+			conn.watched = make(map[string]uint64)
+		}
+		for _, key := range request.arguments {
+			if _, ok := conn.watched[key]; ok {
+				continue
+			}
+
+			version, err := s.storage.Version(key)
+			if err != nil {
+				writeReply(conn, nil, err)
+				return true
+			}
+			conn.watched[key] = version
+		}
+	case "UNWATCH":
+		conn.watched = nil
+	}
+
+	conn.WriteOK()
+
+	return true
+}
+
+// queue checks request and adds it to transaction.
+func (s *Server) queue(tx *transaction, conn *connection, request *request) error {
+	cmd, ok := s.commands[request.command]
+	if !ok {
+		return errWrongCommand
+	}
+
+	if !conn.authenticated {
+		return errAuthRequired
+	}
+	if !validArguments(cmd, len(request.arguments)) {
+		return errArguments
+	}
+	if _, _, ok := requestKeys(cmd, request); !ok {
+		return errNotQueued
+	}
+	if err := s.authorizeRequest(conn, cmd, request); err != nil {
+		return err
+	}
+
+	// data is read now, so queued request never reads connection
+	body := [][]byte{}
+	if c, ok := cmd.(dataCommand); ok {
+		lengths, err := c.lengths(request.arguments)
+		if err != nil {
+			return err
+		}
+
+		values, err := request.values(lengths)
+		if err != nil {
+			return err
+		}
+
+		for _, value := range values {
+			body = append(body, []byte(value))
+		}
+	}
+	request.body = body
+
+	// transaction can't wait for push, so blocking pop replies immediately
+	if c, ok := cmd.(blockingPopCommand); ok {
+		c.waiters = nil
+		cmd = c
+	}
+
+	tx.requests = append(tx.requests, request)
+	tx.commands = append(tx.commands, cmd)
+
+	return nil
+}
+
+// exec runs queued requests with their keys and watched keys locked.
+func (s *Server) exec(conn *connection) {
+	tx, watched := conn.tx, conn.watched
+	conn.tx, conn.watched = nil, nil
+
+	if tx == nil {
+		writeReply(conn, nil, errNoMulti)
+		return
+	}
+	if tx.aborted {
+		conn.Write(resultExecAbort)
+		return
+	}
+
+	keys := make([]string, 0, len(watched))
+	for key := range watched {
+		keys = append(keys, key)
+	}
+	for i, cmd := range tx.commands {
+		commandKeys, all, _ := requestKeys(cmd, tx.requests[i])
+		if all {
+			keys = nil
+			break
+		}
+		keys = append(keys, commandKeys...)
+	}
+
+	values := make([][]string, len(tx.requests))
+	errs := make([]error, len(tx.requests))
+	err := s.storage.Atomic(keys, func(storage Storage) error {
+		for key, version := range watched {
+			current, err := storage.Version(key)
+			if err != nil {
+				return err
+			}
+			if current != version {
+				return errWatchChanged
+			}
+		}
+
+		for i, cmd := range tx.commands {
+			values[i], errs[i] = cmd.process(tx.requests[i], storage)
+		}
+
+		return nil
+	})
+
+	switch err {
+	case nil:
+	case errWatchChanged:
+		conn.Write(resultAborted)
+		return
+	default:
+		writeReply(conn, nil, err)
+		return
+	}
+
+	conn.Write(resultResults)
+	conn.writer.WriteString(strconv.Itoa(len(values)))
+	conn.writer.WriteString("\r\n")
+	for i := range values {
+		writeReply(conn, values[i], errs[i])
+	}
+}
+
+// requestKeys returns keys used by request. all is true if request can use any key,
+// ok is false if request can't be queued in transaction.
+func requestKeys(cmd command, r *request) (keys []string, all bool, ok bool) {
+	switch cmd.(type) {
+	case keysCommand, scanCommand:
+		return nil, true, true
+	case combineCommand, combineStoreCommand:
+		return r.arguments, false, true
+	case blockingPopCommand:
+		return r.arguments[:len(r.arguments)-1], false, true
+	case pingCommand, publishCommand:
+		return nil, false, true
+	case saveCommand, bgSaveCommand, bgRewriteAOFCommand, infoCommand, replicaOfCommand, configCommand:
+		return nil, false, false
+	}
+
+	if len(r.arguments) == 0 {
+		return nil, false, true
+	}
+
+	return r.arguments[:1], false, true
+}
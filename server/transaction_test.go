@@ -0,0 +1,250 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mkabischev/lodge/testutil"
+)
+
+func TestVersion(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		version := func(key string) uint64 {
+			v, err := s.Version(key)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+
+			return v
+		}
+		assertChanged := func(key string, fn func()) {
+			before := version(key)
+			fn()
+			if after := version(key); after == before {
+				t.Fatalf("%s: expected version of %s to change. Got: %d", name, key, after)
+			}
+		}
+
+		if v := version("foo"); v != 0 {
+			t.Fatalf("%s: expected 0 for missing key. Got: %d", name, v)
+		}
+
+		assertChanged("foo", func() { s.Set("foo", "1", 0) })
+		assertChanged("foo", func() { s.Set("foo", "1", 0) })
+		assertChanged("foo", func() { s.Expire("foo", 100) })
+		assertChanged("foo", func() { s.IncrBy("foo", 1) })
+
+		v := version("foo")
+		s.Get("foo")
+		s.TTL("foo")
+		if version("foo") != v {
+			t.Fatalf("%s: expected reads to keep version", name)
+		}
+
+		assertChanged("hash", func() { s.HSet("hash", "a", "1") })
+		assertChanged("hash", func() { s.HSet("hash", "b", "2") })
+		assertChanged("hash", func() { s.HIncrBy("hash", "a", 1) })
+		assertChanged("list", func() { s.RPush("list", "a", "b") })
+		assertChanged("list", func() { s.LPop("list") })
+		assertChanged("set", func() { s.SAdd("set", "a", "b") })
+		assertChanged("set", func() { s.SRem("set", "a") })
+		assertChanged("zset", func() { s.ZAdd("zset", ZMember{"a", 1}, ZMember{"b", 2}) })
+		assertChanged("zset", func() { s.ZIncrBy("zset", "a", 5) })
+		assertChanged("union", func() { s.SUnionStore("union", "set") })
+		assertChanged("restored", func() { s.Restore(Entry{Key: "restored", Value: "x"}) })
+
+		s.Delete("foo")
+		if v := version("foo"); v != 0 {
+			t.Fatalf("%s: expected 0 for deleted key. Got: %d", name, v)
+		}
+
+		// key created again doesn't get version it had before
+		s.Set("foo", "bar", 0)
+		if version("foo") == v {
+			t.Fatalf("%s: expected new version of recreated key", name)
+		}
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	storages := testStorages()
+	storages["journal"] = func() Storage {
+		return newJournal(storages["bucket"](), nil)
+	}
+
+	for name, factory := range storages {
+		s := factory()
+		s.Set("a", "100", 0)
+		s.Set("b", "100", 0)
+
+		// transfers between keys in both directions, so keys are listed in different order
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			from, to := "a", "b"
+			if i%2 == 0 {
+				from, to = to, from
+			}
+
+			wg.Add(1)
+			go func(from, to string) {
+				defer wg.Done()
+
+				for j := 0; j < 100; j++ {
+					err := s.Atomic([]string{from, to}, func(s Storage) error {
+						value, err := s.Get(from)
+						if err != nil {
+							return err
+						}
+						n, _ := strconv.Atoi(value)
+						if n == 0 {
+							return nil
+						}
+
+						s.Set(from, strconv.Itoa(n-1), 0)
+						_, err = s.IncrBy(to, 1)
+
+						return err
+					})
+					if err != nil {
+						t.Errorf("%s: unexpected error: %v", name, err)
+						return
+					}
+				}
+			}(from, to)
+		}
+
+		// reader sees either none or all of transfer
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				s.Atomic(nil, func(s Storage) error {
+					a, _ := s.Get("a")
+					b, _ := s.Get("b")
+					if x, _ := strconv.Atoi(a); x < 0 {
+						t.Errorf("%s: negative value: %s", name, a)
+					}
+					if x, y := atoi(a), atoi(b); x+y != 200 {
+						t.Errorf("%s: expected sum 200. Got: %s + %s", name, a, b)
+					}
+
+					return nil
+				})
+			}
+		}()
+
+		wg.Wait()
+		close(stop)
+		<-done
+
+		if sum := atoi(mustGet(t, s, "a")) + atoi(mustGet(t, s, "b")); sum != 200 {
+			t.Fatalf("%s: expected sum 200. Got: %d", name, sum)
+		}
+	}
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+
+	return n
+}
+
+func mustGet(t *testing.T, s Storage, key string) string {
+	value, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	return value
+}
+
+func TestTransaction(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultQueued)
+	client.assertRequest(t, []byte("HSET hash field 5\r\nvalue\r\n"), resultQueued)
+	client.assertRequest(t, []byte("INCR foo\r\n"), resultQueued)
+	client.assertRequest(t, []byte("GET foo\r\n"), resultQueued)
+	client.assertRequest(t, []byte("BLPOP list 0\r\n"), resultQueued)
+	client.assertRequest(t, []byte("EXEC\r\n"),
+		[]byte("RESULTS\r\n5\r\nOK\r\nOK\r\nNOT_INTEGER\r\nVALUES\r\n1\r\n3\r\nbarNOT_FOUND\r\n"))
+	client.assertRequest(t, []byte("HGET hash field\r\n"), []byte("VALUES\r\n1\r\n5\r\nvalue"))
+
+	// queued requests are dropped
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbaz\r\n"), resultQueued)
+	client.assertRequest(t, []byte("DISCARD\r\n"), resultOK)
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
+
+	client.assertRequest(t, []byte("EXEC\r\n"), resultError)
+	client.assertRequest(t, []byte("DISCARD\r\n"), resultError)
+
+	// invalid request aborts transaction
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("MULTI\r\n"), resultError)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbaz\r\n"), resultQueued)
+	client.assertRequest(t, []byte("UNKNOWN foo\r\n"), resultWrongCommand)
+	client.assertRequest(t, []byte("SAVE\r\n"), resultError)
+	client.assertRequest(t, []byte("EXEC\r\n"), resultExecAbort)
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n3\r\nbar"))
+}
+
+func TestWatch(t *testing.T) {
+	l, conn := testutil.NextListener(t)
+
+	server := New(NewMemory(time.Minute), DefaultConfig())
+	go server.Serve(l)
+	defer server.Close()
+
+	otherConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherConn.Close()
+
+	client := &testClient{connection: conn}
+	other := &testClient{connection: otherConn}
+
+	// unchanged key
+	client.assertRequest(t, []byte("WATCH foo\r\n"), resultOK)
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("WATCH foo\r\n"), resultError)
+	client.assertRequest(t, []byte("SET foo 0 1\r\n1\r\n"), resultQueued)
+	client.assertRequest(t, []byte("EXEC\r\n"), []byte("RESULTS\r\n1\r\nOK\r\n"))
+
+	// key changed by other client
+	client.assertRequest(t, []byte("WATCH foo bar\r\n"), resultOK)
+	other.assertRequest(t, []byte("SET foo 0 1\r\n2\r\n"), resultOK)
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("SET foo 0 1\r\n3\r\n"), resultQueued)
+	client.assertRequest(t, []byte("EXEC\r\n"), resultAborted)
+	client.assertRequest(t, []byte("GET foo\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+
+	// EXEC forgets watched keys
+	other.assertRequest(t, []byte("SET foo 0 1\r\n4\r\n"), resultOK)
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("GET foo\r\n"), resultQueued)
+	client.assertRequest(t, []byte("EXEC\r\n"), []byte("RESULTS\r\n1\r\nVALUES\r\n1\r\n1\r\n4"))
+
+	// unwatched key
+	client.assertRequest(t, []byte("WATCH foo\r\n"), resultOK)
+	client.assertRequest(t, []byte("UNWATCH\r\n"), resultOK)
+	other.assertRequest(t, []byte("DELETE foo\r\n"), resultOK)
+	client.assertRequest(t, []byte("MULTI\r\n"), resultOK)
+	client.assertRequest(t, []byte("EXEC\r\n"), []byte("RESULTS\r\n0\r\n"))
+}
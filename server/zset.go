@@ -0,0 +1,317 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+// Sorted sets are stored as *zset: map of member scores and skiplist ordered by score, then by member.
+// Skiplist links keep spans (number of nodes they skip), so both rank and score lookups are logarithmic.
+// Storages implement sorted set commands on top of zset, empty sorted sets are removed from storage.
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// ZMember is member of sorted set with its score.
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+type skiplistLevel struct {
+	next *skiplistNode
+	span int
+}
+
+type skiplistNode struct {
+	ZMember
+	levels []skiplistLevel
+}
+
+// before checks if node goes before member with score.
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.Score < score || (n.Score == score && n.Member < member)
+}
+
+type skiplist struct {
+	head   *skiplistNode
+	level  int
+	length int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:  &skiplistNode{levels: make([]skiplistLevel, skiplistMaxLevel)},
+		level: 1,
+	}
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+
+	return level
+}
+
+// insert adds member, which must not be in list.
+func (l *skiplist) insert(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+			update[i].levels[i].span = l.length
+		}
+		l.level = level
+	}
+
+	x = &skiplistNode{
+		ZMember: ZMember{Member: member, Score: score},
+		levels:  make([]skiplistLevel, level),
+	}
+	for i := 0; i < level; i++ {
+		x.levels[i].next = update[i].levels[i].next
+		update[i].levels[i].next = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	l.length++
+}
+
+// delete removes member with score. It returns false if there is no such member.
+func (l *skiplist) delete(member string, score float64) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.before(score, member) {
+			x = x.levels[i].next
+		}
+		update[i] = x
+	}
+
+	x = x.levels[0].next
+	if x == nil || x.Score != score || x.Member != member {
+		return false
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].next == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].next = x.levels[i].next
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	for l.level > 1 && l.head.levels[l.level-1].next == nil {
+		l.level--
+	}
+
+	l.length--
+
+	return true
+}
+
+// rank returns 0-based rank of member with score, or -1 if there is no such member.
+func (l *skiplist) rank(member string, score float64) int {
+	rank := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && !(score < x.levels[i].next.Score ||
+			(score == x.levels[i].next.Score && member < x.levels[i].next.Member)) {
+			rank += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if x != l.head && x.Member == member {
+			return rank - 1
+		}
+	}
+
+	return -1
+}
+
+// byRank returns node with 0-based rank.
+func (l *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].next
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+
+	return nil
+}
+
+// firstFrom returns first node with score not less than min.
+func (l *skiplist) firstFrom(min float64) *skiplistNode {
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].next != nil && x.levels[i].next.Score < min {
+			x = x.levels[i].next
+		}
+	}
+
+	return x.levels[0].next
+}
+
+type zset struct {
+	scores map[string]float64
+	list   *skiplist
+}
+
+func newZSet() *zset {
+	return &zset{
+		scores: make(map[string]float64),
+		list:   newSkiplist(),
+	}
+}
+
+// add sets score of member and returns true if member is new.
+func (z *zset) add(member string, score float64) bool {
+	old, ok := z.scores[member]
+	if ok {
+		if old == score {
+			return false
+		}
+		z.list.delete(member, old)
+	}
+
+	z.list.insert(member, score)
+	z.scores[member] = score
+
+	return !ok
+}
+
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+
+	z.list.delete(member, score)
+	delete(z.scores, member)
+
+	return true
+}
+
+func (z *zset) rank(member string) (int, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+
+	return z.list.rank(member, score), true
+}
+
+// rangeByRank returns members between start and stop ranks inclusive. Negative rank counts from the end.
+func (z *zset) rangeByRank(start, stop int) []ZMember {
+	from, to := listRange(z.list.length, start, stop)
+	if from == to {
+		return nil
+	}
+
+	result := make([]ZMember, 0, to-from)
+	for x := z.list.byRank(from); x != nil && len(result) < to-from; x = x.levels[0].next {
+		result = append(result, x.ZMember)
+	}
+
+	return result
+}
+
+// rangeByScore returns members with score between min and max inclusive.
+func (z *zset) rangeByScore(min, max float64) []ZMember {
+	var result []ZMember
+	for x := z.list.firstFrom(min); x != nil && x.Score <= max; x = x.levels[0].next {
+		result = append(result, x.ZMember)
+	}
+
+	return result
+}
+
+// popMin removes and returns member with the lowest score of non-empty sorted set.
+func (z *zset) popMin() ZMember {
+	first := z.list.head.levels[0].next.ZMember
+	z.remove(first.Member)
+
+	return first
+}
+
+func (z *zset) len() int {
+	return len(z.scores)
+}
+
+// members returns all members in order.
+func (z *zset) members() []ZMember {
+	return z.rangeByRank(0, -1)
+}
+
+func (z *zset) copy() *zset {
+	result := newZSet()
+	for member, score := range z.scores {
+		result.add(member, score)
+	}
+
+	return result
+}
+
+// zIncrBy adds delta to score of member. Result can't be NaN, e.g. sum of opposite infinities.
+func zIncrBy(z *zset, member string, delta float64) (float64, error) {
+	score := z.scores[member] + delta
+	if math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+
+	z.add(member, score)
+
+	return score, nil
+}
+
+// parseScore parses score. NaN isn't valid score, infinities are.
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+
+	return score, nil
+}
+
+// formatScore formats score so it can be parsed back without loss of precision.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+)
+
+// zAddCommand is ZADD key score length [score length ...] followed by data blocks.
+type zAddCommand struct{}
+
+func (c zAddCommand) arguments() int {
+	return -3
+}
+
+func (c zAddCommand) lengths(arguments []string) ([]string, error) {
+	// without lengths data blocks can't be skipped
+	pairs := arguments[1:]
+	if len(pairs)%2 != 0 {
+		return nil, errBadLength
+	}
+
+	lengths := make([]string, len(pairs)/2)
+	for i := range lengths {
+		lengths[i] = pairs[i*2+1]
+	}
+
+	return lengths, nil
+}
+
+func (c zAddCommand) process(r *request, s Storage) ([]string, error) {
+	lengths, err := c.lengths(r.arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := r.values(lengths)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]ZMember, len(values))
+	for i, value := range values {
+		score, err := parseScore(r.arguments[i*2+1])
+		if err != nil {
+			return nil, err
+		}
+		members[i] = ZMember{Member: value, Score: score}
+	}
+
+	added, err := s.ZAdd(r.arguments[0], members...)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(added)}, nil
+}
+
+// zRemCommand is ZREM key length [length ...] followed by data blocks.
+type zRemCommand struct{}
+
+func (c zRemCommand) arguments() int {
+	return -2
+}
+
+func (c zRemCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c zRemCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := s.ZRem(r.arguments[0], members...)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(removed)}, nil
+}
+
+// zScoreCommand is ZSCORE key length followed by data block.
+type zScoreCommand struct{}
+
+func (c zScoreCommand) arguments() int {
+	return 2
+}
+
+func (c zScoreCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c zScoreCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	score, err := s.ZScore(r.arguments[0], members[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{formatScore(score)}, nil
+}
+
+// zRankCommand is ZRANK key length followed by data block.
+type zRankCommand struct{}
+
+func (c zRankCommand) arguments() int {
+	return 2
+}
+
+func (c zRankCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[1:], nil
+}
+
+func (c zRankCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	rank, err := s.ZRank(r.arguments[0], members[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(rank)}, nil
+}
+
+// zRangeCommand is ZRANGE key start stop [WITHSCORES].
+type zRangeCommand struct{}
+
+func (c zRangeCommand) arguments() int {
+	return -3
+}
+
+func (c zRangeCommand) process(r *request, s Storage) ([]string, error) {
+	withScores, err := parseWithScores(r.arguments[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	start, stop, err := parseRange(r.arguments[1], r.arguments[2])
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.ZRange(r.arguments[0], start, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	return zMembersReply(members, withScores), nil
+}
+
+// zRangeByScoreCommand is ZRANGEBYSCORE key min max [WITHSCORES]. Bounds are inclusive, -inf and inf
+// can be used for unbounded range.
+type zRangeByScoreCommand struct{}
+
+func (c zRangeByScoreCommand) arguments() int {
+	return -3
+}
+
+func (c zRangeByScoreCommand) process(r *request, s Storage) ([]string, error) {
+	withScores, err := parseWithScores(r.arguments[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	min, err := parseScore(r.arguments[1])
+	if err != nil {
+		return nil, err
+	}
+
+	max, err := parseScore(r.arguments[2])
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := s.ZRangeByScore(r.arguments[0], min, max)
+	if err != nil {
+		return nil, err
+	}
+
+	return zMembersReply(members, withScores), nil
+}
+
+// zIncrByCommand is ZINCRBY key delta length followed by data block.
+type zIncrByCommand struct{}
+
+func (c zIncrByCommand) arguments() int {
+	return 3
+}
+
+func (c zIncrByCommand) lengths(arguments []string) ([]string, error) {
+	return arguments[2:], nil
+}
+
+func (c zIncrByCommand) process(r *request, s Storage) ([]string, error) {
+	members, err := r.values(r.arguments[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := parseScore(r.arguments[1])
+	if err != nil {
+		return nil, err
+	}
+
+	score, err := s.ZIncrBy(r.arguments[0], members[0], delta)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{formatScore(score)}, nil
+}
+
+type zCardCommand struct{}
+
+func (c zCardCommand) arguments() int {
+	return 1
+}
+
+func (c zCardCommand) process(r *request, s Storage) ([]string, error) {
+	n, err := s.ZCard(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{strconv.Itoa(n)}, nil
+}
+
+// zPopMinCommand replies with member and its score.
+type zPopMinCommand struct{}
+
+func (c zPopMinCommand) arguments() int {
+	return 1
+}
+
+func (c zPopMinCommand) process(r *request, s Storage) ([]string, error) {
+	first, err := s.ZPopMin(r.arguments[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{first.Member, formatScore(first.Score)}, nil
+}
+
+func parseWithScores(options []string) (bool, error) {
+	switch {
+	case len(options) == 0:
+		return false, nil
+	case len(options) == 1 && strings.ToUpper(options[0]) == "WITHSCORES":
+		return true, nil
+	default:
+		return false, errBadFormat
+	}
+}
+
+// zMembersReply replies members, every member is followed by its score if withScores is set.
+func zMembersReply(members []ZMember, withScores bool) []string {
+	n := len(members)
+	if withScores {
+		n *= 2
+	}
+
+	values := make([]string, 0, n)
+	for _, member := range members {
+		values = append(values, member.Member)
+		if withScores {
+			values = append(values, formatScore(member.Score))
+		}
+	}
+
+	return values
+}
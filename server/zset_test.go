@@ -0,0 +1,166 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSkiplist(t *testing.T) {
+	z := newZSet()
+	expected := make(map[string]float64)
+	for i := 0; i < 1000; i++ {
+		member := strconv.Itoa(rand.Intn(200))
+		switch rand.Intn(3) {
+		case 0:
+			z.remove(member)
+			delete(expected, member)
+		default:
+			score := float64(rand.Intn(50))
+			z.add(member, score)
+			expected[member] = score
+		}
+	}
+
+	sorted := make([]ZMember, 0, len(expected))
+	for member, score := range expected {
+		sorted = append(sorted, ZMember{Member: member, Score: score})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Score < sorted[j].Score ||
+			(sorted[i].Score == sorted[j].Score && sorted[i].Member < sorted[j].Member)
+	})
+
+	if members := z.members(); !reflect.DeepEqual(members, sorted) {
+		t.Fatalf("Expected: %v. Got: %v", sorted, members)
+	}
+
+	for i, member := range sorted {
+		if rank, ok := z.rank(member.Member); !ok || rank != i {
+			t.Fatalf("Expected rank %d for %s. Got: %d", i, member.Member, rank)
+		}
+		if node := z.list.byRank(i); node == nil || node.ZMember != member {
+			t.Fatalf("Expected: %v. Got: %v", member, node)
+		}
+	}
+
+	var inRange []ZMember
+	for _, member := range sorted {
+		if member.Score >= 10 && member.Score <= 20 {
+			inRange = append(inRange, member)
+		}
+	}
+	if members := z.rangeByScore(10, 20); !reflect.DeepEqual(members, inRange) {
+		t.Fatalf("Expected: %v. Got: %v", inRange, members)
+	}
+}
+
+func TestZSet(t *testing.T) {
+	for name, factory := range testStorages() {
+		s := factory()
+
+		members := []ZMember{{"alice", 10}, {"bob", 5}, {"carol", 10}, {"bob", 7}}
+		if n, err := s.ZAdd("board", members...); err != nil || n != 3 {
+			t.Fatalf("%s: expected 3. Got: %d, %v", name, n, err)
+		}
+		assertZRange(t, s, "board", []ZMember{{"bob", 7}, {"alice", 10}, {"carol", 10}})
+
+		if score, err := s.ZScore("board", "bob"); err != nil || score != 7 {
+			t.Fatalf("%s: expected 7. Got: %v, %v", name, score, err)
+		}
+		if _, err := s.ZScore("board", "dave"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if rank, err := s.ZRank("board", "carol"); err != nil || rank != 2 {
+			t.Fatalf("%s: expected 2. Got: %d, %v", name, rank, err)
+		}
+		if score, err := s.ZIncrBy("board", "bob", 4.5); err != nil || score != 11.5 {
+			t.Fatalf("%s: expected 11.5. Got: %v, %v", name, score, err)
+		}
+		if rank, _ := s.ZRank("board", "bob"); rank != 2 {
+			t.Fatalf("%s: expected 2. Got: %d", name, rank)
+		}
+
+		top, err := s.ZRange("board", -1, -1)
+		if err != nil || !reflect.DeepEqual(top, []ZMember{{"bob", 11.5}}) {
+			t.Fatalf("%s: expected [bob]. Got: %v, %v", name, top, err)
+		}
+		byScore, err := s.ZRangeByScore("board", 10, math.Inf(1))
+		if err != nil || len(byScore) != 3 {
+			t.Fatalf("%s: expected 3 members. Got: %v, %v", name, byScore, err)
+		}
+		if byScore, _ := s.ZRangeByScore("board", 20, 30); len(byScore) != 0 {
+			t.Fatalf("%s: expected no members. Got: %v", name, byScore)
+		}
+
+		if _, err := s.ZIncrBy("board", "inf", math.Inf(1)); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if _, err := s.ZIncrBy("board", "inf", math.Inf(-1)); err != errNotFloat {
+			t.Fatalf("%s: expected errNotFloat. Got: %v", name, err)
+		}
+		if n, err := s.ZRem("board", "inf", "dave"); err != nil || n != 1 {
+			t.Fatalf("%s: expected 1. Got: %d, %v", name, n, err)
+		}
+
+		if first, err := s.ZPopMin("board"); err != nil || first != (ZMember{"alice", 10}) {
+			t.Fatalf("%s: expected alice. Got: %v, %v", name, first, err)
+		}
+		if n, err := s.ZCard("board"); err != nil || n != 2 {
+			t.Fatalf("%s: expected 2. Got: %d, %v", name, n, err)
+		}
+
+		// empty sorted set is removed
+		s.ZRem("board", "bob", "carol")
+		if _, err := s.TTL("board"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+		if _, err := s.ZPopMin("board"); err != errNotFound {
+			t.Fatalf("%s: expected errNotFound. Got: %v", name, err)
+		}
+
+		s.Set("string", "value", 0)
+		if _, err := s.ZAdd("string", ZMember{"a", 1}); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+		if _, err := s.ZRange("string", 0, -1); err != errWrongType {
+			t.Fatalf("%s: expected errWrongType. Got: %v", name, err)
+		}
+	}
+}
+
+func TestZSetCommands(t *testing.T) {
+	client, closer := testServer(t)
+	defer closer.Close()
+
+	client.assertRequest(t, []byte("ZADD board 10 5 2.5 3\r\nalice\r\nbob\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+	client.assertRequest(t, []byte("ZRANGE board 0 -1\r\n"), []byte("VALUES\r\n2\r\n3\r\nbob5\r\nalice"))
+	client.assertRequest(t, []byte("ZRANGE board 0 0 WITHSCORES\r\n"), []byte("VALUES\r\n2\r\n3\r\nbob3\r\n2.5"))
+	client.assertRequest(t, []byte("ZRANGEBYSCORE board 5 inf WITHSCORES\r\n"), []byte("VALUES\r\n2\r\n5\r\nalice2\r\n10"))
+	client.assertRequest(t, []byte("ZRANGEBYSCORE board -inf 0\r\n"), resultOK)
+	client.assertRequest(t, []byte("ZSCORE board 5\r\nalice\r\n"), []byte("VALUES\r\n1\r\n2\r\n10"))
+	client.assertRequest(t, []byte("ZRANK board 5\r\nalice\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("ZRANK board 4\r\ndave\r\n"), resultNotFound)
+	client.assertRequest(t, []byte("ZINCRBY board 10 3\r\nbob\r\n"), []byte("VALUES\r\n1\r\n4\r\n12.5"))
+	client.assertRequest(t, []byte("ZINCRBY board x 3\r\nbob\r\n"), resultNotFloat)
+	client.assertRequest(t, []byte("ZCARD board\r\n"), []byte("VALUES\r\n1\r\n1\r\n2"))
+	client.assertRequest(t, []byte("ZPOPMIN board\r\n"), []byte("VALUES\r\n2\r\n5\r\nalice2\r\n10"))
+	client.assertRequest(t, []byte("ZREM board 3\r\nbob\r\n"), []byte("VALUES\r\n1\r\n1\r\n1"))
+	client.assertRequest(t, []byte("ZPOPMIN board\r\n"), resultNotFound)
+	client.assertRequest(t, []byte("SET foo 0 3\r\nbar\r\n"), resultOK)
+	client.assertRequest(t, []byte("ZADD foo 1 1\r\na\r\n"), resultWrongType)
+}
+
+func assertZRange(t *testing.T, s Storage, key string, expected []ZMember) {
+	members, err := s.ZRange(key, 0, -1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(members, expected) {
+		t.Fatalf("Expected: %v. Got: %v", expected, members)
+	}
+}